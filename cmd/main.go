@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/doomhound188/soulhound/internal/bot"
@@ -16,6 +17,14 @@ func main() {
 	discordToken := flag.String("discord", os.Getenv("DISCORD_TOKEN"), "Discord Bot Token")
 	youtubeToken := flag.String("youtube", os.Getenv("YOUTUBE_TOKEN"), "YouTube API Token")
 	spotifyToken := flag.String("spotify", os.Getenv("SPOTIFY_TOKEN"), "Spotify API Token")
+	spotifyClientID := flag.String("spotify-client-id", os.Getenv("SPOTIFY_CLIENT_ID"), "Spotify app client ID")
+	spotifyClientSecret := flag.String("spotify-client-secret", os.Getenv("SPOTIFY_CLIENT_SECRET"), "Spotify app client secret")
+	commandMode := flag.String("commands", envOrDefault("COMMAND_MODE", config.CommandModeBoth), "Command mode: prefix, slash or both")
+	commandGuild := flag.String("command-guild", os.Getenv("COMMAND_GUILD_ID"), "Register slash commands to this guild only (development)")
+	ytdlpPath := flag.String("ytdlp", envOrDefault("YTDLP_PATH", "yt-dlp"), "Path to the yt-dlp binary")
+	youtubeResolvers := flag.String("youtube-resolvers", envOrDefault("YOUTUBE_RESOLVERS", "ytdlp,library"), "Comma-separated YouTube resolver order (ytdlp, library)")
+	dataDir := flag.String("data-dir", lookupEnvOrDefault("DATA_DIR", "data"), "Directory for saved queues and settings, empty to disable")
+	musicDir := flag.String("music-dir", os.Getenv("MUSIC_DIR"), "Directory of audio files to play with local:<query>, empty to disable")
 	flag.Parse()
 
 	// Check for Discord token in environment if not provided via flag
@@ -26,6 +35,20 @@ func main() {
 	// Initialize configuration
 	config.Init(*discordToken, *youtubeToken, *spotifyToken)
 
+	switch *commandMode {
+	case config.CommandModePrefix, config.CommandModeSlash, config.CommandModeBoth:
+		config.AppConfig.CommandMode = *commandMode
+	default:
+		log.Fatalf("Invalid command mode %q. Use prefix, slash or both", *commandMode)
+	}
+	config.AppConfig.CommandGuild = *commandGuild
+	config.AppConfig.YTDLPPath = *ytdlpPath
+	config.AppConfig.YouTubeResolvers = splitList(*youtubeResolvers)
+	config.AppConfig.SpotifyClientID = *spotifyClientID
+	config.AppConfig.SpotifyClientSecret = *spotifyClientSecret
+	config.AppConfig.DataDir = *dataDir
+	config.AppConfig.MusicDir = *musicDir
+
 	// Create and start the bot
 	discordBot, err := bot.New(&config.AppConfig)
 	if err != nil {
@@ -48,3 +71,31 @@ func main() {
 		log.Printf("Error while shutting down: %v", err)
 	}
 }
+
+// envOrDefault returns the value of an environment variable or a fallback if it is unset
+func envOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// lookupEnvOrDefault returns the value of an environment variable, even an
+// empty one, or a fallback if it is unset
+func lookupEnvOrDefault(key, fallback string) string {
+	if value, set := os.LookupEnv(key); set {
+		return value
+	}
+	return fallback
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -7,6 +7,7 @@ toolchain go1.24.0
 require (
 	github.com/bwmarrin/discordgo v0.27.1
 	github.com/jonas747/dca v0.0.0-20201113050843-65838623978b
+	github.com/kkdai/youtube/v2 v2.10.4
 )
 
 require (
@@ -17,7 +18,6 @@ require (
 	github.com/google/pprof v0.0.0-20250208200701-d0013a598941 // indirect
 	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/jonas747/ogg v0.0.0-20161220051205-b4f6f4cf3757 // indirect
-	github.com/kkdai/youtube/v2 v2.10.4 // indirect
 	golang.org/x/crypto v0.0.0-20210421170649-83a5a9bb288b // indirect
 	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
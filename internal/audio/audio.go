@@ -2,6 +2,7 @@ package audio
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -37,32 +38,85 @@ type YouTubeSnippet struct {
 }
 
 type SearchResult struct {
-	ID       string
-	Title    string
-	Artist   string
-	Duration int
-	Genre    string
+	ID        string
+	Title     string
+	Artist    string
+	Duration  int // Seconds, 0 if unknown
+	Genre     string
+	Thumbnail string
+	URL       string // Canonical link to the track on its platform
+	Live      bool   // Has no end, e.g. internet radio
 }
 
-type YouTubeProvider struct {
-	apiKey string
-}
+// YouTube resolver backends, tried in the configured order
+const (
+	ResolverYTDLP   = "ytdlp"   // yt-dlp binary
+	ResolverLibrary = "library" // Data API search and the kkdai/youtube library
+)
 
-type SpotifyProvider struct {
+// DefaultYouTubeResolvers prefers yt-dlp and falls back to the library
+var DefaultYouTubeResolvers = []string{ResolverYTDLP, ResolverLibrary}
+
+type YouTubeProvider struct {
 	apiKey    string
-	authToken string
+	ytdlp     *YTDLP
+	resolvers []string
 }
 
 func NewYouTubeProvider(apiKey string) *YouTubeProvider {
-	return &YouTubeProvider{apiKey: apiKey}
+	return &YouTubeProvider{
+		apiKey:    apiKey,
+		ytdlp:     NewYTDLP(""),
+		resolvers: DefaultYouTubeResolvers,
+	}
 }
 
-func NewSpotifyProvider(apiKey string) *SpotifyProvider {
-	return &SpotifyProvider{apiKey: apiKey}
+// SetYTDLP changes the yt-dlp binary used for lookups
+func (yt *YouTubeProvider) SetYTDLP(binary string) {
+	yt.ytdlp = NewYTDLP(binary)
+}
+
+// YTDLP returns the yt-dlp resolver used by this provider
+func (yt *YouTubeProvider) YTDLP() *YTDLP {
+	return yt.ytdlp
+}
+
+// SetResolverOrder sets which backends are tried, and in which order
+func (yt *YouTubeProvider) SetResolverOrder(order []string) error {
+	if len(order) == 0 {
+		return fmt.Errorf("at least one YouTube resolver is required")
+	}
+	for _, resolver := range order {
+		if resolver != ResolverYTDLP && resolver != ResolverLibrary {
+			return fmt.Errorf("unknown YouTube resolver %q (use %q or %q)", resolver, ResolverYTDLP, ResolverLibrary)
+		}
+	}
+	yt.resolvers = append([]string{}, order...)
+	return nil
 }
 
 // YouTube Implementation
 func (yt *YouTubeProvider) Search(query string) ([]SearchResult, error) {
+	for _, resolver := range yt.resolvers {
+		switch resolver {
+		case ResolverYTDLP:
+			results, err := yt.ytdlp.Search(query, 5)
+			if err != nil {
+				log.Printf("yt-dlp search failed for %q, trying next resolver: %v", query, err)
+				continue
+			}
+			if len(results) > 0 {
+				return results, nil
+			}
+		case ResolverLibrary:
+			return yt.searchAPI(query)
+		}
+	}
+	return nil, fmt.Errorf("no YouTube resolver returned results for %q", query)
+}
+
+// searchAPI searches through the YouTube Data API, or returns mock data without an API key
+func (yt *YouTubeProvider) searchAPI(query string) ([]SearchResult, error) {
 	// If no API key provided, return mock results for testing
 	if yt.apiKey == "" {
 		return []SearchResult{
@@ -112,6 +166,7 @@ func (yt *YouTubeProvider) Search(query string) ([]SearchResult, error) {
 			Artist:   item.Snippet.ChannelTitle,
 			Duration: 0, // Would need additional API call to get duration
 			Genre:    "unknown",
+			URL:      youtubeWatchURL(item.ID.VideoID),
 		})
 	}
 
@@ -150,21 +205,46 @@ func (yt *YouTubeProvider) GetStreamURL(id string) (string, error) {
 	if strings.HasPrefix(id, "mock_") {
 		return id, nil
 	}
-	
-	// First try using the YouTube library for direct streaming
+
+	var errs []error
+	for _, resolver := range yt.resolvers {
+		var streamURL string
+		var err error
+		switch resolver {
+		case ResolverYTDLP:
+			streamURL, err = yt.ytdlp.StreamURL(id)
+		case ResolverLibrary:
+			streamURL, err = yt.libraryStreamURL(id)
+		}
+		if err == nil {
+			log.Printf("Resolved stream URL for YouTube video %s via %s", id, resolver)
+			return streamURL, nil
+		}
+		log.Printf("Resolver %s failed for YouTube video %s: %v", resolver, id, err)
+		errs = append(errs, fmt.Errorf("%s: %w", resolver, err))
+	}
+
+	return "", fmt.Errorf("failed to get stream URL for video %s: %w", id, errors.Join(errs...))
+}
+
+// GetMetadata returns title, uploader, duration and thumbnail for a video via yt-dlp
+func (yt *YouTubeProvider) GetMetadata(id string) (*SearchResult, error) {
+	return yt.ytdlp.Metadata(id)
+}
+
+// libraryStreamURL resolves a stream URL with the kkdai/youtube library
+func (yt *YouTubeProvider) libraryStreamURL(id string) (string, error) {
 	client := youtube.Client{}
 	
 	video, err := client.GetVideo(id)
 	if err != nil {
-		log.Printf("Failed to get YouTube video info for ID %s: %v", id, err)
-		// If the library fails, we'll return an error but suggest using yt-dlp
-		return "", fmt.Errorf("failed to get video information. For better YouTube support, install yt-dlp: %w", err)
+		return "", fmt.Errorf("failed to get video information: %w", err)
 	}
 	
 	// Get the best audio format available
 	formats := video.Formats.WithAudioChannels()
 	if len(formats) == 0 {
-		return "", fmt.Errorf("no audio formats available for video %s. Try installing yt-dlp for better format support", id)
+		return "", fmt.Errorf("no audio formats available for video %s", id)
 	}
 	
 	// Find the best audio-only format or the best format with audio
@@ -181,14 +261,13 @@ func (yt *YouTubeProvider) GetStreamURL(id string) (string, error) {
 	}
 	
 	if bestFormat == nil {
-		return "", fmt.Errorf("no suitable audio format found for video %s. Consider using yt-dlp for better format detection", id)
+		return "", fmt.Errorf("no suitable audio format found for video %s", id)
 	}
 	
 	// Get the stream URL for the selected format
 	streamURL, err := client.GetStreamURL(video, bestFormat)
 	if err != nil {
-		log.Printf("Failed to get stream URL for video %s: %v", id, err)
-		return "", fmt.Errorf("failed to get stream URL. YouTube may have changed their API. Consider using yt-dlp: %w", err)
+		return "", fmt.Errorf("failed to get stream URL, YouTube may have changed their API: %w", err)
 	}
 	
 	log.Printf("Successfully obtained stream URL for YouTube video %s (format: %s, bitrate: %d)", id, bestFormat.MimeType, bestFormat.Bitrate)
@@ -217,89 +296,105 @@ func (yt *YouTubeProvider) GetRecommendations(genre string) ([]SearchResult, err
 	return recommendations["unknown"], nil
 }
 
-// Spotify Implementation
-func (sp *SpotifyProvider) Search(query string) ([]SearchResult, error) {
-	// If no API key provided, return mock results for testing
-	if sp.apiKey == "" {
-		return []SearchResult{
-			{
-				ID:       "4iV5W9uYEdYUVa79Axb7Rh",
-				Title:    "Shape of You",
-				Artist:   "Ed Sheeran",
-				Duration: 233,
-				Genre:    "pop",
-			},
-			{
-				ID:       "7qiZfU4dY1lWllzX7mPBI3",
-				Title:    "Blinding Lights",
-				Artist:   "The Weeknd",
-				Duration: 200,
-				Genre:    "pop",
-			},
-		}, nil
-	}
+// playlistPageSize is how many playlist entries are fetched per request
+const playlistPageSize = 100
 
-	// Return mock data for now since Spotify requires OAuth setup
-	return sp.getMockResults(query), nil
+// IsPlaylistURL reports whether link is a youtube.com/playlist?list=... link
+func (yt *YouTubeProvider) IsPlaylistURL(link string) bool {
+	return youtubePlaylistID(link) != ""
 }
 
-func (sp *SpotifyProvider) getMockResults(query string) []SearchResult {
-	return []SearchResult{
-		{
-			ID:       "spotify_mock_" + url.QueryEscape(query) + "_1",
-			Title:    query + " - Spotify Song 1",
-			Artist:   "Spotify Artist 1",
-			Duration: 200,
-			Genre:    "unknown",
-		},
-		{
-			ID:       "spotify_mock_" + url.QueryEscape(query) + "_2",
-			Title:    query + " - Spotify Song 2",
-			Artist:   "Spotify Artist 2",
-			Duration: 220,
-			Genre:    "unknown",
-		},
+// OpenPlaylist loads a YouTube playlist through the configured resolvers
+func (yt *YouTubeProvider) OpenPlaylist(link string, limit int) (*Playlist, error) {
+	var errs []error
+	for _, resolver := range yt.resolvers {
+		var playlist *Playlist
+		var err error
+		switch resolver {
+		case ResolverYTDLP:
+			playlist, err = yt.ytdlpPlaylist(link, limit)
+		case ResolverLibrary:
+			playlist, err = yt.libraryPlaylist(link, limit)
+		}
+		if err == nil {
+			return playlist, nil
+		}
+		log.Printf("Resolver %s failed for YouTube playlist %s: %v", resolver, link, err)
+		errs = append(errs, fmt.Errorf("%s: %w", resolver, err))
 	}
+
+	return nil, fmt.Errorf("failed to load playlist %s: %w", link, errors.Join(errs...))
 }
 
-func (sp *SpotifyProvider) GetStreamURL(id string) (string, error) {
-	if id == "" {
-		return "", fmt.Errorf("invalid track ID")
+// ytdlpPlaylist pages through a playlist with yt-dlp, one page per request
+func (yt *YouTubeProvider) ytdlpPlaylist(link string, limit int) (*Playlist, error) {
+	first, err := yt.ytdlp.PlaylistPage(link, 1, playlistPageSize)
+	if err != nil {
+		return nil, err
 	}
-	
-	// For mock IDs, return the ID as-is for testing
-	if strings.HasPrefix(id, "spotify_mock_") {
-		return id, nil
+
+	start := 1
+	pending := first
+	pager := func() ([]SearchResult, bool, error) {
+		page := pending
+		pending = nil
+		if page == nil {
+			var err error
+			if page, err = yt.ytdlp.PlaylistPage(link, start, playlistPageSize); err != nil {
+				return nil, false, err
+			}
+		}
+
+		start += playlistPageSize
+		more := len(page.Tracks) == playlistPageSize && (page.Total == 0 || start <= page.Total)
+		return page.Tracks, more, nil
 	}
-	
-	// Spotify tracks can't be streamed directly due to licensing restrictions
-	// Return the track ID for the streaming function to handle appropriately
-	return id, nil
+
+	return NewPlaylist(first.Title, first.Total, limit, pager)
 }
 
-func (sp *SpotifyProvider) GetRecommendations(genre string) ([]SearchResult, error) {
-	// Return mock recommendations based on genre
-	recommendations := map[string][]SearchResult{
-		"pop": {
-			{ID: "sp_rec_pop_1", Title: "Spotify Pop Song 1", Artist: "Spotify Pop Artist 1", Genre: "pop"},
-			{ID: "sp_rec_pop_2", Title: "Spotify Pop Song 2", Artist: "Spotify Pop Artist 2", Genre: "pop"},
-		},
-		"rock": {
-			{ID: "sp_rec_rock_1", Title: "Spotify Rock Song 1", Artist: "Spotify Rock Artist 1", Genre: "rock"},
-			{ID: "sp_rec_rock_2", Title: "Spotify Rock Song 2", Artist: "Spotify Rock Artist 2", Genre: "rock"},
-		},
-		"unknown": {
-			{ID: "sp_rec_default_1", Title: "Spotify Default Song 1", Artist: "Spotify Default Artist 1", Genre: "unknown"},
-		},
+// libraryPlaylist loads a playlist with the kkdai/youtube library in a single page
+func (yt *YouTubeProvider) libraryPlaylist(link string, limit int) (*Playlist, error) {
+	client := youtube.Client{}
+
+	playlist, err := client.GetPlaylist(link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playlist information: %w", err)
 	}
-	
-	if recs, exists := recommendations[genre]; exists {
-		return recs, nil
+
+	tracks := make([]SearchResult, 0, len(playlist.Videos))
+	for _, video := range playlist.Videos {
+		tracks = append(tracks, SearchResult{
+			ID:       video.ID,
+			Title:    video.Title,
+			Artist:   video.Author,
+			Duration: int(video.Duration.Seconds()),
+			Genre:    "unknown",
+			URL:      youtubeWatchURL(video.ID),
+		})
 	}
-	return recommendations["unknown"], nil
+
+	pager := func() ([]SearchResult, bool, error) {
+		return tracks, false, nil
+	}
+	return NewPlaylist(playlist.Title, len(tracks), limit, pager)
 }
 
-func (sp *SpotifyProvider) refreshToken() error {
-	// Implementation for refreshing Spotify access token
-	return nil
+// youtubePlaylistID returns the list ID of a YouTube playlist link, or "" if link isn't one
+func youtubePlaylistID(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+
+	switch strings.ToLower(u.Hostname()) {
+	case "youtube.com", "www.youtube.com", "m.youtube.com", "music.youtube.com":
+	default:
+		return ""
+	}
+
+	if strings.TrimSuffix(u.Path, "/") != "/playlist" {
+		return ""
+	}
+	return u.Query().Get("list")
 }
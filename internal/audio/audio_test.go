@@ -1,11 +1,85 @@
 package audio
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf16"
 )
 
+// fakeYTDLP answers yt-dlp invocations from canned data so tests never touch the network.
+// Every invocation's arguments are appended to $FAKE_YTDLP_LOG.
+const fakeYTDLP = `#!/bin/sh
+echo "$@" >> "$FAKE_YTDLP_LOG"
+for arg in "$@"; do last="$arg"; done
+case "$last" in
+  *unavailable*) echo "ERROR: [youtube] unavailable: Video unavailable" >&2; exit 1 ;;
+esac
+case "$*" in
+  *--get-url*)
+    echo "https://rr1---sn-fake.googlevideo.com/videoplayback?id=${last##*=}&mime=audio%2Fwebm"
+    echo "https://rr1---sn-fake.googlevideo.com/videoplayback?id=${last##*=}&mime=video%2Fmp4"
+    ;;
+  *ytsearch*)
+    echo '{"id": "aaaaaaaaaaa", "title": "First Result", "channel": "Channel One", "duration": 213.0, "thumbnails": [{"url": "https://i.ytimg.com/vi/aaaaaaaaaaa/default.jpg"}, {"url": "https://i.ytimg.com/vi/aaaaaaaaaaa/hqdefault.jpg"}]}'
+    echo '{"id": "bbbbbbbbbbb", "title": "Second Result", "uploader": "Uploader Two", "duration": 95}'
+    ;;
+  *--dump-single-json*)
+    # Flat playlist of 150 entries, answering only the requested --playlist-items range
+    items=$(echo "$*" | sed 's/.*--playlist-items \([0-9]*:[0-9]*\).*/\1/')
+    i=${items%:*}; end=${items#*:}
+    [ "$end" -gt 150 ] && end=150
+    printf '{"title": "Fake Playlist", "playlist_count": 150, "entries": ['
+    sep=""
+    while [ "$i" -le "$end" ]; do
+      printf '%s{"id": "video%06d", "title": "Entry %d", "channel": "Channel", "duration": 100}' "$sep" "$i" "$i"
+      sep=","; i=$((i+1))
+    done
+    echo ']}'
+    ;;
+  *--dump-json*)
+    echo '{"id": "dQw4w9WgXcQ", "title": "Never Gonna Give You Up", "uploader": "Rick Astley", "duration": 212, "thumbnail": "https://i.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg", "webpage_url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}'
+    ;;
+  *)
+    echo "unexpected arguments: $*" >&2; exit 2
+    ;;
+esac
+`
+
+// installFakeYTDLP puts the fake yt-dlp first on PATH and returns the path of its argument log
+func installFakeYTDLP(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "yt-dlp"), []byte(fakeYTDLP), 0755); err != nil {
+		t.Fatalf("Failed to write fake yt-dlp: %v", err)
+	}
+
+	logPath := filepath.Join(dir, "calls.log")
+	t.Setenv("FAKE_YTDLP_LOG", logPath)
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return logPath
+}
+
 func TestYouTubeProvider(t *testing.T) {
-	// Test with no API key (should return mock data)
+	// Test with no API key and no yt-dlp (should return mock data)
+	t.Setenv("PATH", t.TempDir())
 	yt := NewYouTubeProvider("")
 	
 	results, err := yt.Search("test query")
@@ -32,6 +106,7 @@ func TestYouTubeProvider(t *testing.T) {
 }
 
 func TestYouTubeStreamURL(t *testing.T) {
+	installFakeYTDLP(t)
 	yt := NewYouTubeProvider("")
 	
 	// Test with mock ID
@@ -48,10 +123,14 @@ func TestYouTubeStreamURL(t *testing.T) {
 	if err != nil {
 		t.Errorf("GetStreamURL failed for YouTube ID: %v", err)
 	}
-	// With the YouTube library, we now get actual stream URLs, not just the ID
+	// With yt-dlp, we now get actual stream URLs, not just the ID
 	if url == "" {
 		t.Error("Expected non-empty stream URL for YouTube ID")
 	}
+	// Only the first (audio) URL should be used
+	if !strings.Contains(url, "mime=audio") {
+		t.Errorf("Expected the audio stream URL, got: %s", url)
+	}
 	
 	// Test with empty ID
 	_, err = yt.GetStreamURL("")
@@ -82,6 +161,128 @@ func TestYouTubeRecommendations(t *testing.T) {
 	}
 }
 
+func TestYTDLPSearch(t *testing.T) {
+	logPath := installFakeYTDLP(t)
+	yt := NewYouTubeProvider("")
+
+	results, err := yt.Search("rick astley")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results from yt-dlp, got %d", len(results))
+	}
+
+	first := results[0]
+	if first.ID != "aaaaaaaaaaa" || first.Title != "First Result" {
+		t.Errorf("Unexpected first result: %+v", first)
+	}
+	if first.Artist != "Channel One" {
+		t.Errorf("Expected channel to be used as artist, got %s", first.Artist)
+	}
+	if first.Duration != 213 {
+		t.Errorf("Expected duration 213, got %d", first.Duration)
+	}
+	if first.Thumbnail != "https://i.ytimg.com/vi/aaaaaaaaaaa/hqdefault.jpg" {
+		t.Errorf("Expected the highest quality thumbnail, got %s", first.Thumbnail)
+	}
+	if first.URL != "https://www.youtube.com/watch?v=aaaaaaaaaaa" {
+		t.Errorf("Expected a watch URL for the flat search entry, got %s", first.URL)
+	}
+	if results[1].Artist != "Uploader Two" {
+		t.Errorf("Expected uploader to be used as artist, got %s", results[1].Artist)
+	}
+
+	calls, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read fake yt-dlp log: %v", err)
+	}
+	if !strings.Contains(string(calls), "ytsearch5:rick astley") {
+		t.Errorf("Expected a ytsearch5 query, got calls: %s", calls)
+	}
+}
+
+func TestYTDLPMetadata(t *testing.T) {
+	installFakeYTDLP(t)
+	yt := NewYouTubeProvider("")
+
+	info, err := yt.GetMetadata("dQw4w9WgXcQ")
+	if err != nil {
+		t.Fatalf("GetMetadata failed: %v", err)
+	}
+
+	if info.Title != "Never Gonna Give You Up" || info.Artist != "Rick Astley" {
+		t.Errorf("Unexpected metadata: %+v", info)
+	}
+	if info.Duration != 212 {
+		t.Errorf("Expected duration 212, got %d", info.Duration)
+	}
+	if info.Thumbnail == "" {
+		t.Error("Expected a thumbnail")
+	}
+}
+
+func TestYTDLPErrors(t *testing.T) {
+	installFakeYTDLP(t)
+	ytdlp := NewYTDLP("")
+
+	_, err := ytdlp.StreamURL("unavailable")
+	if err == nil || !strings.Contains(err.Error(), "Video unavailable") {
+		t.Errorf("Expected yt-dlp's error message to be surfaced, got: %v", err)
+	}
+
+	missing := NewYTDLP(filepath.Join(t.TempDir(), "missing-yt-dlp"))
+	if missing.Available() {
+		t.Error("Expected missing binary to be unavailable")
+	}
+	if _, err := missing.Search("test", 5); !errors.Is(err, ErrYTDLPNotFound) {
+		t.Errorf("Expected ErrYTDLPNotFound, got: %v", err)
+	}
+}
+
+func TestYouTubeResolverOrder(t *testing.T) {
+	installFakeYTDLP(t)
+	yt := NewYouTubeProvider("")
+
+	// With the library first, searches use the Data API path (mock data without a key)
+	if err := yt.SetResolverOrder([]string{ResolverLibrary, ResolverYTDLP}); err != nil {
+		t.Fatalf("SetResolverOrder failed: %v", err)
+	}
+	results, err := yt.Search("test")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) == 0 || results[0].ID == "aaaaaaaaaaa" {
+		t.Errorf("Expected library results first, got: %+v", results)
+	}
+
+	// A missing yt-dlp falls back to the library
+	yt.SetYTDLP(filepath.Join(t.TempDir(), "missing-yt-dlp"))
+	if err := yt.SetResolverOrder(DefaultYouTubeResolvers); err != nil {
+		t.Fatalf("SetResolverOrder failed: %v", err)
+	}
+	results, err = yt.Search("test")
+	if err != nil || len(results) == 0 {
+		t.Errorf("Expected fallback to library results, got %v (err: %v)", results, err)
+	}
+
+	// yt-dlp only must report a failure rather than falling back
+	if err := yt.SetResolverOrder([]string{ResolverYTDLP}); err != nil {
+		t.Fatalf("SetResolverOrder failed: %v", err)
+	}
+	if _, err := yt.GetStreamURL("dQw4w9WgXcQ"); !errors.Is(err, ErrYTDLPNotFound) {
+		t.Errorf("Expected ErrYTDLPNotFound, got: %v", err)
+	}
+
+	if err := yt.SetResolverOrder([]string{"invidious"}); err == nil {
+		t.Error("Expected unknown resolver to be rejected")
+	}
+	if err := yt.SetResolverOrder(nil); err == nil {
+		t.Error("Expected empty resolver order to be rejected")
+	}
+}
+
 func TestSpotifyProvider(t *testing.T) {
 	// Test with no API key (should return mock data)
 	sp := NewSpotifyProvider("")
@@ -121,13 +322,10 @@ func TestSpotifyStreamURL(t *testing.T) {
 		t.Errorf("Expected mock ID to be returned as-is, got: %s", url)
 	}
 	
-	// Test with real Spotify ID
-	url, err = sp.GetStreamURL("4iV5W9uYEdYUVa79Axb7Rh")
-	if err != nil {
-		t.Errorf("GetStreamURL failed for Spotify ID: %v", err)
-	}
-	if url != "4iV5W9uYEdYUVa79Axb7Rh" {
-		t.Errorf("Expected Spotify ID to be returned as-is, got: %s", url)
+	// Real Spotify IDs need a mirror to be played
+	_, err = sp.GetStreamURL("4iV5W9uYEdYUVa79Axb7Rh")
+	if !errors.Is(err, ErrNoMirror) {
+		t.Errorf("Expected ErrNoMirror without a mirror provider, got: %v", err)
 	}
 	
 	// Test with empty ID
@@ -158,3 +356,1330 @@ func TestSpotifyRecommendations(t *testing.T) {
 		t.Error("Expected default recommendations for unknown genre")
 	}
 }
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+
+	if err := registry.Register(ProviderInfo{Prefix: "yt", Name: "YouTube", Provider: NewYouTubeProvider(""), Capabilities: CapSearch | CapStream}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := registry.Register(ProviderInfo{Prefix: "sp", Provider: NewSpotifyProvider(""), Capabilities: CapSearch | CapRecommendations}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	invalid := []ProviderInfo{
+		{Prefix: "yt", Provider: NewYouTubeProvider("")}, // duplicate
+		{Prefix: "", Provider: NewYouTubeProvider("")},
+		{Prefix: "Y:T", Provider: NewYouTubeProvider("")},
+		{Prefix: "nil"},
+	}
+	for _, info := range invalid {
+		if err := registry.Register(info); err == nil {
+			t.Errorf("Expected registration of %+v to fail", info)
+		}
+	}
+
+	if sp, _ := registry.Get("sp"); sp.Name != "sp" {
+		t.Errorf("Expected name to default to prefix, got %q", sp.Name)
+	}
+
+	if got := registry.Prefixes(CapSearch); len(got) != 2 || got[0] != "yt" || got[1] != "sp" {
+		t.Errorf("Expected searchable prefixes in registration order, got %v", got)
+	}
+	if got := registry.Prefixes(CapStream); len(got) != 1 || got[0] != "yt" {
+		t.Errorf("Expected only yt to stream, got %v", got)
+	}
+
+	tests := []struct {
+		query, defaultPrefix string
+		wantPrefix, wantRest string
+	}{
+		{"sp:shape of you", "yt", "sp", "shape of you"},
+		{"YT: never gonna", "sp", "yt", "never gonna"},
+		{"plain query", "sp", "sp", "plain query"},
+		{"https://example.com/a.mp3", "yt", "yt", "https://example.com/a.mp3"},
+	}
+	for _, tt := range tests {
+		info, rest, err := registry.Resolve(tt.query, tt.defaultPrefix)
+		if err != nil {
+			t.Errorf("Resolve(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if info.Prefix != tt.wantPrefix || rest != tt.wantRest {
+			t.Errorf("Resolve(%q) = %s, %q; want %s, %q", tt.query, info.Prefix, rest, tt.wantPrefix, tt.wantRest)
+		}
+	}
+
+	if _, _, err := registry.Resolve("query", "xx"); err == nil {
+		t.Error("Expected unknown default prefix to fail")
+	}
+}
+
+// fakeSpotify stands in for the Spotify accounts and Web API servers
+type fakeSpotify struct {
+	server        *httptest.Server
+	tokenRequests atomic.Int32
+	apiRequests   atomic.Int32
+	expiresIn     int
+	rejectToken   atomic.Value // Access token the API answers 401 for
+}
+
+func newFakeSpotify(t *testing.T) *fakeSpotify {
+	t.Helper()
+
+	fake := &fakeSpotify{expiresIn: 3600}
+	fake.rejectToken.Store("")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if r.Method != http.MethodPost || !ok || id != "client-id" || secret != "client-secret" {
+			http.Error(w, `{"error": "invalid_client"}`, http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			http.Error(w, `{"error": "unsupported_grant_type"}`, http.StatusBadRequest)
+			return
+		}
+
+		n := fake.tokenRequests.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   fake.expiresIn,
+		})
+	})
+	mux.HandleFunc("/v1/", func(w http.ResponseWriter, r *http.Request) {
+		fake.apiRequests.Add(1)
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == fake.rejectToken.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"status": 401, "message": "The access token expired"}}`))
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/v1/search" && r.URL.Query().Get("type") == "track":
+			fmt.Fprint(w, `{"tracks": {"items": [
+				{"id": "4iV5W9uYEdYUVa79Axb7Rh", "name": "Shape of You", "duration_ms": 233712,
+				 "artists": [{"name": "Ed Sheeran"}],
+				 "album": {"images": [{"url": "https://i.scdn.co/image/large"}, {"url": "https://i.scdn.co/image/small"}]},
+				 "external_urls": {"spotify": "https://open.spotify.com/track/4iV5W9uYEdYUVa79Axb7Rh?si=share"}},
+				{"id": "7qiZfU4dY1lWllzX7mPBI3", "name": "Blinding Lights", "duration_ms": 200040,
+				 "artists": [{"name": "The Weeknd"}, {"name": "Guest"}], "album": {"images": []}}
+			]}}`)
+		case r.URL.Path == "/v1/playlists/37i9dQZF1DXcBWIGoYBM5M":
+			fmt.Fprint(w, `{"name": "Today's Top Hits", "tracks": {"total": 4}}`)
+		case r.URL.Path == "/v1/playlists/37i9dQZF1DXcBWIGoYBM5M/tracks" && r.URL.Query().Get("offset") == "0":
+			fmt.Fprint(w, `{"items": [
+				{"track": {"id": "4iV5W9uYEdYUVa79Axb7Rh", "type": "track", "name": "Shape of You", "duration_ms": 233712, "artists": [{"name": "Ed Sheeran"}]}},
+				{"track": null}
+			], "next": "https://api.spotify.com/v1/playlists/37i9dQZF1DXcBWIGoYBM5M/tracks?offset=2&limit=100"}`)
+		case r.URL.Path == "/v1/playlists/37i9dQZF1DXcBWIGoYBM5M/tracks" && r.URL.Query().Get("offset") == "2":
+			fmt.Fprint(w, `{"items": [
+				{"track": {"id": "5Q0Nhxo0l2bP3pNjpGJwV1", "type": "episode", "name": "A Podcast Episode", "duration_ms": 1800000}},
+				{"track": {"id": "7qiZfU4dY1lWllzX7mPBI3", "type": "track", "name": "Blinding Lights", "duration_ms": 200040, "artists": [{"name": "The Weeknd"}]}}
+			], "next": null}`)
+		case r.URL.Path == "/v1/albums/3T4tUhGYeRNVUGevb0wThu":
+			fmt.Fprint(w, `{"name": "÷ (Deluxe)", "total_tracks": 2, "images": [{"url": "https://i.scdn.co/image/divide"}]}`)
+		case r.URL.Path == "/v1/albums/3T4tUhGYeRNVUGevb0wThu/tracks":
+			fmt.Fprint(w, `{"items": [
+				{"id": "6PCUP3dWmTjcTtXY02oFdT", "type": "track", "name": "Eraser", "duration_ms": 227426, "artists": [{"name": "Ed Sheeran"}]},
+				{"id": "4iV5W9uYEdYUVa79Axb7Rh", "type": "track", "name": "Shape of You", "duration_ms": 233712, "artists": [{"name": "Ed Sheeran"}]}
+			], "next": null}`)
+		case r.URL.Path == "/v1/tracks/0VjIjW4GlUZAMYd2vXMi3b":
+			fmt.Fprint(w, `{"id": "0VjIjW4GlUZAMYd2vXMi3b", "name": "Blinding Lights", "duration_ms": 200040, "artists": [{"name": "The Weeknd"}], "album": {"images": []}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"status": 404, "message": "Not found"}}`))
+		}
+	})
+
+	fake.server = httptest.NewServer(mux)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// provider returns a SpotifyProvider using the fake servers with valid credentials
+func (f *fakeSpotify) provider() *SpotifyProvider {
+	sp := NewSpotifyProvider("")
+	sp.SetBaseURL(f.server.URL+"/v1", f.server.URL)
+	sp.SetCredentials("client-id", "client-secret")
+	return sp
+}
+
+// fakeMirror records searches and answers them with fixed candidates
+type fakeMirror struct {
+	candidates []SearchResult
+	searches   []string
+}
+
+func (m *fakeMirror) Search(query string) ([]SearchResult, error) {
+	m.searches = append(m.searches, query)
+	return m.candidates, nil
+}
+
+func (m *fakeMirror) GetStreamURL(id string) (string, error) {
+	return "https://audio.example/" + id, nil
+}
+
+func (m *fakeMirror) GetRecommendations(genre string) ([]SearchResult, error) {
+	return nil, nil
+}
+
+func TestSpotifySearch(t *testing.T) {
+	fake := newFakeSpotify(t)
+	sp := fake.provider()
+
+	results, err := sp.Search("shape of you")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d", len(results))
+	}
+
+	want := SearchResult{ID: "4iV5W9uYEdYUVa79Axb7Rh", Title: "Shape of You", Artist: "Ed Sheeran", Duration: 234, Genre: "unknown", Thumbnail: "https://i.scdn.co/image/large", URL: "https://open.spotify.com/track/4iV5W9uYEdYUVa79Axb7Rh?si=share"}
+	if results[0] != want {
+		t.Errorf("Unexpected first result:\n got %+v\nwant %+v", results[0], want)
+	}
+	if results[1].Artist != "The Weeknd, Guest" || results[1].Thumbnail != "" || results[1].URL != "https://open.spotify.com/track/7qiZfU4dY1lWllzX7mPBI3" {
+		t.Errorf("Unexpected second result: %+v", results[1])
+	}
+}
+
+func TestSpotifyTokenCaching(t *testing.T) {
+	fake := newFakeSpotify(t)
+	sp := fake.provider()
+
+	for i := 0; i < 3; i++ {
+		if _, err := sp.Search("query"); err != nil {
+			t.Fatalf("Search %d failed: %v", i, err)
+		}
+	}
+	if n := fake.tokenRequests.Load(); n != 1 {
+		t.Errorf("Expected the access token to be requested once, got %d", n)
+	}
+
+	// A token the API rejects is renewed once and the request retried
+	fake.rejectToken.Store("token-1")
+	if _, err := sp.Search("query"); err != nil {
+		t.Fatalf("Search after token rejection failed: %v", err)
+	}
+	if n := fake.tokenRequests.Load(); n != 2 {
+		t.Errorf("Expected a token refresh after a 401, got %d token requests", n)
+	}
+
+	// Tokens inside the expiry margin are renewed before use
+	fake.expiresIn = 30
+	sp.SetCredentials("client-id", "client-secret")
+	for i := 0; i < 2; i++ {
+		if _, err := sp.Search("query"); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+	}
+	if n := fake.tokenRequests.Load(); n != 4 {
+		t.Errorf("Expected expired tokens to be renewed on every use, got %d token requests", n)
+	}
+}
+
+func TestSpotifyAuthErrors(t *testing.T) {
+	fake := newFakeSpotify(t)
+
+	sp := fake.provider()
+	sp.SetCredentials("client-id", "wrong-secret")
+	if _, err := sp.Search("query"); err == nil || !strings.Contains(err.Error(), "client credentials") {
+		t.Errorf("Expected a client credentials error, got: %v", err)
+	}
+
+	// A static token that is rejected can't be renewed
+	static := NewSpotifyProvider("expired")
+	static.SetBaseURL(fake.server.URL+"/v1", fake.server.URL)
+	fake.rejectToken.Store("expired")
+	if _, err := static.Search("query"); err == nil || !strings.Contains(err.Error(), "The access token expired") {
+		t.Errorf("Expected the API error message, got: %v", err)
+	}
+	if n := fake.tokenRequests.Load(); n != 0 {
+		t.Errorf("Expected no token requests for a static token, got %d", n)
+	}
+}
+
+func TestSpotifyMirror(t *testing.T) {
+	fake := newFakeSpotify(t)
+	sp := fake.provider()
+
+	mirror := &fakeMirror{candidates: []SearchResult{
+		{ID: "live", Title: "Ed Sheeran - Shape of You (Live at Wembley)", Artist: "Ed Sheeran", Duration: 290},
+		{ID: "cover", Title: "Shape of You (Cover)", Artist: "Someone Else", Duration: 233},
+		{ID: "official", Title: "Ed Sheeran - Shape of You [Official Video]", Artist: "Ed Sheeran", Duration: 239},
+	}}
+	sp.SetMirror(mirror)
+
+	if _, err := sp.Search("shape of you"); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	url, err := sp.GetStreamURL("4iV5W9uYEdYUVa79Axb7Rh")
+	if err != nil {
+		t.Fatalf("GetStreamURL failed: %v", err)
+	}
+	if url != "https://audio.example/official" {
+		t.Errorf("Expected the official upload to be picked, got %s", url)
+	}
+	if len(mirror.searches) != 1 || mirror.searches[0] != "Ed Sheeran - Shape of You" {
+		t.Errorf("Unexpected mirror searches: %v", mirror.searches)
+	}
+
+	// Matches are cached per track
+	apiRequests := fake.apiRequests.Load()
+	if _, err := sp.GetStreamURL("4iV5W9uYEdYUVa79Axb7Rh"); err != nil {
+		t.Fatalf("Cached GetStreamURL failed: %v", err)
+	}
+	if len(mirror.searches) != 1 || fake.apiRequests.Load() != apiRequests {
+		t.Error("Expected the cached match to be reused")
+	}
+
+	// Tracks not seen in a search are looked up first
+	mirror.candidates = []SearchResult{{ID: "weeknd", Title: "Blinding Lights", Artist: "The Weeknd", Duration: 201}}
+	url, err = sp.GetStreamURL("0VjIjW4GlUZAMYd2vXMi3b")
+	if err != nil {
+		t.Fatalf("GetStreamURL for unsearched track failed: %v", err)
+	}
+	if url != "https://audio.example/weeknd" {
+		t.Errorf("Unexpected stream URL %s", url)
+	}
+
+	// Candidates of the wrong length are rejected
+	mirror.candidates = []SearchResult{{ID: "extended", Title: "Blinding Lights (Extended)", Artist: "The Weeknd", Duration: 400}}
+	if _, err := sp.GetStreamURL("7qiZfU4dY1lWllzX7mPBI3"); err == nil {
+		t.Error("Expected no match when every candidate has the wrong length")
+	}
+}
+
+func TestPlaylistLinks(t *testing.T) {
+	tests := []struct {
+		link    string
+		youtube string // Expected list ID
+		kind    string // Expected Spotify collection kind
+		id      string
+	}{
+		{link: "https://www.youtube.com/playlist?list=PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG", youtube: "PLx0sYbCqOb8TBPRdmBHs5Iftvv9TPboYG"},
+		{link: "https://music.youtube.com/playlist?list=OLAK5uy_abc&si=xyz", youtube: "OLAK5uy_abc"},
+		{link: "https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLabc"},
+		{link: "https://example.com/playlist?list=PLabc"},
+		{link: "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M?si=abc", kind: "playlist", id: "37i9dQZF1DXcBWIGoYBM5M"},
+		{link: "https://open.spotify.com/intl-de/album/3T4tUhGYeRNVUGevb0wThu", kind: "album", id: "3T4tUhGYeRNVUGevb0wThu"},
+		{link: "spotify:playlist:37i9dQZF1DXcBWIGoYBM5M", kind: "playlist", id: "37i9dQZF1DXcBWIGoYBM5M"},
+		{link: "https://open.spotify.com/track/4iV5W9uYEdYUVa79Axb7Rh"},
+		{link: "never gonna give you up"},
+	}
+
+	for _, tt := range tests {
+		if got := youtubePlaylistID(tt.link); got != tt.youtube {
+			t.Errorf("youtubePlaylistID(%q) = %q, want %q", tt.link, got, tt.youtube)
+		}
+		kind, id, ok := spotifyCollection(tt.link)
+		if ok != (tt.kind != "") || kind != tt.kind || id != tt.id {
+			t.Errorf("spotifyCollection(%q) = %q, %q, %v; want %q, %q", tt.link, kind, id, ok, tt.kind, tt.id)
+		}
+	}
+}
+
+func TestPlaylistPaging(t *testing.T) {
+	pages := [][]SearchResult{
+		{{ID: "1"}, {ID: "2"}, {ID: "3"}},
+		{{ID: "4"}, {ID: "5"}, {ID: "6"}},
+		{{ID: "7"}},
+	}
+	requested := 0
+	pager := func() ([]SearchResult, bool, error) {
+		page := pages[requested]
+		requested++
+		return page, requested < len(pages), nil
+	}
+
+	playlist, err := NewPlaylist("Mix", 7, 5, pager)
+	if err != nil {
+		t.Fatalf("NewPlaylist failed: %v", err)
+	}
+	if len(playlist.Tracks) != 3 || requested != 1 {
+		t.Fatalf("Expected only the first page to be loaded, got %d tracks after %d requests", len(playlist.Tracks), requested)
+	}
+	if playlist.Limit() != 5 || !playlist.More() {
+		t.Errorf("Expected a limit of 5 with more to load, got %d (more: %v)", playlist.Limit(), playlist.More())
+	}
+
+	tracks, err := playlist.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if len(tracks) != 2 || tracks[1].ID != "5" {
+		t.Errorf("Expected the second page to be cut at the limit, got %+v", tracks)
+	}
+	if _, err := playlist.Next(); err != io.EOF {
+		t.Errorf("Expected io.EOF at the limit, got %v", err)
+	}
+	if requested != 2 {
+		t.Errorf("Expected no page requests past the limit, got %d", requested)
+	}
+}
+
+func TestYouTubePlaylist(t *testing.T) {
+	logPath := installFakeYTDLP(t)
+
+	yt := NewYouTubeProvider("")
+	if err := yt.SetResolverOrder([]string{ResolverYTDLP}); err != nil {
+		t.Fatalf("SetResolverOrder failed: %v", err)
+	}
+
+	link := "https://www.youtube.com/playlist?list=PLfake"
+	if !yt.IsPlaylistURL(link) {
+		t.Fatalf("Expected %s to be a playlist link", link)
+	}
+
+	playlist, err := yt.OpenPlaylist(link, 0)
+	if err != nil {
+		t.Fatalf("OpenPlaylist failed: %v", err)
+	}
+	if playlist.Title != "Fake Playlist" || playlist.Total != 150 || len(playlist.Tracks) != 100 {
+		t.Fatalf("Unexpected first page: %q, total %d, %d tracks", playlist.Title, playlist.Total, len(playlist.Tracks))
+	}
+	if first := playlist.Tracks[0]; first.ID != "video000001" || first.Artist != "Channel" || first.Duration != 100 {
+		t.Errorf("Unexpected first entry: %+v", first)
+	}
+
+	tracks, err := playlist.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if len(tracks) != 50 || tracks[49].ID != "video000150" {
+		t.Errorf("Expected the remaining 50 entries, got %d", len(tracks))
+	}
+	if playlist.More() {
+		t.Error("Expected the playlist to be exhausted")
+	}
+
+	calls, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read fake yt-dlp log: %v", err)
+	}
+	if !strings.Contains(string(calls), "--playlist-items 1:100") || !strings.Contains(string(calls), "--playlist-items 101:200") {
+		t.Errorf("Expected paged playlist requests, got:\n%s", calls)
+	}
+}
+
+func TestSpotifyPlaylistImport(t *testing.T) {
+	fake := newFakeSpotify(t)
+	sp := fake.provider()
+
+	playlist, err := sp.OpenPlaylist("https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M", 0)
+	if err != nil {
+		t.Fatalf("OpenPlaylist failed: %v", err)
+	}
+	if playlist.Title != "Today's Top Hits" || playlist.Total != 4 {
+		t.Errorf("Unexpected playlist %q with %d tracks", playlist.Title, playlist.Total)
+	}
+	if len(playlist.Tracks) != 1 || playlist.Tracks[0].Title != "Shape of You" {
+		t.Fatalf("Expected removed tracks to be skipped, got %+v", playlist.Tracks)
+	}
+
+	tracks, err := playlist.Next()
+	if err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	if len(tracks) != 1 || tracks[0].Title != "Blinding Lights" {
+		t.Errorf("Expected episodes to be skipped, got %+v", tracks)
+	}
+	if playlist.More() {
+		t.Error("Expected the playlist to be exhausted")
+	}
+
+	album, err := sp.OpenPlaylist("spotify:album:3T4tUhGYeRNVUGevb0wThu", 0)
+	if err != nil {
+		t.Fatalf("OpenPlaylist for album failed: %v", err)
+	}
+	if album.Title != "÷ (Deluxe)" || len(album.Tracks) != 2 {
+		t.Fatalf("Unexpected album %q with %d tracks", album.Title, len(album.Tracks))
+	}
+	for _, track := range album.Tracks {
+		if track.Thumbnail != "https://i.scdn.co/image/divide" {
+			t.Errorf("Expected album artwork on %s, got %q", track.Title, track.Thumbnail)
+		}
+	}
+
+	// Imported tracks can be mirrored without another lookup
+	mirror := &fakeMirror{candidates: []SearchResult{{ID: "eraser", Title: "Ed Sheeran - Eraser", Artist: "Ed Sheeran", Duration: 227}}}
+	sp.SetMirror(mirror)
+	apiRequests := fake.apiRequests.Load()
+	if _, err := sp.GetStreamURL("6PCUP3dWmTjcTtXY02oFdT"); err != nil {
+		t.Fatalf("GetStreamURL for album track failed: %v", err)
+	}
+	if fake.apiRequests.Load() != apiRequests {
+		t.Error("Expected imported track metadata to be reused")
+	}
+
+	if _, err := NewSpotifyProvider("").OpenPlaylist("spotify:album:3T4tUhGYeRNVUGevb0wThu", 0); err == nil {
+		t.Error("Expected playlist import without credentials to fail")
+	}
+}
+
+// id3Frame builds an ID3v2.3 text frame
+func id3Frame(id string, encoding byte, text []byte) []byte {
+	frame := []byte(id)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(text)+1))
+	frame = append(frame, 0, 0, encoding)
+	return append(frame, text...)
+}
+
+// id3File builds an MP3 file holding only an ID3v2.3 tag with the given frames
+func id3File(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	size := len(body)
+	header := []byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+	// A few bytes standing in for the MPEG frames
+	return append(append(header, body...), 0xff, 0xfb, 0x90, 0x00)
+}
+
+// utf16Text encodes s as UTF-16 with a little-endian byte order mark
+func utf16Text(s string) []byte {
+	text := []byte{0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(s)) {
+		text = binary.LittleEndian.AppendUint16(text, unit)
+	}
+	return append(text, 0, 0)
+}
+
+// flacFile builds a FLAC file with STREAMINFO and Vorbis comment blocks
+func flacFile(sampleRate int, samples int64, comments ...string) []byte {
+	streamInfo := make([]byte, 34)
+	streamInfo[10] = byte(sampleRate >> 12)
+	streamInfo[11] = byte(sampleRate >> 4)
+	streamInfo[12] = byte(sampleRate<<4) | 1<<1 // Stereo
+	streamInfo[13] = byte(15<<4) | byte(samples>>32&0x0f)
+	binary.BigEndian.PutUint32(streamInfo[14:18], uint32(samples))
+
+	vendor := "reference libFLAC 1.4.3"
+	comment := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	comment = append(comment, vendor...)
+	comment = binary.LittleEndian.AppendUint32(comment, uint32(len(comments)))
+	for _, c := range comments {
+		comment = binary.LittleEndian.AppendUint32(comment, uint32(len(c)))
+		comment = append(comment, c...)
+	}
+
+	file := []byte("fLaC")
+	for _, block := range []struct {
+		header byte
+		data   []byte
+	}{{0, streamInfo}, {0x80 | 4, comment}} {
+		file = append(file, block.header, byte(len(block.data)>>16), byte(len(block.data)>>8), byte(len(block.data)))
+		file = append(file, block.data...)
+	}
+	return file
+}
+
+// wavFile builds a 16-bit mono WAV file of the given length with INFO tags
+func wavFile(sampleRate int, duration time.Duration, info map[string]string) []byte {
+	chunk := func(id string, data []byte) []byte {
+		out := binary.LittleEndian.AppendUint32([]byte(id), uint32(len(data)))
+		out = append(out, data...)
+		if len(data)%2 == 1 {
+			out = append(out, 0)
+		}
+		return out
+	}
+
+	format := binary.LittleEndian.AppendUint16(nil, 1) // PCM
+	format = binary.LittleEndian.AppendUint16(format, 1)
+	format = binary.LittleEndian.AppendUint32(format, uint32(sampleRate))
+	format = binary.LittleEndian.AppendUint32(format, uint32(sampleRate*2))
+	format = binary.LittleEndian.AppendUint16(format, 2)
+	format = binary.LittleEndian.AppendUint16(format, 16)
+
+	list := []byte("INFO")
+	for id, value := range info {
+		list = append(list, chunk(id, append([]byte(value), 0))...)
+	}
+
+	body := []byte("WAVE")
+	body = append(body, chunk("fmt ", format)...)
+	body = append(body, chunk("LIST", list)...)
+	body = append(body, chunk("data", make([]byte, int(duration.Seconds()*float64(sampleRate*2))))...)
+	return append(binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body))), body...)
+}
+
+func TestReadTags(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name string
+		data []byte
+		want Tags
+	}{
+		{
+			name: "utf16.mp3",
+			data: id3File(
+				id3Frame("TIT2", 1, utf16Text("Déjà Vu")),
+				id3Frame("TPE1", 0, []byte("Beyonc\xe9")), // ISO-8859-1
+				id3Frame("TALB", 3, []byte("B'Day")),
+				id3Frame("TCON", 0, []byte("(17)")),
+				id3Frame("TLEN", 0, []byte("240500")),
+			),
+			want: Tags{Title: "Déjà Vu", Artist: "Beyoncé", Album: "B'Day", Genre: "Rock", Duration: 240500 * time.Millisecond},
+		},
+		{
+			name: "refined.mp3",
+			data: id3File(id3Frame("TIT2", 3, []byte("Song\x00Alternate")), id3Frame("TCON", 3, []byte("(36)Chiptune"))),
+			want: Tags{Title: "Song", Genre: "Chiptune"},
+		},
+		{
+			name: "album.flac",
+			data: flacFile(44100, 44100*185, "TITLE=Gerudo Valley", "artist=Koji Kondo", "ALBUM=Ocarina of Time", "GENRE=Soundtrack", "COMMENT=ignored"),
+			want: Tags{Title: "Gerudo Valley", Artist: "Koji Kondo", Album: "Ocarina of Time", Genre: "Soundtrack", Duration: 185 * time.Second},
+		},
+		{
+			name: "take.wav",
+			data: wavFile(8000, 3*time.Second, map[string]string{"INAM": "Take One", "IART": "Studio", "IGNR": "Demo"}),
+			want: Tags{Title: "Take One", Artist: "Studio", Genre: "Demo", Duration: 3 * time.Second},
+		},
+		{name: "untagged.mp3", data: []byte{0xff, 0xfb, 0x90, 0x00}},
+		{name: "other.ogg", data: []byte("OggS")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			tags, err := ReadTags(path)
+			if err != nil {
+				t.Fatalf("ReadTags failed: %v", err)
+			}
+			if tags != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, tags)
+			}
+		})
+	}
+
+	if _, err := ReadTags(filepath.Join(dir, "missing.mp3")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+// fakeFFprobe answers like ffprobe for Ogg files, whose tags are on the stream
+const fakeFFprobe = `#!/bin/sh
+for arg in "$@"; do last="$arg"; done
+case "$last" in
+  *.ogg) echo '{"format": {"duration": "95.250000"}, "streams": [{"tags": {"TITLE": "Overworld", "ARTIST": "Chip Band", "GENRE": "Chiptune"}}]}' ;;
+  *) echo '{"format": {}}' ;;
+esac
+`
+
+// writeLibraryFile writes a file into a test library, creating its folders
+func writeLibraryFile(t *testing.T, dir, name string, data []byte) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalProvider(t *testing.T) {
+	bin := t.TempDir()
+	ffprobe := filepath.Join(bin, "ffprobe")
+	if err := os.WriteFile(ffprobe, []byte(fakeFFprobe), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	writeLibraryFile(t, dir, "Zelda/Gerudo Valley.flac", flacFile(48000, 48000*185, "TITLE=Gerudo Valley", "ARTIST=Koji Kondo", "ALBUM=Ocarina of Time", "GENRE=Soundtrack"))
+	writeLibraryFile(t, dir, "Zelda/Song of Storms.mp3", id3File(id3Frame("TIT2", 3, []byte("Song of Storms")), id3Frame("TPE1", 3, []byte("Koji Kondo")), id3Frame("TCON", 3, []byte("Soundtrack"))))
+	writeLibraryFile(t, dir, "chip/overworld.ogg", []byte("OggS"))
+	writeLibraryFile(t, dir, "Licensed Band - Victory Lap.m4a", []byte("ftyp"))
+	writeLibraryFile(t, dir, "notes.txt", []byte("not audio"))
+	writeLibraryFile(t, dir, ".trash/Deleted Song.mp3", id3File(id3Frame("TIT2", 3, []byte("Deleted Song"))))
+
+	library := NewLocalProvider(dir)
+	library.SetFFprobe(ffprobe)
+	changes, err := library.Scan()
+	if err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if changes != 4 || library.Len() != 4 {
+		t.Fatalf("Expected 4 tracks indexed, got %d changes and %d tracks: %+v", changes, library.Len(), library.Tracks())
+	}
+
+	tracks := map[string]LocalTrack{}
+	for _, track := range library.Tracks() {
+		tracks[track.Path] = track
+	}
+	if got := tracks["chip/overworld.ogg"]; got.Title != "Overworld" || got.Artist != "Chip Band" || got.Duration != 95250*time.Millisecond {
+		t.Errorf("Expected probed Ogg tags, got %+v", got)
+	}
+	if got := tracks["Licensed Band - Victory Lap.m4a"]; got.Title != "Victory Lap" || got.Artist != "Licensed Band" {
+		t.Errorf("Expected tags from the file name, got %+v", got)
+	}
+
+	searches := []struct {
+		query string
+		want  []string
+	}{
+		{"gerudo", []string{"Zelda/Gerudo Valley.flac"}},
+		{"kondo storms", []string{"Zelda/Song of Storms.mp3"}},
+		{"xylophone", nil},
+		{"stroms", []string{"Zelda/Song of Storms.mp3"}}, // One typo
+		{"koji", []string{"Zelda/Gerudo Valley.flac", "Zelda/Song of Storms.mp3"}},
+		{"zelda", []string{"Zelda/Gerudo Valley.flac", "Zelda/Song of Storms.mp3"}}, // Folder name
+		{"victory lap", []string{"Licensed Band - Victory Lap.m4a"}},
+		{"chiptune", []string{"chip/overworld.ogg"}},
+		{"deleted", nil},
+	}
+	for _, search := range searches {
+		results, err := library.Search(search.query)
+		if err != nil {
+			t.Fatalf("Search(%q) failed: %v", search.query, err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.ID)
+		}
+		sort.Strings(got)
+		if strings.Join(got, "|") != strings.Join(search.want, "|") {
+			t.Errorf("Search(%q): expected %v, got %v", search.query, search.want, got)
+		}
+	}
+
+	// Title matches rank above other fields
+	writeLibraryFile(t, dir, "Storms/Calm.mp3", id3File(id3Frame("TIT2", 3, []byte("Calm"))))
+	library.Scan()
+	if results, _ := library.Search("storms"); len(results) != 2 || results[0].ID != "Zelda/Song of Storms.mp3" {
+		t.Errorf("Expected the title match first, got %+v", results)
+	}
+	if _, err := library.Search("  "); err == nil {
+		t.Error("Expected an empty search to fail")
+	}
+
+	path, err := library.GetStreamURL("Zelda/Gerudo Valley.flac")
+	if err != nil || path != filepath.Join(dir, "Zelda", "Gerudo Valley.flac") {
+		t.Errorf("Expected the file's path, got %q, %v", path, err)
+	}
+	for _, id := range []string{"notes.txt", "../secret.mp3", ".trash/Deleted Song.mp3"} {
+		if _, err := library.GetStreamURL(id); err == nil {
+			t.Errorf("Expected GetStreamURL(%q) to fail", id)
+		}
+	}
+
+	recs, err := library.GetRecommendations("soundtrack")
+	if err != nil || len(recs) != 2 {
+		t.Fatalf("Expected both soundtrack tracks, got %+v, %v", recs, err)
+	}
+	for _, rec := range recs {
+		if rec.Genre != "Soundtrack" {
+			t.Errorf("Expected a soundtrack, got %+v", rec)
+		}
+	}
+	if recs, _ := library.GetRecommendations("polka"); len(recs) != 5 {
+		t.Errorf("Expected picks from the whole library for an unknown genre, got %d", len(recs))
+	}
+
+	// Rescans pick up added, changed and removed files and skip the rest
+	os.Remove(filepath.Join(dir, "Storms", "Calm.mp3"))
+	os.Remove(filepath.Join(dir, "chip", "overworld.ogg"))
+	writeLibraryFile(t, dir, "Zelda/Song of Storms.mp3", id3File(id3Frame("TIT2", 3, []byte("Song of Storms (Remix)"))))
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "Zelda", "Song of Storms.mp3"), future, future)
+	writeLibraryFile(t, dir, "new/Fanfare.wav", wavFile(8000, time.Second, map[string]string{"INAM": "Fanfare"}))
+
+	if changes, err := library.Scan(); err != nil || changes != 4 {
+		t.Fatalf("Expected 4 changes, got %d, %v", changes, err)
+	}
+	if results, _ := library.Search("remix"); len(results) != 1 {
+		t.Errorf("Expected the changed file to be read again, got %+v", results)
+	}
+	if results, _ := library.Search("fanfare"); len(results) != 1 || results[0].Duration != 1 {
+		t.Errorf("Expected the added file to be found, got %+v", results)
+	}
+	if _, err := library.GetStreamURL("chip/overworld.ogg"); err == nil {
+		t.Error("Expected a removed file to be dropped")
+	}
+	if changes, _ := library.Scan(); changes != 0 {
+		t.Errorf("Expected no changes on an unchanged library, got %d", changes)
+	}
+
+	if _, err := NewLocalProvider(filepath.Join(dir, "missing")).Scan(); err == nil {
+		t.Error("Expected scanning a missing directory to fail")
+	}
+}
+
+func TestLocalProviderWatch(t *testing.T) {
+	dir := t.TempDir()
+	library := NewLocalProvider(dir)
+	library.SetFFprobe("")
+	if _, err := library.Scan(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go library.Watch(done, 10*time.Millisecond)
+
+	writeLibraryFile(t, dir, "Artist - Added Later.mp3", id3File())
+	deadline := time.Now().Add(5 * time.Second)
+	for library.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the watcher to index the added file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if results, _ := library.Search("added"); len(results) != 1 || results[0].Artist != "Artist" {
+		t.Errorf("Expected the added file to be searchable, got %+v", results)
+	}
+}
+
+// icyBlock encodes a StreamTitle as an ICY metadata block with its length byte
+func icyBlock(title string) []byte {
+	if title == "" {
+		return []byte{0}
+	}
+	text := []byte("StreamTitle='" + title + "';StreamUrl='';")
+	blocks := (len(text) + 15) / 16
+	text = append(text, make([]byte, blocks*16-len(text))...)
+	return append([]byte{byte(blocks)}, text...)
+}
+
+// icyMetaint is how many bytes of audio the fake radio sends between metadata blocks
+const icyMetaint = 16
+
+// newFakeStreams serves the kinds of links the stream provider plays: an
+// audio file, an Icecast-like radio station sending titles, playlists of
+// them and pages that aren't audio
+func newFakeStreams(t *testing.T, titles ...string) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/song.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(bytes.Repeat([]byte{0xff}, 1024))
+	})
+	mux.HandleFunc("/radio", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-name", "Test FM")
+		w.Header().Set("icy-genre", "Jazz")
+		w.Header().Set("icy-description", "Smooth tunes all day")
+		metadata := r.Header.Get("Icy-MetaData") == "1"
+		if metadata {
+			w.Header().Set("icy-metaint", strconv.Itoa(icyMetaint))
+		}
+		w.WriteHeader(http.StatusOK)
+		for _, title := range titles {
+			w.Write(bytes.Repeat([]byte{0xff}, icyMetaint))
+			if metadata {
+				w.Write(icyBlock(title))
+			}
+			w.(http.Flusher).Flush()
+		}
+	})
+	mux.HandleFunc("/live.ogg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/ogg")
+		w.Write([]byte("OggS"))
+		w.(http.Flusher).Flush()
+	})
+
+	playlists := map[string]string{
+		"/radio.pls":   "[playlist]\nNumberOfEntries=2\nFile1=http://127.0.0.1:1/offline\nTitle1=Test FM (main)\nFile2=/radio\nTitle2=Test FM (backup)\nLength2=-1\n",
+		"/list.m3u":    "\xef\xbb\xbf#EXTM3U\n#EXTINF:123,Some Artist - Some Song\nsong.mp3\n",
+		"/nested.m3u":  "radio.pls\n",
+		"/loop.m3u":    "loop.m3u\n",
+		"/empty.pls":   "[playlist]\nNumberOfEntries=0\n",
+		"/live.m3u8":   "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:100\n#EXTINF:6.0,\nseg100.ts\n#EXTINF:6.0,\nseg101.ts\n",
+		"/vod.m3u8":    "#EXTM3U\n#EXT-X-TARGETDURATION:10\n#EXTINF:10.0,\nseg1.ts\n#EXTINF:10.0,\nseg2.ts\n#EXTINF:5.5,\nseg3.ts\n#EXT-X-ENDLIST\n",
+		"/master.m3u8": "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\nvod.m3u8\n",
+	}
+	for name, body := range playlists {
+		mux.HandleFunc(name, func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasSuffix(name, ".m3u8") {
+				w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			} else {
+				w.Header().Set("Content-Type", "text/plain")
+			}
+			io.WriteString(w, body)
+		})
+	}
+	mux.HandleFunc("/page.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, "<html></html>")
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamProbe(t *testing.T) {
+	server := newFakeStreams(t, "Artist - Song")
+	streams := NewStreamProvider()
+	streams.SetFFprobe("")
+	streams.SetAllowPrivateNetworks(true)
+
+	tests := []struct {
+		path string
+		want StreamInfo
+	}{
+		{"/song.mp3", StreamInfo{URL: "/song.mp3", Kind: StreamFile, Title: "song", Artist: "127.0.0.1"}},
+		{"/radio", StreamInfo{URL: "/radio", Kind: StreamRadio, Live: true, Title: "Test FM", Artist: "Smooth tunes all day", Genre: "Jazz"}},
+		{"/live.ogg", StreamInfo{URL: "/live.ogg", Kind: StreamRadio, Live: true, Title: "live", Artist: "127.0.0.1"}},
+		{"/radio.pls", StreamInfo{URL: "/radio", Kind: StreamRadio, Live: true, Title: "Test FM", Artist: "Smooth tunes all day", Genre: "Jazz"}},
+		{"/list.m3u", StreamInfo{URL: "/song.mp3", Kind: StreamFile, Title: "Some Artist - Some Song", Artist: "127.0.0.1"}},
+		{"/nested.m3u", StreamInfo{URL: "/radio", Kind: StreamRadio, Live: true, Title: "Test FM", Artist: "Smooth tunes all day", Genre: "Jazz"}},
+		{"/live.m3u8", StreamInfo{URL: "/live.m3u8", Kind: StreamHLS, Live: true, Title: "live", Artist: "127.0.0.1"}},
+		{"/vod.m3u8", StreamInfo{URL: "/vod.m3u8", Kind: StreamHLS, Title: "vod", Artist: "127.0.0.1", Duration: 25500 * time.Millisecond}},
+		{"/master.m3u8", StreamInfo{URL: "/master.m3u8", Kind: StreamHLS, Title: "master", Artist: "127.0.0.1", Duration: 25500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			info, err := streams.Probe(server.URL + tt.path)
+			if err != nil {
+				t.Fatalf("Probe failed: %v", err)
+			}
+			want := tt.want
+			want.URL = server.URL + want.URL
+			if *info != want {
+				t.Errorf("Expected %+v, got %+v", want, *info)
+			}
+		})
+	}
+
+	for path, want := range map[string]string{
+		"/page.html": "doesn't look like audio",
+		"/missing":   "404",
+		"/loop.m3u":  "too many nested playlists",
+		"/empty.pls": "lists no streams",
+	} {
+		if _, err := streams.Probe(server.URL + path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Probe(%s): expected an error containing %q, got %v", path, want, err)
+		}
+	}
+}
+
+func TestStreamProvider(t *testing.T) {
+	server := newFakeStreams(t, "Artist - Song")
+	streams := NewStreamProvider()
+	streams.SetFFprobe("")
+	streams.SetAllowPrivateNetworks(true)
+
+	for link, want := range map[string]bool{
+		server.URL + "/radio":                         true,
+		"https://radio.example/stream.pls":            true,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ": false,
+		"https://youtu.be/dQw4w9WgXcQ":                false,
+		"https://open.spotify.com/track/abc":          false,
+		"ftp://files.example/song.mp3":                false,
+		"never gonna give you up":                     false,
+	} {
+		if got := streams.IsStreamURL(link); got != want {
+			t.Errorf("IsStreamURL(%q) = %v, want %v", link, got, want)
+		}
+	}
+
+	results, err := streams.Search("<" + server.URL + "/radio.pls>")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	want := SearchResult{ID: server.URL + "/radio.pls", Title: "Test FM", Artist: "Smooth tunes all day", Genre: "Jazz", URL: server.URL + "/radio.pls", Live: true}
+	if len(results) != 1 || results[0] != want {
+		t.Errorf("Expected %+v, got %+v", want, results)
+	}
+	if _, err := streams.Search("some song"); err == nil {
+		t.Error("Expected searching text to fail")
+	}
+
+	// Playlists are resolved to the stream when it starts
+	streamURL, err := streams.GetStreamURL(server.URL + "/radio.pls")
+	if err != nil || streamURL != server.URL+"/radio" {
+		t.Errorf("Expected the playlist's stream, got %q, %v", streamURL, err)
+	}
+}
+
+func TestStreamRefusesPrivateNetworks(t *testing.T) {
+	var network publicNetwork
+	for address, want := range map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"192.168.0.10":     true,
+		"169.254.169.254":  true,
+		"0.0.0.0":          true,
+		"0.1.2.3":          true,
+		"100.64.0.1":       true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"fec0::1":          true,
+		"::ffff:127.0.0.1": true,
+		"64:ff9b::7f00:1":  true, // 127.0.0.1 through NAT64
+		"64:ff9b:1::1":     true,
+		"8.8.8.8":          false,
+		"64:ff9b::808:808": false, // 8.8.8.8 through NAT64
+		"2001:4860::8888":  false,
+	} {
+		if got := network.blocked(netip.MustParseAddr(address)); got != want {
+			t.Errorf("blocked(%s) = %v, want %v", address, got, want)
+		}
+	}
+
+	server := newFakeStreams(t, "Artist - Song")
+	streams := NewStreamProvider()
+	streams.SetFFprobe("")
+	if _, err := streams.Search(server.URL + "/radio"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a link to the local host to be refused, got %v", err)
+	}
+	if _, err := streams.GetStreamURL(server.URL + "/radio.pls"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a playlist on the local host to be refused, got %v", err)
+	}
+
+	// A second host that links on the first one must not lead to
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("No second loopback address to test redirects with: %v", err)
+	}
+	private := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write(make([]byte, 64))
+	}))
+	private.Listener.Close()
+	private.Listener = listener
+	private.Start()
+	t.Cleanup(private.Close)
+
+	mux := http.NewServeMux()
+	mux.Handle("/moved.mp3", http.RedirectHandler(private.URL+"/song.mp3", http.StatusFound))
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2,\n%s/segment.ts\n#EXT-X-ENDLIST\n", private.URL)
+	})
+	public := httptest.NewServer(mux)
+	t.Cleanup(public.Close)
+
+	streams.network.allowed = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	if _, err := streams.Search(public.URL + "/moved.mp3"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a redirect to a refused address to be refused, got %v", err)
+	}
+	stream, err := streams.OpenStream(context.Background(), public.URL+"/moved.mp3")
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected opening a redirect to a refused address to be refused, got %v", err)
+	}
+	if stream, err = streams.OpenStream(context.Background(), public.URL+"/live.m3u8"); err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer stream.Close()
+	if _, err := io.ReadAll(stream); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected an HLS segment on a refused address to be refused, got %v", err)
+	}
+}
+
+func TestStreamOpensHLS(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		io.WriteString(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=128000\naudio/index.m3u8\n")
+	})
+	mux.HandleFunc("/audio/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		io.WriteString(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2,\none.m4s\n#EXTINF:2,\ntwo.m4s\n#EXT-X-ENDLIST\n")
+	})
+	for _, name := range []string{"init.mp4", "one.m4s", "two.m4s"} {
+		mux.HandleFunc("/audio/"+name, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "["+name+"]")
+		})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	streams := NewStreamProvider()
+	streams.SetAllowPrivateNetworks(true)
+	stream, err := streams.OpenStream(context.Background(), server.URL+"/master.m3u8")
+	if err != nil {
+		t.Fatalf("OpenStream failed: %v", err)
+	}
+	defer stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil || string(data) != "[init.mp4][one.m4s][two.m4s]" {
+		t.Errorf("Expected the segments in order, got %q, %v", data, err)
+	}
+
+	// Where live playlists continue, and encryption, which isn't supported
+	live := parseHLSPlaylist([]byte("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-KEY:METHOD=NONE\na.ts\nb.ts\n"))
+	if live.sequence != 7 || len(live.segments) != 2 || live.ended || live.encrypted {
+		t.Errorf("Unexpected live playlist: %+v", live)
+	}
+	if encrypted := parseHLSPlaylist([]byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\na.ts\n")); !encrypted.encrypted {
+		t.Error("Expected an encrypted playlist to be recognized")
+	}
+}
+
+func TestWatchStreamTitles(t *testing.T) {
+	titles := []string{"Artist - First", "Artist - First", "", "Don't Stop – The Band", "Caf\xe9 del Mar"}
+	server := newFakeStreams(t, titles...)
+	streams := NewStreamProvider()
+	streams.SetAllowPrivateNetworks(true)
+
+	var got []string
+	err := streams.WatchTitles(context.Background(), server.URL+"/radio", func(title string) {
+		got = append(got, title)
+	})
+	if err != nil {
+		t.Fatalf("WatchTitles failed: %v", err)
+	}
+	want := []string{"Artist - First", "Don't Stop – The Band", "Café del Mar"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected titles %q, got %q", want, got)
+	}
+
+	if err := streams.WatchTitles(context.Background(), server.URL+"/song.mp3", func(string) {}); !errors.Is(err, ErrNoStreamTitles) {
+		t.Errorf("Expected ErrNoStreamTitles for a file, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := streams.WatchTitles(ctx, server.URL+"/radio", func(string) {}); err == nil {
+		t.Error("Expected a cancelled watch to fail to connect")
+	}
+}
+
+func TestShoutcastStatusLine(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 4096)
+				conn.Read(buf)
+				io.WriteString(conn, "ICY 200 OK\r\nicy-name: Old School Radio\r\ncontent-type: audio/mpeg\r\nicy-br: 128\r\n\r\n")
+				conn.Write(bytes.Repeat([]byte{0xff}, 512))
+			}()
+		}
+	}()
+
+	streams := NewStreamProvider()
+	streams.SetAllowPrivateNetworks(true)
+	info, err := streams.Probe("http://" + listener.Addr().String() + "/;stream.mp3")
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if info.Kind != StreamRadio || !info.Live || info.Title != "Old School Radio" {
+		t.Errorf("Expected a Shoutcast station, got %+v", info)
+	}
+}
+
+// rssFixture is a podcast feed with the quirks seen in the wild: RFC 822
+// dates with and without seconds, itunes:duration in seconds and h:mm:ss, a
+// relative enclosure, an item without audio and items out of order
+const rssFixture = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+  <title>Test Talk</title>
+  <itunes:author>Jane Host</itunes:author>
+  <itunes:image href="https://example.com/cover.jpg"/>
+  <item>
+    <title>Episode 2: Second</title>
+    <guid>ep-2</guid>
+    <pubDate>Tue, 07 May 2024 10:00:00 +0000</pubDate>
+    <link>https://example.com/ep2</link>
+    <itunes:duration>1:02:03</itunes:duration>
+    <enclosure url="https://cdn.example.com/ep2.mp3" length="1" type="audio/mpeg"/>
+  </item>
+  <item>
+    <title>Show notes only</title>
+    <pubDate>Wed, 08 May 2024 10:00:00 +0000</pubDate>
+  </item>
+  <item>
+    <title>Episode 1: First &amp; Foremost</title>
+    <pubDate>Mon, 6 May 2024 09:30 GMT</pubDate>
+    <itunes:duration>1800</itunes:duration>
+    <enclosure url="/media/ep1.m4a" type="audio/x-m4a"/>
+    <itunes:image href="https://example.com/ep1.jpg"/>
+  </item>
+  <item>
+    <title>Episode 3: Third</title>
+    <pubDate>Thu, 09 May 2024 08:15:00 +0200</pubDate>
+    <itunes:duration>45:30</itunes:duration>
+    <enclosure url="https://cdn.example.com/ep3.mp3" type="audio/mpeg"/>
+  </item>
+</channel>
+</rss>`
+
+const atomFixture = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom Caf` + "\xe9" + `</title>
+  <author><name>Atom Author</name></author>
+  <logo>logo.png</logo>
+  <entry>
+    <id>urn:uuid:1</id>
+    <title>Older entry</title>
+    <published>2024-01-01T12:00:00Z</published>
+    <link rel="alternate" href="https://example.com/older"/>
+    <link rel="enclosure" href="older.ogg" type="audio/ogg"/>
+  </entry>
+  <entry>
+    <id>urn:uuid:2</id>
+    <title>Newer entry</title>
+    <updated>2024-02-01T12:00:00+01:00</updated>
+    <link rel="enclosure" href="https://example.com/newer.mp3"/>
+  </entry>
+  <entry>
+    <id>urn:uuid:3</id>
+    <title>Text post</title>
+    <published>2024-03-01T12:00:00Z</published>
+    <link href="https://example.com/post"/>
+  </entry>
+</feed>`
+
+func TestPodcastFeed(t *testing.T) {
+	var fetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rss", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/rss+xml")
+		io.WriteString(w, rssFixture)
+	})
+	mux.HandleFunc("/feeds/atom", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/atom+xml")
+		io.WriteString(w, atomFixture)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<html><body>Not a feed</body></html>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	podcasts := NewPodcastProvider()
+	podcasts.SetAllowPrivateNetworks(true)
+	podcast, err := podcasts.Feed("<" + server.URL + "/rss>")
+	if err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+	if podcast.Title != "Test Talk" || podcast.Author != "Jane Host" || podcast.Image != "https://example.com/cover.jpg" {
+		t.Errorf("Unexpected podcast details: %+v", podcast)
+	}
+
+	// Newest first, the item without audio left out
+	wantTitles := []string{"Episode 3: Third", "Episode 2: Second", "Episode 1: First & Foremost"}
+	if len(podcast.Episodes) != len(wantTitles) {
+		t.Fatalf("Expected %d episodes, got %+v", len(wantTitles), podcast.Episodes)
+	}
+	for i, title := range wantTitles {
+		if podcast.Episodes[i].Title != title {
+			t.Errorf("Episode %d: expected %q, got %q", i+1, title, podcast.Episodes[i].Title)
+		}
+	}
+
+	first := podcast.Episodes[2]
+	if first.AudioURL != server.URL+"/media/ep1.m4a" {
+		t.Errorf("Expected the relative enclosure to be resolved, got %q", first.AudioURL)
+	}
+	if first.Duration != 30*time.Minute || podcast.Episodes[1].Duration != time.Hour+2*time.Minute+3*time.Second || podcast.Episodes[0].Duration != 45*time.Minute+30*time.Second {
+		t.Errorf("Unexpected durations: %v, %v, %v", podcast.Episodes[0].Duration, podcast.Episodes[1].Duration, first.Duration)
+	}
+	if want := time.Date(2024, 5, 6, 9, 30, 0, 0, time.UTC); !first.Published.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, first.Published)
+	}
+	if first.ID != first.AudioURL || podcast.Episodes[1].ID != "ep-2" {
+		t.Errorf("Expected guids as IDs, the audio URL without one, got %q and %q", podcast.Episodes[1].ID, first.ID)
+	}
+
+	result := first.SearchResult(podcast)
+	if result.ID != first.AudioURL || result.Artist != "Test Talk" || result.Duration != 1800 || result.Thumbnail != "https://example.com/ep1.jpg" || result.Live {
+		t.Errorf("Unexpected search result: %+v", result)
+	}
+	if result := podcast.Episodes[1].SearchResult(podcast); result.URL != "https://example.com/ep2" || result.Thumbnail != podcast.Image {
+		t.Errorf("Expected the episode page and the podcast's cover, got %+v", result)
+	}
+
+	// Episodes by number or latest
+	if episode, number, err := podcast.Episode("latest"); err != nil || number != 1 || episode.Title != "Episode 3: Third" {
+		t.Errorf("Expected the newest episode for latest, got %v, %d, %v", episode, number, err)
+	}
+	if episode, _, err := podcast.Episode("3"); err != nil || episode.Title != first.Title {
+		t.Errorf("Expected episode 3 to be the oldest, got %v, %v", episode, err)
+	}
+	for _, which := range []string{"0", "4", "newest"} {
+		if _, _, err := podcast.Episode(which); err == nil {
+			t.Errorf("Expected episode %q to be refused", which)
+		}
+	}
+
+	// The feed is fetched once for listing and then queueing
+	results, err := podcasts.Search(server.URL + "/rss")
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %v, %v", results, err)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected the feed to be fetched once, got %d fetches", n)
+	}
+	if link, err := podcasts.GetStreamURL(results[0].ID); err != nil || link != "https://cdn.example.com/ep3.mp3" {
+		t.Errorf("Expected the episode to play from its enclosure, got %q, %v", link, err)
+	}
+
+	// Atom, in Latin-1, with relative links
+	atom, err := podcasts.Feed(server.URL + "/feeds/atom")
+	if err != nil {
+		t.Fatalf("Feed failed for Atom: %v", err)
+	}
+	if atom.Title != "Atom Café" || atom.Author != "Atom Author" || atom.Image != server.URL+"/feeds/logo.png" {
+		t.Errorf("Unexpected Atom podcast: %+v", atom)
+	}
+	if len(atom.Episodes) != 2 || atom.Episodes[0].Title != "Newer entry" || atom.Episodes[1].AudioURL != server.URL+"/feeds/older.ogg" {
+		t.Fatalf("Unexpected Atom episodes: %+v", atom.Episodes)
+	}
+	if atom.Episodes[1].Link != "https://example.com/older" || atom.Episodes[0].ID != "urn:uuid:2" {
+		t.Errorf("Unexpected Atom entry details: %+v", atom.Episodes)
+	}
+
+	for _, link := range []string{server.URL + "/page", server.URL + "/missing", "not a link"} {
+		if _, err := podcasts.Feed(link); err == nil {
+			t.Errorf("Expected %s to be refused as a feed", link)
+		}
+	}
+
+	// Feeds and episodes on the bot's own host or network are refused
+	public := NewPodcastProvider()
+	if _, err := public.Feed(server.URL + "/rss"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected a feed on the local host to be refused, got %v", err)
+	}
+	if _, err := public.OpenStream(context.Background(), server.URL+"/ep1.mp3"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected an enclosure on the local host to be refused, got %v", err)
+	}
+}
+
+func TestLRUCache(t *testing.T) {
+	cache := newLRUCache[int](2)
+	cache.Put("a", 1)
+	cache.Put("b", 2)
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Fatalf("Expected a=1, got %d, %v", v, ok)
+	}
+
+	// b is now the least recently used and makes room for c
+	cache.Put("c", 3)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected b to be dropped")
+	}
+	if v, ok := cache.Get("a"); !ok || v != 1 {
+		t.Errorf("Expected a to be kept, got %d, %v", v, ok)
+	}
+	cache.Put("c", 4)
+	if v, _ := cache.Get("c"); v != 4 || cache.Len() != 2 {
+		t.Errorf("Expected c to be updated in place, got %d with %d entries", v, cache.Len())
+	}
+}
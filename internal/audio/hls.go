@@ -0,0 +1,216 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hlsLiveEdge is how many of a live playlist's newest segments playback
+// starts from, like other players do to stay close to live
+const hlsLiveEdge = 3
+
+// hlsPlaylist is what hlsReader needs from a media playlist
+type hlsPlaylist struct {
+	sequence  int64    // Media sequence number of the first segment
+	segments  []string // Segment URIs in order
+	initURI   string   // #EXT-X-MAP initialization section, if any
+	target    time.Duration
+	ended     bool
+	encrypted bool
+}
+
+// parseHLSPlaylist reads the tags of a media playlist that playback uses
+func parseHLSPlaylist(body []byte) hlsPlaylist {
+	playlist := hlsPlaylist{target: 6 * time.Second}
+	for _, line := range playlistLines(body) {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			if n, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64); err == nil {
+				playlist.sequence = n
+			}
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			if n, err := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:")); err == nil && n > 0 {
+				playlist.target = time.Duration(n) * time.Second
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			playlist.initURI = hlsAttribute(strings.TrimPrefix(line, "#EXT-X-MAP:"), "URI")
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if method := hlsAttribute(strings.TrimPrefix(line, "#EXT-X-KEY:"), "METHOD"); method != "" && method != "NONE" {
+				playlist.encrypted = true
+			}
+		case line == "#EXT-X-ENDLIST":
+			playlist.ended = true
+		case !strings.HasPrefix(line, "#"):
+			playlist.segments = append(playlist.segments, line)
+		}
+	}
+	return playlist
+}
+
+// hlsAttribute returns an attribute of a tag's attribute list, unquoted
+func hlsAttribute(list, name string) string {
+	for _, attribute := range strings.Split(list, ",") {
+		key, value, found := strings.Cut(attribute, "=")
+		if found && strings.TrimSpace(key) == name {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+// hlsReader reads an HLS stream as one stream of its segments, fetched
+// through the provider's client. Live playlists are reloaded for segments
+// published since.
+type hlsReader struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	streams  *StreamProvider
+	playlist *url.URL // Media playlist, once a master playlist was resolved
+	body     []byte   // Playlist to read before reloading, nil once read
+
+	started bool  // Segments were queued before
+	next    int64 // Media sequence number of the next new segment
+	mapped  bool  // The initialization section was queued
+	ended   bool
+	target  time.Duration
+
+	pending []*url.URL
+	segment io.ReadCloser
+}
+
+// newHLSReader reads the stream of an HLS playlist, already fetched as body
+func newHLSReader(ctx context.Context, streams *StreamProvider, playlist *url.URL, body []byte) *hlsReader {
+	ctx, cancel := context.WithCancel(ctx)
+	return &hlsReader{ctx: ctx, cancel: cancel, streams: streams, playlist: playlist, body: body}
+}
+
+func (h *hlsReader) Read(p []byte) (int, error) {
+	for {
+		if h.segment != nil {
+			n, err := h.segment.Read(p)
+			if errors.Is(err, io.EOF) {
+				h.segment.Close()
+				h.segment = nil
+				if n > 0 {
+					return n, nil
+				}
+				continue
+			}
+			return n, err
+		}
+
+		if len(h.pending) > 0 {
+			resp, err := h.streams.get(h.ctx, h.pending[0].String(), false)
+			if err != nil {
+				return 0, err
+			}
+			h.pending = h.pending[1:]
+			h.segment = resp.Body
+			continue
+		}
+
+		if h.ended {
+			return 0, io.EOF
+		}
+		if err := h.reload(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// reload queues the segments of the playlist that weren't played yet. A
+// live playlist is reloaded after waiting for new segments.
+func (h *hlsReader) reload() error {
+	body := h.body
+	h.body = nil
+	if body == nil {
+		// Wait for the segments published meanwhile
+		select {
+		case <-time.After(h.target / 2):
+		case <-h.ctx.Done():
+			return h.ctx.Err()
+		}
+		var err error
+		if body, err = h.fetch(h.playlist); err != nil {
+			return err
+		}
+	}
+
+	if variant := hlsVariant(body); variant != "" {
+		media, err := h.playlist.Parse(variant)
+		if err != nil {
+			return fmt.Errorf("invalid HLS variant %q: %w", variant, err)
+		}
+		if body, err = h.fetch(media); err != nil {
+			return err
+		}
+		h.playlist = media
+	}
+
+	playlist := parseHLSPlaylist(body)
+	if playlist.encrypted {
+		return errors.New("encrypted HLS streams are not supported")
+	}
+	h.target = playlist.target
+	h.ended = playlist.ended
+
+	if playlist.initURI != "" && !h.mapped {
+		link, err := h.playlist.Parse(playlist.initURI)
+		if err != nil {
+			return fmt.Errorf("invalid HLS initialization section %q: %w", playlist.initURI, err)
+		}
+		h.pending = append(h.pending, link)
+		h.mapped = true
+	}
+
+	segments := playlist.segments
+	first := playlist.sequence
+	if !h.started && !playlist.ended && len(segments) > hlsLiveEdge {
+		first += int64(len(segments) - hlsLiveEdge)
+	}
+	if h.started && h.next > first {
+		first = h.next
+	}
+	for i := max(first-playlist.sequence, 0); i < int64(len(segments)); i++ {
+		link, err := h.playlist.Parse(segments[i])
+		if err != nil {
+			return fmt.Errorf("invalid HLS segment %q: %w", segments[i], err)
+		}
+		h.pending = append(h.pending, link)
+	}
+	h.next = max(h.next, playlist.sequence+int64(len(segments)))
+	h.started = true
+	return nil
+}
+
+// fetch reads a playlist
+func (h *hlsReader) fetch(link *url.URL) ([]byte, error) {
+	resp, err := h.streams.get(h.ctx, link.String(), false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readPlaylist(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(body, []byte("#EXT")) {
+		return nil, fmt.Errorf("%s is not an HLS playlist", link)
+	}
+	return body, nil
+}
+
+// Close stops the stream. Reads in progress fail with the cancelled
+// requests, so Close may be called while another goroutine reads.
+func (h *hlsReader) Close() error {
+	h.cancel()
+	return nil
+}
@@ -0,0 +1,378 @@
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// localExtensions are the audio files the local library indexes
+var localExtensions = map[string]bool{
+	".mp3":  true,
+	".flac": true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+	".m4a":  true,
+}
+
+const (
+	localSearchLimit = 10
+	localRecsLimit   = 5
+)
+
+// LocalTrack is an audio file in the local library
+type LocalTrack struct {
+	Path string // Relative to the library directory, with forward slashes
+	Tags
+}
+
+// SearchResult describes the track like the other providers' results. The ID
+// is the track's path, which GetStreamURL turns back into a file.
+func (t *LocalTrack) SearchResult() SearchResult {
+	genre := t.Genre
+	if genre == "" {
+		genre = "unknown"
+	}
+	return SearchResult{
+		ID:       t.Path,
+		Title:    t.Title,
+		Artist:   t.Artist,
+		Duration: int(t.Duration.Seconds()),
+		Genre:    genre,
+	}
+}
+
+// localFile is an indexed file with what was seen of it when it was read, so
+// rescans only read files that changed
+type localFile struct {
+	track   LocalTrack
+	modTime time.Time
+	size    int64
+}
+
+// LocalProvider serves audio files from a directory, searched by their tags.
+// The directory is indexed by Scan and kept up to date by Watch.
+type LocalProvider struct {
+	dir     string
+	ffprobe string // Reads the tags of formats without a built-in reader, skipped if empty
+	timeout time.Duration
+
+	scanMu sync.Mutex // Serializes scans
+	mu     sync.RWMutex
+	files  map[string]*localFile // By path
+}
+
+func NewLocalProvider(dir string) *LocalProvider {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	return &LocalProvider{
+		dir:     dir,
+		ffprobe: "ffprobe",
+		timeout: 15 * time.Second,
+		files:   make(map[string]*localFile),
+	}
+}
+
+// SetFFprobe sets the ffprobe binary used for tags the built-in readers can't
+// get, e.g. of Ogg and M4A files. An empty binary turns probing off.
+func (l *LocalProvider) SetFFprobe(binary string) {
+	l.ffprobe = binary
+}
+
+// Dir returns the library directory
+func (l *LocalProvider) Dir() string {
+	return l.dir
+}
+
+// Len returns the number of indexed tracks
+func (l *LocalProvider) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.files)
+}
+
+// Scan indexes the library directory. Files indexed before are only read
+// again if they changed, and files that are gone are dropped. It returns how
+// many tracks were added, changed or removed.
+func (l *LocalProvider) Scan() (int, error) {
+	l.scanMu.Lock()
+	defer l.scanMu.Unlock()
+
+	l.mu.RLock()
+	previous := l.files
+	l.mu.RUnlock()
+
+	files := make(map[string]*localFile, len(previous))
+	changes := 0
+	err := filepath.WalkDir(l.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if name == l.dir {
+				return err
+			}
+			log.Printf("Skipping %s in the music library: %v", name, err)
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".") && name != l.dir {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !localExtensions[strings.ToLower(filepath.Ext(name))] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil // Removed since the directory was listed
+		}
+		rel, err := filepath.Rel(l.dir, name)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		if old, exists := previous[rel]; exists && old.modTime.Equal(info.ModTime()) && old.size == info.Size() {
+			files[rel] = old
+			return nil
+		}
+		files[rel] = &localFile{track: l.readTrack(rel), modTime: info.ModTime(), size: info.Size()}
+		changes++
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to scan music library %s: %w", l.dir, err)
+	}
+	for rel := range previous {
+		if _, exists := files[rel]; !exists {
+			changes++
+		}
+	}
+
+	l.mu.Lock()
+	l.files = files
+	l.mu.Unlock()
+	return changes, nil
+}
+
+// readTrack reads a file's tags with the built-in readers, then ffprobe,
+// then falls back to its name, as in "Artist - Title.mp3"
+func (l *LocalProvider) readTrack(rel string) LocalTrack {
+	name := filepath.Join(l.dir, filepath.FromSlash(rel))
+	tags, err := ReadTags(name)
+	if err != nil {
+		log.Printf("Failed to read tags of %s: %v", name, err)
+	}
+
+	if !tags.complete() && l.ffprobe != "" {
+		if _, lookErr := exec.LookPath(l.ffprobe); lookErr == nil {
+			probed, err := probeTags(l.ffprobe, name, nil, l.timeout)
+			if err != nil {
+				log.Printf("Failed to probe %s: %v", name, err)
+			}
+			tags.merge(probed)
+		}
+	}
+
+	base := strings.TrimSuffix(path.Base(rel), path.Ext(rel))
+	if artist, title, found := strings.Cut(base, " - "); found {
+		tags.merge(Tags{Title: strings.TrimSpace(title), Artist: strings.TrimSpace(artist)})
+	}
+	tags.merge(Tags{Title: base, Artist: "Unknown Artist"})
+	return LocalTrack{Path: rel, Tags: tags}
+}
+
+// Watch rescans the library every interval until done is closed, so files
+// added, changed or removed show up in searches
+func (l *LocalProvider) Watch(done <-chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changes, err := l.Scan()
+			if err != nil {
+				log.Printf("Music library rescan failed: %v", err)
+			} else if changes > 0 {
+				log.Printf("Music library changed: %d file(s) updated, %d tracks indexed", changes, l.Len())
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Tracks returns the indexed tracks sorted by path
+func (l *LocalProvider) Tracks() []LocalTrack {
+	l.mu.RLock()
+	tracks := make([]LocalTrack, 0, len(l.files))
+	for _, file := range l.files {
+		tracks = append(tracks, file.track)
+	}
+	l.mu.RUnlock()
+
+	sort.Slice(tracks, func(i, j int) bool { return tracks[i].Path < tracks[j].Path })
+	return tracks
+}
+
+// Search finds tracks whose title, artist, album, genre or path match every
+// word of the query. Words match exactly, by prefix, within the track's words
+// or with one typo, and matches in titles rank highest.
+func (l *LocalProvider) Search(query string) ([]SearchResult, error) {
+	words := searchWords(query)
+	if len(words) == 0 {
+		return nil, errors.New("please specify what to search the music library for")
+	}
+
+	type match struct {
+		track LocalTrack
+		score int
+	}
+	var matches []match
+	for _, track := range l.Tracks() {
+		if score := matchScore(words, &track); score > 0 {
+			matches = append(matches, match{track: track, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	results := make([]SearchResult, 0, min(len(matches), localSearchLimit))
+	for _, m := range matches[:min(len(matches), localSearchLimit)] {
+		results = append(results, m.track.SearchResult())
+	}
+	return results, nil
+}
+
+// matchScore scores how well a track matches the query words, or returns 0
+// if any word doesn't match
+func matchScore(query []string, track *LocalTrack) int {
+	// Fields with their weights. The path covers folder names.
+	fields := []struct {
+		words  []string
+		weight int
+	}{
+		{searchWords(track.Title), 3},
+		{searchWords(track.Artist), 2},
+		{searchWords(track.Album), 2},
+		{searchWords(track.Genre), 1},
+		{searchWords(track.Path), 1},
+	}
+
+	total := 0
+	for _, q := range query {
+		best := 0
+		for _, field := range fields {
+			for _, w := range field.words {
+				best = max(best, wordScore(q, w)*field.weight)
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		total += best
+	}
+	return total
+}
+
+// wordScore rates how well a query word matches a word of a track
+func wordScore(q, w string) int {
+	switch {
+	case q == w:
+		return 4
+	case strings.HasPrefix(w, q):
+		return 3
+	case strings.Contains(w, q):
+		return 2
+	case len([]rune(q)) >= 4 && editDistance(q, w) <= 1:
+		return 1
+	}
+	return 0
+}
+
+// searchWords splits text into lowercase words of letters and digits
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// editDistance returns the number of insertions, deletions, substitutions
+// and swaps of neighbouring letters that turn a into b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	rows := make([][]int, len(ra)+1)
+	for i := range rows {
+		rows[i] = make([]int, len(rb)+1)
+		rows[i][0] = i
+	}
+	for j := range rows[0] {
+		rows[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			rows[i][j] = min(rows[i-1][j]+1, rows[i][j-1]+1, rows[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				rows[i][j] = min(rows[i][j], rows[i-2][j-2]+1)
+			}
+		}
+	}
+	return rows[len(ra)][len(rb)]
+}
+
+// GetStreamURL returns the absolute path of an indexed track, which ffmpeg
+// reads like any other stream URL. Only indexed files are served, so IDs
+// can't reach outside the library.
+func (l *LocalProvider) GetStreamURL(id string) (string, error) {
+	l.mu.RLock()
+	_, exists := l.files[id]
+	l.mu.RUnlock()
+	if !exists {
+		return "", fmt.Errorf("%q is not in the music library", id)
+	}
+
+	name := filepath.Join(l.dir, filepath.FromSlash(id))
+	if _, err := os.Stat(name); err != nil {
+		return "", fmt.Errorf("music library file is unavailable: %w", err)
+	}
+	return name, nil
+}
+
+// GetRecommendations picks random tracks of a genre, or from the whole
+// library if it has none of that genre
+func (l *LocalProvider) GetRecommendations(genre string) ([]SearchResult, error) {
+	tracks := l.Tracks()
+	var picks []LocalTrack
+	for _, track := range tracks {
+		if track.Genre != "" && strings.EqualFold(track.Genre, genre) {
+			picks = append(picks, track)
+		}
+	}
+	if len(picks) == 0 {
+		picks = tracks
+	}
+
+	rand.Shuffle(len(picks), func(i, j int) { picks[i], picks[j] = picks[j], picks[i] })
+	results := make([]SearchResult, 0, min(len(picks), localRecsLimit))
+	for _, track := range picks[:min(len(picks), localRecsLimit)] {
+		results = append(results, track.SearchResult())
+	}
+	return results, nil
+}
@@ -0,0 +1,57 @@
+package audio
+
+import "container/list"
+
+// lruCache is a map that keeps at most size entries, dropping the least
+// recently used. It is not safe for concurrent use; callers lock around it.
+type lruCache[V any] struct {
+	size    int
+	order   *list.List // Of *lruEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type lruEntry[V any] struct {
+	key   string
+	value V
+}
+
+func newLRUCache[V any](size int) *lruCache[V] {
+	return &lruCache[V]{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value for key and marks it as recently used
+func (c *lruCache[V]) Get(key string) (V, bool) {
+	element, exists := c.entries[key]
+	if !exists {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry[V]).value, true
+}
+
+// Put sets the value for key, dropping the least recently used entry if the
+// cache is full
+func (c *lruCache[V]) Put(key string, value V) {
+	if element, exists := c.entries[key]; exists {
+		element.Value.(*lruEntry[V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[V]{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[V]).key)
+	}
+}
+
+// Len returns the number of entries
+func (c *lruCache[V]) Len() int {
+	return c.order.Len()
+}
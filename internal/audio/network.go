@@ -0,0 +1,111 @@
+package audio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned for links that lead to the bot's own host or
+// network
+var ErrPrivateAddress = errors.New("links to local or private network addresses are not allowed")
+
+// StreamOpener is implemented by providers whose stream URLs come from users,
+// such as web links and podcast feeds. Their streams are opened by the
+// provider and piped to ffmpeg, which never connects anywhere by itself.
+type StreamOpener interface {
+	// OpenStream opens a stream URL returned by GetStreamURL. The stream
+	// stops when it is closed or ctx is done.
+	OpenStream(ctx context.Context, link string) (io.ReadCloser, error)
+}
+
+// reservedRanges are ranges to refuse that net/netip has no method for
+var reservedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This network", reaches the host itself
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+	netip.MustParsePrefix("fec0::/10"),      // Site-local, the deprecated ULA
+}
+
+// nat64Prefix is the well-known NAT64 prefix. Its addresses embed the IPv4
+// address they translate to.
+var nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// publicNetwork keeps the links users share from reaching the bot's own host
+// or network: loopback, private, link-local, unspecified and other reserved
+// addresses are refused. Providers that open web links use it for their HTTP
+// client, and pipe what it fetched to ffmpeg.
+type publicNetwork struct {
+	allowed []netip.Prefix // Refused addresses to connect to anyway
+}
+
+// allowPrivate lets every address through, e.g. for a radio on the local
+// network
+func (n *publicNetwork) allowPrivate(allow bool) {
+	n.allowed = nil
+	if allow {
+		n.allowed = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	}
+}
+
+// blocked reports whether addr is not to be connected to
+func (n *publicNetwork) blocked(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range n.allowed {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return reservedAddr(addr)
+}
+
+// reservedAddr reports whether addr belongs to the host or a private network
+func reservedAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range reservedRanges {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	if nat64Prefix.Contains(addr) {
+		embedded := addr.As16()
+		return reservedAddr(netip.AddrFrom4([4]byte(embedded[12:])))
+	}
+	return false
+}
+
+// control refuses to dial blocked addresses. Dialers call it with the
+// address DNS resolved to, for every connection the client makes, redirects
+// included.
+func (n *publicNetwork) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if addr, err := netip.ParseAddr(host); err != nil || n.blocked(addr) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// dialer returns a dialer that only connects to allowed addresses
+func (n *publicNetwork) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: n.control}
+}
+
+// transport returns an HTTP transport that only connects to allowed
+// addresses. Proxies are skipped, as the address dialed would be theirs.
+func (n *publicNetwork) transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = n.dialer().DialContext
+	return transport
+}
@@ -0,0 +1,83 @@
+package audio
+
+import (
+	"io"
+)
+
+// PlaylistProvider is implemented by providers that can expand playlist and
+// album links into tracks.
+type PlaylistProvider interface {
+	// IsPlaylistURL reports whether link is a playlist or album this provider can load
+	IsPlaylistURL(link string) bool
+	// OpenPlaylist loads the playlist title and first page of tracks. At most
+	// limit tracks are loaded in total, a limit of 0 loads everything.
+	OpenPlaylist(link string, limit int) (*Playlist, error)
+}
+
+// PlaylistPager fetches the next page of a playlist, reporting whether more pages follow
+type PlaylistPager func() (tracks []SearchResult, more bool, err error)
+
+// Playlist is a playlist or album opened from a link. Tracks holds the first
+// page; the rest is fetched page by page with Next, so large playlists can be
+// queued without loading them up front.
+type Playlist struct {
+	Title  string
+	Total  int // Tracks in the playlist, as reported by the provider
+	Tracks []SearchResult
+
+	limit  int
+	loaded int
+	pager  PlaylistPager
+}
+
+// NewPlaylist creates a playlist and loads its first page with pager
+func NewPlaylist(title string, total, limit int, pager PlaylistPager) (*Playlist, error) {
+	p := &Playlist{Title: title, Total: total, limit: limit, pager: pager}
+
+	tracks, err := p.Next()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	p.Tracks = tracks
+	return p, nil
+}
+
+// Limit returns how many tracks will be loaded in total
+func (p *Playlist) Limit() int {
+	if p.limit > 0 && (p.Total == 0 || p.Total > p.limit) {
+		return p.limit
+	}
+	return p.Total
+}
+
+// Loaded returns how many tracks have been loaded so far
+func (p *Playlist) Loaded() int {
+	return p.loaded
+}
+
+// More reports whether Next has pages left to load
+func (p *Playlist) More() bool {
+	return p.pager != nil && (p.limit <= 0 || p.loaded < p.limit)
+}
+
+// Next loads the next page of tracks. It returns io.EOF once the playlist or
+// the limit is exhausted.
+func (p *Playlist) Next() ([]SearchResult, error) {
+	if !p.More() {
+		return nil, io.EOF
+	}
+
+	tracks, more, err := p.pager()
+	if err != nil {
+		return nil, err
+	}
+	if !more {
+		p.pager = nil
+	}
+
+	if p.limit > 0 && p.loaded+len(tracks) > p.limit {
+		tracks = tracks[:p.limit-p.loaded]
+	}
+	p.loaded += len(tracks)
+	return tracks, nil
+}
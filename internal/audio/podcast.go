@@ -0,0 +1,564 @@
+package audio
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// podcastCacheTTL is how long a fetched feed is reused, so listing the
+	// episodes and then queueing one fetches the feed once
+	podcastCacheTTL = 10 * time.Minute
+
+	maxFeedSize        = 16 << 20
+	podcastSearchLimit = 10
+)
+
+// Podcast is a podcast feed with its episodes, newest first
+type Podcast struct {
+	Title    string
+	Author   string
+	Image    string
+	Link     string // The feed
+	Episodes []Episode
+}
+
+// ErrNoEpisode is returned by Podcast.Episode for numbers outside the feed
+var ErrNoEpisode = errors.New("no such episode")
+
+// Episode returns an episode by its 1-based number, newest first, or the
+// newest for "latest"
+func (p *Podcast) Episode(which string) (*Episode, int, error) {
+	number := 1
+	if !strings.EqualFold(which, "latest") {
+		n, err := strconv.Atoi(which)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%q is not an episode number or \"latest\"", which)
+		}
+		number = n
+	}
+	if number < 1 || number > len(p.Episodes) {
+		return nil, 0, fmt.Errorf("%w: %s has episodes 1 to %d", ErrNoEpisode, p.Title, len(p.Episodes))
+	}
+	return &p.Episodes[number-1], number, nil
+}
+
+// Episode is an episode of a podcast that has audio
+type Episode struct {
+	ID        string // The feed's guid or Atom id, the audio URL if it has neither
+	Title     string
+	Published time.Time // Zero if the feed doesn't say
+	Duration  time.Duration
+	AudioURL  string
+	Link      string // The episode's web page, if any
+	Image     string
+}
+
+// EpisodeID identifies an episode of the podcast by the feed and the
+// episode's guid, as in "https://example.com/feed.xml#<guid>". Hosts change
+// tracking parameters in audio URLs on every fetch of a feed, so the audio
+// URL is only used for episodes without a guid.
+func (p *Podcast) EpisodeID(episode *Episode) string {
+	u, err := url.Parse(p.Link)
+	if err != nil || episode.ID == episode.AudioURL {
+		return episode.AudioURL
+	}
+	u.Fragment = episode.ID
+	return u.String()
+}
+
+// parseEpisodeID returns the feed and the guid an EpisodeID names
+func parseEpisodeID(id string) (feed, guid string, ok bool) {
+	u, err := url.Parse(id)
+	if err != nil || u.Fragment == "" {
+		return "", "", false
+	}
+	guid = u.Fragment
+	u.Fragment = ""
+	return u.String(), guid, true
+}
+
+// SearchResult describes the episode like the other providers' results. The
+// ID is the episode's EpisodeID, which GetStreamURL finds the audio URL for.
+func (e *Episode) SearchResult(podcast *Podcast) SearchResult {
+	image := e.Image
+	if image == "" {
+		image = podcast.Image
+	}
+	link := e.Link
+	if link == "" {
+		link = e.AudioURL
+	}
+	return SearchResult{
+		ID:        podcast.EpisodeID(e),
+		Title:     e.Title,
+		Artist:    podcast.Title,
+		Duration:  int(e.Duration.Seconds()),
+		Genre:     "podcast",
+		Thumbnail: image,
+		URL:       link,
+	}
+}
+
+// cachedFeed is a fetched feed and when it was fetched
+type cachedFeed struct {
+	podcast *Podcast
+	fetched time.Time
+}
+
+// PodcastProvider reads RSS and Atom podcast feeds. Searching takes a feed
+// link and lists its newest episodes; episodes play from the audio URL the
+// feed currently lists for them.
+type PodcastProvider struct {
+	client  *http.Client
+	network publicNetwork
+	timeout time.Duration
+
+	mu    sync.Mutex
+	feeds map[string]cachedFeed // By feed link
+}
+
+func NewPodcastProvider() *PodcastProvider {
+	p := &PodcastProvider{
+		timeout: 20 * time.Second,
+		feeds:   make(map[string]cachedFeed),
+	}
+	p.client = &http.Client{Transport: p.network.transport()}
+	return p
+}
+
+// SetAllowPrivateNetworks lets feeds and episodes be fetched from loopback
+// and private network addresses, which are refused by default like web
+// stream links
+func (p *PodcastProvider) SetAllowPrivateNetworks(allow bool) {
+	p.network.allowPrivate(allow)
+}
+
+// IsFeedURL reports whether link could be a feed, i.e. is an http(s) link
+func (p *PodcastProvider) IsFeedURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Feed fetches and parses a podcast feed, reusing a recent fetch
+func (p *PodcastProvider) Feed(link string) (*Podcast, error) {
+	link = strings.Trim(strings.TrimSpace(link), "<>")
+	if !p.IsFeedURL(link) {
+		return nil, fmt.Errorf("%q is not a feed link", link)
+	}
+
+	p.mu.Lock()
+	cached, exists := p.feeds[link]
+	p.mu.Unlock()
+	if exists && time.Since(cached.fetched) < podcastCacheTTL {
+		return cached.podcast, nil
+	}
+
+	podcast, err := p.fetch(link)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	for key, feed := range p.feeds {
+		if time.Since(feed.fetched) >= podcastCacheTTL {
+			delete(p.feeds, key)
+		}
+	}
+	p.feeds[link] = cachedFeed{podcast: podcast, fetched: time.Now()}
+	p.mu.Unlock()
+	return podcast, nil
+}
+
+func (p *PodcastProvider) fetch(link string) (*Podcast, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed link %q: %w", link, err)
+	}
+	req.Header.Set("User-Agent", streamUserAgent)
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed request failed: %s", resp.Status)
+	}
+
+	podcast, err := ParseFeed(io.LimitReader(resp.Body, maxFeedSize), resp.Request.URL)
+	if err != nil {
+		return nil, err
+	}
+	podcast.Link = link
+	return podcast, nil
+}
+
+// Search lists the newest episodes of the feed the query links to
+func (p *PodcastProvider) Search(query string) ([]SearchResult, error) {
+	podcast, err := p.Feed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	episodes := podcast.Episodes[:min(len(podcast.Episodes), podcastSearchLimit)]
+	results := make([]SearchResult, 0, len(episodes))
+	for i := range episodes {
+		results = append(results, episodes[i].SearchResult(podcast))
+	}
+	return results, nil
+}
+
+// GetStreamURL looks up the audio URL of the episode an EpisodeID names in
+// its feed. Episodes queued by audio URL, before IDs named the feed, play
+// from that URL.
+func (p *PodcastProvider) GetStreamURL(id string) (string, error) {
+	feed, guid, ok := parseEpisodeID(id)
+	if !ok {
+		if !p.IsFeedURL(id) {
+			return "", fmt.Errorf("invalid episode audio URL %q", id)
+		}
+		return id, nil
+	}
+
+	podcast, err := p.Feed(feed)
+	if err != nil {
+		return "", err
+	}
+	for i := range podcast.Episodes {
+		if podcast.Episodes[i].ID == guid {
+			return podcast.Episodes[i].AudioURL, nil
+		}
+	}
+	return "", fmt.Errorf("%w: the episode is no longer in %s", ErrNoEpisode, podcast.Title)
+}
+
+// OpenStream opens an episode's audio for ffmpeg to read. Feeds choose
+// their enclosure links, so they are fetched like the feeds themselves.
+func (p *PodcastProvider) OpenStream(ctx context.Context, link string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid episode audio URL %q: %w", link, err)
+	}
+	req.Header.Set("User-Agent", streamUserAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open episode: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("episode request failed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// GetRecommendations has nothing to recommend, feeds are chosen by link
+func (p *PodcastProvider) GetRecommendations(genre string) ([]SearchResult, error) {
+	return nil, nil
+}
+
+// rssFeed is the subset of an RSS 2.0 podcast feed we use
+type rssFeed struct {
+	Channel struct {
+		Title  string `xml:"title"`
+		Author string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		// Before Image, which would otherwise take itunes:image as well
+		ItunesImage struct {
+			Href string `xml:"href,attr"`
+		} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Image struct {
+			URL string `xml:"url"`
+		} `xml:"image"`
+		Items []struct {
+			Title     string `xml:"title"`
+			GUID      string `xml:"guid"`
+			PubDate   string `xml:"pubDate"`
+			Link      string `xml:"link"`
+			Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+			ItunesImage struct {
+				Href string `xml:"href,attr"`
+			} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+// atomFeed is the subset of an Atom feed we use
+type atomFeed struct {
+	Title  string     `xml:"title"`
+	Author atomAuthor `xml:"author"`
+	Icon   string     `xml:"icon"`
+	Logo   string     `xml:"logo"`
+	Entry  []struct {
+		ID        string     `xml:"id"`
+		Title     string     `xml:"title"`
+		Published string     `xml:"published"`
+		Updated   string     `xml:"updated"`
+		Links     []atomLink `xml:"link"`
+		Duration  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	} `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// ParseFeed parses an RSS 2.0 or Atom podcast feed. Relative links are
+// resolved against base. Entries without audio are left out and episodes
+// are sorted newest first.
+func ParseFeed(r io.Reader, base *url.URL) (*Podcast, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var podcast *Podcast
+	switch root {
+	case "rss":
+		var feed rssFeed
+		if err := decodeFeed(data, &feed); err != nil {
+			return nil, err
+		}
+		podcast = feed.podcast(base)
+	case "feed":
+		var feed atomFeed
+		if err := decodeFeed(data, &feed); err != nil {
+			return nil, err
+		}
+		podcast = feed.podcast(base)
+	default:
+		return nil, fmt.Errorf("not an RSS or Atom feed (root element <%s>)", root)
+	}
+
+	if len(podcast.Episodes) == 0 {
+		return nil, fmt.Errorf("%s has no episodes with audio", podcast.Title)
+	}
+	// Feeds usually list the newest first already; keep their order for ties
+	sort.SliceStable(podcast.Episodes, func(i, j int) bool {
+		return podcast.Episodes[i].Published.After(podcast.Episodes[j].Published)
+	})
+	return podcast, nil
+}
+
+// rootElement returns the local name of a document's root element
+func rootElement(data []byte) (string, error) {
+	decoder := newFeedDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("failed to parse feed: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func decodeFeed(data []byte, v interface{}) error {
+	if err := newFeedDecoder(data).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse feed: %w", err)
+	}
+	return nil
+}
+
+// newFeedDecoder returns a lenient decoder that also reads Latin-1 feeds
+func newFeedDecoder(data []byte) *xml.Decoder {
+	decoder := xml.NewDecoder(strings.NewReader(string(data)))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "iso-8859-1", "latin1", "latin-1", "windows-1252", "us-ascii":
+			return latin1Reader(input)
+		}
+		return nil, fmt.Errorf("unsupported feed charset %q", charset)
+	}
+	return decoder
+}
+
+func latin1Reader(input io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	runes := make([]rune, len(data))
+	for i, c := range data {
+		runes[i] = rune(c)
+	}
+	return strings.NewReader(string(runes)), nil
+}
+
+func (feed *rssFeed) podcast(base *url.URL) *Podcast {
+	channel := &feed.Channel
+	podcast := &Podcast{
+		Title:  strings.TrimSpace(channel.Title),
+		Author: strings.TrimSpace(channel.Author),
+		Image:  resolveLink(base, firstNonEmpty(channel.ItunesImage.Href, channel.Image.URL)),
+	}
+
+	for _, item := range channel.Items {
+		audio := item.Enclosure.URL
+		if audio == "" || !isAudioEnclosure(item.Enclosure.Type, audio) {
+			continue
+		}
+		audio = resolveLink(base, audio)
+		podcast.Episodes = append(podcast.Episodes, Episode{
+			ID:        firstNonEmpty(strings.TrimSpace(item.GUID), audio),
+			Title:     strings.TrimSpace(item.Title),
+			Published: parseFeedTime(item.PubDate),
+			Duration:  parseFeedDuration(item.Duration),
+			AudioURL:  audio,
+			Link:      resolveLink(base, strings.TrimSpace(item.Link)),
+			Image:     resolveLink(base, item.ItunesImage.Href),
+		})
+	}
+	return podcast
+}
+
+func (feed *atomFeed) podcast(base *url.URL) *Podcast {
+	podcast := &Podcast{
+		Title:  strings.TrimSpace(feed.Title),
+		Author: strings.TrimSpace(feed.Author.Name),
+		Image:  resolveLink(base, firstNonEmpty(feed.Logo, feed.Icon)),
+	}
+
+	for _, entry := range feed.Entry {
+		var audio, page string
+		for _, link := range entry.Links {
+			switch {
+			case link.Rel == "enclosure" && audio == "" && isAudioEnclosure(link.Type, link.Href):
+				audio = link.Href
+			case (link.Rel == "" || link.Rel == "alternate") && page == "":
+				page = link.Href
+			}
+		}
+		if audio == "" {
+			continue
+		}
+		audio = resolveLink(base, audio)
+		podcast.Episodes = append(podcast.Episodes, Episode{
+			ID:        firstNonEmpty(strings.TrimSpace(entry.ID), audio),
+			Title:     strings.TrimSpace(entry.Title),
+			Published: parseFeedTime(firstNonEmpty(entry.Published, entry.Updated)),
+			Duration:  parseFeedDuration(entry.Duration),
+			AudioURL:  audio,
+			Link:      resolveLink(base, page),
+		})
+	}
+	return podcast
+}
+
+// isAudioEnclosure reports whether an enclosure is audio, or video whose
+// sound can be played. Enclosures without a type are judged by extension.
+func isAudioEnclosure(contentType, link string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "" && mediaType != "application/octet-stream" {
+		return strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")
+	}
+	if u, err := url.Parse(link); err == nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		return localExtensions[ext] || ext == ".aac" || ext == ".mp4"
+	}
+	return false
+}
+
+// feedTimeLayouts are the date formats seen in feeds: RFC 822 as RSS
+// specifies, with and without seconds and day names, and RFC 3339 for Atom
+var feedTimeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 2 Jan 2006 15:04 -0700",
+	"Mon, 2 Jan 2006 15:04 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+	time.RFC822Z,
+	time.RFC822,
+	time.RFC3339,
+	"2006-01-02",
+}
+
+// parseFeedTime parses a publication date, returning the zero time if it
+// has none of the known formats
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseFeedDuration parses an itunes:duration, given in seconds or as
+// h:mm:ss or mm:ss
+func parseFeedDuration(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// resolveLink resolves a possibly relative link against the feed's URL
+func resolveLink(base *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || base == nil {
+		return link
+	}
+	resolved, err := base.Parse(link)
+	if err != nil {
+		return link
+	}
+	return resolved.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
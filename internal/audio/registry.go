@@ -0,0 +1,134 @@
+package audio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Capability flags describe what a registered provider supports
+type Capability int
+
+const (
+	CapSearch          Capability = 1 << iota // Search(query) returns results
+	CapStream                                 // GetStreamURL(id) yields playable audio
+	CapRecommendations                        // GetRecommendations(genre) returns results
+	CapPlaylists                              // Implements PlaylistProvider
+)
+
+// ProviderInfo describes a MusicProvider registered under a prefix, e.g. "yt"
+// for "!play yt:query".
+type ProviderInfo struct {
+	Prefix       string
+	Name         string
+	Provider     MusicProvider
+	Capabilities Capability
+}
+
+// Can reports whether the provider has all of the given capabilities
+func (p *ProviderInfo) Can(caps Capability) bool {
+	return p.Capabilities&caps == caps
+}
+
+// Registry holds the available providers, keyed by prefix
+type Registry struct {
+	mu        sync.RWMutex
+	providers map[string]*ProviderInfo
+	order     []string // Registration order, used for listings
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providers: make(map[string]*ProviderInfo),
+	}
+}
+
+// Register adds a provider. Prefixes must be unique, lowercase and made of letters and digits.
+func (r *Registry) Register(info ProviderInfo) error {
+	if info.Provider == nil {
+		return fmt.Errorf("provider %q has no implementation", info.Prefix)
+	}
+	if !validPrefix(info.Prefix) {
+		return fmt.Errorf("invalid provider prefix %q", info.Prefix)
+	}
+	if info.Name == "" {
+		info.Name = info.Prefix
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.providers[info.Prefix]; exists {
+		return fmt.Errorf("provider prefix %q is already registered", info.Prefix)
+	}
+
+	r.providers[info.Prefix] = &info
+	r.order = append(r.order, info.Prefix)
+	return nil
+}
+
+// Get returns the provider registered under prefix
+func (r *Registry) Get(prefix string) (*ProviderInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info, exists := r.providers[strings.ToLower(prefix)]
+	return info, exists
+}
+
+// List returns all providers in registration order
+func (r *Registry) List() []*ProviderInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]*ProviderInfo, 0, len(r.order))
+	for _, prefix := range r.order {
+		list = append(list, r.providers[prefix])
+	}
+	return list
+}
+
+// Prefixes returns the registered prefixes that have all of the given capabilities
+func (r *Registry) Prefixes(caps Capability) []string {
+	var prefixes []string
+	for _, info := range r.List() {
+		if info.Can(caps) {
+			prefixes = append(prefixes, info.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// Resolve splits an optional "<prefix>:" from a query. Queries without a known
+// prefix go to the default provider.
+func (r *Registry) Resolve(query, defaultPrefix string) (*ProviderInfo, string, error) {
+	if prefix, rest, found := strings.Cut(query, ":"); found {
+		if info, exists := r.Get(prefix); exists {
+			return info, strings.TrimSpace(rest), nil
+		}
+	}
+
+	info, exists := r.Get(defaultPrefix)
+	if !exists {
+		return nil, "", fmt.Errorf("unknown platform %q (available: %s)", defaultPrefix, strings.Join(r.sortedPrefixes(), ", "))
+	}
+	return info, query, nil
+}
+
+func (r *Registry) sortedPrefixes() []string {
+	prefixes := r.Prefixes(0)
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func validPrefix(prefix string) bool {
+	if prefix == "" {
+		return false
+	}
+	for _, c := range prefix {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,596 @@
+package audio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spotify Web API endpoints, overridable with SetBaseURL
+const (
+	DefaultSpotifyAPIURL      = "https://api.spotify.com/v1"
+	DefaultSpotifyAccountsURL = "https://accounts.spotify.com"
+)
+
+const (
+	// tokenExpiryMargin renews access tokens a little before Spotify expires them
+	tokenExpiryMargin = time.Minute
+
+	// mirrorDurationTolerance is how far (in seconds) a mirror match may be from the Spotify track length
+	mirrorDurationTolerance = 15
+)
+
+// spotifyCacheSize is how many tracks and mirror matches are kept, enough
+// for a few full playlists without growing for as long as the bot runs
+const spotifyCacheSize = 5000
+
+// ErrNoMirror is returned when a Spotify track is played without a provider to mirror it to
+var ErrNoMirror = errors.New("Spotify tracks can't be streamed directly and no mirror provider is configured")
+
+// SpotifyProvider searches the Spotify Web API. Spotify doesn't serve audio, so
+// tracks are played by resolving them to a match on a mirror provider (YouTube).
+type SpotifyProvider struct {
+	apiKey       string // Static access token, used when no client credentials are set
+	clientID     string
+	clientSecret string
+	apiURL       string
+	accountsURL  string
+	client       *http.Client
+	mirror       MusicProvider
+
+	mu          sync.Mutex
+	accessToken string
+	tokenExpiry time.Time
+	tracks      *lruCache[SearchResult] // Tracks seen in searches, so mirroring needs no extra lookup
+	mirrored    *lruCache[string]       // Spotify track ID -> mirror provider ID
+}
+
+// Spotify API response structures
+type spotifyTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+type spotifySearchResponse struct {
+	Tracks struct {
+		Items []spotifyTrack `json:"items"`
+	} `json:"tracks"`
+}
+
+type spotifyTrack struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"` // "track", or "episode" in playlists
+	Name       string `json:"name"`
+	DurationMS int    `json:"duration_ms"`
+	Artists    []struct {
+		Name string `json:"name"`
+	} `json:"artists"`
+	Album struct {
+		Images []struct {
+			URL string `json:"url"`
+		} `json:"images"`
+	} `json:"album"`
+	ExternalURLs struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+}
+
+type spotifyPlaylistTracks struct {
+	Items []struct {
+		Track *spotifyTrack `json:"track"` // null for removed tracks
+	} `json:"items"`
+	Next string `json:"next"`
+}
+
+type spotifyAlbum struct {
+	Name        string `json:"name"`
+	TotalTracks int    `json:"total_tracks"`
+	Images      []struct {
+		URL string `json:"url"`
+	} `json:"images"`
+}
+
+type spotifyAlbumTracks struct {
+	Items []spotifyTrack `json:"items"`
+	Next  string         `json:"next"`
+}
+
+type spotifyErrorResponse struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func NewSpotifyProvider(apiKey string) *SpotifyProvider {
+	return &SpotifyProvider{
+		apiKey:      apiKey,
+		apiURL:      DefaultSpotifyAPIURL,
+		accountsURL: DefaultSpotifyAccountsURL,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		tracks:      newLRUCache[SearchResult](spotifyCacheSize),
+		mirrored:    newLRUCache[string](spotifyCacheSize),
+	}
+}
+
+// SetCredentials enables the client credentials flow. Access tokens are requested
+// on first use, cached and renewed when they expire.
+func (sp *SpotifyProvider) SetCredentials(clientID, clientSecret string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.clientID = clientID
+	sp.clientSecret = clientSecret
+	sp.accessToken = ""
+}
+
+// SetBaseURL points the provider at different API and accounts servers, e.g. in tests
+func (sp *SpotifyProvider) SetBaseURL(apiURL, accountsURL string) {
+	sp.apiURL = strings.TrimRight(apiURL, "/")
+	sp.accountsURL = strings.TrimRight(accountsURL, "/")
+}
+
+// SetMirror sets the provider Spotify tracks are resolved to for playback
+func (sp *SpotifyProvider) SetMirror(mirror MusicProvider) {
+	sp.mirror = mirror
+}
+
+// authenticated reports whether the provider can call the Web API
+func (sp *SpotifyProvider) authenticated() bool {
+	return (sp.clientID != "" && sp.clientSecret != "") || sp.apiKey != ""
+}
+
+// Spotify Implementation
+func (sp *SpotifyProvider) Search(query string) ([]SearchResult, error) {
+	// Without credentials return mock results for testing
+	if !sp.authenticated() {
+		results := []SearchResult{
+			{
+				ID:       "4iV5W9uYEdYUVa79Axb7Rh",
+				Title:    "Shape of You",
+				Artist:   "Ed Sheeran",
+				Duration: 233,
+				Genre:    "pop",
+			},
+			{
+				ID:       "7qiZfU4dY1lWllzX7mPBI3",
+				Title:    "Blinding Lights",
+				Artist:   "The Weeknd",
+				Duration: 200,
+				Genre:    "pop",
+			},
+		}
+		sp.remember(results)
+		return results, nil
+	}
+
+	params := url.Values{
+		"q":     {query},
+		"type":  {"track"},
+		"limit": {"5"},
+	}
+
+	var response spotifySearchResponse
+	if err := sp.get("/search?"+params.Encode(), &response); err != nil {
+		return nil, fmt.Errorf("Spotify search failed: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(response.Tracks.Items))
+	for _, track := range response.Tracks.Items {
+		results = append(results, track.searchResult())
+	}
+	sp.remember(results)
+
+	return results, nil
+}
+
+// GetStreamURL resolves a Spotify track to a match on the mirror provider and
+// returns the mirror's stream URL. Matches are cached per track.
+func (sp *SpotifyProvider) GetStreamURL(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("invalid track ID")
+	}
+
+	// For mock IDs, return the ID as-is for testing
+	if strings.HasPrefix(id, "spotify_mock_") {
+		return id, nil
+	}
+
+	if sp.mirror == nil {
+		return "", ErrNoMirror
+	}
+
+	sp.mu.Lock()
+	mirrorID, cached := sp.mirrored.Get(id)
+	sp.mu.Unlock()
+
+	if !cached {
+		track, err := sp.track(id)
+		if err != nil {
+			return "", err
+		}
+
+		match, err := sp.findMirror(track)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Mirrored Spotify track %s (%s - %s) to %s (%s - %s)", id, track.Artist, track.Title, match.ID, match.Artist, match.Title)
+
+		mirrorID = match.ID
+		sp.mu.Lock()
+		sp.mirrored.Put(id, mirrorID)
+		sp.mu.Unlock()
+	}
+
+	return sp.mirror.GetStreamURL(mirrorID)
+}
+
+func (sp *SpotifyProvider) GetRecommendations(genre string) ([]SearchResult, error) {
+	// Return mock recommendations based on genre
+	recommendations := map[string][]SearchResult{
+		"pop": {
+			{ID: "sp_rec_pop_1", Title: "Spotify Pop Song 1", Artist: "Spotify Pop Artist 1", Genre: "pop"},
+			{ID: "sp_rec_pop_2", Title: "Spotify Pop Song 2", Artist: "Spotify Pop Artist 2", Genre: "pop"},
+		},
+		"rock": {
+			{ID: "sp_rec_rock_1", Title: "Spotify Rock Song 1", Artist: "Spotify Rock Artist 1", Genre: "rock"},
+			{ID: "sp_rec_rock_2", Title: "Spotify Rock Song 2", Artist: "Spotify Rock Artist 2", Genre: "rock"},
+		},
+		"unknown": {
+			{ID: "sp_rec_default_1", Title: "Spotify Default Song 1", Artist: "Spotify Default Artist 1", Genre: "unknown"},
+		},
+	}
+
+	if recs, exists := recommendations[genre]; exists {
+		return recs, nil
+	}
+	return recommendations["unknown"], nil
+}
+
+// IsPlaylistURL reports whether link is a Spotify playlist or album link or URI
+func (sp *SpotifyProvider) IsPlaylistURL(link string) bool {
+	_, _, ok := spotifyCollection(link)
+	return ok
+}
+
+// OpenPlaylist loads a Spotify playlist or album. Tracks are fetched page by
+// page and resolved to mirror matches only when they are played.
+func (sp *SpotifyProvider) OpenPlaylist(link string, limit int) (*Playlist, error) {
+	kind, id, ok := spotifyCollection(link)
+	if !ok {
+		return nil, fmt.Errorf("not a Spotify playlist or album link: %s", link)
+	}
+	if !sp.authenticated() {
+		return nil, fmt.Errorf("Spotify credentials are required to import playlists and albums")
+	}
+
+	if kind == "album" {
+		return sp.openAlbum(id, limit)
+	}
+	return sp.openPlaylist(id, limit)
+}
+
+func (sp *SpotifyProvider) openPlaylist(id string, limit int) (*Playlist, error) {
+	var info struct {
+		Name   string `json:"name"`
+		Tracks struct {
+			Total int `json:"total"`
+		} `json:"tracks"`
+	}
+	if err := sp.get("/playlists/"+url.PathEscape(id)+"?fields=name,tracks.total", &info); err != nil {
+		return nil, fmt.Errorf("failed to load Spotify playlist %s: %w", id, err)
+	}
+
+	offset := 0
+	pager := func() ([]SearchResult, bool, error) {
+		var page spotifyPlaylistTracks
+		if err := sp.get(fmt.Sprintf("/playlists/%s/tracks?%s", url.PathEscape(id), pageParams(offset, 100)), &page); err != nil {
+			return nil, false, fmt.Errorf("failed to load Spotify playlist tracks: %w", err)
+		}
+		offset += len(page.Items)
+
+		var tracks []SearchResult
+		for _, item := range page.Items {
+			// Skip removed tracks, local files (no ID) and podcast episodes
+			if item.Track == nil || item.Track.ID == "" || item.Track.Type == "episode" {
+				continue
+			}
+			tracks = append(tracks, item.Track.searchResult())
+		}
+		sp.remember(tracks)
+		return tracks, page.Next != "", nil
+	}
+
+	return NewPlaylist(info.Name, info.Tracks.Total, limit, pager)
+}
+
+func (sp *SpotifyProvider) openAlbum(id string, limit int) (*Playlist, error) {
+	var album spotifyAlbum
+	if err := sp.get("/albums/"+url.PathEscape(id), &album); err != nil {
+		return nil, fmt.Errorf("failed to load Spotify album %s: %w", id, err)
+	}
+
+	// Album track listings don't repeat the album artwork
+	var thumbnail string
+	if len(album.Images) > 0 {
+		thumbnail = album.Images[0].URL
+	}
+
+	offset := 0
+	pager := func() ([]SearchResult, bool, error) {
+		var page spotifyAlbumTracks
+		if err := sp.get(fmt.Sprintf("/albums/%s/tracks?%s", url.PathEscape(id), pageParams(offset, 50)), &page); err != nil {
+			return nil, false, fmt.Errorf("failed to load Spotify album tracks: %w", err)
+		}
+		offset += len(page.Items)
+
+		tracks := make([]SearchResult, 0, len(page.Items))
+		for _, item := range page.Items {
+			track := item.searchResult()
+			track.Thumbnail = thumbnail
+			tracks = append(tracks, track)
+		}
+		sp.remember(tracks)
+		return tracks, page.Next != "", nil
+	}
+
+	return NewPlaylist(album.Name, album.TotalTracks, limit, pager)
+}
+
+// track returns metadata for a track, from earlier searches or the Web API
+func (sp *SpotifyProvider) track(id string) (SearchResult, error) {
+	sp.mu.Lock()
+	track, known := sp.tracks.Get(id)
+	sp.mu.Unlock()
+	if known {
+		return track, nil
+	}
+
+	if !sp.authenticated() {
+		return SearchResult{}, fmt.Errorf("unknown Spotify track %s and no credentials to look it up", id)
+	}
+
+	var response spotifyTrack
+	if err := sp.get("/tracks/"+url.PathEscape(id), &response); err != nil {
+		return SearchResult{}, fmt.Errorf("failed to look up Spotify track %s: %w", id, err)
+	}
+
+	track = response.searchResult()
+	sp.remember([]SearchResult{track})
+	return track, nil
+}
+
+// findMirror searches the mirror provider for the track and picks the best match
+func (sp *SpotifyProvider) findMirror(track SearchResult) (SearchResult, error) {
+	candidates, err := sp.mirror.Search(track.Artist + " - " + track.Title)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("mirror search failed for %s - %s: %w", track.Artist, track.Title, err)
+	}
+
+	match, found := bestMirrorMatch(track, candidates)
+	if !found {
+		return SearchResult{}, fmt.Errorf("no playable match found for %s - %s", track.Artist, track.Title)
+	}
+	return match, nil
+}
+
+// bestMirrorMatch scores candidates by how far their length is from the track
+// and whether they mention its title and main artist. Candidates whose length
+// is off by more than mirrorDurationTolerance are rejected; ties keep the
+// mirror's own ranking.
+func bestMirrorMatch(track SearchResult, candidates []SearchResult) (SearchResult, bool) {
+	title := strings.ToLower(track.Title)
+	artist := strings.ToLower(strings.Split(track.Artist, ", ")[0])
+
+	best, bestScore := -1, 0
+	for i, candidate := range candidates {
+		score := 0
+		if track.Duration > 0 && candidate.Duration > 0 {
+			diff := track.Duration - candidate.Duration
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > mirrorDurationTolerance {
+				continue
+			}
+			score += diff
+		}
+
+		text := strings.ToLower(candidate.Title + " " + candidate.Artist)
+		if !strings.Contains(text, title) {
+			score += 2 * mirrorDurationTolerance
+		}
+		if !strings.Contains(text, artist) {
+			score += mirrorDurationTolerance
+		}
+
+		if best < 0 || score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		return SearchResult{}, false
+	}
+	return candidates[best], true
+}
+
+// remember caches track metadata for mirroring
+func (sp *SpotifyProvider) remember(results []SearchResult) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	for _, result := range results {
+		sp.tracks.Put(result.ID, result)
+	}
+}
+
+// get performs an authorized Web API request and decodes the JSON response
+// into v. A rejected token is renewed once before giving up.
+func (sp *SpotifyProvider) get(path string, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := sp.token(attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodGet, sp.apiURL+path, nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := sp.client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 && sp.clientID != "" {
+			resp.Body.Close()
+			continue
+		}
+
+		err = decodeSpotifyResponse(resp, v)
+		resp.Body.Close()
+		return err
+	}
+}
+
+// token returns a valid access token, requesting a new one when the cached
+// token is missing, expired or forceRefresh is set.
+func (sp *SpotifyProvider) token(forceRefresh bool) (string, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if sp.clientID == "" || sp.clientSecret == "" {
+		return sp.apiKey, nil
+	}
+
+	if forceRefresh || sp.accessToken == "" || !time.Now().Before(sp.tokenExpiry) {
+		if err := sp.refreshToken(); err != nil {
+			return "", err
+		}
+	}
+	return sp.accessToken, nil
+}
+
+// refreshToken requests a new access token with the client credentials flow.
+// The caller must hold sp.mu.
+func (sp *SpotifyProvider) refreshToken() error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	req, err := http.NewRequest(http.MethodPost, sp.accountsURL+"/api/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(sp.clientID, sp.clientSecret)
+
+	resp, err := sp.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request Spotify access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Spotify rejected the client credentials: %s", resp.Status)
+	}
+
+	var token spotifyTokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to parse Spotify access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("Spotify returned an empty access token")
+	}
+
+	sp.accessToken = token.AccessToken
+	sp.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpiryMargin)
+	return nil
+}
+
+// decodeSpotifyResponse decodes a successful response into v, or turns an
+// error response into an error carrying Spotify's message
+func decodeSpotifyResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode != http.StatusOK {
+		var apiErr spotifyErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("Spotify API error (%s): %s", resp.Status, apiErr.Error.Message)
+		}
+		return fmt.Errorf("Spotify API error: %s", resp.Status)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse Spotify response: %w", err)
+	}
+	return nil
+}
+
+// spotifyCollection parses open.spotify.com playlist and album links (with or
+// without a locale segment such as /intl-de/) and spotify:playlist:ID URIs.
+func spotifyCollection(link string) (kind, id string, ok bool) {
+	link = strings.TrimSpace(link)
+
+	var segments []string
+	if strings.HasPrefix(link, "spotify:") {
+		segments = strings.Split(strings.TrimPrefix(link, "spotify:"), ":")
+	} else {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || strings.ToLower(u.Hostname()) != "open.spotify.com" {
+			return "", "", false
+		}
+		segments = strings.Split(strings.Trim(u.Path, "/"), "/")
+		if len(segments) > 0 && strings.HasPrefix(segments[0], "intl-") {
+			segments = segments[1:]
+		}
+	}
+
+	if len(segments) != 2 || segments[1] == "" {
+		return "", "", false
+	}
+	if segments[0] != "playlist" && segments[0] != "album" {
+		return "", "", false
+	}
+	return segments[0], segments[1], true
+}
+
+// pageParams returns offset/limit query parameters for paged endpoints
+func pageParams(offset, limit int) string {
+	return url.Values{
+		"offset": {strconv.Itoa(offset)},
+		"limit":  {strconv.Itoa(limit)},
+	}.Encode()
+}
+
+func (t spotifyTrack) searchResult() SearchResult {
+	artists := make([]string, 0, len(t.Artists))
+	for _, artist := range t.Artists {
+		artists = append(artists, artist.Name)
+	}
+
+	var thumbnail string
+	if len(t.Album.Images) > 0 {
+		// Spotify lists album images from largest to smallest
+		thumbnail = t.Album.Images[0].URL
+	}
+
+	link := t.ExternalURLs.Spotify
+	if link == "" && t.ID != "" {
+		link = "https://open.spotify.com/track/" + t.ID
+	}
+
+	return SearchResult{
+		ID:        t.ID,
+		Title:     t.Name,
+		Artist:    strings.Join(artists, ", "),
+		Duration:  (t.DurationMS + 500) / 1000,
+		Genre:     "unknown",
+		Thumbnail: thumbnail,
+		URL:       link,
+	}
+}
@@ -0,0 +1,630 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ErrNoStreamTitles is returned by WatchTitles for streams without ICY metadata
+var ErrNoStreamTitles = errors.New("stream has no title metadata")
+
+const (
+	streamUserAgent = "SoulHound/1.0"
+
+	maxPlaylistSize  = 1 << 20
+	maxPlaylistDepth = 3 // Playlists pointing at playlists, e.g. an M3U listing a PLS
+)
+
+// StreamKind is what a web link plays
+type StreamKind int
+
+const (
+	StreamFile  StreamKind = iota // An audio file
+	StreamRadio                   // Icecast, Shoutcast or other endless audio
+	StreamHLS                     // HTTP Live Streaming (.m3u8)
+)
+
+// StreamInfo describes what a web link plays
+type StreamInfo struct {
+	URL      string // What ffmpeg plays, with PLS and M3U playlists resolved
+	Kind     StreamKind
+	Live     bool // Has no end, so it can't be seeked
+	Title    string
+	Artist   string
+	Genre    string
+	Duration time.Duration // 0 if live or unknown
+}
+
+// playlistTypes are the content types of M3U playlists, HLS included
+var playlistTypes = map[string]bool{
+	"audio/mpegurl":                 true,
+	"audio/x-mpegurl":               true,
+	"application/x-mpegurl":         true,
+	"application/vnd.apple.mpegurl": true,
+}
+
+// platformHosts are sites with their own providers, whose links aren't
+// played as web streams
+var platformHosts = []string{"youtube.com", "youtu.be", "spotify.com", "spotify.link"}
+
+// StreamProvider plays audio straight from web links: audio files,
+// Icecast and Shoutcast radio, HLS, and PLS or M3U playlists of those.
+// Searching for a link describes it, and stream URLs are the links
+// themselves with playlists resolved.
+type StreamProvider struct {
+	client  *http.Client
+	network publicNetwork
+	ffprobe string // Reads the tags and length of audio files, skipped if empty
+	timeout time.Duration
+}
+
+func NewStreamProvider() *StreamProvider {
+	s := &StreamProvider{
+		ffprobe: "ffprobe",
+		timeout: 15 * time.Second,
+	}
+
+	dialer := s.network.dialer()
+	transport := s.network.transport()
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &icyConn{Conn: conn}, nil
+	}
+	s.client = &http.Client{Transport: transport}
+	return s
+}
+
+// SetFFprobe sets the ffprobe binary used to read the tags and length of
+// audio files. An empty binary turns probing off.
+func (s *StreamProvider) SetFFprobe(binary string) {
+	s.ffprobe = binary
+}
+
+// SetAllowPrivateNetworks lets links lead to loopback and private network
+// addresses, e.g. for a radio served on the local network. They are refused
+// by default, so links shared in chat can't reach the bot's host or network.
+func (s *StreamProvider) SetAllowPrivateNetworks(allow bool) {
+	s.network.allowPrivate(allow)
+}
+
+// IsStreamURL reports whether link is a web link to play as a stream, rather
+// than a link for another provider such as a YouTube video
+func (s *StreamProvider) IsStreamURL(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, platform := range platformHosts {
+		if host == platform || strings.HasSuffix(host, "."+platform) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search describes the stream behind a web link, which is the query
+func (s *StreamProvider) Search(query string) ([]SearchResult, error) {
+	link := strings.Trim(strings.TrimSpace(query), "<>")
+	if !s.IsStreamURL(link) {
+		return nil, fmt.Errorf("%q is not a web link", query)
+	}
+
+	info, err := s.Probe(link)
+	if err != nil {
+		return nil, err
+	}
+
+	genre := info.Genre
+	if genre == "" {
+		genre = "unknown"
+	}
+	return []SearchResult{{
+		ID:       link, // Playlists are resolved again when the stream starts
+		Title:    info.Title,
+		Artist:   info.Artist,
+		Duration: int(info.Duration.Seconds()),
+		Genre:    genre,
+		URL:      link,
+		Live:     info.Live,
+	}}, nil
+}
+
+// GetStreamURL resolves the playlists a link may point at to the stream to play
+func (s *StreamProvider) GetStreamURL(id string) (string, error) {
+	info, err := s.probe(id, 0, false)
+	if err != nil {
+		return "", err
+	}
+	return info.URL, nil
+}
+
+// OpenStream opens a stream URL for ffmpeg to read. HLS streams are read as
+// one stream of their segments.
+func (s *StreamProvider) OpenStream(ctx context.Context, link string) (io.ReadCloser, error) {
+	resp, err := s.get(ctx, link, false)
+	if err != nil {
+		return nil, err
+	}
+
+	final := resp.Request.URL // After redirects
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext := strings.ToLower(path.Ext(final.Path))
+	if ext != ".m3u8" && ext != ".m3u" && !playlistTypes[contentType] {
+		return resp.Body, nil
+	}
+
+	body, err := readPlaylist(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Contains(body, []byte("#EXT-X-")) {
+		return nil, fmt.Errorf("%s is a playlist, not a stream", link)
+	}
+	return newHLSReader(ctx, s, final, body), nil
+}
+
+// GetRecommendations has nothing to recommend, web streams aren't a catalog
+func (s *StreamProvider) GetRecommendations(genre string) ([]SearchResult, error) {
+	return nil, nil
+}
+
+// Probe works out what a web link plays, following PLS and M3U playlists,
+// and reads the stream's name, tags and length
+func (s *StreamProvider) Probe(link string) (*StreamInfo, error) {
+	info, err := s.probe(link, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if u, err := url.Parse(info.URL); err == nil {
+		if info.Title == "" {
+			info.Title = linkTitle(u)
+		}
+		if info.Artist == "" {
+			info.Artist = u.Hostname()
+		}
+	}
+	return info, nil
+}
+
+// probe opens a link and describes it by its content type, extension and
+// headers. With details, audio files are probed for their tags and length.
+func (s *StreamProvider) probe(link string, depth int, details bool) (*StreamInfo, error) {
+	if depth > maxPlaylistDepth {
+		return nil, errors.New("too many nested playlists")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	resp, err := s.get(ctx, link, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	final := resp.Request.URL // After redirects
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext := strings.ToLower(path.Ext(final.Path))
+
+	switch {
+	case ext == ".m3u8" || ext == ".m3u" || playlistTypes[contentType]:
+		body, err := readPlaylist(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(body, []byte("#EXT-X-")) {
+			return s.probeHLS(ctx, final, body)
+		}
+		return s.probePlaylist(final, parseM3U(body), depth, details)
+
+	case ext == ".pls" || contentType == "audio/x-scpls":
+		body, err := readPlaylist(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return s.probePlaylist(final, parsePLS(body), depth, details)
+
+	case isRadio(resp.Header):
+		return &StreamInfo{
+			URL:    final.String(),
+			Kind:   StreamRadio,
+			Live:   true,
+			Title:  strings.TrimSpace(resp.Header.Get("icy-name")),
+			Artist: strings.TrimSpace(resp.Header.Get("icy-description")),
+			Genre:  strings.TrimSpace(resp.Header.Get("icy-genre")),
+		}, nil
+
+	case isAudio(contentType, ext):
+		// Files have a length, endless streams can't announce one
+		if resp.ContentLength < 0 && resp.Header.Get("Accept-Ranges") == "" {
+			return &StreamInfo{URL: final.String(), Kind: StreamRadio, Live: true}, nil
+		}
+		info := &StreamInfo{URL: final.String(), Kind: StreamFile}
+		if details {
+			s.probeFile(info, resp.Body)
+		}
+		return info, nil
+	}
+
+	if contentType == "" {
+		contentType = "no content type"
+	}
+	return nil, fmt.Errorf("%s doesn't look like audio (%s)", link, contentType)
+}
+
+// get requests a link, optionally asking for ICY metadata in the stream
+func (s *StreamProvider) get(ctx context.Context, link string, metadata bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %q: %w", link, err)
+	}
+	req.Header.Set("User-Agent", streamUserAgent)
+	if metadata {
+		req.Header.Set("Icy-MetaData", "1")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", link, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s answered %s", link, resp.Status)
+	}
+	return resp, nil
+}
+
+// isRadio reports whether response headers come from an Icecast or
+// Shoutcast server
+func isRadio(header http.Header) bool {
+	for _, name := range []string{"icy-name", "icy-metaint", "icy-br", "icy-genre", "ice-audio-info"} {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// isAudio reports whether a response is audio by its content type, or by
+// its extension for servers that don't say
+func isAudio(contentType, ext string) bool {
+	switch {
+	case strings.HasPrefix(contentType, "audio/"), strings.HasPrefix(contentType, "video/"), contentType == "application/ogg":
+		return true
+	case contentType == "" || contentType == "application/octet-stream" || contentType == "binary/octet-stream":
+		return localExtensions[ext] || ext == ".aac"
+	}
+	return false
+}
+
+// probeFile reads an audio file's tags and length with ffprobe, if it is
+// available. The file is piped to ffprobe rather than opened by it.
+func (s *StreamProvider) probeFile(info *StreamInfo, file io.Reader) {
+	if s.ffprobe == "" {
+		return
+	}
+	if _, err := exec.LookPath(s.ffprobe); err != nil {
+		return
+	}
+
+	tags, err := probeTags(s.ffprobe, "pipe:0", file, s.timeout)
+	if err != nil {
+		// The file may still play, it just has no metadata
+		return
+	}
+	info.Title, info.Artist, info.Genre, info.Duration = tags.Title, tags.Artist, tags.Genre, tags.Duration
+}
+
+// probeHLS describes an HLS playlist. Master playlists are described by
+// their first variant. Playlists without an end tag are live.
+func (s *StreamProvider) probeHLS(ctx context.Context, link *url.URL, body []byte) (*StreamInfo, error) {
+	info := &StreamInfo{URL: link.String(), Kind: StreamHLS}
+
+	media := body
+	if variant := hlsVariant(body); variant != "" {
+		variantURL, err := link.Parse(variant)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HLS variant %q: %w", variant, err)
+		}
+		resp, err := s.get(ctx, variantURL.String(), false)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if media, err = readPlaylist(resp.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	info.Live = !bytes.Contains(media, []byte("#EXT-X-ENDLIST"))
+	if !info.Live {
+		info.Duration = hlsDuration(media)
+	}
+	return info, nil
+}
+
+// hlsVariant returns the URI of the first variant of a master playlist, or
+// "" for media playlists
+func hlsVariant(body []byte) string {
+	lines := playlistLines(body)
+	for i, line := range lines {
+		if strings.HasPrefix(line, "#EXT-X-STREAM-INF") && i+1 < len(lines) && !strings.HasPrefix(lines[i+1], "#") {
+			return lines[i+1]
+		}
+	}
+	return ""
+}
+
+// hlsDuration adds up the segment lengths of a media playlist
+func hlsDuration(body []byte) time.Duration {
+	var seconds float64
+	for _, line := range playlistLines(body) {
+		if value, found := strings.CutPrefix(line, "#EXTINF:"); found {
+			value, _, _ = strings.Cut(value, ",")
+			if n, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && n > 0 {
+				seconds += n
+			}
+		}
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// playlistEntry is a stream listed in a PLS or M3U playlist
+type playlistEntry struct {
+	link  string
+	title string
+}
+
+// probePlaylist describes the first entry of a playlist that can be played.
+// Radio playlists often list several servers for the same station.
+func (s *StreamProvider) probePlaylist(base *url.URL, entries []playlistEntry, depth int, details bool) (*StreamInfo, error) {
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("the playlist at %s lists no streams", base)
+	}
+
+	var lastErr error
+	for _, entry := range entries {
+		link, err := base.Parse(entry.link)
+		if err != nil {
+			lastErr = fmt.Errorf("invalid playlist entry %q: %w", entry.link, err)
+			continue
+		}
+		info, err := s.probe(link.String(), depth+1, details)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if info.Title == "" {
+			info.Title = entry.title
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("no stream in the playlist at %s could be opened: %w", base, lastErr)
+}
+
+// parsePLS lists the entries of a PLS playlist in order
+func parsePLS(body []byte) []playlistEntry {
+	entries := map[int]*playlistEntry{}
+	entry := func(n int) *playlistEntry {
+		if entries[n] == nil {
+			entries[n] = &playlistEntry{}
+		}
+		return entries[n]
+	}
+
+	for _, line := range playlistLines(body) {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, "file")); err == nil && strings.HasPrefix(key, "file") {
+			entry(n).link = value
+		} else if n, err := strconv.Atoi(strings.TrimPrefix(key, "title")); err == nil && strings.HasPrefix(key, "title") {
+			entry(n).title = value
+		}
+	}
+
+	numbers := make([]int, 0, len(entries))
+	for n, e := range entries {
+		if e.link != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	list := make([]playlistEntry, 0, len(numbers))
+	for _, n := range numbers {
+		list = append(list, *entries[n])
+	}
+	return list
+}
+
+// parseM3U lists the entries of an M3U playlist, titled by their #EXTINF lines
+func parseM3U(body []byte) []playlistEntry {
+	var entries []playlistEntry
+	var title string
+	for _, line := range playlistLines(body) {
+		if info, found := strings.CutPrefix(line, "#EXTINF:"); found {
+			if _, name, found := strings.Cut(info, ","); found {
+				title = strings.TrimSpace(name)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, playlistEntry{link: line, title: title})
+		title = ""
+	}
+	return entries
+}
+
+// playlistLines splits a playlist into its non-empty lines
+func playlistLines(body []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(body), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func readPlaylist(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, maxPlaylistSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+	if len(body) > maxPlaylistSize {
+		return nil, errors.New("playlist is too large")
+	}
+	// Playlists may start with a UTF-8 byte order mark
+	return bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")), nil
+}
+
+// linkTitle names a stream after the file in its link, e.g. "Episode 12"
+// for ".../Episode%2012.mp3"
+func linkTitle(u *url.URL) string {
+	name := strings.TrimSuffix(path.Base(u.Path), path.Ext(u.Path))
+	if name == "" || name == "/" || name == "." {
+		return u.Hostname()
+	}
+	return name
+}
+
+// WatchTitles follows the ICY metadata of an Icecast or Shoutcast stream
+// and calls onTitle with each new StreamTitle, until ctx is done or the
+// stream ends. It opens a connection of its own next to the one ffmpeg
+// plays from, so a metadata problem never interrupts the audio.
+func (s *StreamProvider) WatchTitles(ctx context.Context, link string, onTitle func(string)) error {
+	resp, err := s.get(ctx, link, true)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	metaint, err := strconv.Atoi(resp.Header.Get("icy-metaint"))
+	if err != nil || metaint <= 0 {
+		return ErrNoStreamTitles
+	}
+	if err := readICYTitles(resp.Body, metaint, onTitle); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return nil
+}
+
+// readICYTitles reads a stream with ICY metadata, which follows every
+// metaint bytes of audio as a length byte counting 16-byte blocks and then
+// text such as "StreamTitle='Artist - Song';". It returns nil once the
+// stream ends.
+func readICYTitles(r io.Reader, metaint int, onTitle func(string)) error {
+	br := bufio.NewReader(r)
+	block := make([]byte, 255*16)
+	var last string
+	for {
+		if _, err := io.CopyN(io.Discard, br, int64(metaint)); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		length, err := br.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if length == 0 {
+			continue
+		}
+
+		metadata := block[:int(length)*16]
+		if _, err := io.ReadFull(br, metadata); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if title := streamTitle(metadata); title != "" && title != last {
+			last = title
+			onTitle(title)
+		}
+	}
+}
+
+// streamTitle returns the StreamTitle of an ICY metadata block. Titles may
+// hold quotes, so the value ends at the last "';" rather than the first quote.
+func streamTitle(metadata []byte) string {
+	text := string(bytes.TrimRight(metadata, "\x00"))
+	_, value, found := strings.Cut(text, "StreamTitle='")
+	if !found {
+		return ""
+	}
+	if end := strings.Index(value, "';"); end >= 0 {
+		value = value[:end]
+	} else {
+		value = strings.TrimSuffix(value, "'")
+	}
+
+	// Older servers send Latin-1
+	if !utf8.ValidString(value) {
+		runes := make([]rune, len(value))
+		for i := 0; i < len(value); i++ {
+			runes[i] = rune(value[i])
+		}
+		value = string(runes)
+	}
+	return strings.TrimSpace(value)
+}
+
+// icyConn lets net/http read Shoutcast v1 servers, which answer with
+// "ICY 200 OK" instead of an HTTP status line
+type icyConn struct {
+	net.Conn
+	checked bool
+	pending []byte
+}
+
+func (c *icyConn) Read(p []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(p, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	n, err := c.Conn.Read(p)
+	if c.checked || n == 0 {
+		return n, err
+	}
+	c.checked = true
+	if n >= 4 && string(p[:4]) == "ICY " {
+		rewritten := append([]byte("HTTP/1.0 "), p[4:n]...)
+		n = copy(p, rewritten)
+		c.pending = rewritten[n:]
+	}
+	return n, err
+}
@@ -0,0 +1,455 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Tags are the metadata read from an audio file. Empty fields are unknown.
+type Tags struct {
+	Title    string
+	Artist   string
+	Album    string
+	Genre    string
+	Duration time.Duration
+}
+
+// complete reports whether every field is known
+func (t *Tags) complete() bool {
+	return t.Title != "" && t.Artist != "" && t.Album != "" && t.Genre != "" && t.Duration > 0
+}
+
+// merge fills the fields t doesn't know from other
+func (t *Tags) merge(other Tags) {
+	if t.Title == "" {
+		t.Title = other.Title
+	}
+	if t.Artist == "" {
+		t.Artist = other.Artist
+	}
+	if t.Album == "" {
+		t.Album = other.Album
+	}
+	if t.Genre == "" {
+		t.Genre = other.Genre
+	}
+	if t.Duration <= 0 {
+		t.Duration = other.Duration
+	}
+}
+
+// set stores a tag by its common name, as used by Vorbis comments, RIFF INFO
+// chunks and ffprobe. The first value of a tag wins.
+func (t *Tags) set(name, value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+
+	var field *string
+	switch strings.ToLower(name) {
+	case "title", "inam":
+		field = &t.Title
+	case "artist", "iart":
+		field = &t.Artist
+	case "album", "iprd":
+		field = &t.Album
+	case "genre", "ignr":
+		field = &t.Genre
+	default:
+		return
+	}
+	if *field == "" {
+		*field = value
+	}
+}
+
+// maxTagSize bounds how much of a file is read for tags, so a corrupt size
+// field can't make us allocate gigabytes
+const maxTagSize = 16 << 20
+
+// errNoTags is returned by the tag readers for files without the tags they read
+var errNoTags = errors.New("no tags found")
+
+// ReadTags reads the tags of an MP3 (ID3v2), FLAC or WAV file. Other formats
+// have no built-in reader and return empty tags.
+func ReadTags(path string) (Tags, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+
+	var tags Tags
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp3":
+		err = readID3v2(f, &tags)
+	case ".flac":
+		err = readFLAC(f, &tags)
+	case ".wav":
+		err = readWAV(f, &tags)
+	}
+	if errors.Is(err, errNoTags) {
+		err = nil
+	}
+	return tags, err
+}
+
+// readID3v2 reads the title, artist, album, genre and length frames of an
+// ID3v2.2, 2.3 or 2.4 tag at the start of r
+func readID3v2(r io.Reader, tags *Tags) error {
+	var header [10]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:3]) != "ID3" {
+		return errNoTags
+	}
+	version, flags := header[3], header[5]
+	if version < 2 || version > 4 {
+		return fmt.Errorf("unsupported ID3v2.%d tag", version)
+	}
+	if flags&0x80 != 0 {
+		// Unsynchronised tags are rare and would need every frame undone
+		return errNoTags
+	}
+
+	size := syncsafe(header[6:10])
+	if size > maxTagSize {
+		return fmt.Errorf("ID3 tag of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return fmt.Errorf("truncated ID3 tag: %w", err)
+	}
+
+	if flags&0x40 != 0 && version > 2 && len(data) >= 4 {
+		// Skip the extended header. Only v2.4 counts the size field itself.
+		skip := int(binary.BigEndian.Uint32(data)) + 4
+		if version == 4 {
+			skip = syncsafe(data[:4])
+		}
+		data = data[min(skip, len(data)):]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+		var frameSize int
+		switch version {
+		case 2:
+			frameSize = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(data[4:8]))
+		default:
+			frameSize = syncsafe(data[4:8])
+		}
+		if frameSize < 0 || frameSize > len(data)-headerSize {
+			break
+		}
+		frame := data[headerSize : headerSize+frameSize]
+		data = data[headerSize+frameSize:]
+
+		switch id {
+		case "TIT2", "TT2":
+			tags.set("title", id3Text(frame))
+		case "TPE1", "TP1":
+			tags.set("artist", id3Text(frame))
+		case "TALB", "TAL":
+			tags.set("album", id3Text(frame))
+		case "TCON", "TCO":
+			tags.set("genre", id3Genre(id3Text(frame)))
+		case "TLEN", "TLE":
+			if ms, err := strconv.Atoi(strings.TrimSpace(id3Text(frame))); err == nil && tags.Duration == 0 {
+				tags.Duration = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return nil
+}
+
+// syncsafe decodes an ID3v2 syncsafe integer, which uses 7 bits per byte
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3Text decodes a text frame. Frames holding several values keep the first.
+func id3Text(frame []byte) string {
+	if len(frame) == 0 {
+		return ""
+	}
+	encoding, text := frame[0], frame[1:]
+
+	var s string
+	switch encoding {
+	case 1, 2: // UTF-16 with a byte order mark, UTF-16BE without
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(text) >= 2 {
+			if text[0] == 0xff && text[1] == 0xfe {
+				order = binary.LittleEndian
+			}
+			text = text[2:]
+		}
+		units := make([]uint16, 0, len(text)/2)
+		for i := 0; i+1 < len(text); i += 2 {
+			unit := order.Uint16(text[i:])
+			if unit == 0 {
+				break
+			}
+			units = append(units, unit)
+		}
+		s = string(utf16.Decode(units))
+	case 3: // UTF-8
+		s, _, _ = strings.Cut(string(text), "\x00")
+	default: // ISO-8859-1, whose code points match Unicode's first 256
+		text, _, _ = bytes.Cut(text, []byte{0})
+		runes := make([]rune, len(text))
+		for i, c := range text {
+			runes[i] = rune(c)
+		}
+		s = string(runes)
+	}
+	return strings.TrimSpace(s)
+}
+
+// id3Genres are the ID3v1 genres, which ID3v2 genre frames may refer to by number
+var id3Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+}
+
+// id3Genre resolves genres given by number, as in "17" or "(17)", and drops
+// the number from refinements such as "(17)Rock"
+func id3Genre(genre string) string {
+	number := genre
+	if strings.HasPrefix(genre, "(") {
+		ref, refinement, found := strings.Cut(genre[1:], ")")
+		if !found {
+			return genre
+		}
+		if refinement = strings.TrimSpace(refinement); refinement != "" {
+			return refinement
+		}
+		number = ref
+	}
+	if n, err := strconv.Atoi(number); err == nil {
+		if n >= 0 && n < len(id3Genres) {
+			return id3Genres[n]
+		}
+		return ""
+	}
+	return genre
+}
+
+// readFLAC reads the duration from a FLAC file's STREAMINFO block and the
+// tags from its Vorbis comment block
+func readFLAC(r io.Reader, tags *Tags) error {
+	var marker [4]byte
+	if _, err := io.ReadFull(r, marker[:]); err != nil || string(marker[:]) != "fLaC" {
+		return errNoTags
+	}
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return fmt.Errorf("truncated FLAC metadata: %w", err)
+		}
+		last, blockType := header[0]&0x80 != 0, header[0]&0x7f
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+
+		switch blockType {
+		case 0, 4: // STREAMINFO, VORBIS_COMMENT
+			block := make([]byte, size)
+			if _, err := io.ReadFull(r, block); err != nil {
+				return fmt.Errorf("truncated FLAC metadata: %w", err)
+			}
+			if blockType == 0 && len(block) >= 18 {
+				sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+				samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+				if sampleRate > 0 {
+					tags.Duration = time.Duration(samples * int64(time.Second) / sampleRate)
+				}
+			} else if blockType == 4 {
+				readVorbisComments(block, tags)
+			}
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+				return fmt.Errorf("truncated FLAC metadata: %w", err)
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// readVorbisComments reads "NAME=value" comments after the vendor string
+func readVorbisComments(block []byte, tags *Tags) {
+	next := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(block)
+		if uint64(n) > uint64(len(block)-4) {
+			return nil, false
+		}
+		value := block[4 : 4+n]
+		block = block[4+n:]
+		return value, true
+	}
+
+	if _, ok := next(); !ok { // Vendor
+		return
+	}
+	if len(block) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+		if name, value, found := strings.Cut(string(comment), "="); found {
+			tags.set(name, value)
+		}
+	}
+}
+
+// readWAV reads the duration from a WAV file's format and data chunks and the
+// tags from its LIST INFO chunk
+func readWAV(r io.Reader, tags *Tags) error {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return errNoTags
+	}
+
+	var byteRate uint32
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil // The chunks we need may come in any order, stop at the end
+		}
+		id, size := string(chunk[:4]), binary.LittleEndian.Uint32(chunk[4:])
+		// Chunks are padded to an even size
+		padded := int64(size) + int64(size&1)
+
+		switch {
+		case id == "fmt " && size >= 16:
+			data := make([]byte, padded)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("truncated WAV format chunk: %w", err)
+			}
+			byteRate = binary.LittleEndian.Uint32(data[8:12])
+		case id == "LIST" && size <= maxTagSize:
+			data := make([]byte, padded)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("truncated WAV info chunk: %w", err)
+			}
+			readRIFFInfo(data[:size], tags)
+		default:
+			if id == "data" && byteRate > 0 {
+				tags.Duration = time.Duration(int64(size) * int64(time.Second) / int64(byteRate))
+			}
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return nil // The data chunk often runs to the end of the file
+			}
+		}
+	}
+}
+
+// readRIFFInfo reads the text entries of a LIST chunk of type INFO
+func readRIFFInfo(data []byte, tags *Tags) {
+	if len(data) < 4 || string(data[:4]) != "INFO" {
+		return
+	}
+	data = data[4:]
+	for len(data) >= 8 {
+		id, size := string(data[:4]), int(binary.LittleEndian.Uint32(data[4:8]))
+		if size > len(data)-8 {
+			return
+		}
+		value, _, _ := strings.Cut(string(data[8:8+size]), "\x00")
+		tags.set(id, value)
+		data = data[min(8+size+size&1, len(data)):]
+	}
+}
+
+// ffprobeOutput is the subset of ffprobe's JSON output we use
+type ffprobeOutput struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams []struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"streams"`
+}
+
+// probeTags reads the tags and duration of any format ffmpeg understands by
+// running ffprobe. A path of "pipe:0" reads the file from stdin instead.
+func probeTags(binary, path string, stdin io.Reader, timeout time.Duration) (Tags, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", "--", path)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return Tags{}, fmt.Errorf("ffprobe timed out after %v", timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return Tags{}, fmt.Errorf("ffprobe failed: %s", msg)
+		}
+		return Tags{}, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var output ffprobeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return Tags{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var tags Tags
+	// Containers such as Ogg keep the tags on the stream instead of the format
+	for _, values := range append([]map[string]string{output.Format.Tags}, streamTags(output)...) {
+		for name, value := range values {
+			tags.set(name, value)
+		}
+	}
+	if seconds, err := strconv.ParseFloat(output.Format.Duration, 64); err == nil && seconds > 0 {
+		tags.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return tags, nil
+}
+
+func streamTags(output ffprobeOutput) []map[string]string {
+	tags := make([]map[string]string, 0, len(output.Streams))
+	for _, stream := range output.Streams {
+		tags = append(tags, stream.Tags)
+	}
+	return tags
+}
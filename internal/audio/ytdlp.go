@@ -0,0 +1,219 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ErrYTDLPNotFound is returned when the configured yt-dlp binary cannot be executed
+var ErrYTDLPNotFound = errors.New("yt-dlp not found. Install it (pip install yt-dlp) or set YTDLP_PATH")
+
+// YTDLP resolves YouTube searches, metadata and audio stream URLs by shelling
+// out to a yt-dlp binary.
+type YTDLP struct {
+	binary  string
+	timeout time.Duration
+}
+
+// ytdlpInfo is the subset of yt-dlp's --dump-json output we use.
+// Flat playlist entries only carry thumbnails, full extractions also carry thumbnail.
+type ytdlpInfo struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Uploader   string  `json:"uploader"`
+	Channel    string  `json:"channel"`
+	Duration   float64 `json:"duration"`
+	Thumbnail  string  `json:"thumbnail"`
+	WebpageURL string  `json:"webpage_url"`
+	URL        string  `json:"url"`
+	Thumbnails []struct {
+		URL string `json:"url"`
+	} `json:"thumbnails"`
+}
+
+// ytdlpPlaylist is the subset of yt-dlp's --dump-single-json output for flat playlists
+type ytdlpPlaylist struct {
+	Title         string      `json:"title"`
+	PlaylistCount int         `json:"playlist_count"`
+	Entries       []ytdlpInfo `json:"entries"`
+}
+
+// PlaylistPage is a slice of a playlist's entries
+type PlaylistPage struct {
+	Title  string
+	Total  int // Entries in the whole playlist, 0 if unknown
+	Tracks []SearchResult
+}
+
+func NewYTDLP(binary string) *YTDLP {
+	if binary == "" {
+		binary = "yt-dlp"
+	}
+	return &YTDLP{binary: binary, timeout: 30 * time.Second}
+}
+
+// Available reports whether the yt-dlp binary can be found
+func (y *YTDLP) Available() bool {
+	_, err := exec.LookPath(y.binary)
+	return err == nil
+}
+
+// Search runs a ytsearch query and returns up to limit results
+func (y *YTDLP) Search(query string, limit int) ([]SearchResult, error) {
+	if limit <= 0 {
+		limit = 5
+	}
+
+	output, err := y.run("--flat-playlist", "--dump-json", "--no-warnings", fmt.Sprintf("ytsearch%d:%s", limit, query))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var info ytdlpInfo
+		if err := json.Unmarshal(line, &info); err != nil {
+			return nil, fmt.Errorf("failed to parse yt-dlp search output: %w", err)
+		}
+		if info.ID == "" {
+			continue
+		}
+		results = append(results, info.searchResult())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read yt-dlp search output: %w", err)
+	}
+
+	return results, nil
+}
+
+// Metadata fetches title, uploader, duration and thumbnail for a single video
+func (y *YTDLP) Metadata(id string) (*SearchResult, error) {
+	output, err := y.run("--dump-json", "--no-playlist", "--no-warnings", "--", youtubeWatchURL(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var info ytdlpInfo
+	if err := json.Unmarshal(bytes.TrimSpace(output), &info); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp metadata: %w", err)
+	}
+
+	result := info.searchResult()
+	return &result, nil
+}
+
+// PlaylistPage lists up to count entries of a playlist, starting at the 1-based index start.
+// Entries are listed without extracting each video, which keeps large playlists fast.
+func (y *YTDLP) PlaylistPage(link string, start, count int) (*PlaylistPage, error) {
+	items := fmt.Sprintf("%d:%d", start, start+count-1)
+	output, err := y.run("--flat-playlist", "--dump-single-json", "--no-warnings", "--playlist-items", items, "--", link)
+	if err != nil {
+		return nil, err
+	}
+
+	var playlist ytdlpPlaylist
+	if err := json.Unmarshal(bytes.TrimSpace(output), &playlist); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp playlist output: %w", err)
+	}
+
+	page := &PlaylistPage{Title: playlist.Title, Total: playlist.PlaylistCount}
+	for _, entry := range playlist.Entries {
+		if entry.ID != "" {
+			page.Tracks = append(page.Tracks, entry.searchResult())
+		}
+	}
+	return page, nil
+}
+
+// StreamURL returns a direct URL to the best available audio format
+func (y *YTDLP) StreamURL(id string) (string, error) {
+	output, err := y.run("--get-url", "--format", "bestaudio/best", "--no-playlist", "--no-warnings", "--", youtubeWatchURL(id))
+	if err != nil {
+		return "", err
+	}
+
+	// Formats with separate audio and video print one URL per line, audio comes first
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("yt-dlp returned no stream URL for %s", id)
+}
+
+func (y *YTDLP) run(args ...string) ([]byte, error) {
+	if !y.Available() {
+		return nil, ErrYTDLPNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), y.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, y.binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("yt-dlp timed out after %v", y.timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("yt-dlp failed: %s", msg)
+		}
+		return nil, fmt.Errorf("yt-dlp failed: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (info ytdlpInfo) searchResult() SearchResult {
+	artist := info.Uploader
+	if artist == "" {
+		artist = info.Channel
+	}
+
+	thumbnail := info.Thumbnail
+	if thumbnail == "" && len(info.Thumbnails) > 0 {
+		// yt-dlp orders thumbnails from lowest to highest quality
+		thumbnail = info.Thumbnails[len(info.Thumbnails)-1].URL
+	}
+
+	link := info.WebpageURL
+	if link == "" && info.ID != "" {
+		// Flat playlist entries have no webpage_url
+		link = youtubeWatchURL(info.ID)
+	}
+
+	return SearchResult{
+		ID:        info.ID,
+		Title:     info.Title,
+		Artist:    artist,
+		Duration:  int(info.Duration),
+		Genre:     "unknown",
+		Thumbnail: thumbnail,
+		URL:       link,
+	}
+}
+
+// youtubeWatchURL turns a bare video ID into a watch URL; URLs pass through unchanged
+func youtubeWatchURL(id string) string {
+	if strings.Contains(id, "://") {
+		return id
+	}
+	return "https://www.youtube.com/watch?v=" + id
+}
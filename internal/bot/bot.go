@@ -1,9 +1,13 @@
 package bot
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -13,18 +17,39 @@ import (
 	"github.com/doomhound188/soulhound/internal/audio"
 	"github.com/doomhound188/soulhound/internal/config"
 	"github.com/doomhound188/soulhound/internal/queue"
+	"github.com/doomhound188/soulhound/internal/source"
+	"github.com/doomhound188/soulhound/internal/store"
 	"github.com/jonas747/dca"
 )
 
 type Bot struct {
 	session       *discordgo.Session
-	queue         *queue.Queue
-	youtubePlayer *audio.YouTubeProvider
-	spotifyPlayer *audio.SpotifyProvider
+	players       map[string]*Player // Per-guild playback state
+	providers     *audio.Registry
+	settings      *config.Guilds // Per-guild settings
 	voiceConn     map[string]*VoiceConnection
 	voiceStates   map[string]*VoiceStateInfo // Enhanced voice state tracking
+	searches      map[string]*searchResults  // Recent !search results by guild and user
+	searchCount   int                        // Searches offered so far, numbering their menus
+	cfg           *config.Config
 	mu            sync.Mutex
-	isPlaying     bool
+	done          chan struct{} // Closed on shutdown, stops background loops
+
+	// Joining and leaving voice channels, through the session except in tests
+	joinVoice  func(guildID, channelID string) (*discordgo.VoiceConnection, error)
+	leaveVoice func(conn *discordgo.VoiceConnection)
+
+	// Podcast listening positions, guarded by mu
+	podcastPositions map[string]map[string]int // Seconds into episodes, by guild and episode ID
+	podcastsChanged  map[string]bool           // Guilds whose positions weren't saved yet
+
+	// Persistence, disabled when no data directory is configured
+	store          *store.Store
+	pendingRestore []store.GuildState // Saved guilds waiting for the first Ready
+	restoreOnce    sync.Once
+	saveMu         sync.Mutex      // Serializes saveState, guards the fields below
+	restored       bool            // Saved guilds were resumed, saving may begin
+	savedGuilds    map[string]bool // Guilds with a state file
 }
 
 // Enhanced voice state tracking with timestamps and validation
@@ -40,6 +65,8 @@ type VoiceConnection struct {
 	guildID    string
 	encoder    *dca.EncodeSession
 	stream     *dca.StreamingSession
+	idleSince  time.Time // when the connection became idle, zero while in use
+	leavingBy  time.Time // until when Discord reporting the bot left is the bot's own doing
 }
 
 func New(cfg *config.Config) (*Bot, error) {
@@ -48,35 +75,95 @@ func New(cfg *config.Config) (*Bot, error) {
 		return nil, fmt.Errorf("error creating Discord session: %w", err)
 	}
 
+	providers, err := newProviderRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	bot := &Bot{
-		session:       session,
-		queue:         queue.NewQueue(),
-		youtubePlayer: audio.NewYouTubeProvider(cfg.YouTubeToken),
-		spotifyPlayer: audio.NewSpotifyProvider(cfg.SpotifyToken),
-		voiceConn:     make(map[string]*VoiceConnection),
-		voiceStates:   make(map[string]*VoiceStateInfo),
+		session:     session,
+		players:     make(map[string]*Player),
+		providers:   providers,
+		settings:    config.NewGuilds(config.DefaultGuildSettings(cfg)),
+		voiceConn:   make(map[string]*VoiceConnection),
+		voiceStates: make(map[string]*VoiceStateInfo),
+		searches:    make(map[string]*searchResults),
+		cfg:         cfg,
+		done:        make(chan struct{}),
+		savedGuilds: make(map[string]bool),
+
+		podcastPositions: make(map[string]map[string]int),
+		podcastsChanged:  make(map[string]bool),
+	}
+	bot.joinVoice = func(guildID, channelID string) (*discordgo.VoiceConnection, error) {
+		return session.ChannelVoiceJoin(guildID, channelID, false, false)
+	}
+	bot.leaveVoice = func(conn *discordgo.VoiceConnection) {
+		conn.Disconnect()
+	}
+
+	if cfg.DataDir != "" {
+		if bot.store, err = store.Open(cfg.DataDir); err != nil {
+			return nil, err
+		}
+		if err := bot.loadState(); err != nil {
+			return nil, fmt.Errorf("failed to load saved state: %w", err)
+		}
 	}
 
 	session.AddHandler(bot.messageHandler)
+	session.AddHandler(bot.interactionHandler)
 	session.AddHandler(bot.readyHandler)
 	session.AddHandler(bot.voiceStateUpdateHandler)
-	session.Identify.Intents = discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates | discordgo.IntentsMessageContent
+	session.Identify.Intents = bot.requiredIntents()
 
 	return bot, nil
 }
 
 func (b *Bot) Start() error {
-	return b.session.Open()
+	if err := b.session.Open(); err != nil {
+		return err
+	}
+
+	if b.cfg.SlashCommandsEnabled() {
+		if err := b.registerSlashCommands(); err != nil {
+			// Prefix commands may still work, so don't fail startup
+			log.Printf("Failed to register slash commands: %v", err)
+		}
+	}
+
+	go b.runIdleWatcher()
+	if library, ok := b.localLibrary(); ok {
+		go b.indexLibrary(library)
+	}
+	return nil
+}
+
+// requiredIntents returns the gateway intents needed for the configured command mode.
+// The privileged MessageContent intent is only requested when prefix commands are on.
+func (b *Bot) requiredIntents() discordgo.Intent {
+	intents := discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates
+	if b.cfg.PrefixCommandsEnabled() {
+		intents |= discordgo.IntentsMessageContent
+	}
+	return intents
 }
 
 func (b *Bot) Close() error {
+	close(b.done)
+
+	// Save queues and positions before the voice connections go away
+	if b.store != nil {
+		b.saveState()
+	}
+
 	// Cleanup voice connections
 	for _, vc := range b.voiceConn {
 		if vc.encoder != nil {
 			vc.encoder.Cleanup()
 		}
 		if vc.connection != nil {
-			vc.connection.Disconnect()
+			b.leaveVoice(vc.connection)
 		}
 	}
 	return b.session.Close()
@@ -86,7 +173,7 @@ func (b *Bot) readyHandler(s *discordgo.Session, r *discordgo.Ready) {
 	log.Printf("Bot is ready! Logged in as: %s#%s", r.User.Username, r.User.Discriminator)
 	log.Printf("Connected to %d guilds", len(r.Guilds))
 	log.Printf("Bot intents configured: %d", s.Identify.Intents)
-	log.Printf("Required intents: %d", discordgo.IntentsGuildMessages|discordgo.IntentsGuildVoiceStates|discordgo.IntentsMessageContent)
+	log.Printf("Required intents: %d", b.requiredIntents())
 	
 	// Initialize voice states from current guild data for all guilds
 	totalVoiceStates := 0
@@ -108,6 +195,12 @@ func (b *Bot) readyHandler(s *discordgo.Session, r *discordgo.Ready) {
 		}
 	}
 	log.Printf("Initialized with %d voice states across all guilds", totalVoiceStates)
+
+	if b.store != nil {
+		b.restoreOnce.Do(func() {
+			go b.restoreState()
+		})
+	}
 }
 
 func (b *Bot) voiceStateUpdateHandler(s *discordgo.Session, vsu *discordgo.VoiceStateUpdate) {
@@ -126,9 +219,23 @@ func (b *Bot) voiceStateUpdateHandler(s *discordgo.Session, vsu *discordgo.Voice
 	if vsu.ChannelID == "" {
 		log.Printf("🔊 User %s LEFT voice channel in guild %s", vsu.UserID, vsu.GuildID)
 		delete(b.voiceStates, key)
+
+		// The bot itself was disconnected (kicked, channel deleted, etc.),
+		// unless it left to rejoin or move
+		if b.isBotUser(vsu.UserID) {
+			if vc, exists := b.voiceConn[vsu.GuildID]; exists && vc.leftOnPurpose() {
+				log.Printf("🔊 Bot left voice in guild %s to rejoin, keeping the player", vsu.GuildID)
+			} else {
+				log.Printf("🔊 Bot was disconnected from voice in guild %s, tearing down player", vsu.GuildID)
+				b.teardownGuild(vsu.GuildID)
+			}
+		}
 		log.Printf("🔊 Internal tracking: Removed user %s from guild %s (total tracked: %d)", vsu.UserID, vsu.GuildID, len(b.voiceStates))
 	} else {
 		log.Printf("🔊 User %s JOINED voice channel %s in guild %s", vsu.UserID, vsu.ChannelID, vsu.GuildID)
+		if b.isBotUser(vsu.UserID) {
+			b.botMoved(vsu.GuildID, vsu.ChannelID)
+		}
 		b.voiceStates[key] = &VoiceStateInfo{
 			VoiceState: &discordgo.VoiceState{
 				UserID:    vsu.UserID,
@@ -140,12 +247,16 @@ func (b *Bot) voiceStateUpdateHandler(s *discordgo.Session, vsu *discordgo.Voice
 				SelfDeaf:  vsu.SelfDeaf,
 				SelfMute:  vsu.SelfMute,
 				Suppress:  vsu.Suppress,
+				Member:    vsu.Member,
 			},
 			LastUpdate: time.Now(),
 			Validated:  true,
 		}
 		log.Printf("🔊 Internal tracking: Added user %s to channel %s in guild %s (total tracked: %d)", vsu.UserID, vsu.ChannelID, vsu.GuildID, len(b.voiceStates))
 	}
+
+	// Pause when the bot is left alone, resume when someone comes back
+	b.updateAutoPause(vsu.GuildID)
 	
 	// Log all currently tracked voice states for debugging
 	log.Printf("🔊 All tracked voice states after update:")
@@ -155,191 +266,264 @@ func (b *Bot) voiceStateUpdateHandler(s *discordgo.Session, vsu *discordgo.Voice
 }
 
 func (b *Bot) messageHandler(s *discordgo.Session, m *discordgo.MessageCreate) {
+	// Prefix commands can be switched off once slash commands are rolled out
+	if !b.cfg.PrefixCommandsEnabled() {
+		return
+	}
+
 	// Ignore messages from the bot itself
 	if m.Author.ID == s.State.User.ID {
 		return
 	}
 
-	// Check if message starts with command prefix
-	if !strings.HasPrefix(m.Content, "!") {
+	// Check if message starts with the guild's command prefix
+	prefix := b.settings.Prefix(m.GuildID)
+	if !strings.HasPrefix(m.Content, prefix) {
 		return
 	}
 
 	// Split command and arguments
-	parts := strings.Fields(m.Content[1:])
+	parts := strings.Fields(m.Content[len(prefix):])
 	if len(parts) == 0 {
 		return
 	}
 
 	command := parts[0]
 	args := parts[1:]
-
-	// Check if command requires voice channel
-	voiceRequiredCommands := []string{"play", "pause", "resume", "stop", "skip"}
-	requiresVoice := false
-	for _, cmd := range voiceRequiredCommands {
-		if strings.ToLower(command) == cmd {
-			requiresVoice = true
-			break
-		}
-	}
+	rememberMember(s, m.GuildID, m.Member, m.Author)
 
 	var voiceChannelID string
-	if requiresVoice {
+	if commandRequiresVoice(command, args) {
 		// Ensure we have a valid guild ID
 		if m.GuildID == "" {
 			s.ChannelMessageSend(m.ChannelID, "Error: This command can only be used in a server")
 			return
 		}
 
-		// Skip permission check for now as it's causing false positives
-		// The Discord permissions screen shows the bot has all required permissions
+		voiceState := b.findUserVoiceState(s, m.GuildID, m.Author.ID, m.Author.Username)
+		if voiceState == nil {
+			s.ChannelMessageSend(m.ChannelID, voiceChannelRequiredMessage)
+			return
+		}
+		voiceChannelID = voiceState.ChannelID
+		log.Printf("Voice detection: SUCCESS - User %s is in voice channel %s", m.Author.Username, voiceChannelID)
+	}
 
-		// Enhanced voice detection - prioritize reliable internal tracking over unreliable API
-		var voiceState *discordgo.VoiceState
-		log.Printf("Voice detection: Starting voice state lookup for user %s (%s) in guild %s", m.Author.Username, m.Author.ID, m.GuildID)
+	if strings.EqualFold(command, "play") || strings.EqualFold(command, "pick") || strings.EqualFold(command, "podcast") {
+		b.setTextChannel(m.GuildID, m.ChannelID)
+	}
 
-		// Method 1: Check our internal voice state tracking first (MOST RELIABLE)
-		log.Printf("Voice detection: Trying method 1 - internal voice state tracking (primary)")
-		b.mu.Lock()
-		key := m.GuildID + ":" + m.Author.ID
-		if vs, exists := b.voiceStates[key]; exists && vs.VoiceState.ChannelID != "" {
-			log.Printf("Voice detection: ✅ FOUND in internal tracking - Channel: %s (Updated: %v)", vs.VoiceState.ChannelID, vs.LastUpdate.Format("15:04:05"))
-			voiceState = vs.VoiceState
-			b.mu.Unlock()
-			
-			// Internal tracking found the user - this is the most reliable source
-			// Skip other methods since voice state events are working perfectly
-			log.Printf("Voice detection: SUCCESS via internal tracking - User %s is in voice channel %s", m.Author.Username, voiceState.ChannelID)
-		} else {
-			b.mu.Unlock()
-			log.Printf("Voice detection: ❌ NOT FOUND in internal tracking")
-			
-			// Method 2: Direct API call as fallback (less reliable due to sync issues)
-			log.Printf("Voice detection: Trying method 2 - direct API call (fallback)")
-			guild, err := s.Guild(m.GuildID)
-			if err == nil && guild != nil {
-				log.Printf("Voice detection: API call successful, found %d voice states", len(guild.VoiceStates))
-				apiFoundUser := false
-				for _, vs := range guild.VoiceStates {
-					if vs.UserID == m.Author.ID && vs.ChannelID != "" {
-						log.Printf("Voice detection: Found matching voice state via API - Channel: %s", vs.ChannelID)
-						voiceState = vs
-						apiFoundUser = true
-						break
-					}
+	started := time.Now()
+	response, err := b.HandleCommand(command, args, voiceChannelID, m.GuildID, m.Author.ID)
+	if err != nil {
+		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %s", err))
+		return
+	}
+
+	if response == "" {
+		return
+	}
+	if offersResults(command, args) {
+		if menu := b.searchMenu(m.GuildID, m.Author.ID, started); menu != nil {
+			s.ChannelMessageSendComplex(m.ChannelID, &discordgo.MessageSend{Content: response, Components: menu})
+			return
+		}
+	}
+	s.ChannelMessageSend(m.ChannelID, response)
+}
+
+// voiceChannelRequiredMessage is shown when a voice command is used outside a voice channel
+const voiceChannelRequiredMessage = "❌ **You must be in a voice channel to use this command**\n\n" +
+	"**Troubleshooting:**\n" +
+	"• Make sure you're connected to a voice channel\n" +
+	"• Try leaving and rejoining the voice channel\n" +
+	"• Use `!debug` to see voice channel information\n" +
+	"• Wait a few seconds after joining before using commands\n" +
+	"• Check if the bot can see the voice channel you're in"
+
+// commandRequiresVoice reports whether a command needs the caller to be in a
+// voice channel. Listing a podcast's episodes doesn't, playing one does.
+func commandRequiresVoice(command string, args []string) bool {
+	if strings.EqualFold(command, "podcast") {
+		return len(args) > 1 && !strings.EqualFold(args[1], "list")
+	}
+	voiceRequiredCommands := []string{"play", "pick", "pause", "resume", "stop", "skip", "forceskip", "fs", "jump", "back", "seek", "forward", "ff", "rewind", "rw"}
+	for _, cmd := range voiceRequiredCommands {
+		if strings.ToLower(command) == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// findUserVoiceState locates the voice channel a user is connected to, trying
+// internal tracking first and falling back to the API and state cache.
+// It returns nil if the user is not in a voice channel.
+func (b *Bot) findUserVoiceState(s *discordgo.Session, guildID, userID, username string) *discordgo.VoiceState {
+	var voiceState *discordgo.VoiceState
+
+	// Enhanced voice detection - prioritize reliable internal tracking over unreliable API
+	log.Printf("Voice detection: Starting voice state lookup for user %s (%s) in guild %s", username, userID, guildID)
+
+	// Method 1: Check our internal voice state tracking first (MOST RELIABLE)
+	log.Printf("Voice detection: Trying method 1 - internal voice state tracking (primary)")
+	b.mu.Lock()
+	key := guildID + ":" + userID
+	if vs, exists := b.voiceStates[key]; exists && vs.VoiceState.ChannelID != "" {
+		log.Printf("Voice detection: ✅ FOUND in internal tracking - Channel: %s (Updated: %v)", vs.VoiceState.ChannelID, vs.LastUpdate.Format("15:04:05"))
+		voiceState = vs.VoiceState
+		b.mu.Unlock()
+		
+		// Internal tracking found the user - this is the most reliable source
+		// Skip other methods since voice state events are working perfectly
+		log.Printf("Voice detection: SUCCESS via internal tracking - User %s is in voice channel %s", username, voiceState.ChannelID)
+	} else {
+		b.mu.Unlock()
+		log.Printf("Voice detection: ❌ NOT FOUND in internal tracking")
+		
+		// Method 2: Direct API call as fallback (less reliable due to sync issues)
+		log.Printf("Voice detection: Trying method 2 - direct API call (fallback)")
+		guild, err := s.Guild(guildID)
+		if err == nil && guild != nil {
+			log.Printf("Voice detection: API call successful, found %d voice states", len(guild.VoiceStates))
+			apiFoundUser := false
+			for _, vs := range guild.VoiceStates {
+				if vs.UserID == userID && vs.ChannelID != "" {
+					log.Printf("Voice detection: Found matching voice state via API - Channel: %s", vs.ChannelID)
+					voiceState = vs
+					apiFoundUser = true
+					break
 				}
+			}
+			
+			// Check for API sync issues
+			if !apiFoundUser {
+				log.Printf("Voice detection: ⚠️ API SYNC ISSUE DETECTED - API shows 0 voice states but internal tracking may have data")
 				
-				// Check for API sync issues
-				if !apiFoundUser {
-					log.Printf("Voice detection: ⚠️ API SYNC ISSUE DETECTED - API shows 0 voice states but internal tracking may have data")
-					
-					// Double-check internal tracking one more time
-					b.mu.Lock()
-					if vs, exists := b.voiceStates[key]; exists && vs.VoiceState.ChannelID != "" {
-						log.Printf("Voice detection: 🔄 RECOVERED from API sync issue - Using internal tracking: Channel %s", vs.VoiceState.ChannelID)
-						voiceState = vs.VoiceState
-					}
-					b.mu.Unlock()
+				// Double-check internal tracking one more time
+				b.mu.Lock()
+				if vs, exists := b.voiceStates[key]; exists && vs.VoiceState.ChannelID != "" {
+					log.Printf("Voice detection: 🔄 RECOVERED from API sync issue - Using internal tracking: Channel %s", vs.VoiceState.ChannelID)
+					voiceState = vs.VoiceState
 				}
-			} else {
-				log.Printf("Voice detection: API call failed: %v", err)
+				b.mu.Unlock()
 			}
+		} else {
+			log.Printf("Voice detection: API call failed: %v", err)
+		}
 
-			// Method 3: Try cache lookup as additional fallback
-			if voiceState == nil || voiceState.ChannelID == "" {
-				log.Printf("Voice detection: Trying method 3 - cache lookup")
-				cacheVoiceState, err := s.State.VoiceState(m.GuildID, m.Author.ID)
-				if err != nil {
-					log.Printf("Voice detection: Cache lookup failed: %v", err)
-				} else if cacheVoiceState != nil && cacheVoiceState.ChannelID != "" {
-					log.Printf("Voice detection: Found voice state in cache - Channel: %s", cacheVoiceState.ChannelID)
-					voiceState = cacheVoiceState
-				} else {
-					log.Printf("Voice detection: Cache lookup returned nil or empty channel")
-				}
+		// Method 3: Try cache lookup as additional fallback
+		if voiceState == nil || voiceState.ChannelID == "" {
+			log.Printf("Voice detection: Trying method 3 - cache lookup")
+			cacheVoiceState, err := s.State.VoiceState(guildID, userID)
+			if err != nil {
+				log.Printf("Voice detection: Cache lookup failed: %v", err)
+			} else if cacheVoiceState != nil && cacheVoiceState.ChannelID != "" {
+				log.Printf("Voice detection: Found voice state in cache - Channel: %s", cacheVoiceState.ChannelID)
+				voiceState = cacheVoiceState
+			} else {
+				log.Printf("Voice detection: Cache lookup returned nil or empty channel")
 			}
+		}
 
-			// Method 4: Search through all cached voice states in the guild
-			if voiceState == nil || voiceState.ChannelID == "" {
-				log.Printf("Voice detection: Trying method 4 - searching guild voice states")
-				guild, err := s.State.Guild(m.GuildID)
-				if err == nil && guild != nil {
-					log.Printf("Voice detection: Found guild with %d voice states", len(guild.VoiceStates))
-					for _, vs := range guild.VoiceStates {
-						if vs.UserID == m.Author.ID && vs.ChannelID != "" {
-							log.Printf("Voice detection: Found matching voice state in guild cache - Channel: %s", vs.ChannelID)
-							voiceState = vs
-							break
-						}
+		// Method 4: Search through all cached voice states in the guild
+		if voiceState == nil || voiceState.ChannelID == "" {
+			log.Printf("Voice detection: Trying method 4 - searching guild voice states")
+			guild, err := s.State.Guild(guildID)
+			if err == nil && guild != nil {
+				log.Printf("Voice detection: Found guild with %d voice states", len(guild.VoiceStates))
+				for _, vs := range guild.VoiceStates {
+					if vs.UserID == userID && vs.ChannelID != "" {
+						log.Printf("Voice detection: Found matching voice state in guild cache - Channel: %s", vs.ChannelID)
+						voiceState = vs
+						break
 					}
-				} else {
-					log.Printf("Voice detection: Could not get guild from cache: %v", err)
-				}
-			}
-
-			// Method 5: Last resort - wait a moment and try cache again
-			if voiceState == nil || voiceState.ChannelID == "" {
-				log.Printf("Voice detection: Trying method 5 - retry after delay")
-				// Sometimes there's a delay in state updates, give it a moment
-				time.Sleep(100 * time.Millisecond)
-				delayedVoiceState, _ := s.State.VoiceState(m.GuildID, m.Author.ID)
-				if delayedVoiceState != nil && delayedVoiceState.ChannelID != "" {
-					log.Printf("Voice detection: Found voice state after delay - Channel: %s", delayedVoiceState.ChannelID)
-					voiceState = delayedVoiceState
 				}
+			} else {
+				log.Printf("Voice detection: Could not get guild from cache: %v", err)
 			}
 		}
 
+		// Method 5: Last resort - wait a moment and try cache again
 		if voiceState == nil || voiceState.ChannelID == "" {
-			log.Printf("Voice detection: FAILED - No voice state found after all methods")
-			errorMsg := "❌ **You must be in a voice channel to use this command**\n\n"
-			errorMsg += "**Troubleshooting:**\n"
-			errorMsg += "• Make sure you're connected to a voice channel\n"
-			errorMsg += "• Try leaving and rejoining the voice channel\n"
-			errorMsg += "• Use `!debug` to see voice channel information\n"
-			errorMsg += "• Wait a few seconds after joining before using commands\n"
-			errorMsg += "• Check if the bot can see the voice channel you're in"
-			s.ChannelMessageSend(m.ChannelID, errorMsg)
-			return
+			log.Printf("Voice detection: Trying method 5 - retry after delay")
+			// Sometimes there's a delay in state updates, give it a moment
+			time.Sleep(100 * time.Millisecond)
+			delayedVoiceState, _ := s.State.VoiceState(guildID, userID)
+			if delayedVoiceState != nil && delayedVoiceState.ChannelID != "" {
+				log.Printf("Voice detection: Found voice state after delay - Channel: %s", delayedVoiceState.ChannelID)
+				voiceState = delayedVoiceState
+			}
 		}
-		voiceChannelID = voiceState.ChannelID
-		log.Printf("Voice detection: SUCCESS - User %s is in voice channel %s", m.Author.Username, voiceChannelID)
-	}
-
-	response, err := b.HandleCommand(command, args, voiceChannelID, m.GuildID, m.Author.ID)
-	if err != nil {
-		s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Error: %s", err))
-		return
 	}
 
-	if response != "" {
-		s.ChannelMessageSend(m.ChannelID, response)
+	if voiceState == nil || voiceState.ChannelID == "" {
+		log.Printf("Voice detection: FAILED - No voice state found after all methods")
+		return nil
 	}
+	return voiceState
 }
 
 func (b *Bot) HandleCommand(command string, args []string, channelID string, guildID string, userID string) (string, error) {
+	if err := b.checkCommandPermission(command, args, guildID, userID); err != nil {
+		return "", err
+	}
+
 	switch strings.ToLower(command) {
 	case "play":
-		return b.handlePlay(args, channelID, guildID)
+		return b.handlePlay(args, channelID, guildID, userID)
 	case "pause":
-		return b.handlePause()
+		return b.handlePause(guildID)
 	case "resume":
-		return b.handleResume()
+		return b.handleResume(guildID)
 	case "stop":
-		return b.handleStop()
+		return b.handleStop(guildID)
+	case "leave":
+		return b.handleLeave(guildID)
 	case "queue":
-		return b.handleQueue()
+		return b.handleQueue(guildID)
+	case "nowplaying", "np":
+		return b.handleNowPlaying(guildID)
+	case "volume", "vol":
+		return b.handleVolume(args, guildID)
+	case "filter", "filters":
+		return b.handleFilter(args, guildID)
+	case "seek":
+		return b.handleSeek(args, guildID, 0)
+	case "forward", "ff":
+		return b.handleSeek(args, guildID, 1)
+	case "rewind", "rw":
+		return b.handleSeek(args, guildID, -1)
 	case "skip":
-		return b.handleSkip()
+		return b.handleSkip(guildID, userID)
+	case "forceskip", "fs":
+		return b.handleForceSkip(guildID, userID)
 	case "remove":
-		return b.handleRemove(args)
+		return b.handleRemove(args, guildID)
+	case "move":
+		return b.handleMove(args, guildID)
+	case "jump":
+		return b.handleJump(args, guildID)
+	case "back":
+		return b.handleBack(guildID)
+	case "shuffle":
+		return b.handleShuffle(guildID)
+	case "loop":
+		return b.handleLoop(args, guildID)
 	case "search":
-		return b.handleSearch(args)
+		return b.handleSearch(args, guildID, userID)
+	case "pick":
+		return b.handlePick(args, channelID, guildID, userID)
+	case "podcast":
+		return b.handlePodcast(args, channelID, guildID, userID)
 	case "setdefault":
-		return b.handleSetDefault(args)
+		return b.handleSetDefault(args, guildID)
 	case "smartplay":
-		return b.handleSmartPlay(args)
+		return b.handleSmartPlay(args, guildID)
+	case "settings":
+		return b.handleSettings(args, guildID)
+	case "permissions":
+		return b.handlePermissions(args, guildID)
 	case "help":
 		return b.handleHelp()
 	case "debug":
@@ -363,7 +547,11 @@ func (b *Bot) HandleCommand(command string, args []string, channelID string, gui
 	}
 }
 
-func (b *Bot) handlePlay(args []string, channelID string, guildID string) (string, error) {
+func (b *Bot) handlePlay(args []string, channelID string, guildID string, userID string) (string, error) {
+	choose := len(args) > 0 && isChooseFlag(args[0])
+	if choose {
+		args = args[1:]
+	}
 	if len(args) == 0 {
 		return "", errors.New("please provide a search query")
 	}
@@ -382,26 +570,27 @@ func (b *Bot) handlePlay(args []string, channelID string, guildID string) (strin
 		return "", fmt.Errorf("failed to join voice channel: %w", err)
 	}
 
-	platform := config.AppConfig.DefaultPlayer
 	query := strings.Join(args, " ")
+	by := b.requesterFor(guildID, userID)
 
-	// Check if platform is specified in the query
-	if strings.HasPrefix(query, "yt:") || strings.HasPrefix(query, "sp:") {
-		platform = query[:2]
-		query = query[3:]
+	// Playlist and album links are expanded instead of searched. Discord
+	// users wrap links in <> to suppress embeds.
+	link := strings.Trim(query, "<>")
+	if info, playlists, found := b.playlistProvider(link); found {
+		return b.queuePlaylist(guildID, info, playlists, link, by)
 	}
 
-	var results []audio.SearchResult
+	// Other web links are played as they are, e.g. audio files and radio
+	if streams, ok := b.webStreams(); ok && streams.IsStreamURL(link) {
+		return b.queueStream(guildID, streams, link, by)
+	}
 
-	switch platform {
-	case "yt":
-		results, err = b.youtubePlayer.Search(query)
-	case "sp":
-		results, err = b.spotifyPlayer.Search(query)
-	default:
-		return "", errors.New("invalid platform")
+	provider, query, err := b.searchProvider(guildID, query)
+	if err != nil {
+		return "", err
 	}
 
+	results, err := provider.Provider.Search(query)
 	if err != nil {
 		return "", err
 	}
@@ -410,34 +599,46 @@ func (b *Bot) handlePlay(args []string, channelID string, guildID string) (strin
 		return "No results found", nil
 	}
 
-	// Add first result to queue
-	track := queue.Track{
-		Title:    results[0].Title,
-		Artist:   results[0].Artist,
-		URL:      results[0].ID, // Store ID as URL for later streaming
-		Platform: platform,
-		Genre:    results[0].Genre,
+	if choose {
+		return b.offerResults(guildID, userID, provider.Prefix, results), nil
 	}
 
-	b.queue.Add(track)
+	// Add first result to queue
+	return b.queueTrack(guildID, newTrack(results[0], provider.Prefix, by))
+}
 
-	// Start playing if not already
+// queueTrack adds a track to a guild's queue, within the guild's limits, and
+// starts playback if nothing is playing
+func (b *Bot) queueTrack(guildID string, track queue.Track) (string, error) {
 	b.mu.Lock()
-	wasPlaying := b.isPlaying
+	player := b.getPlayer(guildID)
+	if b.queueRoom(guildID, player) == 0 {
+		b.mu.Unlock()
+		return "", b.errQueueFull(guildID)
+	}
+	if err := b.checkUserLimits(guildID, player, track); err != nil {
+		b.mu.Unlock()
+		return "", err
+	}
+	player.queue.Add(track)
+	wasPlaying := player.isPlaying
 	b.mu.Unlock()
 
+	// Start playing if not already
 	if !wasPlaying {
-		go b.startPlaying()
+		go b.startPlaying(guildID)
 	}
 
 	// Provide helpful feedback about what will happen
 	var response string
 	if strings.HasPrefix(track.URL, "mock_") || strings.HasPrefix(track.URL, "spotify_mock_") {
-		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n🎵 **Note:** This is a test track that will play silence for demonstration purposes.", track.Title, track.Artist)
-	} else if track.Platform == "yt" {
-		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n⚠️ **Note:** YouTube audio streaming requires youtube-dl/yt-dlp setup for actual playback.", track.Title, track.Artist)
-	} else if track.Platform == "sp" {
-		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n⚠️ **Note:** Spotify tracks cannot be streamed directly due to licensing restrictions.", track.Title, track.Artist)
+		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n🎵 **Note:** This is a test track that will play a short test tone for demonstration purposes.", track.Title, track.Artist)
+	} else if track.Platform == youtubePrefix && !b.ytdlpAvailable() {
+		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n⚠️ **Note:** yt-dlp was not found, YouTube playback falls back to the built-in library and may fail.", track.Title, track.Artist)
+	} else if track.Live {
+		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n📻 **Note:** This is a live stream, it plays until it is skipped.", track.Title, track.Artist)
+	} else if track.Platform == spotifyPrefix {
+		response = fmt.Sprintf("✅ **Added to queue:** %s - %s\n🔁 **Note:** Spotify tracks play through a matching YouTube upload.", track.Title, track.Artist)
 	} else {
 		response = fmt.Sprintf("✅ **Added to queue:** %s - %s", track.Title, track.Artist)
 	}
@@ -445,50 +646,50 @@ func (b *Bot) handlePlay(args []string, channelID string, guildID string) (strin
 	return response, nil
 }
 
-func (b *Bot) handlePause() (string, error) {
+func (b *Bot) handlePause(guildID string) (string, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if !b.isPlaying {
+	player, exists := b.players[guildID]
+	if !exists || !player.isPlaying || player.isPaused {
 		return "Nothing is playing", nil
 	}
 
-	for _, vc := range b.voiceConn {
-		if vc.stream != nil {
-			vc.stream.SetPaused(true)
-		}
-	}
-
-	b.isPlaying = false
+	b.setPaused(guildID, player, true)
 	return "Playback paused", nil
 }
 
-func (b *Bot) handleResume() (string, error) {
+func (b *Bot) handleResume(guildID string) (string, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if b.isPlaying {
-		return "Already playing", nil
+	player, exists := b.players[guildID]
+	if !exists || !player.isPlaying {
+		return "Nothing is playing", nil
 	}
 
-	for _, vc := range b.voiceConn {
-		if vc.stream != nil {
-			vc.stream.SetPaused(false)
-		}
+	if !player.isPaused {
+		return "Already playing", nil
 	}
 
-	b.isPlaying = true
+	b.setPaused(guildID, player, false)
 	return "Playback resumed", nil
 }
 
-func (b *Bot) handleStop() (string, error) {
+func (b *Bot) handleStop(guildID string) (string, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.isPlaying = false
-	b.queue.Clear()
+	if player, exists := b.players[guildID]; exists {
+		player.isPlaying = false
+		player.isPaused = false
+		player.current = nil
+		player.queue.Clear()
+		player.queueGen++
+		b.endNowPlaying(player)
+	}
 
-	for _, vc := range b.voiceConn {
+	if vc, exists := b.voiceConn[guildID]; exists {
 		if vc.encoder != nil {
 			vc.encoder.Cleanup()
 		}
@@ -500,40 +701,224 @@ func (b *Bot) handleStop() (string, error) {
 	return "Playback stopped and queue cleared", nil
 }
 
-func (b *Bot) handleQueue() (string, error) {
-	tracks := b.queue.List()
+// handleLeave disconnects from the guild's voice channel and discards its player
+func (b *Bot) handleLeave(guildID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, exists := b.voiceConn[guildID]; !exists {
+		return "Not connected to a voice channel", nil
+	}
+
+	b.teardownGuild(guildID)
+	return "Left the voice channel and cleared the queue", nil
+}
+
+func (b *Bot) handleQueue(guildID string) (string, error) {
+	player, exists := b.player(guildID)
+	if !exists {
+		return "Queue is empty", nil
+	}
+
+	tracks := player.queue.List()
 	if len(tracks) == 0 {
 		return "Queue is empty", nil
 	}
 
+	current := player.queue.CurrentIndex()
+
 	var sb strings.Builder
 	sb.WriteString("Current queue:\n")
 	for i, track := range tracks {
-		sb.WriteString(fmt.Sprintf("%d. %s - %s [%s]\n", i+1, track.Title, track.Artist, track.Platform))
+		marker := ""
+		if i == current {
+			marker = "▶ "
+		}
+		sb.WriteString(fmt.Sprintf("%s%d. %s - %s (%s) [%s] · requested by %s\n", marker, i+1, track.Title, track.Artist, trackLength(track), track.Platform, requestedBy(track)))
+	}
+	if current >= 0 {
+		seconds, unknown := player.queue.Remaining()
+		remaining := formatDuration(seconds)
+		if unknown > 0 {
+			remaining += fmt.Sprintf(" + %d of unknown length", unknown)
+		}
+		sb.WriteString(fmt.Sprintf("⏱ Remaining: %s\n", remaining))
+	}
+	if loop := player.queue.Loop(); loop != queue.LoopOff {
+		sb.WriteString(fmt.Sprintf("🔁 Loop: %s\n", loop))
 	}
 	return sb.String(), nil
 }
 
-func (b *Bot) handleSkip() (string, error) {
-	track, err := b.queue.Next()
+// handleNowPlaying shows the current track and how it is being played
+func (b *Bot) handleNowPlaying(guildID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists || !player.isPlaying || player.current == nil {
+		return "Nothing is playing", nil
+	}
+
+	track := player.current
+	status := "▶️ **Now playing:**"
+	if player.isPaused {
+		status = "⏸️ **Paused:**"
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s %s - %s [%s]\n", status, track.Title, track.Artist, track.Platform))
+	if track.Live {
+		if player.streamTitle != "" {
+			sb.WriteString(fmt.Sprintf("🎶 On air: %s\n", player.streamTitle))
+		}
+		sb.WriteString(fmt.Sprintf("🔴 Live for %s\n", formatPosition(b.playbackPosition(guildID, player))))
+	} else {
+		sb.WriteString(fmt.Sprintf("⏱ %s\n", positionOf(b.playbackPosition(guildID, player), track.Duration)))
+	}
+	sb.WriteString(fmt.Sprintf("👤 Requested by %s\n", requestedBy(*track)))
+	sb.WriteString(fmt.Sprintf("🔊 Volume: %d%%\n", b.settings.Volume(guildID)))
+	if len(player.filters) > 0 {
+		sb.WriteString(fmt.Sprintf("🎛️ Filters: %s\n", activeFilters(player.filters)))
+	}
+	if loop := player.queue.Loop(); loop != queue.LoopOff {
+		sb.WriteString(fmt.Sprintf("🔁 Loop: %s\n", loop))
+	}
+	return sb.String(), nil
+}
+
+// playCurrent interrupts the current stream so the playback loop picks up
+// the queue's new current track, or starts the loop if it had finished.
+// The caller must hold b.mu.
+func (b *Bot) playCurrent(guildID string, player *Player) {
+	if player.isPlaying {
+		player.skipped = true
+	}
+	if vc, exists := b.voiceConn[guildID]; exists && vc.encoder != nil {
+		vc.encoder.Cleanup()
+	}
+
+	if !player.isPlaying {
+		go b.startPlaying(guildID)
+	}
+}
+
+func (b *Bot) handleJump(args []string, guildID string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("please provide the track number to jump to")
+	}
+
+	index, err := parsePosition(args[0])
 	if err != nil {
 		return "", err
 	}
 
-	// Stop current playback
-	for _, vc := range b.voiceConn {
-		if vc.encoder != nil {
-			vc.encoder.Cleanup()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists {
+		return "", queue.ErrInvalidIndex
+	}
+
+	track, err := player.queue.JumpTo(index)
+	if err != nil {
+		return "", err
+	}
+
+	b.playCurrent(guildID, player)
+	return fmt.Sprintf("Jumped to: %s - %s", track.Title, track.Artist), nil
+}
+
+func (b *Bot) handleBack(guildID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists {
+		return "", queue.ErrQueueEmpty
+	}
+
+	track, err := player.queue.Previous()
+	if err != nil {
+		return "", err
+	}
+
+	b.playCurrent(guildID, player)
+	return fmt.Sprintf("Back to: %s - %s", track.Title, track.Artist), nil
+}
+
+func (b *Bot) handleMove(args []string, guildID string) (string, error) {
+	if len(args) != 2 {
+		return "", errors.New("please provide the track number to move and its new position")
+	}
+
+	from, err := parsePosition(args[0])
+	if err != nil {
+		return "", err
+	}
+	to, err := parsePosition(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	player, exists := b.player(guildID)
+	if !exists {
+		return "", queue.ErrInvalidIndex
+	}
+
+	if err := player.queue.Move(from, to); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Moved track %d to position %d", from+1, to+1), nil
+}
+
+func (b *Bot) handleShuffle(guildID string) (string, error) {
+	player, exists := b.player(guildID)
+	if !exists {
+		return "Queue is empty", nil
+	}
+
+	shuffled := player.queue.Shuffle()
+	if shuffled < 2 {
+		return "Not enough upcoming tracks to shuffle", nil
+	}
+	return fmt.Sprintf("🔀 Shuffled %d upcoming tracks", shuffled), nil
+}
+
+// handleLoop sets the loop mode, or cycles off -> track -> queue without arguments
+func (b *Bot) handleLoop(args []string, guildID string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("please specify off, track or queue")
+	}
+
+	b.mu.Lock()
+	player := b.getPlayer(guildID)
+	b.mu.Unlock()
+
+	mode := (player.queue.Loop() + 1) % (queue.LoopQueue + 1)
+	if len(args) == 1 {
+		var err error
+		if mode, err = queue.ParseLoopMode(args[0]); err != nil {
+			return "", err
 		}
 	}
 
-	// Start playing next track
-	go b.startPlaying()
+	player.queue.SetLoop(mode)
+	return fmt.Sprintf("🔁 Loop mode: %s", mode), nil
+}
 
-	return fmt.Sprintf("Skipped to: %s - %s", track.Title, track.Artist), nil
+// parsePosition converts a 1-based queue position argument into an index
+func parsePosition(arg string) (int, error) {
+	position, err := strconv.Atoi(arg)
+	if err != nil || position < 1 {
+		return 0, errors.New("invalid track number")
+	}
+	return position - 1, nil
 }
 
-func (b *Bot) handleRemove(args []string) (string, error) {
+func (b *Bot) handleRemove(args []string, guildID string) (string, error) {
 	if len(args) == 0 {
 		return "", errors.New("please provide the track number to remove")
 	}
@@ -543,32 +928,32 @@ func (b *Bot) handleRemove(args []string) (string, error) {
 		return "", errors.New("invalid track number")
 	}
 
+	player, exists := b.player(guildID)
+	if !exists {
+		return "", queue.ErrInvalidIndex
+	}
+
 	index-- // Convert to 0-based index
-	if err := b.queue.Remove(index); err != nil {
+	if err := player.queue.Remove(index); err != nil {
 		return "", err
 	}
 
 	return fmt.Sprintf("Removed track at position %d", index+1), nil
 }
 
-func (b *Bot) handleSearch(args []string) (string, error) {
+// handleSearch lists the top results for a query and remembers them, so the
+// user can queue one with !pick
+func (b *Bot) handleSearch(args []string, guildID, userID string) (string, error) {
 	if len(args) == 0 {
 		return "", errors.New("please provide a search query")
 	}
 
-	query := strings.Join(args, " ")
-	platform := config.AppConfig.DefaultPlayer
-
-	var results []audio.SearchResult
-	var err error
-
-	switch platform {
-	case "yt":
-		results, err = b.youtubePlayer.Search(query)
-	case "sp":
-		results, err = b.spotifyPlayer.Search(query)
+	provider, query, err := b.searchProvider(guildID, strings.Join(args, " "))
+	if err != nil {
+		return "", err
 	}
 
+	results, err := provider.Provider.Search(query)
 	if err != nil {
 		return "", err
 	}
@@ -576,141 +961,245 @@ func (b *Bot) handleSearch(args []string) (string, error) {
 	if len(results) == 0 {
 		return "No results found", nil
 	}
+	return b.offerResults(guildID, userID, provider.Prefix, results), nil
+}
 
-	var sb strings.Builder
-	sb.WriteString("Search results:\n")
-	maxResults := 5
-	if len(results) < maxResults {
-		maxResults = len(results)
+// handleSetDefault sets the guild's default platform, a shortcut for !settings platform
+func (b *Bot) handleSetDefault(args []string, guildID string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("please specify a platform: %s", b.platformList())
 	}
-	for i, result := range results[:maxResults] {
-		sb.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, result.Title, result.Artist))
-	}
-	return sb.String(), nil
-}
 
-func (b *Bot) handleSetDefault(args []string) (string, error) {
-	if len(args) != 1 || (args[0] != "yt" && args[0] != "sp") {
-		return "", errors.New("please specify either 'yt' or 'sp'")
+	provider, exists := b.providers.Get(args[0])
+	if !exists || !provider.Can(audio.CapSearch) {
+		return "", fmt.Errorf("unknown platform %q, available: %s", args[0], b.platformList())
 	}
 
-	config.SetDefaultPlayer(args[0])
-	return fmt.Sprintf("Default player set to %s", args[0]), nil
+	if err := b.settings.SetDefaultPlatform(guildID, provider.Prefix); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Default player set to %s (%s)", provider.Prefix, provider.Name), nil
 }
 
-func (b *Bot) handleSmartPlay(args []string) (string, error) {
+func (b *Bot) handleSmartPlay(args []string, guildID string) (string, error) {
 	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
 		return "", errors.New("please specify either 'on' or 'off'")
 	}
 
 	enabled := args[0] == "on"
-	config.ToggleSmartPlay(enabled)
+	if err := b.settings.SetSmartPlay(guildID, enabled); err != nil {
+		return "", err
+	}
 	return fmt.Sprintf("Smart play %s", args[0]), nil
 }
 
-func (b *Bot) startPlaying() {
+// startPlaying runs the playback loop for a single guild until its queue
+// runs out or playback is stopped.
+func (b *Bot) startPlaying(guildID string) {
 	b.mu.Lock()
-	if b.isPlaying {
+	player := b.getPlayer(guildID)
+	if player.isPlaying {
 		b.mu.Unlock()
 		return
 	}
-	b.isPlaying = true
+	player.isPlaying = true
+	player.isPaused = false
 	b.mu.Unlock()
 
+	announce := true
+	failures := 0 // Tracks in a row whose stream URL couldn't be resolved
 	for {
 		b.mu.Lock()
-		if !b.isPlaying {
+		if !player.isPlaying || b.players[guildID] != player {
 			b.mu.Unlock()
 			return
 		}
 
-		track, err := b.queue.Current()
+		track, err := player.queue.Current()
 		if err != nil {
-			log.Printf("No current track in queue, stopping playback: %v", err)
-			b.isPlaying = false
+			log.Printf("No current track in queue for guild %s, stopping playback: %v", guildID, err)
+			player.isPlaying = false
+			player.current = nil
+			b.endNowPlaying(player)
 			b.mu.Unlock()
 			return
 		}
+		player.current = &track
+		if announce {
+			player.skipVotes = nil
+			player.streamTitle = ""
+			// Podcast episodes continue where they were left off
+			if player.startAt == 0 {
+				player.startAt = b.resumeEpisode(guildID, track)
+			}
+		}
 
-		log.Printf("Starting playback for track: %s - %s (Platform: %s, URL: %s)", track.Title, track.Artist, track.Platform, track.URL)
+		// Resumed and restarted tracks continue where they left off
+		settings := b.streamSettings(guildID, player)
+		player.startAt = 0
+		player.streamStart = settings.Start
+		player.streamSpeed = settings.speed()
+		if vc, exists := b.voiceConn[guildID]; exists {
+			vc.stream = nil
+		}
 
-		// Get stream URL based on platform
+		b.mu.Unlock()
+
+		log.Printf("Starting playback in guild %s for track: %s - %s (Platform: %s, URL: %s)", guildID, track.Title, track.Artist, track.Platform, track.URL)
+
+		// Get stream URL from the track's provider. This can take a while,
+		// e.g. running yt-dlp, so b.mu is released and other guilds carry on.
 		var streamURL string
 		var streamErr error
-		if track.Platform == "yt" {
-			streamURL, streamErr = b.youtubePlayer.GetStreamURL(track.URL)
+		var opener audio.StreamOpener
+		if provider, exists := b.providers.Get(track.Platform); !exists {
+			streamErr = fmt.Errorf("unknown platform %q", track.Platform)
+		} else if !provider.Can(audio.CapStream) {
+			streamErr = fmt.Errorf("%s does not support streaming", provider.Name)
 		} else {
-			streamURL, streamErr = b.spotifyPlayer.GetStreamURL(track.URL)
+			streamURL, streamErr = provider.Provider.GetStreamURL(track.URL)
+			opener, _ = provider.Provider.(audio.StreamOpener)
+		}
+
+		b.mu.Lock()
+		if !player.isPlaying || b.players[guildID] != player {
+			b.mu.Unlock()
+			return
+		}
+		// A command moved to another track or position in the meantime
+		if player.skipped {
+			announce = !player.restarting
+			player.skipped = false
+			player.restarting = false
+			b.mu.Unlock()
+			continue
 		}
 
 		if streamErr != nil {
 			log.Printf("Failed to get stream URL for track %s: %v", track.Title, streamErr)
+			// Once every track failed in a row, a looping queue would only
+			// go round resolving them again
+			failures++
+			if failures >= len(player.queue.List()) {
+				log.Printf("No track in the queue of guild %s could be played, stopping playback", guildID)
+				player.isPlaying = false
+				player.current = nil
+				b.endNowPlaying(player)
+				b.mu.Unlock()
+				return
+			}
 			// Skip this track and move to next
-			b.queue.Next()
+			player.queue.Next()
 			b.mu.Unlock()
 			continue
 		}
+		failures = 0
 
-		// Get a copy of voice connections for iteration
-		voiceConnections := make(map[string]*VoiceConnection)
-		for k, v := range b.voiceConn {
-			voiceConnections[k] = v
-		}
+		vc, connected := b.voiceConn[guildID]
 		b.mu.Unlock()
 
+		// A restarted track keeps its now playing message
+		if announce && connected {
+			go b.announceTrack(guildID)
+		}
+		announce = true
+
+		stopTitles := b.watchStreamTitle(guildID, player, track, streamURL)
+		stopEpisode := b.watchEpisode(guildID, player, track)
+
 		// Track streaming success/failure
 		streamingSuccessful := false
 		maxRetries := 3
-		retryCount := 0
 
-		// Stream to all connected voice channels with retry logic
-		for guildID, vc := range voiceConnections {
-			for retryCount < maxRetries {
-				log.Printf("Attempting to stream audio in guild %s (attempt %d/%d)", guildID, retryCount+1, maxRetries)
-				
-				if err := b.streamAudio(streamURL, vc); err != nil {
-					log.Printf("Error streaming audio in guild %s (attempt %d): %v", guildID, retryCount+1, err)
-					retryCount++
-					
-					// Check if this is a mock/test track that should be skipped
-					if strings.HasPrefix(streamURL, "spotify_mock_") || strings.HasPrefix(streamURL, "mock_") {
-						log.Printf("Mock track detected, skipping retries for %s", streamURL)
-						break
-					}
-					
-					// Wait before retry (exponential backoff)
-					if retryCount < maxRetries {
-						waitTime := time.Duration(retryCount) * time.Second
-						log.Printf("Waiting %v before retry...", waitTime)
-						time.Sleep(waitTime)
-					}
-				} else {
-					log.Printf("Successfully streamed audio in guild %s", guildID)
-					streamingSuccessful = true
+		// Stream to this guild's voice channel with retry logic
+		for retryCount := 0; connected && retryCount < maxRetries; {
+			log.Printf("Attempting to stream audio in guild %s (attempt %d/%d)", guildID, retryCount+1, maxRetries)
+
+			if err := b.streamAudio(streamURL, opener, vc, settings); err != nil {
+				log.Printf("Error streaming audio in guild %s (attempt %d): %v", guildID, retryCount+1, err)
+				retryCount++
+
+				// A skip or stop interrupts the stream on purpose, don't retry it
+				b.mu.Lock()
+				interrupted := player.skipped || !player.isPlaying
+				b.mu.Unlock()
+				if interrupted {
 					break
 				}
+
+				// A dropped voice connection is rejoined and the track resumed
+				// where it stopped, instead of retrying from the start
+				if voiceDropped(vc, err) {
+					b.recoverVoice(guildID, player, vc)
+					break
+				}
+
+				// Check if this is a mock/test track that should be skipped
+				if strings.HasPrefix(streamURL, "spotify_mock_") || strings.HasPrefix(streamURL, "mock_") {
+					log.Printf("Mock track detected, skipping retries for %s", streamURL)
+					break
+				}
+
+				// Wait before retry (exponential backoff)
+				if retryCount < maxRetries {
+					waitTime := time.Duration(retryCount) * time.Second
+					log.Printf("Waiting %v before retry...", waitTime)
+					time.Sleep(waitTime)
+				}
+			} else {
+				log.Printf("Successfully streamed audio in guild %s", guildID)
+				streamingSuccessful = true
+				break
 			}
-			
-			// Reset retry count for next guild
-			retryCount = 0
 		}
 
+		stopTitles()
+		stopEpisode()
+
 		// If streaming failed completely, skip this track
-		if !streamingSuccessful && len(voiceConnections) > 0 {
+		if !streamingSuccessful && connected {
 			log.Printf("Failed to stream track %s after %d retries, skipping to next track", track.Title, maxRetries)
 		}
 
+		// Move to next track unless a command already did
+		b.mu.Lock()
+		if !player.isPlaying {
+			b.mu.Unlock()
+			return
+		}
+		if player.restarting {
+			player.restarting = false
+			player.skipped = false
+			announce = false
+			b.mu.Unlock()
+			continue
+		}
+		b.mu.Unlock()
+
 		// If smart play is enabled, add recommendations to queue
-		if config.PlayerConfig.SmartPlayEnabled {
-			b.addRecommendations(track)
+		if b.settings.SmartPlay(guildID) {
+			b.addRecommendations(player, &track)
+		}
+
+		b.mu.Lock()
+		if player.skipped {
+			player.skipped = false
+			b.mu.Unlock()
+			continue
 		}
+		// A podcast episode that played to the end starts over next time
+		if streamingSuccessful {
+			b.rememberEpisode(guildID, track, 0)
+		}
+		b.mu.Unlock()
 
-		// Move to next track
-		_, err = b.queue.Next()
+		_, err = player.queue.Advance()
 		if err != nil {
-			log.Printf("No more tracks in queue, stopping playback: %v", err)
+			log.Printf("No more tracks in queue for guild %s, stopping playback: %v", guildID, err)
 			b.mu.Lock()
-			b.isPlaying = false
+			player.isPlaying = false
+			player.current = nil
+			b.endNowPlaying(player)
 			b.mu.Unlock()
 			return
 		}
@@ -718,6 +1207,19 @@ func (b *Bot) startPlaying() {
 }
 
 func (b *Bot) joinVoiceChannel(guildID, channelID string) (*VoiceConnection, error) {
+	b.mu.Lock()
+	current, connected := b.voiceConn[guildID]
+	inChannel := connected && current.channelID == channelID
+	b.mu.Unlock()
+	if inChannel {
+		return current, nil
+	}
+
+	// Find out what is missing before Discord silently refuses the join
+	if err := b.checkVoiceJoin(guildID, channelID); err != nil {
+		return nil, err
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
@@ -726,13 +1228,19 @@ func (b *Bot) joinVoiceChannel(guildID, channelID string) (*VoiceConnection, err
 		if vc.channelID == channelID {
 			return vc, nil
 		}
-		// Disconnect from current channel
-		vc.connection.Disconnect()
+		// Disconnect from current channel, keeping the player when Discord
+		// reports the bot left
+		vc.expectLeave()
+		b.leaveVoice(vc.connection)
 	}
 
 	// Join new channel
-	conn, err := b.session.ChannelVoiceJoin(guildID, channelID, false, false)
+	conn, err := b.joinVoice(guildID, channelID)
 	if err != nil {
+		if vc, exists := b.voiceConn[guildID]; exists {
+			// The bot is out of voice after all
+			vc.leavingBy = time.Time{}
+		}
 		return nil, err
 	}
 
@@ -741,12 +1249,17 @@ func (b *Bot) joinVoiceChannel(guildID, channelID string) (*VoiceConnection, err
 		channelID:  channelID,
 		guildID:    guildID,
 	}
+	if old, exists := b.voiceConn[guildID]; exists {
+		vc.leavingBy = old.leavingBy
+	}
 	b.voiceConn[guildID] = vc
 
 	return vc, nil
 }
 
-func (b *Bot) streamAudio(url string, vc *VoiceConnection) error {
+// streamAudio plays a stream URL with the given encoder settings. URLs of
+// providers with an opener are opened by the provider, not by ffmpeg.
+func (b *Bot) streamAudio(url string, opener audio.StreamOpener, vc *VoiceConnection, settings streamSettings) error {
 	// Validate URL
 	if url == "" {
 		return fmt.Errorf("empty stream URL")
@@ -756,74 +1269,96 @@ func (b *Bot) streamAudio(url string, vc *VoiceConnection) error {
 
 	// Check if this is a mock/test URL (including Spotify mock URLs)
 	if strings.HasPrefix(url, "mock_") || strings.HasPrefix(url, "spotify_mock_") {
-		log.Printf("Mock audio detected, creating test silence stream")
-		return b.streamTestAudio(vc)
+		log.Printf("Mock audio detected, creating test tone stream")
+		return b.streamTestAudio(vc, mockTrackDuration)
 	}
 
-	// Check if this is a YouTube URL or ID
-	if strings.Contains(url, "youtube.com") || strings.Contains(url, "youtu.be") || (len(url) == 11 && !strings.Contains(url, "/")) {
-		log.Printf("YouTube content detected, attempting to stream using YouTube library")
-		return b.streamYouTubeAudio(url, vc)
+	// Files from the local library are encoded straight from disk, links
+	// shared in chat are piped from their provider
+	if filepath.IsAbs(url) || opener != nil {
+		return b.streamDirectAudio(url, opener, vc, settings)
 	}
 
-	// Check if this is a Spotify ID (real Spotify IDs are 22 characters)
-	if len(url) == 22 && !strings.Contains(url, "/") && !strings.HasPrefix(url, "spotify_mock_") {
-		log.Printf("Spotify content detected. Audio streaming not supported for Spotify tracks")
-		return fmt.Errorf("Spotify audio streaming is not supported. Spotify does not provide direct audio streams")
+	// Check if this is a YouTube URL or ID
+	if strings.Contains(url, "youtube.com") || strings.Contains(url, "youtu.be") || (len(url) == 11 && !strings.Contains(url, "/")) {
+		log.Printf("YouTube content detected, attempting to stream using the YouTube resolvers")
+		return b.streamYouTubeAudio(url, vc, settings)
 	}
 
 	// Try to stream if it's a direct audio URL
-	return b.streamDirectAudio(url, vc)
+	return b.streamDirectAudio(url, nil, vc, settings)
 }
 
-// streamTestAudio creates a brief test audio stream for testing purposes
-func (b *Bot) streamTestAudio(vc *VoiceConnection) error {
+// Test audio played for mock tracks and by !test
+const (
+	testToneFrequency = 440.0 // Hz
+	testToneDuration  = 2 * time.Second
+	mockTrackDuration = 5 * time.Second
+)
+
+// streamTestAudio plays a test tone to check that the voice connection works.
+// The tone is encoded to Opus with ffmpeg; if that fails, Opus silence is sent instead.
+func (b *Bot) streamTestAudio(vc *VoiceConnection, duration time.Duration) error {
 	log.Printf("Creating test audio stream")
-	
-	// Create a brief silence stream for testing
-	// This demonstrates that the voice connection works
+
 	if vc.connection == nil {
 		return fmt.Errorf("voice connection is nil")
 	}
 
-	// Send a brief period of silence to test voice connection
-	// This proves the bot can connect and send audio data
 	vc.connection.Speaking(true)
 	defer vc.connection.Speaking(false)
 
-	// Create 2 seconds of silence (48000 Hz, 2 channels, 16-bit samples)
-	silenceFrames := 48000 * 2 * 2 // 2 seconds of audio
-	silenceData := make([]byte, silenceFrames)
-	
-	// Send the silence data in chunks
-	chunkSize := 3840 // 20ms worth of audio data
-	for i := 0; i < len(silenceData); i += chunkSize {
-		end := i + chunkSize
-		if end > len(silenceData) {
-			end = len(silenceData)
+	options := *dca.StdEncodeOptions
+	options.RawOutput = true
+
+	tone := source.NewTone(testToneFrequency, duration)
+	sent := 0
+	encodingSession, err := dca.EncodeMem(bytes.NewReader(tone.WAV()), &options)
+	if err != nil {
+		log.Printf("Could not start test tone encoder: %v", err)
+	} else {
+		defer encodingSession.Cleanup()
+		b.mu.Lock()
+		vc.encoder = encodingSession
+		b.mu.Unlock()
+		defer b.forgetEncoder(vc, encodingSession)
+
+		sent, err = source.Pace(encodingSession, vc.connection.OpusSend, nil)
+		if err != nil {
+			return fmt.Errorf("failed to send test tone: %w", err)
 		}
-		
-		select {
-		case vc.connection.OpusSend <- silenceData[i:end]:
-		default:
-			// Channel might be full, skip this frame
+	}
+
+	// Nothing was encoded (e.g. ffmpeg is missing), prove the connection with silence
+	if sent == 0 {
+		log.Printf("Test tone unavailable, sending Opus silence instead")
+		if sent, err = source.Pace(source.NewSilence(duration), vc.connection.OpusSend, nil); err != nil {
+			return fmt.Errorf("failed to send test silence: %w", err)
 		}
 	}
 
-	log.Printf("Test audio stream completed successfully")
+	// Trailing silence stops clients from interpolating the last frame
+	source.Pace(source.NewSilence(5*source.FrameDuration), vc.connection.OpusSend, nil)
+
+	log.Printf("Test audio stream completed successfully (%d frames)", sent)
 	return nil
 }
 
-// streamYouTubeAudio attempts to stream YouTube audio using the YouTube library
-func (b *Bot) streamYouTubeAudio(videoID string, vc *VoiceConnection) error {
+// streamYouTubeAudio attempts to stream YouTube audio resolved through yt-dlp or the YouTube library
+func (b *Bot) streamYouTubeAudio(videoID string, vc *VoiceConnection, settings streamSettings) error {
 	log.Printf("Attempting to stream YouTube audio for video ID: %s", videoID)
 
 	if vc.connection == nil {
 		return fmt.Errorf("voice connection is nil")
 	}
 
+	youtube, ok := b.youtube()
+	if !ok {
+		return fmt.Errorf("YouTube provider is not registered")
+	}
+
 	// Get the stream URL using our YouTube provider
-	streamURL, err := b.youtubePlayer.GetStreamURL(videoID)
+	streamURL, err := youtube.GetStreamURL(videoID)
 	if err != nil {
 		log.Printf("Failed to get YouTube stream URL for %s: %v", videoID, err)
 		return fmt.Errorf("failed to get YouTube stream URL: %w", err)
@@ -832,32 +1367,65 @@ func (b *Bot) streamYouTubeAudio(videoID string, vc *VoiceConnection) error {
 	log.Printf("Successfully obtained YouTube stream URL, attempting to stream")
 
 	// Now stream the URL using DCA
-	return b.streamDirectAudio(streamURL, vc)
+	return b.streamDirectAudio(streamURL, nil, vc, settings)
 }
 
-// streamDirectAudio attempts to stream a direct audio file URL
-func (b *Bot) streamDirectAudio(url string, vc *VoiceConnection) error {
+// streamDirectAudio attempts to stream a direct audio file URL, opened by
+// opener if there is one
+func (b *Bot) streamDirectAudio(url string, opener audio.StreamOpener, vc *VoiceConnection, settings streamSettings) error {
 	log.Printf("Attempting to stream direct audio URL: %s", url)
 
-	// Create DCA encoding session
-	options := dca.StdEncodeOptions
+	if vc.connection == nil {
+		return fmt.Errorf("voice connection is nil")
+	}
+
+	// Create DCA encoding session. Copy the defaults, they are shared.
+	options := *dca.StdEncodeOptions
 	options.RawOutput = true
 	options.Bitrate = 96
+	options.StartTime = int(settings.Start.Seconds())
+	options.AudioFilter = settings.audioFilter()
 
-	// Try to encode the URL directly (this only works for direct audio files)
-	encodingSession, err := dca.EncodeFile(url, options)
+	var encodingSession *dca.EncodeSession
+	var err error
+	if opener != nil {
+		// ffmpeg gets what the provider fetched, so it never follows
+		// redirects or playlist entries to addresses the provider refuses
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		input, openErr := opener.OpenStream(ctx, url)
+		if openErr != nil {
+			return fmt.Errorf("unable to open the stream: %w", openErr)
+		}
+		defer input.Close()
+		encodingSession, err = dca.EncodeMem(input, &options)
+	} else {
+		// Try to encode the URL directly (this only works for direct audio files)
+		encodingSession, err = dca.EncodeFile(url, &options)
+	}
 	if err != nil {
 		log.Printf("Could not encode audio from URL %s: %v", url, err)
 		return fmt.Errorf("unable to stream audio from this source. URL may not be a direct audio file: %w", err)
 	}
 	defer encodingSession.Cleanup()
 
-	vc.encoder = encodingSession
 	done := make(chan error)
 	stream := dca.NewStream(encodingSession, vc.connection, done)
+	b.mu.Lock()
+	vc.encoder = encodingSession
 	vc.stream = stream
+	// A track restarted while paused stays paused
+	if player, exists := b.players[vc.guildID]; exists && player.isPaused {
+		stream.SetPaused(true)
+	}
+	b.mu.Unlock()
+	defer b.forgetEncoder(vc, encodingSession)
 
+	// The stream ends with io.EOF once the whole track was sent
 	err = <-done
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
 	if err != nil {
 		log.Printf("Streaming finished with error: %v", err)
 	} else {
@@ -866,51 +1434,72 @@ func (b *Bot) streamDirectAudio(url string, vc *VoiceConnection) error {
 	return err
 }
 
-func (b *Bot) addRecommendations(track *queue.Track) {
-	var results []audio.SearchResult
-	var err error
-
-	if track.Platform == "yt" {
-		results, err = b.youtubePlayer.GetRecommendations(track.Genre)
-	} else {
-		results, err = b.spotifyPlayer.GetRecommendations(track.Genre)
+func (b *Bot) addRecommendations(player *Player, track *queue.Track) {
+	provider, exists := b.providers.Get(track.Platform)
+	if !exists || !provider.Can(audio.CapRecommendations) {
+		return
 	}
 
+	results, err := provider.Provider.GetRecommendations(track.Genre)
 	if err != nil {
 		return
 	}
 
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	room := b.queueRoom(player.guildID, player)
+	if room >= 0 && len(results) > room {
+		results = results[:room]
+	}
 	for _, result := range results {
-		b.queue.Add(queue.Track{
-			Title:    result.Title,
-			Artist:   result.Artist,
-			URL:      result.ID,
-			Platform: track.Platform,
-			Genre:    result.Genre,
-		})
+		player.queue.Add(newTrack(result, track.Platform, requester{}))
 	}
 }
 
 func (b *Bot) handleHelp() (string, error) {
-	help := `**SoulHound Music Bot Commands:**
+	help := fmt.Sprintf(`**SoulHound Music Bot Commands:**
 
 **Music Controls (requires voice channel):**
-• !play <query> - Play a song (prefix with yt: or sp: to specify platform)
+• !play <query> - Play a song (prefix with <platform>: to pick a platform)
+• !play <link> - Queue a YouTube playlist or Spotify playlist/album
+• !play <url> - Play an audio file, internet radio station or HLS/PLS/M3U stream
 • !pause - Pause current playback
 • !resume - Resume paused playback
 • !stop - Stop playback and clear queue
-• !skip - Skip to next track
+• !skip - Vote to skip the current track (skips right away for its requester)
+• !forceskip - Skip without a vote (requester and DJs)
+• !volume [0-200] - Show or set the volume
+• !filter [name...] - List or toggle audio filters (!filter clear removes them)
+• !back - Go back to the previous track
+• !seek <time> - Move to a position in the track, e.g. !seek 1:23
+• !forward [seconds] - Skip ahead in the track (default 10)
+• !rewind [seconds] - Go back in the track (default 10)
+• !jump <number> - Jump to a track in the queue
+• !leave - Leave the voice channel and clear the queue
 
 **Queue Management:**
-• !queue - Show current queue
+• !queue - Show the queue, who requested each track and the time left
+• !nowplaying - Show the current track (alias !np)
 • !remove <number> - Remove track from queue
+• !move <from> <to> - Move a track to another position
+• !shuffle - Shuffle the upcoming tracks
+• !loop [off/track/queue] - Set the loop mode (cycles without an argument)
 
 **Search & Discovery:**
-• !search <query> - Search without adding to queue
+• !search <query> - Show the top results to pick from
+• !pick <number> - Queue a result of your last search
+• !play --choose <query> - Search and pick instead of playing the first result
+• !podcast <feed> [list] - List a podcast's newest episodes
+• !podcast <feed> <number/latest> - Play an episode, resuming where this server left off
 
 **Settings:**
-• !setdefault <yt/sp> - Set default platform (YouTube/Spotify)
+• !settings - Show this server's settings
+• !settings <name> <value> - Change a setting (!settings reset restores the defaults)
+• !setdefault <platform> - Set the default platform
 • !smartplay <on/off> - Toggle smart recommendations
+• !permissions [command] [everyone/dj/admin/default] - Show or change who may run a command
+
+**Platforms:** %s
 
 **Testing & Debug:**
 • !test - Run comprehensive bot functionality test
@@ -926,8 +1515,9 @@ func (b *Bot) handleHelp() (string, error) {
 • !play sp:shape of you
 • !setdefault yt
 • !smartplay on
+• !volume 80
 
-Type !help to see this message again.`
+Type !help to see this message again.`, b.platformList())
 
 	return help, nil
 }
@@ -948,7 +1538,11 @@ func (b *Bot) handleDebug(guildID string) (string, error) {
 
 	// Check bot permissions
 	debugInfo.WriteString(fmt.Sprintf("**Bot Intents:** %d\n", b.session.Identify.Intents))
-	debugInfo.WriteString("**Required Intents:** GuildMessages + GuildVoiceStates + MessageContent\n")
+	if b.cfg.PrefixCommandsEnabled() {
+		debugInfo.WriteString("**Required Intents:** GuildMessages + GuildVoiceStates + MessageContent\n")
+	} else {
+		debugInfo.WriteString("**Required Intents:** GuildMessages + GuildVoiceStates (slash commands only)\n")
+	}
 
 	// Detailed intent breakdown
 	debugInfo.WriteString("**Intent Breakdown:**\n")
@@ -969,11 +1563,13 @@ func (b *Bot) handleDebug(guildID string) (string, error) {
 
 	if currentIntents&discordgo.IntentsMessageContent != 0 {
 		debugInfo.WriteString("✅ Message Content Intent (32768)\n")
+	} else if !b.cfg.PrefixCommandsEnabled() {
+		debugInfo.WriteString("➖ Message Content Intent (32768) - not needed for slash commands\n")
 	} else {
 		debugInfo.WriteString("❌ Message Content Intent (32768) - MISSING!\n")
 	}
 
-	expectedIntents := discordgo.IntentsGuildMessages | discordgo.IntentsGuildVoiceStates | discordgo.IntentsMessageContent
+	expectedIntents := b.requiredIntents()
 	if currentIntents == expectedIntents {
 		debugInfo.WriteString("✅ **All required intents are enabled**\n")
 	} else {
@@ -1036,6 +1632,15 @@ func (b *Bot) handleDebug(guildID string) (string, error) {
 		debugInfo.WriteString(fmt.Sprintf("• Guild %s: Channel %s\n", gid, vc.channelID))
 	}
 
+	// Check this guild's player
+	if player, exists := b.player(guildID); exists {
+		b.mu.Lock()
+		debugInfo.WriteString(fmt.Sprintf("\n**Player:** playing=%v, paused=%v, queued=%d\n", player.isPlaying, player.isPaused, len(player.queue.List())))
+		b.mu.Unlock()
+	} else {
+		debugInfo.WriteString("\n**Player:** none for this guild\n")
+	}
+
 	return debugInfo.String(), nil
 }
 
@@ -1511,11 +2116,13 @@ func (b *Bot) handleUndeafen(guildID string) string {
 
 	// Disconnect and rejoin with proper settings (not deafened)
 	channelID := vc.channelID
-	vc.connection.Disconnect()
+	vc.expectLeave()
+	b.leaveVoice(vc.connection)
 
 	// Rejoin the channel with deaf=false
-	conn, err := b.session.ChannelVoiceJoin(guildID, channelID, false, false)
+	conn, err := b.joinVoice(guildID, channelID)
 	if err != nil {
+		vc.leavingBy = time.Time{}
 		return fmt.Sprintf("❌ Failed to rejoin voice channel: %v", err)
 	}
 
@@ -1642,55 +2249,64 @@ func (b *Bot) handleTest(channelID, guildID string) (string, error) {
 	// Test 3: Audio provider functionality
 	response.WriteString("\n**3. Audio Provider Test:**\n")
 	
-	// Test YouTube provider
-	ytResults, err := b.youtubePlayer.Search("test")
-	if err != nil {
-		response.WriteString(fmt.Sprintf("❌ YouTube provider error: %v\n", err))
-	} else if len(ytResults) > 0 {
-		response.WriteString(fmt.Sprintf("✅ YouTube provider working (%d results)\n", len(ytResults)))
+	// Test yt-dlp availability
+	if b.ytdlpAvailable() {
+		response.WriteString("✅ yt-dlp found\n")
 	} else {
-		response.WriteString("⚠️ YouTube provider returned no results\n")
+		response.WriteString("⚠️ yt-dlp not found, YouTube falls back to the built-in library\n")
 	}
 
-	// Test Spotify provider
-	spResults, err := b.spotifyPlayer.Search("test")
-	if err != nil {
-		response.WriteString(fmt.Sprintf("❌ Spotify provider error: %v\n", err))
-	} else if len(spResults) > 0 {
-		response.WriteString(fmt.Sprintf("✅ Spotify provider working (%d results)\n", len(spResults)))
-	} else {
-		response.WriteString("⚠️ Spotify provider returned no results\n")
+	// Test each searchable provider
+	for _, provider := range b.providers.List() {
+		if !provider.Can(audio.CapSearch) {
+			continue
+		}
+
+		results, err := provider.Provider.Search("test")
+		if err != nil {
+			response.WriteString(fmt.Sprintf("❌ %s provider error: %v\n", provider.Name, err))
+		} else if len(results) > 0 {
+			response.WriteString(fmt.Sprintf("✅ %s provider working (%d results)\n", provider.Name, len(results)))
+		} else {
+			response.WriteString(fmt.Sprintf("⚠️ %s provider returned no results\n", provider.Name))
+		}
 	}
 
 	// Test 4: Queue functionality
 	response.WriteString("\n**4. Queue System Test:**\n")
 	
-	// Save current queue state
-	originalTracks := b.queue.List()
+	// Test on a queue of its own, set up like the guild's, so the test
+	// track never ends up among the guild's tracks
+	b.mu.Lock()
+	testQueue := queue.NewQueue()
+	testQueue.SetFair(b.getPlayer(guildID).queue.Fair())
+	b.mu.Unlock()
 	
 	// Test adding to queue
 	testTrack := queue.Track{
 		Title:    "Test Song",
 		Artist:   "Test Artist",
 		URL:      "mock_test_song",
-		Platform: "yt",
+		Platform: youtubePrefix,
 		Genre:    "test",
 	}
 	
-	b.queue.Add(testTrack)
-	queueTracks := b.queue.List()
-	
-	if len(queueTracks) > len(originalTracks) {
+	testQueue.Add(testTrack)
+	testIndex := -1
+	for i, track := range testQueue.List() {
+		if track.URL == testTrack.URL {
+			testIndex = i
+		}
+	}
+	if testIndex >= 0 {
 		response.WriteString("✅ Queue add functionality working\n")
 		
 		// Test queue removal
-		if len(queueTracks) > 0 {
-			err := b.queue.Remove(len(queueTracks) - 1) // Remove the test track
-			if err != nil {
-				response.WriteString(fmt.Sprintf("❌ Queue remove failed: %v\n", err))
-			} else {
-				response.WriteString("✅ Queue remove functionality working\n")
-			}
+		err := testQueue.Remove(testIndex)
+		if err != nil {
+			response.WriteString(fmt.Sprintf("❌ Queue remove failed: %v\n", err))
+		} else {
+			response.WriteString("✅ Queue remove functionality working\n")
 		}
 	} else {
 		response.WriteString("❌ Queue add functionality failed\n")
@@ -1705,11 +2321,11 @@ func (b *Bot) handleTest(channelID, guildID string) (string, error) {
 			b.mu.Unlock()
 			
 			// Test mock audio streaming
-			err := b.streamTestAudio(vc)
+			err := b.streamTestAudio(vc, testToneDuration)
 			if err != nil {
 				response.WriteString(fmt.Sprintf("❌ Mock audio streaming failed: %v\n", err))
 			} else {
-				response.WriteString("✅ Mock audio streaming successful\n")
+				response.WriteString("✅ Mock audio streaming successful (you should have heard a 440 Hz tone)\n")
 			}
 		} else {
 			b.mu.Unlock()
@@ -1719,8 +2335,9 @@ func (b *Bot) handleTest(channelID, guildID string) (string, error) {
 
 	// Test 6: Configuration
 	response.WriteString("\n**6. Configuration Test:**\n")
-	response.WriteString(fmt.Sprintf("✅ Default platform: %s\n", config.AppConfig.DefaultPlayer))
-	response.WriteString(fmt.Sprintf("✅ Smart play enabled: %v\n", config.PlayerConfig.SmartPlayEnabled))
+	settings := b.settings.Get(guildID)
+	response.WriteString(fmt.Sprintf("✅ Default platform: %s\n", settings.DefaultPlatform))
+	response.WriteString(fmt.Sprintf("✅ Smart play enabled: %v\n", settings.SmartPlay))
 
 	// Final summary
 	response.WriteString("\n**🎯 Test Summary:**\n")
@@ -1733,8 +2350,10 @@ func (b *Bot) handleTest(channelID, guildID string) (string, error) {
 		response.WriteString("• Audio streaming: ✅ Working (test mode)\n")
 		response.WriteString("\n**Next Steps:**\n")
 		response.WriteString("• Try `!play test` to test full playback\n")
-		response.WriteString("• For YouTube: Set up yt-dlp for real audio\n")
-		response.WriteString("• For Spotify: Note that direct streaming isn't supported\n")
+		if !b.ytdlpAvailable() {
+			response.WriteString("• For YouTube: Install yt-dlp for reliable audio\n")
+		}
+		response.WriteString("• For Spotify: Set SPOTIFY_CLIENT_ID and SPOTIFY_CLIENT_SECRET for real search results\n")
 	} else {
 		response.WriteString("• Voice connection: ⚠️ Join a voice channel to test\n")
 		response.WriteString("\n**Next Steps:**\n")
@@ -1818,66 +2437,3 @@ func (b *Bot) handleVoiceMonitor(guildID, userID string) string {
 
 	return response.String()
 }
-
-// checkVoicePermissions verifies that the bot has the necessary permissions for voice operations
-func (b *Bot) checkVoicePermissions(s *discordgo.Session, guildID string) bool {
-	// Get the bot's member info in the guild
-	botMember, err := s.State.Member(guildID, s.State.User.ID)
-	if err != nil {
-		// Try API call if cache fails
-		botMember, err = s.GuildMember(guildID, s.State.User.ID)
-		if err != nil {
-			log.Printf("Warning: Could not get bot member info for permission check: %v", err)
-			return true // Assume permissions are okay if we can't check
-		}
-	}
-
-	// Get guild info to check permissions
-	guild, err := s.State.Guild(guildID)
-	if err != nil {
-		guild, err = s.Guild(guildID)
-		if err != nil {
-			log.Printf("Warning: Could not get guild info for permission check: %v", err)
-			return true // Assume permissions are okay if we can't check
-		}
-	}
-
-	// Calculate permissions for the bot
-	permissions := int64(0)
-
-	// Check @everyone role permissions
-	for _, role := range guild.Roles {
-		if role.ID == guildID { // @everyone role has the same ID as the guild
-			permissions |= role.Permissions
-			break
-		}
-	}
-
-	// Add permissions from bot's roles
-	for _, roleID := range botMember.Roles {
-		for _, role := range guild.Roles {
-			if role.ID == roleID {
-				permissions |= role.Permissions
-				break
-			}
-		}
-	}
-
-	// Check for required permissions
-	const (
-		PermissionViewChannel  = int64(0x0000000000000400) // 1024
-		PermissionConnect      = int64(0x0000000000100000) // 1048576
-		PermissionSpeak        = int64(0x0000000000200000) // 2097152
-		PermissionSendMessages = int64(0x0000000000000800) // 2048
-	)
-
-	requiredPerms := PermissionViewChannel | PermissionConnect | PermissionSpeak | PermissionSendMessages
-
-	// Check if bot has administrator permission (overrides all)
-	if permissions&int64(0x0000000000000008) != 0 { // Administrator permission
-		return true
-	}
-
-	// Check specific required permissions
-	return (permissions & requiredPerms) == requiredPerms
-}
@@ -1,11 +1,22 @@
 package bot
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/audio"
 	"github.com/doomhound188/soulhound/internal/config"
+	"github.com/doomhound188/soulhound/internal/queue"
+	"github.com/jonas747/dca"
 )
 
 func TestBotCreation(t *testing.T) {
@@ -26,8 +37,8 @@ func TestBotCreation(t *testing.T) {
 		t.Fatal("Expected bot to be non-nil")
 	}
 
-	if bot.queue == nil {
-		t.Error("Expected bot queue to be initialized")
+	if bot.players == nil {
+		t.Error("Expected bot players map to be initialized")
 	}
 
 	if bot.voiceConn == nil {
@@ -38,12 +49,12 @@ func TestBotCreation(t *testing.T) {
 		t.Error("Expected bot voice states map to be initialized")
 	}
 
-	if bot.youtubePlayer == nil {
-		t.Error("Expected YouTube provider to be initialized")
+	if _, exists := bot.providers.Get("yt"); !exists {
+		t.Error("Expected YouTube provider to be registered")
 	}
 
-	if bot.spotifyPlayer == nil {
-		t.Error("Expected Spotify provider to be initialized")
+	if _, exists := bot.providers.Get("sp"); !exists {
+		t.Error("Expected Spotify provider to be registered")
 	}
 }
 
@@ -126,6 +137,64 @@ func TestVoiceStateTracking(t *testing.T) {
 	}
 }
 
+func TestPlayersArePerGuild(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		YouTubeToken:  "",
+		SpotifyToken:  "",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	bot.mu.Lock()
+	guildA := bot.getPlayer("guild-a")
+	guildB := bot.getPlayer("guild-b")
+	bot.mu.Unlock()
+
+	guildA.queue.Add(queue.Track{Title: "Song A", Artist: "Artist A", URL: "mock_a", Platform: "yt"})
+	guildB.queue.Add(queue.Track{Title: "Song B", Artist: "Artist B", URL: "mock_b", Platform: "yt"})
+
+	if guildA == guildB {
+		t.Fatal("Expected separate players for separate guilds")
+	}
+
+	response, err := bot.HandleCommand("queue", []string{}, "", "guild-a", "")
+	if err != nil {
+		t.Fatalf("Queue command failed: %v", err)
+	}
+	if !strings.Contains(response, "Song A") || strings.Contains(response, "Song B") {
+		t.Errorf("Expected guild-a queue to contain only its own track, got: %s", response)
+	}
+
+	// Stopping one guild must not affect the other
+	if _, err := bot.HandleCommand("stop", []string{}, "", "guild-a", ""); err != nil {
+		t.Fatalf("Stop command failed: %v", err)
+	}
+
+	if len(guildA.queue.List()) != 0 {
+		t.Error("Expected guild-a queue to be cleared after stop")
+	}
+	if len(guildB.queue.List()) != 1 {
+		t.Errorf("Expected guild-b queue to be untouched, got %d tracks", len(guildB.queue.List()))
+	}
+
+	// A guild that never played has no player and an empty queue
+	response, err = bot.HandleCommand("queue", []string{}, "", "guild-c", "")
+	if err != nil {
+		t.Fatalf("Queue command failed: %v", err)
+	}
+	if response != "Queue is empty" {
+		t.Errorf("Expected empty queue for new guild, got: %s", response)
+	}
+	if _, exists := bot.player("guild-c"); exists {
+		t.Error("Expected read-only commands not to create a player")
+	}
+}
+
 func TestHandleCommands(t *testing.T) {
 	cfg := &config.Config{
 		DiscordToken:  "Bot.fake.token",
@@ -185,13 +254,13 @@ func TestStreamAudioMock(t *testing.T) {
 	}
 
 	// Test mock URL detection
-	err = bot.streamAudio("mock_test", vc)
+	err = bot.streamAudio("mock_test", nil, vc, streamSettings{Volume: 100})
 	if err == nil {
 		t.Error("Expected error when trying to stream with nil connection")
 	}
 
 	// Test YouTube URL detection
-	err = bot.streamAudio("dQw4w9WgXcQ", vc)
+	err = bot.streamAudio("dQw4w9WgXcQ", nil, vc, streamSettings{Volume: 100})
 	if err == nil {
 		t.Error("Expected error when trying to stream with nil connection")
 	}
@@ -200,12 +269,2190 @@ func TestStreamAudioMock(t *testing.T) {
 		t.Errorf("Expected nil connection error, got: %v", err)
 	}
 
-	// Test Spotify ID detection
-	err = bot.streamAudio("4iV5W9uYEdYUVa79Axb7Rh", vc)
-	if err == nil {
-		t.Error("Expected error explaining Spotify streaming limitations")
+	// Spotify tracks arrive as mirrored stream URLs and are streamed directly
+	err = bot.streamAudio("https://rr1---sn-fake.googlevideo.com/videoplayback?id=abc", nil, vc, streamSettings{Volume: 100})
+	if err == nil || !strings.Contains(err.Error(), "voice connection is nil") {
+		t.Errorf("Expected nil connection error for a direct stream URL, got: %v", err)
+	}
+}
+
+func TestSlashCommandArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		options  []*discordgo.ApplicationCommandInteractionDataOption
+		expected []string
+	}{
+		{
+			name:    "play with platform",
+			command: "play",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "query", Type: discordgo.ApplicationCommandOptionString, Value: "shape of you"},
+				{Name: "platform", Type: discordgo.ApplicationCommandOptionString, Value: "sp"},
+			},
+			expected: []string{"sp:shape of you"},
+		},
+		{
+			name:    "play without platform",
+			command: "play",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "query", Type: discordgo.ApplicationCommandOptionString, Value: "never gonna give you up"},
+			},
+			expected: []string{"never gonna give you up"},
+		},
+		{
+			name:    "play choosing a result",
+			command: "play",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "query", Type: discordgo.ApplicationCommandOptionString, Value: "shape of you"},
+				{Name: "choose", Type: discordgo.ApplicationCommandOptionBoolean, Value: true},
+			},
+			expected: []string{"--choose", "shape of you"},
+		},
+		{
+			name:    "pick number",
+			command: "pick",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "number", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(3)},
+			},
+			expected: []string{"3"},
+		},
+		{
+			name:    "remove position",
+			command: "remove",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "position", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(3)},
+			},
+			expected: []string{"3"},
+		},
+		{
+			name:    "smartplay boolean",
+			command: "smartplay",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "enabled", Type: discordgo.ApplicationCommandOptionBoolean, Value: false},
+			},
+			expected: []string{"off"},
+		},
+		{
+			name:    "settings change",
+			command: "settings",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "setting", Type: discordgo.ApplicationCommandOptionString, Value: "volume"},
+				{Name: "value", Type: discordgo.ApplicationCommandOptionString, Value: "80"},
+			},
+			expected: []string{"volume", "80"},
+		},
+		{
+			name:     "settings overview",
+			command:  "settings",
+			expected: []string{},
+		},
+		{
+			name:    "volume level",
+			command: "volume",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "level", Type: discordgo.ApplicationCommandOptionInteger, Value: float64(0)},
+			},
+			expected: []string{"0"},
+		},
+		{
+			name:     "volume without level",
+			command:  "volume",
+			expected: []string{},
+		},
+		{
+			name:    "filter preset",
+			command: "filter",
+			options: []*discordgo.ApplicationCommandInteractionDataOption{
+				{Name: "preset", Type: discordgo.ApplicationCommandOptionString, Value: "nightcore"},
+			},
+			expected: []string{"nightcore"},
+		},
+		{
+			name:     "command without options",
+			command:  "queue",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, known := slashCommandArgs(tt.command, tt.options)
+			if !known {
+				t.Fatalf("Expected %s to be a known slash command", tt.command)
+			}
+			if strings.Join(args, "|") != strings.Join(tt.expected, "|") || len(args) != len(tt.expected) {
+				t.Errorf("Expected args %q, got %q", tt.expected, args)
+			}
+		})
+	}
+
+	if _, known := slashCommandArgs("notacommand", nil); known {
+		t.Error("Expected unknown slash command to be rejected")
+	}
+}
+
+func TestSlashCommandsAreHandled(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, cmd := range slashCommands {
+		name := cmd.definition.Name
+		if seen[name] {
+			t.Errorf("Duplicate slash command %s", name)
+		}
+		seen[name] = true
+
+		if name != strings.ToLower(name) || len(name) > 32 {
+			t.Errorf("Invalid slash command name %q", name)
+		}
+		if cmd.definition.Description == "" || len(cmd.definition.Description) > 100 {
+			t.Errorf("Slash command %s needs a description of 1-100 characters", name)
+		}
+
+		// Only dispatch commands that are safe without a connected session
+		if name == "help" || name == "queue" {
+			if _, err := bot.HandleCommand(name, []string{}, "", "", ""); err != nil {
+				t.Errorf("Slash command %s is not handled: %v", name, err)
+			}
+		}
+	}
+}
+
+func TestSetDefaultUsesRegistry(t *testing.T) {
+	config.Init("Bot.fake.token", "", "")
+
+	bot, err := New(&config.AppConfig)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	if _, err := bot.HandleCommand("setdefault", []string{"sp"}, "", "guild-a", ""); err != nil {
+		t.Fatalf("setdefault sp failed: %v", err)
+	}
+	if platform := bot.settings.DefaultPlatform("guild-a"); platform != "sp" {
+		t.Errorf("Expected default player sp, got %s", platform)
+	}
+
+	if _, err := bot.HandleCommand("setdefault", []string{"xx"}, "", "guild-a", ""); err == nil {
+		t.Error("Expected unregistered platform to be rejected")
+	}
+	if platform := bot.settings.DefaultPlatform("guild-a"); platform != "sp" {
+		t.Errorf("Expected default player to stay sp, got %s", platform)
+	}
+
+	// Other servers keep their own default
+	if platform := bot.settings.DefaultPlatform("guild-b"); platform != "yt" {
+		t.Errorf("Expected another guild to keep yt, got %s", platform)
+	}
+	if info, _, err := bot.searchProvider("guild-b", "query"); err != nil || info.Prefix != "yt" {
+		t.Errorf("Expected guild-b searches to use yt, got %v (%v)", info, err)
+	}
+
+	help, err := bot.HandleCommand("help", []string{}, "", "", "")
+	if err != nil {
+		t.Fatalf("help failed: %v", err)
+	}
+	if !strings.Contains(help, "yt (YouTube)") || !strings.Contains(help, "sp (Spotify)") {
+		t.Errorf("Expected help to list registered platforms, got:\n%s", help)
+	}
+
+	choices := bot.platformChoices()
+	if len(choices) != 2 || choices[0].Value != "yt" || choices[1].Value != "sp" {
+		t.Errorf("Unexpected platform choices: %+v", choices)
+	}
+}
+
+// fakePlaylists serves a playlist of three pages of two tracks. Pages after the
+// first are released one at a time through the release channel.
+type fakePlaylists struct {
+	release chan struct{}
+	pages   int // Three if unset
+}
+
+func (f *fakePlaylists) Search(query string) ([]audio.SearchResult, error) { return nil, nil }
+func (f *fakePlaylists) GetStreamURL(id string) (string, error)            { return id, nil }
+func (f *fakePlaylists) GetRecommendations(genre string) ([]audio.SearchResult, error) {
+	return nil, nil
+}
+
+func (f *fakePlaylists) IsPlaylistURL(link string) bool {
+	return strings.HasPrefix(link, "https://playlists.example/")
+}
+
+func (f *fakePlaylists) OpenPlaylist(link string, limit int) (*audio.Playlist, error) {
+	page, pages := 0, f.pages
+	if pages == 0 {
+		pages = 3
+	}
+	return audio.NewPlaylist("Fake Mix", 2*pages, limit, func() ([]audio.SearchResult, bool, error) {
+		if page > 0 {
+			<-f.release
+		}
+		page++
+		tracks := []audio.SearchResult{
+			{ID: fmt.Sprintf("mock_%d_a", page), Title: fmt.Sprintf("Track %da", page)},
+			{ID: fmt.Sprintf("mock_%d_b", page), Title: fmt.Sprintf("Track %db", page)},
+		}
+		return tracks, page < pages, nil
+	})
+}
+
+func TestQueuePlaylist(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	fake := &fakePlaylists{release: make(chan struct{})}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "fake", Provider: fake, Capabilities: audio.CapStream | audio.CapPlaylists}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	info, playlists, found := bot.playlistProvider("https://playlists.example/mix")
+	if !found || info.Prefix != "fake" {
+		t.Fatal("Expected the playlist link to be matched to the fake provider")
+	}
+	if _, _, found := bot.playlistProvider("never gonna give you up"); found {
+		t.Error("Expected search text not to be treated as a playlist")
+	}
+
+	// Pretend playback is running so no playback loop is started
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	response, err := bot.queuePlaylist("guild-a", info, playlists, "https://playlists.example/mix", requester{ID: "user-1", Name: "Alice"})
+	if err != nil {
+		t.Fatalf("queuePlaylist failed: %v", err)
+	}
+	if !strings.Contains(response, "Queued 2 tracks from Fake Mix") || !strings.Contains(response, "4 more") {
+		t.Errorf("Unexpected response: %s", response)
+	}
+
+	// The command returned before the remaining pages were loaded
+	fake.release <- struct{}{}
+	waitForQueueLength(t, player.queue, 4)
+	for _, track := range player.queue.List() {
+		if track.RequestedBy != "user-1" || track.RequesterName != "Alice" {
+			t.Errorf("Expected %q to be attributed to the requester, got %q (%q)", track.Title, track.RequestedBy, track.RequesterName)
+		}
+	}
+
+	// Stopping cancels the rest of the import
+	if _, err := bot.HandleCommand("stop", []string{}, "", "guild-a", ""); err != nil {
+		t.Fatalf("Stop command failed: %v", err)
+	}
+	fake.release <- struct{}{}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(player.queue.List()); n != 0 {
+		t.Errorf("Expected no tracks to be queued after stop, got %d", n)
+	}
+}
+
+func TestPlaylistFillingTheQueue(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	fake := &fakePlaylists{release: make(chan struct{}), pages: 1}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "fake", Provider: fake, Capabilities: audio.CapStream | audio.CapPlaylists}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if _, err := bot.HandleCommand("settings", []string{"maxqueue", "2"}, "", "guild-a", ""); err != nil {
+		t.Fatalf("settings failed: %v", err)
+	}
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	// Two tracks fit exactly into a queue of two, nothing was cut
+	info, playlists, _ := bot.playlistProvider("https://playlists.example/mix")
+	response, err := bot.queuePlaylist("guild-a", info, playlists, "https://playlists.example/mix", requester{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("queuePlaylist failed: %v", err)
+	}
+	if !strings.Contains(response, "Queued 2 tracks") || strings.Contains(response, "not queued") {
+		t.Errorf("Expected the whole playlist to be queued, got %s", response)
+	}
+
+	// A longer playlist is cut at the limit
+	player.queue.Clear()
+	fake.pages = 3
+	response, err = bot.queuePlaylist("guild-a", info, playlists, "https://playlists.example/mix", requester{ID: "user-1"})
+	if err != nil {
+		t.Fatalf("queuePlaylist failed: %v", err)
+	}
+	if !strings.Contains(response, "Queued 2 tracks") || !strings.Contains(response, "limited to 2 upcoming tracks") {
+		t.Errorf("Expected the playlist to be cut at the queue limit, got %s", response)
+	}
+}
+
+// waitForQueueLength waits up to a second for background loading to reach n tracks
+func waitForQueueLength(t *testing.T, q *queue.Queue, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if len(q.List()) == n {
+			return
+		}
+	}
+	t.Fatalf("Expected %d queued tracks, got %d", n, len(q.List()))
+}
+
+func TestQueueCommands(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	bot.mu.Unlock()
+	for _, title := range []string{"One", "Two", "Three"} {
+		player.queue.Add(queue.Track{Title: title, Artist: "Artist", URL: "mock_" + title, Platform: "yt"})
+	}
+
+	run := func(command string, args ...string) string {
+		t.Helper()
+		response, err := bot.HandleCommand(command, args, "", "guild-a", "")
+		if err != nil {
+			t.Fatalf("%s %v failed: %v", command, args, err)
+		}
+		return response
+	}
+
+	if response := run("move", "3", "1"); response != "Moved track 3 to position 1" {
+		t.Errorf("Unexpected move response: %s", response)
+	}
+	if response := run("queue"); !strings.Contains(response, "1. Three") || !strings.Contains(response, "▶ 2. One") {
+		t.Errorf("Expected the moved queue with the current track marked, got:\n%s", response)
+	}
+
+	if response := run("loop"); response != "🔁 Loop mode: track" {
+		t.Errorf("Expected loop to cycle to track, got: %s", response)
+	}
+	if response := run("loop", "queue"); response != "🔁 Loop mode: queue" {
+		t.Errorf("Unexpected loop response: %s", response)
+	}
+	if response := run("queue"); !strings.Contains(response, "Loop: queue") {
+		t.Errorf("Expected the queue to show the loop mode, got:\n%s", response)
+	}
+	if _, err := bot.HandleCommand("loop", []string{"forever"}, "", "guild-a", ""); err == nil {
+		t.Error("Expected an invalid loop mode to be rejected")
+	}
+
+	// Pretend playback is running so jumps don't start a playback loop
+	bot.mu.Lock()
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	if response := run("jump", "3"); response != "Jumped to: Two - Artist" {
+		t.Errorf("Unexpected jump response: %s", response)
+	}
+	if response := run("back"); response != "Back to: One - Artist" {
+		t.Errorf("Unexpected back response: %s", response)
+	}
+	if _, err := bot.HandleCommand("jump", []string{"0"}, "", "guild-a", ""); err == nil {
+		t.Error("Expected position 0 to be rejected")
+	}
+	if _, err := bot.HandleCommand("move", []string{"1", "9"}, "", "guild-a", ""); err == nil {
+		t.Error("Expected an out of range move to be rejected")
+	}
+
+	if response := run("shuffle"); response != "Not enough upcoming tracks to shuffle" {
+		t.Errorf("Unexpected shuffle response: %s", response)
+	}
+}
+
+func TestQueueListing(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
 	}
-	if err != nil && err.Error() != "Spotify audio streaming is not supported. Spotify does not provide direct audio streams" {
-		t.Errorf("Expected specific Spotify error message, got: %v", err)
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	player.queue.Add(newTrack(audio.SearchResult{ID: "a", Title: "Song A", Artist: "Artist", Duration: 200}, "yt", requester{ID: "user-1", Name: "Alice"}))
+	player.queue.Add(newTrack(audio.SearchResult{ID: "b", Title: "Song B", Artist: "Artist", Duration: 3700}, "yt", requester{ID: "user-2"}))
+	player.queue.Add(newTrack(audio.SearchResult{ID: "c", Title: "Song C", Artist: "Artist"}, "yt", requester{}))
+	bot.mu.Unlock()
+
+	response, err := bot.HandleCommand("queue", []string{}, "", "guild-a", "")
+	if err != nil {
+		t.Fatalf("Queue command failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"▶ 1. Song A - Artist (3:20) [yt] · requested by Alice",
+		"2. Song B - Artist (1:01:40) [yt] · requested by user-2",
+		"3. Song C - Artist (?:??) [yt] · requested by smart play",
+		"Remaining: 1:05:00 + 1 of unknown length",
+	} {
+		if !strings.Contains(response, want) {
+			t.Errorf("Expected queue listing to contain %q, got:\n%s", want, response)
+		}
+	}
+
+	if _, err := player.queue.Next(); err != nil {
+		t.Fatalf("Next failed: %v", err)
+	}
+	response, _ = bot.HandleCommand("queue", []string{}, "", "guild-a", "")
+	if !strings.Contains(response, "Remaining: 1:01:40 + 1 of unknown length") {
+		t.Errorf("Expected played tracks not to count as remaining, got:\n%s", response)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := map[int]string{
+		0:    "0:00",
+		59:   "0:59",
+		61:   "1:01",
+		3600: "1:00:00",
+		3725: "1:02:05",
+		-5:   "0:00",
+	}
+	for seconds, want := range tests {
+		if got := formatDuration(seconds); got != want {
+			t.Errorf("formatDuration(%d) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+		DataDir:       t.TempDir(),
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	// Nothing to resume, so saving starts right away
+	bot.restoreState()
+	defer close(bot.done)
+
+	if _, err := bot.HandleCommand("smartplay", []string{"on"}, "", "guild-a", ""); err != nil {
+		t.Fatalf("Smart play command failed: %v", err)
+	}
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	for _, title := range []string{"Song A", "Song B", "Song C"} {
+		player.queue.Add(newTrack(audio.SearchResult{ID: title, Title: title, Duration: 200}, "yt", requester{ID: "user-1"}))
+	}
+	player.queue.Next()
+	player.queue.SetLoop(queue.LoopQueue)
+	player.startAt = 42 * time.Second
+	bot.voiceConn["guild-a"] = &VoiceConnection{channelID: "voice-1", guildID: "guild-a"}
+	bot.getPlayer("guild-b") // Empty queues aren't saved
+	bot.mu.Unlock()
+
+	bot.saveState()
+
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create restarted bot: %v", err)
+	}
+	if !restarted.settings.SmartPlay("guild-a") {
+		t.Error("Expected smart play to be restored")
+	}
+	if len(restarted.pendingRestore) != 1 {
+		t.Fatalf("Expected 1 guild to resume, got %d", len(restarted.pendingRestore))
+	}
+
+	state := restarted.pendingRestore[0]
+	if state.GuildID != "guild-a" || state.VoiceChannelID != "voice-1" || state.Position != 42 {
+		t.Errorf("Unexpected saved state: %+v", state)
+	}
+
+	restarted.mu.Lock()
+	if !restarted.restorePlayer(state) {
+		t.Fatal("Expected the saved queue to be restored")
+	}
+	resumed := restarted.getPlayer("guild-a")
+	restarted.mu.Unlock()
+
+	if resumed.queue.CurrentIndex() != 1 || resumed.queue.Loop() != queue.LoopQueue || len(resumed.queue.List()) != 3 {
+		t.Errorf("Unexpected restored queue: current %d, loop %s, %d tracks", resumed.queue.CurrentIndex(), resumed.queue.Loop(), len(resumed.queue.List()))
+	}
+	if resumed.startAt != 42*time.Second {
+		t.Errorf("Expected playback to resume at 42s, got %v", resumed.startAt)
+	}
+	if track := resumed.queue.List()[0]; track.RequestedBy != "user-1" {
+		t.Errorf("Expected requester to survive the restart, got %+v", track)
+	}
+
+	// Leaving the channel drops the saved queue
+	bot.mu.Lock()
+	bot.teardownGuild("guild-a")
+	bot.mu.Unlock()
+	bot.saveState()
+
+	guilds, err := bot.store.LoadGuilds()
+	if err != nil {
+		t.Fatalf("LoadGuilds failed: %v", err)
+	}
+	if len(guilds) != 0 {
+		t.Errorf("Expected no saved guilds after leaving, got %d", len(guilds))
+	}
+}
+
+func TestSettingsCommand(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+		DataDir:       t.TempDir(),
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	settings := func(args ...string) (string, error) {
+		return bot.HandleCommand("settings", args, "", "guild-a", "")
+	}
+
+	response, err := settings()
+	if err != nil {
+		t.Fatalf("settings failed: %v", err)
+	}
+	for _, want := range []string{"platform**: yt (YouTube)", "volume**: 100%", "prefix**: `!`", "maxqueue**: no limit", "idle**: 5m", "autopause**: on", "voteskip**: 50%"} {
+		if !strings.Contains(response, want) {
+			t.Errorf("Expected settings to contain %q, got:\n%s", want, response)
+		}
+	}
+
+	changes := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"platform", "sp"}, "sp (Spotify)"},
+		{[]string{"smartplay", "on"}, "on"},
+		{[]string{"volume", "80%"}, "80%"},
+		{[]string{"prefix", "?"}, "`?`"},
+		{[]string{"djrole", "<@&123456789012345678>"}, "123456789012345678"},
+		{[]string{"maxqueue", "2"}, "2"},
+		{[]string{"announce", "<#223456789012345678>"}, "<#223456789012345678>"},
+		{[]string{"idle", "10"}, "10m"},
+		{[]string{"autopause", "off"}, "off"},
+		{[]string{"voteskip", "75%"}, "75%"},
+		{[]string{"fairqueue", "on"}, "on"},
+		{[]string{"usertracks", "3"}, "3"},
+		{[]string{"usertime", "30m"}, "30m"},
+	}
+	for _, change := range changes {
+		response, err := settings(change.args...)
+		if err != nil {
+			t.Errorf("settings %v failed: %v", change.args, err)
+			continue
+		}
+		if !strings.HasSuffix(response, "set to "+change.want) {
+			t.Errorf("settings %v: expected %q, got %q", change.args, change.want, response)
+		}
+	}
+
+	invalid := [][]string{
+		{"platform", "xx"},
+		{"smartplay", "maybe"},
+		{"volume", "201"},
+		{"volume", "loud"},
+		{"prefix", "toolong"},
+		{"maxqueue", "-1"},
+		{"announce", "general"},
+		{"idle", "soon"},
+		{"voteskip", "150"},
+		{"usertracks", "-2"},
+		{"usertime", "forever"},
+		{"colour", "blue"},
+	}
+	for _, args := range invalid {
+		if _, err := settings(args...); err == nil {
+			t.Errorf("Expected settings %v to be rejected", args)
+		}
+	}
+
+	got := bot.settings.Get("guild-a")
+	want := config.GuildSettings{
+		DefaultPlatform:  "sp",
+		SmartPlay:        true,
+		Volume:           80,
+		Prefix:           "?",
+		DJRole:           "123456789012345678",
+		MaxQueueLength:   2,
+		AnnounceChannel:  "223456789012345678",
+		IdleTimeout:      10 * time.Minute,
+		KeepPlayingAlone: true,
+		SkipVotes:        75,
+		FairQueue:        true,
+		MaxUserTracks:    3,
+		MaxUserDuration:  30 * time.Minute,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected settings %+v, got %+v", want, got)
+	}
+	if other := bot.settings.Get("guild-b"); !reflect.DeepEqual(other, bot.settings.Defaults()) {
+		t.Errorf("Expected other guilds to keep the defaults, got %+v", other)
+	}
+
+	// Settings are saved as they change
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create restarted bot: %v", err)
+	}
+	if restored := restarted.settings.Get("guild-a"); !reflect.DeepEqual(restored, want) {
+		t.Errorf("Expected saved settings %+v, got %+v", want, restored)
+	}
+
+	// The queue limit counts the upcoming tracks, not the current one
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	player.queue.Add(queue.Track{Title: "Song A"})
+	player.queue.Add(queue.Track{Title: "Song B"})
+	room := bot.queueRoom("guild-a", player)
+	player.queue.Next()
+	roomAfterNext := bot.queueRoom("guild-a", player)
+	bot.mu.Unlock()
+	if room != 1 || roomAfterNext != 2 {
+		t.Errorf("Expected room for 1 track, then 2 after moving on, got %d and %d", room, roomAfterNext)
+	}
+
+	if _, err := settings("reset"); err != nil {
+		t.Fatalf("settings reset failed: %v", err)
+	}
+	if got := bot.settings.Get("guild-a"); !reflect.DeepEqual(got, bot.settings.Defaults()) {
+		t.Errorf("Expected defaults after reset, got %+v", got)
+	}
+
+	// Resetting puts the defaults into effect like changing them does
+	if player.queue.Fair() {
+		t.Error("Expected the reset to turn fair queueing off in the player")
+	}
+}
+
+func TestVolumeCommand(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	response, err := bot.HandleCommand("volume", []string{}, "", "guild-a", "")
+	if err != nil || !strings.Contains(response, "100%") {
+		t.Fatalf("Expected the default volume of 100%%, got %q (%v)", response, err)
+	}
+
+	response, err = bot.HandleCommand("volume", []string{"80"}, "", "guild-a", "")
+	if err != nil {
+		t.Fatalf("volume failed: %v", err)
+	}
+	if !strings.Contains(response, "80%") || strings.Contains(response, "current track") {
+		t.Errorf("Expected the volume to be set without a track to restart, got %q", response)
+	}
+	if got := bot.settings.Volume("guild-a"); got != 80 {
+		t.Errorf("Expected volume 80, got %d", got)
+	}
+	if got := bot.settings.Volume("guild-b"); got != 100 {
+		t.Errorf("Expected other guilds to keep volume 100, got %d", got)
+	}
+
+	for _, args := range [][]string{{"201"}, {"-1"}, {"loud"}, {"1", "2"}} {
+		if _, err := bot.HandleCommand("volume", args, "", "guild-a", ""); err == nil {
+			t.Errorf("Expected volume %v to be rejected", args)
+		}
+	}
+	if got := bot.settings.Volume("guild-a"); got != 80 {
+		t.Errorf("Expected rejected volumes to leave 80, got %d", got)
+	}
+
+	// Now playing reports the guild's volume
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.queue.Add(newTrack(audio.SearchResult{ID: "a", Title: "Song A", Artist: "Artist", Duration: 200}, "yt", requester{ID: "user-1", Name: "Alice"}))
+	player.current = currentTrack(player)
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	response, err = bot.HandleCommand("np", []string{}, "", "guild-a", "")
+	if err != nil {
+		t.Fatalf("nowplaying failed: %v", err)
+	}
+	for _, want := range []string{"Song A - Artist [yt]", "0:00 / 3:20", "Requested by Alice", "Volume: 80%"} {
+		if !strings.Contains(response, want) {
+			t.Errorf("Expected now playing to contain %q, got:\n%s", want, response)
+		}
+	}
+
+	if response, _ := bot.HandleCommand("nowplaying", []string{}, "", "guild-b", ""); response != "Nothing is playing" {
+		t.Errorf("Expected nothing playing in another guild, got %q", response)
+	}
+}
+
+func TestAudioFilter(t *testing.T) {
+	tests := map[int]string{
+		100: "",
+		80:  "volume=0.80",
+		0:   "volume=0.00",
+		150: "volume=1.50",
+		200: "volume=2.00",
+	}
+	for volume, want := range tests {
+		if got := (streamSettings{Volume: volume}).audioFilter(); got != want {
+			t.Errorf("audioFilter() with volume %d = %q, want %q", volume, got, want)
+		}
+	}
+}
+
+func TestFilterChain(t *testing.T) {
+	tests := []struct {
+		filters []string
+		volume  int
+		want    string
+		speed   float64
+	}{
+		{nil, 100, "", 1},
+		{[]string{"bassboost"}, 100, "equalizer=f=40:width_type=h:width=50:g=10,equalizer=f=100:width_type=h:width=100:g=4", 1},
+		{[]string{"nightcore"}, 100, "aresample=48000,asetrate=60000,aresample=48000", 1.25},
+		{[]string{"vaporwave", "8d"}, 50, "aresample=48000,asetrate=38400,aresample=48000,apulsator=hz=0.125,volume=0.50", 0.8},
+		{[]string{"karaoke"}, 100, "pan=stereo|c0=c0-c1|c1=c1-c0", 1},
+		{[]string{"unknown"}, 100, "", 1},
+	}
+	for _, tt := range tests {
+		if got := filterChain(tt.filters, tt.volume); got != tt.want {
+			t.Errorf("filterChain(%v, %d) = %q, want %q", tt.filters, tt.volume, got, tt.want)
+		}
+		if got := filterSpeed(tt.filters); got != tt.speed {
+			t.Errorf("filterSpeed(%v) = %v, want %v", tt.filters, got, tt.speed)
+		}
+	}
+}
+
+func TestToggleFilters(t *testing.T) {
+	tests := []struct {
+		active []string
+		names  []string
+		want   []string
+	}{
+		{nil, []string{"karaoke", "BassBoost"}, []string{"bassboost", "karaoke"}},
+		{[]string{"bassboost", "karaoke"}, []string{"bassboost"}, []string{"karaoke"}},
+		{[]string{"nightcore", "8d"}, []string{"vaporwave"}, []string{"vaporwave", "8d"}},
+		{[]string{"8d"}, []string{"8d"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := toggleFilters(tt.active, tt.names)
+		if err != nil {
+			t.Fatalf("toggleFilters(%v, %v) failed: %v", tt.active, tt.names, err)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("toggleFilters(%v, %v) = %v, want %v", tt.active, tt.names, got, tt.want)
+		}
+	}
+
+	if _, err := toggleFilters(nil, []string{"bassboost", "chipmunk"}); err == nil {
+		t.Error("Expected an unknown filter to be rejected")
+	}
+}
+
+func TestFilterCommand(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	filter := func(args ...string) (string, error) {
+		return bot.HandleCommand("filter", args, "", "guild-a", "")
+	}
+
+	response, err := filter()
+	if err != nil {
+		t.Fatalf("filter failed: %v", err)
+	}
+	if !strings.Contains(response, "Filters:** none") || !strings.Contains(response, "nightcore") {
+		t.Errorf("Expected no active filters and the preset list, got:\n%s", response)
+	}
+	if _, exists := bot.player("guild-a"); exists {
+		t.Error("Expected listing filters not to create a player")
+	}
+
+	if response, err = filter("nightcore", "bassboost"); err != nil || !strings.Contains(response, "bassboost, nightcore") {
+		t.Errorf("Expected both filters on, got %q (%v)", response, err)
+	}
+	if _, err := filter("chipmunk"); err == nil {
+		t.Error("Expected an unknown filter to be rejected")
+	}
+
+	bot.mu.Lock()
+	settings := bot.streamSettings("guild-a", bot.players["guild-a"])
+	bot.mu.Unlock()
+	if settings.speed() != 1.25 || !strings.HasPrefix(settings.audioFilter(), "equalizer=") {
+		t.Errorf("Expected the next stream to use the filters, got %+v", settings)
+	}
+
+	if response, err = filter("clear"); err != nil || !strings.Contains(response, "Filters: none") {
+		t.Errorf("Expected the filters to be cleared, got %q (%v)", response, err)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	valid := map[string]time.Duration{
+		"0":       0,
+		"83":      83 * time.Second,
+		"1:23":    83 * time.Second,
+		"01:05":   65 * time.Second,
+		"1:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+	}
+	for value, want := range valid {
+		got, err := parseTimestamp(value)
+		if err != nil || got != want {
+			t.Errorf("parseTimestamp(%q) = %v (%v), want %v", value, got, err, want)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "-5", "1:5", "1:60", "1:2:3:4", "1:-1"} {
+		if _, err := parseTimestamp(value); err == nil {
+			t.Errorf("Expected parseTimestamp(%q) to fail", value)
+		}
+	}
+}
+
+func TestSeekCommands(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	if _, err := bot.HandleCommand("seek", []string{"1:00"}, "", "guild-a", ""); err == nil || !strings.Contains(err.Error(), "nothing is playing") {
+		t.Errorf("Expected seeking with nothing playing to fail, got %v", err)
+	}
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.queue.Add(newTrack(audio.SearchResult{ID: "a", Title: "Song A", Artist: "Artist", Duration: 200}, "yt", requester{}))
+	player.current = currentTrack(player)
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	if _, err := bot.HandleCommand("seek", []string{"3:20"}, "", "guild-a", ""); err == nil || !strings.Contains(err.Error(), "past the end") {
+		t.Errorf("Expected seeking past the end to fail, got %v", err)
+	}
+	if _, err := bot.HandleCommand("seek", []string{}, "", "guild-a", ""); err == nil {
+		t.Error("Expected seek without a position to fail")
+	}
+
+	// Without a voice connection there is no stream to restart, and the
+	// position is left alone
+	if _, err := bot.HandleCommand("forward", []string{}, "", "guild-a", ""); err == nil {
+		t.Error("Expected seeking without a stream to fail")
+	}
+	bot.mu.Lock()
+	startAt := player.startAt
+	bot.mu.Unlock()
+	if startAt != 0 {
+		t.Errorf("Expected a failed seek to leave the start position, got %v", startAt)
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		position, length time.Duration
+		want             string
+	}{
+		{0, 100 * time.Second, "🔘▬▬▬▬▬▬▬▬▬"},
+		{50 * time.Second, 100 * time.Second, "▬▬▬▬▬🔘▬▬▬▬"},
+		{100 * time.Second, 100 * time.Second, "▬▬▬▬▬▬▬▬▬🔘"},
+		{500 * time.Second, 100 * time.Second, "▬▬▬▬▬▬▬▬▬🔘"},
+		{30 * time.Second, 0, "🔘▬▬▬▬▬▬▬▬▬"},
+	}
+	for _, tt := range tests {
+		if got := progressBar(tt.position, tt.length, 10); got != tt.want {
+			t.Errorf("progressBar(%v, %v) = %q, want %q", tt.position, tt.length, got, tt.want)
+		}
+	}
+}
+
+func TestNowPlayingEmbed(t *testing.T) {
+	track := newTrack(audio.SearchResult{ID: "a", Title: "Song A", Artist: "Artist", Duration: 200, URL: "https://www.youtube.com/watch?v=a", Thumbnail: "https://i.ytimg.com/a.jpg"}, "yt", requester{ID: "user-1", Name: "Alice"})
+	view := nowPlayingView{
+		Track:    track,
+		Position: 83 * time.Second,
+		Upcoming: 3,
+		Loop:     queue.LoopQueue,
+		Volume:   80,
+		Filters:  []string{"bassboost"},
+	}
+
+	embed := nowPlayingEmbed(view)
+	if embed.Title != "Song A" || embed.URL != track.SourceURL || embed.Thumbnail == nil || embed.Thumbnail.URL != track.Thumbnail {
+		t.Errorf("Unexpected embed header: %+v", embed)
+	}
+	if !strings.Contains(embed.Description, "1:23 / 3:20") || !strings.Contains(embed.Description, "🔘") {
+		t.Errorf("Expected a progress bar at 1:23 / 3:20, got %q", embed.Description)
+	}
+	fields := map[string]string{}
+	for _, field := range embed.Fields {
+		fields[field.Name] = field.Value
+	}
+	if fields["Requested by"] != "Alice" || fields["Up next"] != "3 tracks" || fields["Loop"] != "queue" {
+		t.Errorf("Unexpected fields: %v", fields)
+	}
+	if embed.Footer == nil || embed.Footer.Text != "Volume 80% · yt · Filters: bassboost" {
+		t.Errorf("Unexpected footer: %+v", embed.Footer)
+	}
+
+	view.Paused = true
+	if embed := nowPlayingEmbed(view); embed.Author == nil || !strings.Contains(embed.Author.Name, "Paused") {
+		t.Errorf("Expected a paused embed, got %+v", embed.Author)
+	}
+
+	finished := nowPlayingEmbed(nowPlayingView{Track: track, Finished: true})
+	if finished.Title != "Song A" || len(finished.Fields) != 0 || strings.Contains(finished.Description, "🔘") {
+		t.Errorf("Expected a finished embed without progress, got %+v", finished)
+	}
+}
+
+func TestNowPlayingButtons(t *testing.T) {
+	ids := func(paused bool) []string {
+		var ids []string
+		for _, row := range nowPlayingComponents(paused) {
+			for _, component := range row.(discordgo.ActionsRow).Components {
+				ids = append(ids, component.(discordgo.Button).CustomID)
+			}
+		}
+		return ids
+	}
+
+	playing := ids(false)
+	if strings.Join(playing, ",") != "np:pause,np:skip,np:stop,np:shuffle,np:loop" {
+		t.Errorf("Unexpected buttons while playing: %v", playing)
+	}
+	if paused := ids(true); paused[0] != "np:resume" {
+		t.Errorf("Expected a resume button while paused, got %v", paused)
+	}
+
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	// Every button runs a command HandleCommand knows
+	for _, id := range append(playing, "np:resume") {
+		command, known := buttonCommand(id)
+		if !known {
+			t.Errorf("Expected button %s to map to a command", id)
+			continue
+		}
+		if _, err := bot.HandleCommand(command, []string{}, "", "guild-a", ""); err != nil && strings.Contains(err.Error(), "unknown command") {
+			t.Errorf("Button %s maps to unknown command %s", id, command)
+		}
+	}
+
+	for _, id := range []string{"np:leave", "pause", "other:skip"} {
+		if _, known := buttonCommand(id); known {
+			t.Errorf("Expected button %s to be rejected", id)
+		}
+	}
+}
+
+func TestAutoPause(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	voiceUpdate := func(userID, channelID string, isBot bool) {
+		bot.voiceStateUpdateHandler(bot.session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{
+			GuildID:   "guild-a",
+			UserID:    userID,
+			ChannelID: channelID,
+			Member:    &discordgo.Member{User: &discordgo.User{ID: userID, Bot: isBot}},
+		}})
+	}
+	paused := func() (bool, bool) {
+		bot.mu.Lock()
+		defer bot.mu.Unlock()
+		player := bot.players["guild-a"]
+		return player.isPaused, player.autoPaused
+	}
+
+	bot.mu.Lock()
+	bot.voiceConn["guild-a"] = &VoiceConnection{guildID: "guild-a", channelID: "voice-1"}
+	bot.getPlayer("guild-a").isPlaying = true
+	bot.mu.Unlock()
+
+	voiceUpdate("user-1", "voice-1", false)
+	voiceUpdate("other-bot", "voice-1", true)
+	voiceUpdate("user-2", "voice-2", false)
+	bot.mu.Lock()
+	listeners := bot.listeners("guild-a", "voice-1")
+	bot.mu.Unlock()
+	if listeners != 1 {
+		t.Errorf("Expected 1 listener, got %d", listeners)
+	}
+	if isPaused, _ := paused(); isPaused {
+		t.Error("Expected playback to continue while someone listens")
+	}
+
+	// Only bots and users in other channels are left
+	voiceUpdate("user-1", "", false)
+	if isPaused, autoPaused := paused(); !isPaused || !autoPaused {
+		t.Errorf("Expected playback to pause when everyone left, got paused=%v auto=%v", isPaused, autoPaused)
+	}
+
+	voiceUpdate("user-2", "voice-1", false)
+	if isPaused, autoPaused := paused(); isPaused || autoPaused {
+		t.Errorf("Expected playback to resume when someone joined, got paused=%v auto=%v", isPaused, autoPaused)
+	}
+
+	// A manual pause survives everyone leaving and coming back
+	if _, err := bot.HandleCommand("pause", []string{}, "", "guild-a", ""); err != nil {
+		t.Fatalf("pause failed: %v", err)
+	}
+	voiceUpdate("user-2", "", false)
+	voiceUpdate("user-2", "voice-1", false)
+	if isPaused, _ := paused(); !isPaused {
+		t.Error("Expected a manual pause to stay paused")
+	}
+	if _, err := bot.HandleCommand("resume", []string{}, "", "guild-a", ""); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+
+	// With auto-pause off, playback continues for an empty channel
+	if err := bot.settings.SetAutoPause("guild-a", false); err != nil {
+		t.Fatalf("SetAutoPause failed: %v", err)
+	}
+	voiceUpdate("user-2", "", false)
+	if isPaused, _ := paused(); isPaused {
+		t.Error("Expected playback to continue with auto-pause off")
+	}
+}
+
+func TestLeaveIdle(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	connected := func(guildID string) bool {
+		bot.mu.Lock()
+		defer bot.mu.Unlock()
+		_, exists := bot.voiceConn[guildID]
+		return exists
+	}
+
+	bot.mu.Lock()
+	// guild-a has played its queue out, guild-b is playing to a listener
+	bot.voiceConn["guild-a"] = &VoiceConnection{guildID: "guild-a", channelID: "voice-a"}
+	bot.getPlayer("guild-a").queue.Add(queue.Track{Title: "Done"})
+	bot.voiceConn["guild-b"] = &VoiceConnection{guildID: "guild-b", channelID: "voice-b"}
+	bot.getPlayer("guild-b").isPlaying = true
+	bot.voiceStates["guild-b:user-1"] = &VoiceStateInfo{VoiceState: &discordgo.VoiceState{GuildID: "guild-b", UserID: "user-1", ChannelID: "voice-b"}}
+	// guild-c has nothing to do but stays
+	bot.voiceConn["guild-c"] = &VoiceConnection{guildID: "guild-c", channelID: "voice-c"}
+	bot.mu.Unlock()
+	if err := bot.settings.SetIdleTimeout("guild-c", 0); err != nil {
+		t.Fatalf("SetIdleTimeout failed: %v", err)
+	}
+
+	start := time.Now()
+	bot.leaveIdle(start)
+	bot.leaveIdle(start.Add(config.DefaultIdleTimeout - time.Second))
+	if !connected("guild-a") {
+		t.Fatal("Expected the bot to stay before the idle timeout")
+	}
+
+	bot.leaveIdle(start.Add(config.DefaultIdleTimeout))
+	if connected("guild-a") {
+		t.Error("Expected the bot to leave after the idle timeout")
+	}
+	if _, exists := bot.player("guild-a"); exists {
+		t.Error("Expected the idle guild's player to be torn down")
+	}
+	if !connected("guild-b") {
+		t.Error("Expected the bot to stay where it is playing to a listener")
+	}
+	if !connected("guild-c") {
+		t.Error("Expected the bot to stay when the idle timeout is off")
+	}
+
+	// Once the listener leaves, the idle time counts from when it was noticed
+	bot.mu.Lock()
+	delete(bot.voiceStates, "guild-b:user-1")
+	bot.mu.Unlock()
+	later := start.Add(time.Hour)
+	bot.leaveIdle(later)
+	bot.leaveIdle(later.Add(config.DefaultIdleTimeout - time.Second))
+	if !connected("guild-b") {
+		t.Error("Expected the idle time to start when the channel emptied")
+	}
+	bot.leaveIdle(later.Add(config.DefaultIdleTimeout))
+	if connected("guild-b") {
+		t.Error("Expected the bot to leave an empty channel after the idle timeout")
+	}
+}
+
+func TestVoiceDropped(t *testing.T) {
+	ready := &discordgo.VoiceConnection{Ready: true}
+	dropped := &discordgo.VoiceConnection{Ready: false}
+	sourceErr := errors.New("ffmpeg exited")
+
+	tests := []struct {
+		name string
+		vc   *VoiceConnection
+		err  error
+		want bool
+	}{
+		{"send timed out", &VoiceConnection{connection: ready}, dca.ErrVoiceConnClosed, true},
+		{"wrapped send timeout", &VoiceConnection{connection: ready}, fmt.Errorf("stream: %w", dca.ErrVoiceConnClosed), true},
+		{"connection not ready", &VoiceConnection{connection: dropped}, sourceErr, true},
+		{"source error", &VoiceConnection{connection: ready}, sourceErr, false},
+		{"no connection", &VoiceConnection{}, sourceErr, false},
+	}
+	for _, tt := range tests {
+		if got := voiceDropped(tt.vc, tt.err); got != tt.want {
+			t.Errorf("%s: voiceDropped = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestBotMovedToAnotherChannel(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	bot.session.State.User = &discordgo.User{ID: "bot-id"}
+
+	voiceUpdate := func(userID, channelID string) {
+		bot.voiceStateUpdateHandler(bot.session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{
+			GuildID:   "guild-a",
+			UserID:    userID,
+			ChannelID: channelID,
+		}})
+	}
+
+	bot.mu.Lock()
+	bot.voiceConn["guild-a"] = &VoiceConnection{guildID: "guild-a", channelID: "voice-1"}
+	bot.getPlayer("guild-a").isPlaying = true
+	bot.mu.Unlock()
+	voiceUpdate("user-1", "voice-1")
+	voiceUpdate("bot-id", "voice-1")
+
+	// A moderator drags the bot into a channel where nobody is listening
+	voiceUpdate("bot-id", "voice-2")
+	bot.mu.Lock()
+	vc, connected := bot.voiceConn["guild-a"]
+	channelID := vc.channelID
+	paused := bot.players["guild-a"].isPaused
+	bot.mu.Unlock()
+	if !connected || channelID != "voice-2" {
+		t.Fatalf("Expected the bot to follow the move to voice-2, got %q (connected: %v)", channelID, connected)
+	}
+	if !paused {
+		t.Error("Expected playback to pause in a channel without listeners")
+	}
+
+	// The listener follows it and playback resumes
+	voiceUpdate("user-1", "voice-2")
+	if player, _ := bot.player("guild-a"); player.isPaused {
+		t.Error("Expected playback to resume once the listener followed")
+	}
+
+	// Being disconnected still tears everything down
+	voiceUpdate("bot-id", "")
+	if _, exists := bot.player("guild-a"); exists {
+		t.Error("Expected a disconnected bot to tear down the player")
+	}
+}
+
+func TestRecoverVoiceKeepsThePlayer(t *testing.T) {
+	defer func(delays []time.Duration) { reconnectDelays = delays }(reconnectDelays)
+	reconnectDelays = []time.Duration{time.Millisecond}
+
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	bot.session.State.User = &discordgo.User{ID: "bot-id"}
+
+	// Like Discord, report the bot leaving voice when it disconnects, and
+	// only let it back in once the report was handled
+	left := make(chan struct{}, 4)
+	var joined []string
+	bot.leaveVoice = func(conn *discordgo.VoiceConnection) {
+		go func() {
+			bot.voiceStateUpdateHandler(bot.session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{
+				GuildID: "guild-a",
+				UserID:  "bot-id",
+			}})
+			left <- struct{}{}
+		}()
+	}
+	rejoined := &discordgo.VoiceConnection{}
+	bot.joinVoice = func(guildID, channelID string) (*discordgo.VoiceConnection, error) {
+		<-left
+		joined = append(joined, channelID)
+		return rejoined, nil
+	}
+
+	bot.mu.Lock()
+	vc := &VoiceConnection{guildID: "guild-a", channelID: "voice-1", connection: &discordgo.VoiceConnection{}}
+	bot.voiceConn["guild-a"] = vc
+	player := bot.getPlayer("guild-a")
+	player.queue.Add(queue.Track{Title: "Song A", URL: "mock_a", Platform: "yt"})
+	player.queue.Add(queue.Track{Title: "Song B", URL: "mock_b", Platform: "yt"})
+	player.current = currentTrack(player)
+	player.isPlaying = true
+	player.streamStart = 42 * time.Second
+	bot.mu.Unlock()
+
+	if !bot.recoverVoice("guild-a", player, vc) {
+		t.Fatal("Expected playback to continue after reconnecting")
+	}
+	if !reflect.DeepEqual(joined, []string{"voice-1"}) {
+		t.Errorf("Expected one rejoin of voice-1, got %v", joined)
+	}
+
+	bot.mu.Lock()
+	kept := bot.players["guild-a"] == player && bot.voiceConn["guild-a"] == vc
+	connection := vc.connection
+	queued := len(player.queue.List())
+	restarting, startAt := player.restarting, player.startAt
+	bot.mu.Unlock()
+	if !kept {
+		t.Fatal("Expected the bot leaving to rejoin to keep the player and voice connection")
+	}
+	if connection != rejoined {
+		t.Error("Expected the voice connection to be replaced by the new one")
+	}
+	if queued != 2 {
+		t.Errorf("Expected the queue to keep 2 tracks, got %d", queued)
+	}
+	if !restarting || startAt != 42*time.Second {
+		t.Errorf("Expected the track to restart at 42s, got restarting %v at %v", restarting, startAt)
+	}
+
+	// Being disconnected afterwards still tears everything down
+	bot.voiceStateUpdateHandler(bot.session, &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{
+		GuildID: "guild-a",
+		UserID:  "bot-id",
+	}})
+	if _, exists := bot.player("guild-a"); exists {
+		t.Error("Expected a later disconnect to tear down the player")
+	}
+}
+
+func TestVotesNeeded(t *testing.T) {
+	tests := []struct {
+		listeners, percent, want int
+	}{
+		{0, 50, 1},
+		{1, 50, 1},
+		{2, 50, 1},
+		{3, 50, 2},
+		{4, 50, 2},
+		{4, 75, 3},
+		{5, 100, 5},
+		{10, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := votesNeeded(tt.listeners, tt.percent); got != tt.want {
+			t.Errorf("votesNeeded(%d, %d) = %d, want %d", tt.listeners, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestVoteSkip(t *testing.T) {
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	bot.mu.Lock()
+	bot.voiceConn["guild-a"] = &VoiceConnection{guildID: "guild-a", channelID: "voice-1"}
+	for _, userID := range []string{"user-1", "user-2", "user-3", "user-4"} {
+		bot.voiceStates["guild-a:"+userID] = &VoiceStateInfo{VoiceState: &discordgo.VoiceState{
+			GuildID:   "guild-a",
+			UserID:    userID,
+			ChannelID: "voice-1",
+			Member:    &discordgo.Member{User: &discordgo.User{ID: userID}},
+		}}
+	}
+	bot.voiceStates["guild-a:user-2"].VoiceState.Member.Roles = []string{"dj-role"}
+	bot.voiceStates["guild-a:user-5"] = &VoiceStateInfo{VoiceState: &discordgo.VoiceState{GuildID: "guild-a", UserID: "user-5", ChannelID: "voice-2"}}
+
+	player := bot.getPlayer("guild-a")
+	for i, requestedBy := range []string{"user-1", "user-4", "user-1", "user-1"} {
+		player.queue.Add(queue.Track{Title: fmt.Sprintf("Song %d", i+1), RequestedBy: requestedBy})
+	}
+	player.current = currentTrack(player)
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	skip := func(command, userID string) (string, error) {
+		response, err := bot.HandleCommand(command, []string{}, "", "guild-a", userID)
+		bot.mu.Lock()
+		player.current = currentTrack(player)
+		bot.mu.Unlock()
+		return response, err
+	}
+	current := func() string {
+		bot.mu.Lock()
+		defer bot.mu.Unlock()
+		return player.current.Title
+	}
+
+	// Half of the 4 listeners must agree
+	if response, err := skip("skip", "user-2"); err != nil || !strings.Contains(response, "registered (1/2") {
+		t.Errorf("Expected the first vote to be registered, got %q (%v)", response, err)
+	}
+	if response, _ := skip("skip", "user-2"); !strings.Contains(response, "already voted") {
+		t.Errorf("Expected a repeated vote not to count twice, got %q", response)
+	}
+	if _, err := skip("skip", "user-5"); err == nil {
+		t.Error("Expected a user in another channel not to be able to vote")
+	}
+	if current() != "Song 1" {
+		t.Fatalf("Expected Song 1 to keep playing, got %s", current())
+	}
+	if response, err := skip("skip", "user-3"); err != nil || !strings.Contains(response, "Vote passed (2/2)") {
+		t.Errorf("Expected the second vote to skip, got %q (%v)", response, err)
+	}
+	if current() != "Song 2" {
+		t.Fatalf("Expected Song 2 after the vote, got %s", current())
+	}
+
+	// Votes start over for the new track, and its requester skips right away
+	if response, _ := skip("skip", "user-3"); !strings.Contains(response, "(1/2") {
+		t.Errorf("Expected votes to reset on track change, got %q", response)
+	}
+	if _, err := skip("skip", "user-4"); err != nil || current() != "Song 3" {
+		t.Errorf("Expected the requester to skip right away, got %s (%v)", current(), err)
+	}
+
+	// With a DJ role, only DJs and the requester can force a skip
+	if err := bot.settings.SetDJRole("guild-a", "dj-role"); err != nil {
+		t.Fatalf("SetDJRole failed: %v", err)
+	}
+	if _, err := skip("forceskip", "user-3"); err == nil {
+		t.Error("Expected a listener without the DJ role not to force a skip")
+	}
+	if _, err := skip("forceskip", "user-2"); err != nil || current() != "Song 4" {
+		t.Errorf("Expected a DJ to force a skip, got %s (%v)", current(), err)
+	}
+
+	// With vote skipping off, one !skip is enough
+	if err := bot.settings.SetSkipVotes("guild-a", 0); err != nil {
+		t.Fatalf("SetSkipVotes failed: %v", err)
+	}
+	if response, err := skip("skip", "user-3"); err != nil || strings.Contains(response, "Vote") {
+		t.Errorf("Expected an immediate skip with voting off, got %q (%v)", response, err)
+	}
+}
+
+func TestCommandPermissions(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	state := bot.session.State
+	if err := state.GuildAdd(&discordgo.Guild{
+		ID:      "guild-a",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "guild-a", Name: "@everyone"},
+			{ID: "dj-role", Name: "DJ"},
+			{ID: "mod-role", Name: "Mod", Permissions: discordgo.PermissionManageServer},
+		},
+	}); err != nil {
+		t.Fatalf("GuildAdd failed: %v", err)
+	}
+	for userID, roles := range map[string][]string{"listener": nil, "dj": {"dj-role"}, "mod": {"mod-role"}} {
+		if err := state.MemberAdd(&discordgo.Member{GuildID: "guild-a", User: &discordgo.User{ID: userID}, Roles: roles}); err != nil {
+			t.Fatalf("MemberAdd failed: %v", err)
+		}
+	}
+
+	// Every command has a level
+	for _, cmd := range slashCommands {
+		if _, known := commandName(cmd.definition.Name); !known {
+			t.Errorf("Expected command %s to have a permission level", cmd.definition.Name)
+		}
+	}
+
+	denied := func(userID, command string, args ...string) bool {
+		_, err := bot.HandleCommand(command, args, "", "guild-a", userID)
+		return err != nil && strings.Contains(err.Error(), "🚫")
+	}
+
+	// Without a DJ role everyone is a DJ, but admin commands need Manage Server
+	if denied("listener", "stop") {
+		t.Error("Expected everyone to manage playback without a DJ role")
+	}
+	if !denied("listener", "setdefault", "sp") || !denied("listener", "settings", "volume", "80") {
+		t.Error("Expected admin commands to be denied to a listener")
+	}
+	if denied("mod", "setdefault", "sp") || denied("owner", "smartplay", "on") {
+		t.Error("Expected Manage Server and the owner to run admin commands")
+	}
+
+	if err := bot.settings.SetDJRole("guild-a", "dj-role"); err != nil {
+		t.Fatalf("SetDJRole failed: %v", err)
+	}
+	_, err = bot.HandleCommand("stop", []string{}, "", "guild-a", "listener")
+	if err == nil || err.Error() != "🚫 !stop needs the @DJ role or the Manage Server permission" {
+		t.Errorf("Expected a clear denial for !stop, got %v", err)
+	}
+	if !denied("listener", "vol", "50") || denied("listener", "volume") || denied("listener", "settings") {
+		t.Error("Expected aliases to be checked and viewing to stay open")
+	}
+	if denied("dj", "stop") || denied("mod", "shuffle") || !denied("dj", "settings", "volume", "80") {
+		t.Error("Expected DJs to manage playback only, and admins to do everything")
+	}
+	if !denied("listener", "test") || !denied("listener", "undeafen") || !denied("dj", "apitest") || !denied("dj", "voicemonitor") {
+		t.Error("Expected voice commands to need DJ and diagnostics to need admin")
+	}
+	if denied("", "stop") {
+		t.Error("Expected commands without a user to be allowed")
+	}
+
+	// Servers can move commands between levels
+	if !denied("dj", "permissions", "stop", "everyone") {
+		t.Error("Expected only admins to change permissions")
+	}
+	if _, err := bot.HandleCommand("permissions", []string{"stop", "everyone"}, "", "guild-a", "mod"); err != nil {
+		t.Fatalf("permissions failed: %v", err)
+	}
+	if _, err := bot.HandleCommand("permissions", []string{"queue", "admin"}, "", "guild-a", "mod"); err != nil {
+		t.Fatalf("permissions failed: %v", err)
+	}
+	if denied("listener", "stop") || !denied("dj", "queue") {
+		t.Error("Expected the overrides to apply")
+	}
+	list, err := bot.HandleCommand("permissions", []string{}, "", "guild-a", "listener")
+	if err != nil || !strings.Contains(list, "!stop (changed)") || !strings.Contains(list, "!queue (changed)") {
+		t.Errorf("Expected the list to mark changed commands, got %q (%v)", list, err)
+	}
+
+	for _, args := range [][]string{{"stop", "owner"}, {"dance", "dj"}, {"permissions", "everyone"}} {
+		if _, err := bot.HandleCommand("permissions", args, "", "guild-a", "mod"); err == nil {
+			t.Errorf("Expected permissions %v to be rejected", args)
+		}
+	}
+
+	if _, err := bot.HandleCommand("permissions", []string{"stop", "default"}, "", "guild-a", "mod"); err != nil {
+		t.Fatalf("permissions failed: %v", err)
+	}
+	if !denied("listener", "stop") {
+		t.Error("Expected default to restore the DJ requirement")
+	}
+}
+
+func TestCheckVoiceJoin(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	state := bot.session.State
+	state.User = &discordgo.User{ID: "bot"}
+	if err := state.GuildAdd(&discordgo.Guild{
+		ID: "guild-a",
+		Roles: []*discordgo.Role{
+			{ID: "guild-a", Permissions: voicePermissions},
+			{ID: "music-bot"},
+		},
+	}); err != nil {
+		t.Fatalf("GuildAdd failed: %v", err)
+	}
+	if err := state.MemberAdd(&discordgo.Member{GuildID: "guild-a", User: &discordgo.User{ID: "bot"}, Roles: []string{"music-bot"}}); err != nil {
+		t.Fatalf("MemberAdd failed: %v", err)
+	}
+
+	deny := func(id string, overwriteType discordgo.PermissionOverwriteType, permissions int64) *discordgo.PermissionOverwrite {
+		return &discordgo.PermissionOverwrite{ID: id, Type: overwriteType, Deny: permissions}
+	}
+	allow := func(id string, overwriteType discordgo.PermissionOverwriteType, permissions int64) *discordgo.PermissionOverwrite {
+		return &discordgo.PermissionOverwrite{ID: id, Type: overwriteType, Allow: permissions}
+	}
+	channels := []*discordgo.Channel{
+		{ID: "lounge", Name: "Lounge", Type: discordgo.ChannelTypeGuildCategory, PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			deny("guild-a", discordgo.PermissionOverwriteTypeRole, discordgo.PermissionVoiceConnect),
+		}},
+		{ID: "open", Name: "Open"},
+		{ID: "music", Name: "Music", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			deny("music-bot", discordgo.PermissionOverwriteTypeRole, discordgo.PermissionVoiceSpeak),
+		}},
+		{ID: "hidden", Name: "Hidden", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			deny("guild-a", discordgo.PermissionOverwriteTypeRole, voicePermissions),
+		}},
+		// Synced with its category, so it holds a copy of the category's overwrites
+		{ID: "private", Name: "Private", ParentID: "lounge", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			deny("guild-a", discordgo.PermissionOverwriteTypeRole, discordgo.PermissionVoiceConnect),
+		}},
+		{ID: "booth", Name: "Booth", ParentID: "lounge", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+			deny("guild-a", discordgo.PermissionOverwriteTypeRole, discordgo.PermissionVoiceConnect),
+			allow("bot", discordgo.PermissionOverwriteTypeMember, discordgo.PermissionVoiceConnect),
+		}},
+	}
+	for _, channel := range channels {
+		channel.GuildID = "guild-a"
+		if channel.Type == 0 {
+			channel.Type = discordgo.ChannelTypeGuildVoice
+		}
+		if err := state.ChannelAdd(channel); err != nil {
+			t.Fatalf("ChannelAdd failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		channelID string
+		want      string
+	}{
+		{"open", ""},
+		{"music", "I lack Speak in #Music"},
+		{"hidden", "I lack View Channel, Connect and Speak in #Hidden"},
+		{"private", "I lack Connect in #Private"},
+		{"booth", ""},
+	}
+	for _, tt := range tests {
+		err := bot.checkVoiceJoin("guild-a", tt.channelID)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: expected the join to be allowed, got %v", tt.channelID, err)
+		}
+		if tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%s: expected %q, got %v", tt.channelID, tt.want, err)
+		}
+	}
+}
+
+func TestUserQueueLimits(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	fake := &fakePlaylists{release: make(chan struct{})}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "fake", Provider: fake, Capabilities: audio.CapStream | audio.CapPlaylists}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	for _, setting := range [][]string{{"usertracks", "3"}, {"fairqueue", "on"}} {
+		if _, err := bot.HandleCommand("settings", setting, "", "guild-a", ""); err != nil {
+			t.Fatalf("settings %v failed: %v", setting, err)
+		}
+	}
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	player.queue.Add(queue.Track{Title: "Now", RequestedBy: "user-2"})
+	player.current = currentTrack(player)
+	player.queue.Add(queue.Track{Title: "Bob's", RequestedBy: "user-2"})
+	bot.mu.Unlock()
+
+	// The playlist stops at the requester's limit
+	info, playlists, _ := bot.playlistProvider("https://playlists.example/mix")
+	if _, err := bot.queuePlaylist("guild-a", info, playlists, "https://playlists.example/mix", requester{ID: "user-1", Name: "Alice"}); err != nil {
+		t.Fatalf("queuePlaylist failed: %v", err)
+	}
+	fake.release <- struct{}{}
+	waitForQueueLength(t, player.queue, 5)
+	time.Sleep(20 * time.Millisecond)
+	if tracks, _ := player.queue.Queued("user-1"); tracks != 3 {
+		t.Errorf("Expected the playlist to stop at 3 tracks, got %d", tracks)
+	}
+
+	// Requesters take turns, and the current track was user-2's turn
+	var titles []string
+	for _, track := range player.queue.List() {
+		titles = append(titles, track.Title)
+	}
+	if got := strings.Join(titles, ", "); got != "Now, Track 1a, Bob's, Track 1b, Track 2a" {
+		t.Errorf("Expected requesters to take turns, got %s", got)
+	}
+
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	if err := bot.checkUserLimits("guild-a", player, queue.Track{Title: "More", RequestedBy: "user-1"}); err == nil || !strings.Contains(err.Error(), "already have 3 upcoming tracks") {
+		t.Errorf("Expected a fourth track to be rejected, got %v", err)
+	}
+	if err := bot.checkUserLimits("guild-a", player, queue.Track{Title: "Bonus", RequestedBy: ""}); err != nil {
+		t.Errorf("Expected tracks added by the bot not to be limited, got %v", err)
+	}
+
+	if err := bot.settings.SetMaxUserDuration("guild-a", 5*time.Minute); err != nil {
+		t.Fatalf("SetMaxUserDuration failed: %v", err)
+	}
+	if err := bot.checkUserLimits("guild-a", player, queue.Track{Title: "Epic", RequestedBy: "user-3", Duration: 400}); err == nil || !strings.Contains(err.Error(), "Epic is 6:40 long") {
+		t.Errorf("Expected a track over the time limit to be rejected, got %v", err)
+	}
+	if err := bot.checkUserLimits("guild-a", player, queue.Track{Title: "Short", RequestedBy: "user-3", Duration: 200}); err != nil {
+		t.Errorf("Expected a track within the limits to be accepted, got %v", err)
+	}
+}
+
+// fakeSearch finds six tracks for any query
+type fakeSearch struct{}
+
+func (fakeSearch) Search(query string) ([]audio.SearchResult, error) {
+	var results []audio.SearchResult
+	for i := 1; i <= 6; i++ {
+		results = append(results, audio.SearchResult{ID: fmt.Sprintf("%s-%d", query, i), Title: fmt.Sprintf("%s %d", query, i), Artist: "Band"})
+	}
+	return results, nil
+}
+func (fakeSearch) GetStreamURL(id string) (string, error) { return id, nil }
+func (fakeSearch) GetRecommendations(genre string) ([]audio.SearchResult, error) {
+	return nil, nil
+}
+
+func TestSearchAndPick(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "fake", Provider: fakeSearch{}, Capabilities: audio.CapSearch | audio.CapStream}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	// Pretend the bot is playing in the user's channel, so picks only queue
+	bot.mu.Lock()
+	bot.voiceConn["guild-a"] = &VoiceConnection{channelID: "voice-1", guildID: "guild-a"}
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	pick := func(userID, number string) (string, error) {
+		return bot.HandleCommand("pick", []string{number}, "voice-1", "guild-a", userID)
+	}
+
+	if _, err := pick("user-1", "1"); err == nil || !strings.Contains(err.Error(), "no recent search results") {
+		t.Errorf("Expected picking without a search to fail, got %v", err)
+	}
+
+	started := time.Now()
+	response, err := bot.HandleCommand("search", []string{"fake:song"}, "", "guild-a", "user-1")
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if !strings.Contains(response, "5. song 5 - Band") || strings.Contains(response, "song 6") || !strings.Contains(response, "!pick <number>") {
+		t.Errorf("Expected the top five results with a hint, got:\n%s", response)
+	}
+
+	menu := bot.searchMenu("guild-a", "user-1", started)
+	if len(menu) != 1 {
+		t.Fatalf("Expected a menu for the new results, got %v", menu)
+	}
+	selectMenu := menu[0].(discordgo.ActionsRow).Components[0].(discordgo.SelectMenu)
+	if selectMenu.CustomID != "pick:user-1:1" || len(selectMenu.Options) != 5 || selectMenu.Options[2].Value != "3" {
+		t.Errorf("Unexpected search menu: %+v", selectMenu)
+	}
+	if userID, searchID, ok := parseSearchMenuID(selectMenu.CustomID); !ok || userID != "user-1" || searchID != 1 {
+		t.Errorf("Expected the menu to name user-1's first search, got %q, %d, %v", userID, searchID, ok)
+	}
+	if _, _, ok := parseSearchMenuID("pick:user-1"); ok {
+		t.Error("Expected a menu without a search number to be rejected")
+	}
+	if bot.searchMenu("guild-a", "user-1", time.Now().Add(time.Second)) != nil {
+		t.Error("Expected no menu for results from before the command")
+	}
+
+	for _, number := range []string{"0", "6", "three"} {
+		if _, err := pick("user-1", number); err == nil {
+			t.Errorf("Expected pick %s to be rejected", number)
+		}
+	}
+	if _, err := pick("user-2", "1"); err == nil {
+		t.Error("Expected other users not to pick from someone else's results")
+	}
+
+	if response, err := pick("user-1", "3"); err != nil || !strings.Contains(response, "song 3") {
+		t.Fatalf("Expected song 3 to be queued, got %q (%v)", response, err)
+	}
+	if response, err := pick("user-1", "1"); err != nil || !strings.Contains(response, "song 1") {
+		t.Fatalf("Expected to pick again from the same results, got %q (%v)", response, err)
+	}
+	tracks := player.queue.List()
+	if len(tracks) != 2 || tracks[0].URL != "song-3" || tracks[0].Platform != "fake" || tracks[0].RequestedBy != "user-1" {
+		t.Errorf("Unexpected queue after picking: %+v", tracks)
+	}
+
+	// Choosing with !play offers the results instead of queueing the first
+	response, err = bot.HandleCommand("play", []string{"--choose", "fake:tune"}, "voice-1", "guild-a", "user-2")
+	if err != nil || !strings.Contains(response, "1. tune 1") || len(player.queue.List()) != 2 {
+		t.Errorf("Expected play --choose to list results, got %q (%v)", response, err)
+	}
+	if menu := bot.searchMenu("guild-a", "user-2", started); len(menu) != 1 ||
+		menu[0].(discordgo.ActionsRow).Components[0].(discordgo.SelectMenu).CustomID != "pick:user-2:2" {
+		t.Errorf("Expected the menu of a later search to name it, got %+v", menu)
+	}
+	if !offersResults("play", []string{"-c", "tune"}) || offersResults("play", []string{"tune"}) {
+		t.Error("Expected only play with the choose flag to offer results")
+	}
+
+	// Results expire
+	bot.mu.Lock()
+	bot.searches[searchKey("guild-a", "user-2")].created = time.Now().Add(-searchResultsTTL - time.Second)
+	bot.mu.Unlock()
+	if _, err := pick("user-2", "1"); err == nil {
+		t.Error("Expected expired results not to be picked from")
+	}
+}
+
+func TestWebStreams(t *testing.T) {
+	// An Icecast-like station that announces one song in its metadata
+	mux := http.NewServeMux()
+	mux.HandleFunc("/radio", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-name", "Test FM")
+		if r.Header.Get("Icy-MetaData") != "1" {
+			w.Write(make([]byte, 64))
+			return
+		}
+		w.Header().Set("icy-metaint", "8")
+		w.Write(make([]byte, 8))
+		w.Write(append([]byte{2}, fmt.Sprintf("%-32s", "StreamTitle='DJ - Opening';")...))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, "<html></html>")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+	}
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	streams, ok := bot.webStreams()
+	if !ok {
+		t.Fatal("Expected the web stream provider to be registered")
+	}
+	streams.SetAllowPrivateNetworks(true)
+	if streams.IsStreamURL("https://www.youtube.com/watch?v=dQw4w9WgXcQ") {
+		t.Error("Expected YouTube links to be left to the YouTube provider")
+	}
+
+	// Pretend playback is running so no playback loop is started
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	response, err := bot.queueStream("guild-a", streams, server.URL+"/radio", requester{ID: "user-1", Name: "Alice"})
+	if err != nil {
+		t.Fatalf("queueStream failed: %v", err)
+	}
+	if !strings.Contains(response, "Test FM") || !strings.Contains(response, "live stream") {
+		t.Errorf("Unexpected response: %s", response)
+	}
+	if _, err := bot.queueStream("guild-a", streams, server.URL+"/page", requester{ID: "user-1"}); err == nil {
+		t.Error("Expected a web page to be refused")
+	}
+
+	track, err := player.queue.Current()
+	if err != nil || !track.Live || track.Platform != streamPrefix || track.URL != server.URL+"/radio" {
+		t.Fatalf("Expected a live web stream in the queue, got %+v, %v", track, err)
+	}
+	if got := trackLength(track); got != "live" {
+		t.Errorf("Expected the queue to show live, got %q", got)
+	}
+
+	bot.mu.Lock()
+	player.current = &track
+	player.startAt = time.Minute
+	if settings := bot.streamSettings("guild-a", player); settings.Start != 0 {
+		t.Errorf("Expected live streams to start at what is playing now, got %v", settings.Start)
+	}
+	bot.mu.Unlock()
+
+	if _, err := bot.HandleCommand("seek", []string{"1:00"}, "", "guild-a", ""); err == nil || !strings.Contains(err.Error(), "live") {
+		t.Errorf("Expected seeking a live stream to fail, got %v", err)
+	}
+	if err := bot.settings.SetMaxUserDuration("guild-a", 30*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	bot.mu.Lock()
+	err = bot.checkUserLimits("guild-a", player, track)
+	bot.mu.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "live stream") {
+		t.Errorf("Expected a live stream to exceed a time limit, got %v", err)
+	}
+
+	// Titles from the stream's metadata show up in now playing
+	stop := bot.watchStreamTitle("guild-a", player, track, server.URL+"/radio")
+	defer stop()
+	var title string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline) && title == ""; time.Sleep(5 * time.Millisecond) {
+		bot.mu.Lock()
+		title = player.streamTitle
+		bot.mu.Unlock()
+	}
+	if title != "DJ - Opening" {
+		t.Fatalf("Expected the stream title, got %q", title)
+	}
+
+	bot.mu.Lock()
+	view := bot.nowPlayingView("guild-a", player)
+	bot.mu.Unlock()
+	embed := nowPlayingEmbed(view)
+	if !strings.Contains(embed.Description, "LIVE") || !strings.Contains(embed.Description, "DJ - Opening") || strings.Contains(embed.Description, "🔘") {
+		t.Errorf("Expected a live embed with the stream title and no progress bar, got %q", embed.Description)
+	}
+	if response, _ := bot.HandleCommand("nowplaying", nil, "", "guild-a", ""); !strings.Contains(response, "On air: DJ - Opening") || !strings.Contains(response, "Live for") {
+		t.Errorf("Unexpected now playing response: %s", response)
+	}
+}
+
+func TestPodcasts(t *testing.T) {
+	// Like many podcast hosts, the feed tracks each fetch in its audio URLs
+	feed := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+<channel>
+  <title>Test Talk</title>
+  <item>
+    <title>Pilot</title>
+    <guid isPermaLink="false">test-talk-1</guid>
+    <pubDate>Mon, 06 May 2024 10:00:00 +0000</pubDate>
+    <itunes:duration>30:00</itunes:duration>
+    <enclosure url="/ep1.mp3?fetch=%[1]d" type="audio/mpeg"/>
+  </item>
+  <item>
+    <title>Second Wind</title>
+    <guid isPermaLink="false">test-talk-2</guid>
+    <pubDate>Mon, 13 May 2024 10:00:00 +0000</pubDate>
+    <itunes:duration>40:00</itunes:duration>
+    <enclosure url="/ep2.mp3?fetch=%[1]d" type="audio/mpeg"/>
+  </item>
+</channel>
+</rss>`
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, feed, fetches.Add(1))
+	}))
+	defer server.Close()
+	feedURL := server.URL + "/feed.xml"
+
+	cfg := &config.Config{
+		DiscordToken:  "Bot.fake.token",
+		DefaultPlayer: "yt",
+		DataDir:       t.TempDir(),
+	}
+	bot, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+
+	podcasts, ok := bot.podcasts()
+	if !ok {
+		t.Fatal("Expected the podcast provider to be registered")
+	}
+	podcasts.SetAllowPrivateNetworks(true)
+
+	// Listing needs no voice channel, playing does
+	if commandRequiresVoice("podcast", []string{feedURL}) || commandRequiresVoice("podcast", []string{feedURL, "list"}) {
+		t.Error("Expected listing episodes not to need a voice channel")
+	}
+	if !commandRequiresVoice("podcast", []string{feedURL, "latest"}) {
+		t.Error("Expected playing an episode to need a voice channel")
+	}
+
+	response, err := bot.HandleCommand("podcast", []string{feedURL}, "", "guild-a", "user-1")
+	if err != nil {
+		t.Fatalf("Listing episodes failed: %v", err)
+	}
+	if !strings.Contains(response, "Test Talk") || strings.Index(response, "`1.` **Second Wind** (2024-05-13, 40:00)") > strings.Index(response, "`2.` **Pilot**") {
+		t.Errorf("Expected the newest episode first, got:\n%s", response)
+	}
+	if _, err := bot.HandleCommand("podcast", []string{feedURL, "latest"}, "", "guild-a", "user-1"); err == nil {
+		t.Error("Expected playing an episode outside a voice channel to fail")
+	}
+
+	podcast, err := podcasts.Feed(feedURL)
+	if err != nil {
+		t.Fatalf("Feed failed: %v", err)
+	}
+
+	// Pretend playback is running so no playback loop is started
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.isPlaying = true
+	bot.mu.Unlock()
+
+	response, err = bot.queueEpisode("guild-a", podcast, "2", requester{ID: "user-1", Name: "Alice"})
+	if err != nil {
+		t.Fatalf("queueEpisode failed: %v", err)
+	}
+	if !strings.Contains(response, "Pilot") || strings.Contains(response, "Resumes") {
+		t.Errorf("Unexpected response for a new episode: %s", response)
+	}
+	track, err := player.queue.Current()
+	if err != nil || track.Platform != podcastPrefix || track.URL != feedURL+"#test-talk-1" || track.Duration != 1800 {
+		t.Fatalf("Expected the episode in the queue, got %+v, %v", track, err)
+	}
+	if link, err := podcasts.GetStreamURL(track.URL); err != nil || link != server.URL+"/ep1.mp3?fetch=1" {
+		t.Errorf("Expected the episode to play from the feed's audio URL, got %q, %v", link, err)
+	}
+
+	// Where playback got to is remembered when state is saved
+	bot.mu.Lock()
+	player.current = &track
+	player.streamStart = 12*time.Minute + 34*time.Second
+	bot.mu.Unlock()
+	bot.saveState()
+
+	restarted, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create restarted bot: %v", err)
+	}
+	refetched, ok := restarted.podcasts()
+	if !ok {
+		t.Fatal("Expected the podcast provider to be registered after a restart")
+	}
+	refetched.SetAllowPrivateNetworks(true)
+	restarted.mu.Lock()
+	resumeAt := restarted.resumeEpisode("guild-a", track)
+	restarted.mu.Unlock()
+	if resumeAt != 12*time.Minute+34*time.Second {
+		t.Fatalf("Expected the episode to resume at 12:34 after a restart, got %v", resumeAt)
+	}
+
+	response, _ = restarted.HandleCommand("podcast", []string{feedURL, "list"}, "", "guild-a", "user-1")
+	if !strings.Contains(response, "**Pilot** (2024-05-06, 30:00) ⏯ 12:34") {
+		t.Errorf("Expected the list to show where the episode stopped, got:\n%s", response)
+	}
+
+	// The feed now lists other audio URLs for the same episodes
+	podcast, err = refetched.Feed(feedURL)
+	if err != nil {
+		t.Fatalf("Feed failed after a restart: %v", err)
+	}
+	if podcast.Episodes[1].AudioURL == server.URL+"/ep1.mp3?fetch=1" {
+		t.Fatalf("Expected the audio URL to change between fetches, got %s", podcast.Episodes[1].AudioURL)
+	}
+	restarted.mu.Lock()
+	restarted.getPlayer("guild-a").isPlaying = true
+	restarted.mu.Unlock()
+	response, err = restarted.queueEpisode("guild-a", podcast, "2", requester{ID: "user-1"})
+	if err != nil || !strings.Contains(response, "Resumes at** 12:34") {
+		t.Errorf("Expected queueing to mention the resume position, got %q, %v", response, err)
+	}
+
+	// Other guilds and tracks don't resume
+	restarted.mu.Lock()
+	other := restarted.resumeEpisode("guild-b", track)
+	song := newTrack(audio.SearchResult{ID: track.URL, Title: "Song"}, "yt", requester{})
+	notPodcast := restarted.resumeEpisode("guild-a", song)
+	restarted.mu.Unlock()
+	if other != 0 || notPodcast != 0 {
+		t.Errorf("Expected only the guild's podcast episode to resume, got %v and %v", other, notPodcast)
+	}
+
+	// An episode played to within the last seconds counts as finished
+	restarted.mu.Lock()
+	restarted.rememberEpisode("guild-a", track, 29*time.Minute+45*time.Second)
+	finished := restarted.resumeEpisode("guild-a", track)
+	restarted.mu.Unlock()
+	if finished != 0 {
+		t.Errorf("Expected a finished episode to start over, got %v", finished)
+	}
+	restarted.saveState()
+	if positions, err := restarted.store.LoadPodcastPositions(); err != nil || len(positions["guild-a"]) != 0 {
+		t.Errorf("Expected the finished episode to be forgotten on disk, got %v, %v", positions, err)
+	}
+}
+
+// currentTrack returns a copy of the player's current queue track, as the
+// playback loop keeps it, or nil if there is none
+func currentTrack(player *Player) *queue.Track {
+	track, err := player.queue.Current()
+	if err != nil {
+		return nil
+	}
+	return &track
+}
+
+// slowResolver takes until released to resolve each stream URL, then fails
+type slowResolver struct {
+	started chan string
+	release chan struct{}
+}
+
+func (s *slowResolver) Search(query string) ([]audio.SearchResult, error) { return nil, nil }
+func (s *slowResolver) GetStreamURL(id string) (string, error) {
+	s.started <- id
+	<-s.release
+	return "", errors.New("unavailable")
+}
+func (s *slowResolver) GetRecommendations(genre string) ([]audio.SearchResult, error) {
+	return nil, nil
+}
+
+func TestResolvesStreamsWithoutBlocking(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	slow := &slowResolver{started: make(chan string), release: make(chan struct{})}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "slow", Provider: slow, Capabilities: audio.CapStream}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	player.queue.Add(queue.Track{Title: "A", URL: "a", Platform: "slow"})
+	player.queue.Add(queue.Track{Title: "B", URL: "b", Platform: "slow"})
+	bot.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bot.startPlaying("guild-a")
+		close(done)
+	}()
+	if id := <-slow.started; id != "a" {
+		t.Fatalf("Expected track a to be resolved first, got %s", id)
+	}
+
+	// Other commands go ahead while the URL is resolved
+	responded := make(chan struct{})
+	go func() {
+		bot.HandleCommand("queue", []string{}, "", "guild-b", "")
+		close(responded)
+	}()
+	select {
+	case <-responded:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected commands not to wait for a stream URL to resolve")
+	}
+
+	// A skip while resolving moves on instead of playing the skipped track
+	bot.mu.Lock()
+	player.queue.Next()
+	bot.playCurrent("guild-a", player)
+	bot.mu.Unlock()
+	slow.release <- struct{}{}
+	if id := <-slow.started; id != "b" {
+		t.Fatalf("Expected the skip to move on to track b, got %s", id)
+	}
+	slow.release <- struct{}{}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected playback to stop after the last track failed")
+	}
+}
+
+func TestStopsWhenNoTrackResolves(t *testing.T) {
+	bot, err := New(&config.Config{DiscordToken: "Bot.fake.token", DefaultPlayer: "yt"})
+	if err != nil {
+		t.Fatalf("Failed to create bot: %v", err)
+	}
+	slow := &slowResolver{started: make(chan string), release: make(chan struct{})}
+	if err := bot.providers.Register(audio.ProviderInfo{Prefix: "slow", Provider: slow, Capabilities: audio.CapStream}); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	// A looping queue of tracks that all fail is tried once round
+	bot.mu.Lock()
+	player := bot.getPlayer("guild-a")
+	for _, id := range []string{"a", "b", "c"} {
+		player.queue.Add(queue.Track{Title: id, URL: id, Platform: "slow"})
+	}
+	player.queue.SetLoop(queue.LoopQueue)
+	bot.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		bot.startPlaying("guild-a")
+		close(done)
+	}()
+
+	var resolved []string
+	for {
+		select {
+		case id := <-slow.started:
+			resolved = append(resolved, id)
+			slow.release <- struct{}{}
+			continue
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected playback to stop, still resolving after %v", resolved)
+		}
+		break
+	}
+	if strings.Join(resolved, " ") != "a b c" {
+		t.Errorf("Expected each track to be tried once, got %v", resolved)
+	}
+	bot.mu.Lock()
+	defer bot.mu.Unlock()
+	if player.isPlaying || player.current != nil {
+		t.Error("Expected playback to have stopped")
 	}
 }
@@ -0,0 +1,187 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// outputSampleRate is the sample rate the encoder produces for Discord
+const outputSampleRate = 48000
+
+// filterPreset is a named ffmpeg audio filter chain that !filter can turn on
+type filterPreset struct {
+	name        string
+	description string
+	chain       string  // ffmpeg -af filters, comma separated
+	speed       float64 // Playback speed relative to the track, 0 if unchanged
+}
+
+// filterPresets lists the presets in the order their chains are applied.
+// Speed changing presets resample to the output rate first, so the speed
+// doesn't depend on the source's sample rate.
+var filterPresets = []filterPreset{
+	{
+		name:        "bassboost",
+		description: "Boost the low end",
+		chain:       "equalizer=f=40:width_type=h:width=50:g=10,equalizer=f=100:width_type=h:width=100:g=4",
+	},
+	{
+		name:        "nightcore",
+		description: "Faster and higher pitched",
+		chain:       resampleChain(1.25),
+		speed:       1.25,
+	},
+	{
+		name:        "vaporwave",
+		description: "Slower and lower pitched",
+		chain:       resampleChain(0.8),
+		speed:       0.8,
+	},
+	{
+		name:        "8d",
+		description: "Audio circling between the left and right ear",
+		chain:       "apulsator=hz=0.125",
+	},
+	{
+		name:        "karaoke",
+		description: "Remove centred vocals",
+		chain:       "pan=stereo|c0=c0-c1|c1=c1-c0",
+	},
+}
+
+// resampleChain changes the playback speed and pitch together by
+// reinterpreting the sample rate
+func resampleChain(speed float64) string {
+	return fmt.Sprintf("aresample=%d,asetrate=%d,aresample=%d", outputSampleRate, int(outputSampleRate*speed), outputSampleRate)
+}
+
+// findFilterPreset looks up a preset by name
+func findFilterPreset(name string) (filterPreset, bool) {
+	for _, preset := range filterPresets {
+		if strings.EqualFold(preset.name, name) {
+			return preset, true
+		}
+	}
+	return filterPreset{}, false
+}
+
+// filterNames lists the preset names for error messages
+func filterNames() string {
+	names := make([]string, 0, len(filterPresets))
+	for _, preset := range filterPresets {
+		names = append(names, preset.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// toggleFilters turns the named presets on or off in active, returning the
+// new set in preset order. Presets that change the speed replace each other.
+func toggleFilters(active []string, names []string) ([]string, error) {
+	enabled := make(map[string]bool, len(active))
+	for _, name := range active {
+		enabled[name] = true
+	}
+
+	for _, name := range names {
+		preset, exists := findFilterPreset(name)
+		if !exists {
+			return nil, fmt.Errorf("unknown filter %q, available: %s", name, filterNames())
+		}
+		if enabled[preset.name] {
+			delete(enabled, preset.name)
+			continue
+		}
+		if preset.speed != 0 {
+			for _, other := range filterPresets {
+				if other.speed != 0 {
+					delete(enabled, other.name)
+				}
+			}
+		}
+		enabled[preset.name] = true
+	}
+
+	var filters []string
+	for _, preset := range filterPresets {
+		if enabled[preset.name] {
+			filters = append(filters, preset.name)
+		}
+	}
+	return filters, nil
+}
+
+// filterChain builds the ffmpeg filter chain for a set of presets and a
+// volume in percent, empty if no filter is needed
+func filterChain(filters []string, volume int) string {
+	var chain []string
+	for _, name := range filters {
+		if preset, exists := findFilterPreset(name); exists {
+			chain = append(chain, preset.chain)
+		}
+	}
+	if volume != 100 {
+		chain = append(chain, fmt.Sprintf("volume=%.2f", float64(volume)/100))
+	}
+	return strings.Join(chain, ",")
+}
+
+// filterSpeed returns the playback speed a set of presets results in
+func filterSpeed(filters []string) float64 {
+	speed := 1.0
+	for _, name := range filters {
+		if preset, exists := findFilterPreset(name); exists && preset.speed != 0 {
+			speed *= preset.speed
+		}
+	}
+	return speed
+}
+
+// handleFilter shows the active filters, toggles presets, or clears them.
+// Changes apply to the current track by restarting it at its position.
+func (b *Bot) handleFilter(args []string, guildID string) (string, error) {
+	if guildID == "" {
+		return "", errors.New("filters can only be changed in a server")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(args) == 0 {
+		// Listing doesn't create a player for a guild that never played
+		var active []string
+		if player, exists := b.players[guildID]; exists {
+			active = player.filters
+		}
+		var sb strings.Builder
+		sb.WriteString(fmt.Sprintf("**🎛️ Filters:** %s\n", activeFilters(active)))
+		for _, preset := range filterPresets {
+			sb.WriteString(fmt.Sprintf("• **%s** - %s\n", preset.name, preset.description))
+		}
+		sb.WriteString("\nToggle presets with `!filter <name> [name...]`, remove them all with `!filter clear`")
+		return sb.String(), nil
+	}
+
+	player := b.getPlayer(guildID)
+	var filters []string
+	if len(args) != 1 || !(strings.EqualFold(args[0], "clear") || isNone(args[0])) {
+		var err error
+		if filters, err = toggleFilters(player.filters, args); err != nil {
+			return "", err
+		}
+	}
+	player.filters = filters
+
+	response := fmt.Sprintf("🎛️ Filters: %s", activeFilters(filters))
+	if b.restartCurrent(guildID, player) {
+		response += " (applied to the current track)"
+	}
+	return response, nil
+}
+
+func activeFilters(filters []string) string {
+	if len(filters) == 0 {
+		return "none"
+	}
+	return strings.Join(filters, ", ")
+}
@@ -0,0 +1,134 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// idleCheckInterval is how often voice connections are checked for being
+// idle. Idle timeouts are enforced to within this interval.
+const idleCheckInterval = 15 * time.Second
+
+// listeners counts the users other than bots in a voice channel.
+// The caller must hold b.mu.
+func (b *Bot) listeners(guildID, channelID string) int {
+	count := 0
+	for key, info := range b.voiceStates {
+		vs := info.VoiceState
+		if !strings.HasPrefix(key, guildID+":") || vs.ChannelID != channelID || b.isBotUser(vs.UserID) {
+			continue
+		}
+		if vs.Member != nil && vs.Member.User != nil && vs.Member.User.Bot {
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// setPaused pauses or resumes a guild's stream. The caller must hold b.mu.
+func (b *Bot) setPaused(guildID string, player *Player, paused bool) {
+	if vc, exists := b.voiceConn[guildID]; exists && vc.stream != nil {
+		vc.stream.SetPaused(paused)
+	}
+	player.isPaused = paused
+	player.autoPaused = false
+	player.refreshNowPlaying()
+}
+
+// updateAutoPause pauses playback when the bot is left alone in its voice
+// channel, and resumes it when someone comes back. Playback paused with
+// !pause stays paused. The caller must hold b.mu.
+func (b *Bot) updateAutoPause(guildID string) {
+	vc, connected := b.voiceConn[guildID]
+	player, exists := b.players[guildID]
+	if !connected || !exists || !player.isPlaying {
+		return
+	}
+
+	alone := b.listeners(guildID, vc.channelID) == 0
+	switch {
+	case alone && !player.isPaused && b.settings.AutoPause(guildID):
+		log.Printf("Everyone left the voice channel in guild %s, pausing", guildID)
+		b.setPaused(guildID, player, true)
+		player.autoPaused = true
+	case !alone && player.autoPaused:
+		log.Printf("Someone rejoined the voice channel in guild %s, resuming", guildID)
+		b.setPaused(guildID, player, false)
+	}
+}
+
+// isIdle reports whether a guild's voice connection is doing nothing useful:
+// nothing is playing, playback is paused, or nobody is listening.
+// The caller must hold b.mu.
+func (b *Bot) isIdle(guildID string, vc *VoiceConnection) bool {
+	player, exists := b.players[guildID]
+	if !exists || !player.isPlaying || player.isPaused {
+		return true
+	}
+	return b.listeners(guildID, vc.channelID) == 0
+}
+
+// runIdleWatcher leaves voice channels that stayed idle for longer than
+// their guild's idle timeout, until the bot closes
+func (b *Bot) runIdleWatcher() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			b.leaveIdle(now)
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// leaveIdle tears down the voice connections that have been idle for longer
+// than their guild's idle timeout at now, and says so in the channel music
+// was requested from
+func (b *Bot) leaveIdle(now time.Time) {
+	type departure struct {
+		channelID string
+		timeout   time.Duration
+	}
+
+	b.mu.Lock()
+	var departures []departure
+	for guildID, vc := range b.voiceConn {
+		if !b.isIdle(guildID, vc) {
+			vc.idleSince = time.Time{}
+			continue
+		}
+		if vc.idleSince.IsZero() {
+			vc.idleSince = now
+		}
+
+		timeout := b.settings.IdleTimeout(guildID)
+		if timeout <= 0 || now.Sub(vc.idleSince) < timeout {
+			continue
+		}
+
+		log.Printf("Leaving voice in guild %s after being idle for %v", guildID, now.Sub(vc.idleSince))
+		var channelID string
+		if player, exists := b.players[guildID]; exists {
+			channelID = player.textChannelID
+		}
+		b.teardownGuild(guildID)
+		departures = append(departures, departure{channelID: channelID, timeout: timeout})
+	}
+	b.mu.Unlock()
+
+	for _, d := range departures {
+		if d.channelID == "" || b.session == nil {
+			continue
+		}
+		message := fmt.Sprintf("👋 Left the voice channel after %s without anything to play or anyone listening", formatTimeout(d.timeout))
+		if _, err := b.session.ChannelMessageSend(d.channelID, message); err != nil {
+			log.Printf("Failed to send idle message in channel %s: %v", d.channelID, err)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+const (
+	// nowPlayingUpdateInterval is how often the now playing message is edited
+	// to move its progress bar. Discord rate limits message edits, so this
+	// stays well above a second.
+	nowPlayingUpdateInterval = 15 * time.Second
+
+	progressBarWidth = 16
+	nowPlayingColor  = 0x1DB954
+
+	// nowPlayingButtonPrefix marks the custom IDs of the now playing buttons
+	nowPlayingButtonPrefix = "np:"
+)
+
+// nowPlayingMessage is a posted now playing message that is kept up to date
+// while its track plays
+type nowPlayingMessage struct {
+	channelID string
+	messageID string
+	track     queue.Track
+	refresh   chan struct{} // Requests an update before the next tick
+	done      chan struct{} // Closed when the message stops being updated
+	closeOnce sync.Once
+}
+
+func (m *nowPlayingMessage) stop() {
+	m.closeOnce.Do(func() { close(m.done) })
+}
+
+// nowPlayingView is everything the now playing message shows
+type nowPlayingView struct {
+	Track       queue.Track
+	Position    time.Duration
+	Paused      bool
+	Finished    bool
+	Upcoming    int
+	Loop        queue.LoopMode
+	Volume      int
+	Filters     []string
+	StreamTitle string // What a live stream is playing, if it says
+}
+
+// setTextChannel remembers the channel a guild's music was requested from,
+// where now playing messages go unless an announce channel is set
+func (b *Bot) setTextChannel(guildID, channelID string) {
+	if guildID == "" || channelID == "" {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.getPlayer(guildID).textChannelID = channelID
+}
+
+// nowPlayingView captures the state of a guild's player for the now playing
+// message. The caller must hold b.mu.
+func (b *Bot) nowPlayingView(guildID string, player *Player) nowPlayingView {
+	view := nowPlayingView{
+		Position:    b.playbackPosition(guildID, player),
+		Paused:      player.isPaused,
+		Upcoming:    player.queue.Pending(),
+		Loop:        player.queue.Loop(),
+		Volume:      b.settings.Volume(guildID),
+		Filters:     append([]string(nil), player.filters...),
+		StreamTitle: player.streamTitle,
+	}
+	if player.current != nil {
+		view.Track = *player.current
+	}
+	return view
+}
+
+// announceTrack posts the now playing message for a guild's current track,
+// retiring the previous one, and keeps it updated while the track plays
+func (b *Bot) announceTrack(guildID string) {
+	b.mu.Lock()
+	player, exists := b.players[guildID]
+	if !exists || player.current == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.endNowPlaying(player)
+
+	channelID := b.settings.AnnounceChannel(guildID)
+	if channelID == "" {
+		channelID = player.textChannelID
+	}
+	view := b.nowPlayingView(guildID, player)
+	b.mu.Unlock()
+
+	if channelID == "" || b.session == nil {
+		return
+	}
+
+	message, err := b.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{nowPlayingEmbed(view)},
+		Components: nowPlayingComponents(view.Paused),
+	})
+	if err != nil {
+		log.Printf("Failed to post now playing message in channel %s: %v", channelID, err)
+		return
+	}
+
+	msg := &nowPlayingMessage{
+		channelID: channelID,
+		messageID: message.ID,
+		track:     view.Track,
+		refresh:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	b.mu.Lock()
+	if b.players[guildID] != player || !player.isPlaying {
+		b.mu.Unlock()
+		msg.stop()
+	} else {
+		player.nowPlaying = msg
+		b.mu.Unlock()
+	}
+	go b.runNowPlaying(guildID, player, msg)
+}
+
+// endNowPlaying stops updating the player's now playing message.
+// The caller must hold b.mu.
+func (b *Bot) endNowPlaying(player *Player) {
+	if player.nowPlaying != nil {
+		player.nowPlaying.stop()
+		player.nowPlaying = nil
+	}
+}
+
+// refreshNowPlaying updates a guild's now playing message right away, e.g.
+// after a button press
+func (b *Bot) refreshNowPlaying(guildID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if player, exists := b.players[guildID]; exists {
+		player.refreshNowPlaying()
+	}
+}
+
+// refreshNowPlaying updates the player's now playing message right away.
+// The caller must hold Bot.mu.
+func (p *Player) refreshNowPlaying() {
+	if p.nowPlaying == nil {
+		return
+	}
+	select {
+	case p.nowPlaying.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// runNowPlaying edits a now playing message as its track progresses. Once
+// the message is retired, it is edited a last time without its buttons.
+func (b *Bot) runNowPlaying(guildID string, player *Player, msg *nowPlayingMessage) {
+	ticker := time.NewTicker(nowPlayingUpdateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-msg.refresh:
+		case <-msg.done:
+		}
+
+		b.mu.Lock()
+		current := b.players[guildID] == player && player.nowPlaying == msg && player.isPlaying
+		view := b.nowPlayingView(guildID, player)
+		if !current {
+			if player.nowPlaying == msg {
+				player.nowPlaying = nil
+			}
+			msg.stop()
+		}
+		b.mu.Unlock()
+
+		edit := discordgo.NewMessageEdit(msg.channelID, msg.messageID)
+		if current {
+			edit.Embeds = []*discordgo.MessageEmbed{nowPlayingEmbed(view)}
+			edit.Components = nowPlayingComponents(view.Paused)
+		} else {
+			// The player has moved on, so only the track itself is still accurate
+			view = nowPlayingView{Track: msg.track, Finished: true}
+			edit.Embeds = []*discordgo.MessageEmbed{nowPlayingEmbed(view)}
+			edit.Components = []discordgo.MessageComponent{}
+		}
+		if _, err := b.session.ChannelMessageEditComplex(edit); err != nil {
+			log.Printf("Failed to update now playing message in channel %s: %v", msg.channelID, err)
+		}
+
+		if !current {
+			return
+		}
+	}
+}
+
+// nowPlayingEmbed renders the now playing message
+func nowPlayingEmbed(view nowPlayingView) *discordgo.MessageEmbed {
+	track := view.Track
+	embed := &discordgo.MessageEmbed{
+		Title: track.Title,
+		URL:   track.SourceURL,
+		Color: nowPlayingColor,
+	}
+	if track.Thumbnail != "" {
+		embed.Thumbnail = &discordgo.MessageEmbedThumbnail{URL: track.Thumbnail}
+	}
+
+	if view.Finished {
+		embed.Author = &discordgo.MessageEmbedAuthor{Name: "Played"}
+		embed.Description = track.Artist
+		return embed
+	}
+
+	status := "▶️ Now playing"
+	if view.Paused {
+		status = "⏸️ Paused"
+	}
+	embed.Author = &discordgo.MessageEmbedAuthor{Name: status}
+
+	if track.Live {
+		// Live streams have no end to show progress towards
+		embed.Description = track.Artist
+		if view.StreamTitle != "" {
+			embed.Description += "\n🎶 " + view.StreamTitle
+		}
+		embed.Description += fmt.Sprintf("\n\n🔴 **LIVE** `%s`", formatPosition(view.Position))
+	} else {
+		length := time.Duration(track.Duration) * time.Second
+		embed.Description = fmt.Sprintf("%s\n\n%s `%s`", track.Artist, progressBar(view.Position, length, progressBarWidth), positionOf(view.Position, track.Duration))
+	}
+
+	upNext := "Nothing"
+	if view.Upcoming == 1 {
+		upNext = "1 track"
+	} else if view.Upcoming > 1 {
+		upNext = fmt.Sprintf("%d tracks", view.Upcoming)
+	}
+	embed.Fields = []*discordgo.MessageEmbedField{
+		{Name: "Requested by", Value: requestedBy(track), Inline: true},
+		{Name: "Up next", Value: upNext, Inline: true},
+		{Name: "Loop", Value: view.Loop.String(), Inline: true},
+	}
+
+	footer := fmt.Sprintf("Volume %d%% · %s", view.Volume, track.Platform)
+	if len(view.Filters) > 0 {
+		footer += " · Filters: " + strings.Join(view.Filters, ", ")
+	}
+	embed.Footer = &discordgo.MessageEmbedFooter{Text: footer}
+	return embed
+}
+
+// progressBar draws how far position is into a track of the given length.
+// Tracks of unknown length get a bar with the marker at the start.
+func progressBar(position, length time.Duration, width int) string {
+	filled := 0
+	if length > 0 {
+		filled = int(int64(width) * int64(min(max(position, 0), length)) / int64(length))
+	}
+	filled = min(filled, width-1)
+	return strings.Repeat("▬", filled) + "🔘" + strings.Repeat("▬", width-filled-1)
+}
+
+// nowPlayingComponents builds the buttons under the now playing message
+func nowPlayingComponents(paused bool) []discordgo.MessageComponent {
+	pause := discordgo.Button{Label: "Pause", Emoji: discordgo.ComponentEmoji{Name: "⏸️"}, Style: discordgo.SecondaryButton, CustomID: nowPlayingButtonPrefix + "pause"}
+	if paused {
+		pause = discordgo.Button{Label: "Resume", Emoji: discordgo.ComponentEmoji{Name: "▶️"}, Style: discordgo.SuccessButton, CustomID: nowPlayingButtonPrefix + "resume"}
+	}
+
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			pause,
+			discordgo.Button{Label: "Skip", Emoji: discordgo.ComponentEmoji{Name: "⏭️"}, Style: discordgo.SecondaryButton, CustomID: nowPlayingButtonPrefix + "skip"},
+			discordgo.Button{Label: "Stop", Emoji: discordgo.ComponentEmoji{Name: "⏹️"}, Style: discordgo.DangerButton, CustomID: nowPlayingButtonPrefix + "stop"},
+			discordgo.Button{Label: "Shuffle", Emoji: discordgo.ComponentEmoji{Name: "🔀"}, Style: discordgo.SecondaryButton, CustomID: nowPlayingButtonPrefix + "shuffle"},
+			discordgo.Button{Label: "Loop", Emoji: discordgo.ComponentEmoji{Name: "🔁"}, Style: discordgo.SecondaryButton, CustomID: nowPlayingButtonPrefix + "loop"},
+		}},
+	}
+}
+
+// buttonCommand maps a now playing button to the command it runs
+func buttonCommand(customID string) (string, bool) {
+	command, found := strings.CutPrefix(customID, nowPlayingButtonPrefix)
+	if !found {
+		return "", false
+	}
+	switch command {
+	case "pause", "resume", "skip", "stop", "shuffle", "loop":
+		return command, true
+	}
+	return "", false
+}
+
+// handleButton runs the command behind a now playing button for the user who
+// pressed it, answers them privately and refreshes the message
+func (b *Bot) handleButton(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User) {
+	data := i.MessageComponentData()
+	command, known := buttonCommand(data.CustomID)
+	if !known {
+		log.Printf("Received unknown button: %s", data.CustomID)
+		return
+	}
+
+	// Acknowledge right away, finding the user's voice channel and running
+	// the command can take longer than the three seconds Discord allows
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		log.Printf("Failed to acknowledge button %s: %v", data.CustomID, err)
+		return
+	}
+
+	var response string
+	if i.GuildID == "" {
+		response = "Error: This command can only be used in a server"
+	} else if commandRequiresVoice(command, nil) && b.findUserVoiceState(s, i.GuildID, user.ID, user.Username) == nil {
+		response = voiceChannelRequiredMessage
+	} else {
+		response, err = b.HandleCommand(command, []string{}, "", i.GuildID, user.ID)
+		if err != nil {
+			response = fmt.Sprintf("Error: %s", err)
+		}
+	}
+
+	_, err = s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{Content: response, Flags: discordgo.MessageFlagsEphemeral})
+	if err != nil {
+		log.Printf("Failed to respond to button %s: %v", data.CustomID, err)
+	}
+
+	b.refreshNowPlaying(i.GuildID)
+}
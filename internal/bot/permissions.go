@@ -0,0 +1,307 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/config"
+	"github.com/doomhound188/soulhound/internal/permissions"
+)
+
+// permissionLevel is who may run a command. Each level includes the ones
+// below it, so admins can do everything DJs can.
+type permissionLevel int
+
+const (
+	levelEveryone permissionLevel = iota
+	levelDJ
+	levelAdmin
+)
+
+func (l permissionLevel) String() string {
+	switch l {
+	case levelDJ:
+		return config.LevelDJ
+	case levelAdmin:
+		return config.LevelAdmin
+	default:
+		return config.LevelEveryone
+	}
+}
+
+// parseLevel parses the level names used in guild settings
+func parseLevel(name string) (permissionLevel, bool) {
+	switch strings.ToLower(name) {
+	case config.LevelEveryone:
+		return levelEveryone, true
+	case config.LevelDJ:
+		return levelDJ, true
+	case config.LevelAdmin:
+		return levelAdmin, true
+	}
+	return levelEveryone, false
+}
+
+// commandLevels lists every command by its full name with the level it needs
+// unless a server overrides it with !permissions
+var commandLevels = map[string]permissionLevel{
+	"play":         levelEveryone,
+	"skip":         levelEveryone,
+	"forceskip":    levelEveryone, // Requesters may force a skip, so the DJ check is in the command
+	"queue":        levelEveryone,
+	"nowplaying":   levelEveryone,
+	"search":       levelEveryone,
+	"pick":         levelEveryone,
+	"podcast":      levelEveryone,
+	"help":         levelEveryone,
+	"pause":        levelDJ,
+	"resume":       levelDJ,
+	"stop":         levelDJ,
+	"leave":        levelDJ,
+	"volume":       levelDJ,
+	"filter":       levelDJ,
+	"seek":         levelDJ,
+	"forward":      levelDJ,
+	"rewind":       levelDJ,
+	"remove":       levelDJ,
+	"move":         levelDJ,
+	"jump":         levelDJ,
+	"back":         levelDJ,
+	"shuffle":      levelDJ,
+	"loop":         levelDJ,
+	"settings":     levelAdmin,
+	"setdefault":   levelAdmin,
+	"smartplay":    levelAdmin,
+	"permissions":  levelAdmin,
+	"debug":        levelEveryone,
+	"refreshvoice": levelDJ,
+	"undeafen":     levelDJ,
+	"test":         levelDJ,
+	"voicetest":    levelAdmin,
+	"diagnose":     levelAdmin,
+	"apitest":      levelAdmin,
+	"voicemonitor": levelAdmin,
+}
+
+// commandAliases maps short command names to their full names
+var commandAliases = map[string]string{
+	"np":      "nowplaying",
+	"vol":     "volume",
+	"filters": "filter",
+	"ff":      "forward",
+	"rw":      "rewind",
+	"fs":      "forceskip",
+}
+
+// viewableCommands only show something when run without arguments, which
+// everyone may do
+var viewableCommands = map[string]bool{
+	"volume":      true,
+	"filter":      true,
+	"settings":    true,
+	"permissions": true,
+}
+
+// commandName returns a command's full name, and whether it exists
+func commandName(command string) (string, bool) {
+	name := strings.ToLower(command)
+	if alias, exists := commandAliases[name]; exists {
+		name = alias
+	}
+	_, exists := commandLevels[name]
+	return name, exists
+}
+
+// commandLevel returns the level a command needs in a guild
+func (b *Bot) commandLevel(guildID, name string) permissionLevel {
+	if override, exists := parseLevel(b.settings.CommandLevel(guildID, name)); exists {
+		return override
+	}
+	return commandLevels[name]
+}
+
+// requiredLevel returns the level a command run with args needs in a guild.
+// Viewing with a command that can change something is open to everyone,
+// unless the guild set its level.
+func (b *Bot) requiredLevel(guildID, name string, args []string) permissionLevel {
+	if len(args) == 0 && viewableCommands[name] && b.settings.CommandLevel(guildID, name) == "" {
+		return levelEveryone
+	}
+	return b.commandLevel(guildID, name)
+}
+
+// checkCommandPermission returns an error explaining why a user may not run a
+// command, or nil if they may. Commands run without a guild or user, such as
+// those the bot runs itself, are always allowed.
+func (b *Bot) checkCommandPermission(command string, args []string, guildID, userID string) error {
+	name, known := commandName(command)
+	if !known || guildID == "" || userID == "" {
+		return nil
+	}
+
+	required := b.requiredLevel(guildID, name, args)
+	if required == levelEveryone {
+		return nil
+	}
+
+	b.mu.Lock()
+	level := b.memberLevel(guildID, userID)
+	b.mu.Unlock()
+	if level >= required {
+		return nil
+	}
+	return fmt.Errorf("🚫 %s%s needs %s", b.settings.Prefix(guildID), name, b.levelRequirement(guildID, required))
+}
+
+// levelRequirement describes what a user needs to reach a level
+func (b *Bot) levelRequirement(guildID string, level permissionLevel) string {
+	if level == levelDJ {
+		return fmt.Sprintf("the %s role or the Manage Server permission", b.roleName(guildID, b.settings.DJRole(guildID)))
+	}
+	return "the Manage Server permission"
+}
+
+// memberLevel returns the highest level a guild member has: admin with the
+// Manage Server or Administrator permission or as the owner, DJ with the DJ
+// role. The caller must hold b.mu.
+func (b *Bot) memberLevel(guildID, userID string) permissionLevel {
+	if b.isAdmin(guildID, userID) {
+		return levelAdmin
+	}
+	if b.isDJ(guildID, userID) {
+		return levelDJ
+	}
+	return levelEveryone
+}
+
+// isAdmin reports whether a user may manage the guild. Members the session
+// state doesn't know aren't admins. The caller must hold b.mu.
+func (b *Bot) isAdmin(guildID, userID string) bool {
+	if b.session == nil || b.session.State == nil {
+		return false
+	}
+
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return false
+	}
+	member, err := b.session.State.Member(guildID, userID)
+	if err != nil {
+		info, exists := b.voiceStates[guildID+":"+userID]
+		if !exists || info.VoiceState.Member == nil {
+			return guild.OwnerID == userID
+		}
+		member = info.VoiceState.Member
+	}
+
+	member = withUser(member, userID)
+	return permissions.Has(permissions.Guild(guild, member), discordgo.PermissionManageServer)
+}
+
+// withUser returns member with its user set, as members from voice states and
+// events may come without one
+func withUser(member *discordgo.Member, userID string) *discordgo.Member {
+	if member.User != nil {
+		return member
+	}
+	withUser := *member
+	withUser.User = &discordgo.User{ID: userID}
+	return &withUser
+}
+
+// rememberMember adds the member who ran a command to the session state, so
+// permission checks see their roles. Message and interaction events carry
+// the member, but discordgo only tracks members it gets from member events.
+func rememberMember(s *discordgo.Session, guildID string, member *discordgo.Member, user *discordgo.User) {
+	if guildID == "" || member == nil || user == nil || s.State == nil {
+		return
+	}
+
+	m := *member
+	m.GuildID = guildID
+	m.User = user
+	if err := s.State.MemberAdd(&m); err != nil {
+		log.Printf("Failed to cache member %s of guild %s: %v", user.ID, guildID, err)
+	}
+}
+
+// handlePermissions shows the commands that need a role, shows what one
+// command needs, or changes it for the guild
+func (b *Bot) handlePermissions(args []string, guildID string) (string, error) {
+	if guildID == "" {
+		return "", errors.New("permissions can only be changed in a server")
+	}
+
+	prefix := b.settings.Prefix(guildID)
+	if len(args) == 0 {
+		return b.permissionList(guildID), nil
+	}
+
+	name, known := commandName(args[0])
+	if !known {
+		return "", fmt.Errorf("unknown command %q", args[0])
+	}
+	if len(args) == 1 {
+		return fmt.Sprintf("**%s%s** needs: %s", prefix, name, b.commandLevel(guildID, name)), nil
+	}
+	if len(args) > 2 {
+		return "", errors.New("please specify a command and everyone, dj, admin or default")
+	}
+	if name == "permissions" {
+		return "", errors.New("the permissions command always needs the Manage Server permission")
+	}
+
+	level := strings.ToLower(args[1])
+	if level == "default" {
+		level = ""
+	} else if _, valid := parseLevel(level); !valid {
+		return "", fmt.Errorf("invalid level %q, use everyone, dj, admin or default", args[1])
+	}
+	if err := b.settings.SetCommandLevel(guildID, name, level); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("✅ **%s%s** now needs: %s", prefix, name, b.commandLevel(guildID, name)), nil
+}
+
+// permissionList lists the commands that need DJ or admin in a guild,
+// marking the ones the guild changed
+func (b *Bot) permissionList(guildID string) string {
+	names := make([]string, 0, len(commandLevels))
+	for name := range commandLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := b.settings.Prefix(guildID)
+	var sb strings.Builder
+	sb.WriteString("**🔐 Command Permissions:**\n")
+	for _, level := range []permissionLevel{levelAdmin, levelDJ, levelEveryone} {
+		var commands []string
+		for _, name := range names {
+			if b.commandLevel(guildID, name) != level {
+				continue
+			}
+			if b.settings.CommandLevel(guildID, name) != "" {
+				commands = append(commands, fmt.Sprintf("%s%s (changed)", prefix, name))
+			} else if level != levelEveryone {
+				commands = append(commands, prefix+name)
+			}
+		}
+		if len(commands) > 0 {
+			sb.WriteString(fmt.Sprintf("• **%s**: %s\n", level, strings.Join(commands, ", ")))
+		}
+	}
+	sb.WriteString("\nEveryone may run the other commands. ")
+	if roleID := b.settings.DJRole(guildID); roleID != "" {
+		sb.WriteString(fmt.Sprintf("DJs have the %s role, ", b.roleName(guildID, roleID)))
+	} else {
+		sb.WriteString(fmt.Sprintf("Without a DJ role (`%ssettings djrole`) everyone is a DJ, ", prefix))
+	}
+	sb.WriteString("admins have the Manage Server permission.\n")
+	sb.WriteString(fmt.Sprintf("Change one with `%spermissions <command> <everyone|dj|admin|default>`", prefix))
+	return sb.String()
+}
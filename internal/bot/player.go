@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+// Player holds the playback state for a single guild. Players are created
+// lazily on the first !play in a guild and torn down when the bot leaves
+// that guild's voice channel. All fields are guarded by Bot.mu.
+type Player struct {
+	guildID    string
+	queue      *queue.Queue
+	current    *queue.Track
+	isPlaying  bool
+	isPaused   bool
+	autoPaused bool // paused because nobody was listening, resumes when someone joins
+	skipped    bool // set when a command already advanced the queue
+	queueGen   int  // bumped when the queue is cleared, cancels background playlist loading
+
+	startAt     time.Duration   // where to start the current track's next stream, e.g. when resuming
+	streamStart time.Duration   // where the current stream started in the track
+	streamSpeed float64         // how fast the current stream plays through the track, 0 if unchanged
+	filters     []string        // active filter presets
+	restarting  bool            // set when the current track is restarted rather than skipped
+	skipVotes   map[string]bool // users who voted to skip the current track
+	streamTitle string          // what a live stream says it is playing, from its ICY metadata
+
+	textChannelID string             // channel music was last requested from
+	nowPlaying    *nowPlayingMessage // message showing the current track, if any
+}
+
+func newPlayer(guildID string) *Player {
+	return &Player{
+		guildID: guildID,
+		queue:   queue.NewQueue(),
+	}
+}
+
+// isCurrent reports whether track is the one the player is playing, by its
+// provider and ID. The caller must hold b.mu.
+func (p *Player) isCurrent(track queue.Track) bool {
+	return p.current != nil && p.current.Platform == track.Platform && p.current.URL == track.URL
+}
+
+// getPlayer returns the player for a guild, creating it if needed.
+// The caller must hold b.mu.
+func (b *Bot) getPlayer(guildID string) *Player {
+	p, exists := b.players[guildID]
+	if !exists {
+		p = newPlayer(guildID)
+		p.queue.SetFair(b.settings.FairQueue(guildID))
+		b.players[guildID] = p
+		log.Printf("Created player for guild %s (total players: %d)", guildID, len(b.players))
+	}
+	return p
+}
+
+// player returns the player for a guild without creating one, so read-only
+// commands in a guild that never played anything don't allocate state.
+func (b *Bot) player(guildID string) (*Player, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, exists := b.players[guildID]
+	return p, exists
+}
+
+// isBotUser reports whether the given user ID belongs to the bot itself.
+func (b *Bot) isBotUser(userID string) bool {
+	if b.session == nil || b.session.State == nil || b.session.State.User == nil {
+		return false
+	}
+	return b.session.State.User.ID == userID
+}
+
+// teardownGuild stops playback, drops the guild's player and disconnects its
+// voice connection. The caller must hold b.mu.
+func (b *Bot) teardownGuild(guildID string) {
+	if p, exists := b.players[guildID]; exists {
+		p.isPlaying = false
+		p.isPaused = false
+		p.queue.Clear()
+		p.queueGen++
+		b.endNowPlaying(p)
+		delete(b.players, guildID)
+	}
+
+	if vc, exists := b.voiceConn[guildID]; exists {
+		if vc.encoder != nil {
+			vc.encoder.Cleanup()
+		}
+		vc.stream = nil
+		if vc.connection != nil {
+			b.leaveVoice(vc.connection)
+		}
+		delete(b.voiceConn, guildID)
+	}
+
+	log.Printf("Tore down player and voice connection for guild %s", guildID)
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/doomhound188/soulhound/internal/audio"
+)
+
+// maxPlaylistTracks caps how many tracks a single playlist or album link queues
+const maxPlaylistTracks = 500
+
+// queuePlaylist queues the first page of a playlist and starts playback right
+// away. Remaining pages are loaded in the background so large playlists don't
+// hold up the command.
+func (b *Bot) queuePlaylist(guildID string, info *audio.ProviderInfo, playlists audio.PlaylistProvider, link string, by requester) (string, error) {
+	playlist, err := playlists.OpenPlaylist(link, maxPlaylistTracks)
+	if err != nil {
+		return "", fmt.Errorf("failed to load %s playlist: %w", info.Name, err)
+	}
+	if len(playlist.Tracks) == 0 && !playlist.More() {
+		return fmt.Sprintf("No playable tracks found in %s", playlist.Title), nil
+	}
+
+	b.mu.Lock()
+	player := b.getPlayer(guildID)
+	room := b.queueRoom(guildID, player)
+	if room == 0 {
+		b.mu.Unlock()
+		return "", b.errQueueFull(guildID)
+	}
+	// Only a playlist with more tracks than fit is cut short
+	tracks := playlist.Tracks
+	full := room > 0 && len(tracks) >= room && (len(tracks) > room || playlist.More())
+	if full {
+		tracks = tracks[:room]
+	}
+	queued, limited := b.addPlaylistTracks(guildID, player, tracks, info.Prefix, by)
+	if queued == 0 && limited != nil {
+		b.mu.Unlock()
+		return "", limited
+	}
+	wasPlaying := player.isPlaying
+	queueGen := player.queueGen
+	b.mu.Unlock()
+
+	if !wasPlaying {
+		go b.startPlaying(guildID)
+	}
+
+	response := fmt.Sprintf("✅ **Queued %d tracks from %s**", queued, playlist.Title)
+	if limited != nil {
+		response += fmt.Sprintf("\n⚠️ **Note:** The rest of the playlist was not queued: %s", limited)
+		return response, nil
+	}
+	if full {
+		response += fmt.Sprintf("\n⚠️ **Note:** The queue is limited to %d upcoming tracks, the rest of the playlist was not queued.", b.settings.MaxQueueLength(guildID))
+		return response, nil
+	}
+	if playlist.More() {
+		response += fmt.Sprintf("\n⏳ Loading up to %d more in the background", playlist.Limit()-playlist.Loaded())
+		go b.loadPlaylistPages(guildID, player, queueGen, playlist, info.Prefix, by)
+	}
+	if playlist.Total > maxPlaylistTracks {
+		response += fmt.Sprintf("\n⚠️ **Note:** The playlist has %d tracks, only the first %d are queued.", playlist.Total, maxPlaylistTracks)
+	}
+
+	return response, nil
+}
+
+// loadPlaylistPages queues the remaining pages of a playlist. It gives up when
+// the queue is cleared or the player torn down while it is loading.
+func (b *Bot) loadPlaylistPages(guildID string, player *Player, queueGen int, playlist *audio.Playlist, platform string, by requester) {
+	for {
+		results, err := playlist.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Stopped loading playlist %s for guild %s after %d tracks: %v", playlist.Title, guildID, playlist.Loaded(), err)
+			return
+		}
+
+		b.mu.Lock()
+		if b.players[guildID] != player || player.queueGen != queueGen {
+			b.mu.Unlock()
+			log.Printf("Queue for guild %s was cleared, stopped loading playlist %s", guildID, playlist.Title)
+			return
+		}
+		room := b.queueRoom(guildID, player)
+		full := room >= 0 && len(results) >= room && (len(results) > room || playlist.More())
+		if full {
+			results = results[:room]
+		}
+		_, limited := b.addPlaylistTracks(guildID, player, results, platform, by)
+		b.mu.Unlock()
+
+		if limited != nil {
+			log.Printf("Stopped loading playlist %s for guild %s at the requester's limit: %v", playlist.Title, guildID, limited)
+			return
+		}
+		if full {
+			log.Printf("Queue for guild %s is full, stopped loading playlist %s", guildID, playlist.Title)
+			return
+		}
+	}
+
+	log.Printf("Finished loading playlist %s for guild %s (%d tracks)", playlist.Title, guildID, playlist.Loaded())
+}
+
+// addPlaylistTracks queues playlist tracks until the requester reaches their
+// limits. It returns how many were queued, and the limit that stopped it if
+// any. The caller must hold b.mu.
+func (b *Bot) addPlaylistTracks(guildID string, player *Player, results []audio.SearchResult, platform string, by requester) (int, error) {
+	for i, result := range results {
+		track := newTrack(result, platform, by)
+		if err := b.checkUserLimits(guildID, player, track); err != nil {
+			return i, err
+		}
+		player.queue.Add(track)
+	}
+	return len(results), nil
+}
@@ -0,0 +1,229 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/doomhound188/soulhound/internal/audio"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+const (
+	// podcastListLimit is how many of a feed's newest episodes !podcast lists
+	podcastListLimit = 10
+
+	// podcastSaveInterval is how often the listening position of a playing
+	// episode is remembered
+	podcastSaveInterval = 5 * time.Second
+
+	// podcastFinishedMargin is how close to its end an episode counts as
+	// finished, so outros and ads don't leave it half-listened
+	podcastFinishedMargin = 30 * time.Second
+)
+
+// handlePodcast lists a podcast feed's episodes or queues one of them:
+// !podcast <feed> [list|latest|<number>]
+func (b *Bot) handlePodcast(args []string, channelID, guildID, userID string) (string, error) {
+	if len(args) == 0 {
+		return "", errors.New("please provide a podcast feed link, e.g. !podcast <feed> list")
+	}
+	podcasts, ok := b.podcasts()
+	if !ok {
+		return "", errors.New("podcasts are not available")
+	}
+
+	podcast, err := podcasts.Feed(args[0])
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 1 || strings.EqualFold(args[1], "list") {
+		return b.podcastList(guildID, podcast), nil
+	}
+
+	if channelID == "" {
+		return "", errors.New("you must be in a voice channel to play podcasts - use !debug to troubleshoot")
+	}
+	if guildID == "" {
+		return "", errors.New("no guild available to join voice channel - this command must be used in a server")
+	}
+	if _, err := b.joinVoiceChannel(guildID, channelID); err != nil {
+		return "", fmt.Errorf("failed to join voice channel: %w", err)
+	}
+	return b.queueEpisode(guildID, podcast, args[1], b.requesterFor(guildID, userID))
+}
+
+// queueEpisode queues an episode by number or "latest", mentioning where it
+// resumes if it was listened to before
+func (b *Bot) queueEpisode(guildID string, podcast *audio.Podcast, which string, by requester) (string, error) {
+	episode, _, err := podcast.Episode(which)
+	if err != nil {
+		return "", err
+	}
+
+	response, err := b.queueTrack(guildID, newTrack(episode.SearchResult(podcast), podcastPrefix, by))
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	position := b.podcastPositions[guildID][podcast.EpisodeID(episode)]
+	b.mu.Unlock()
+	if position > 0 {
+		response += fmt.Sprintf("\n⏯ **Resumes at** %s", formatDuration(position))
+	}
+	return response, nil
+}
+
+// podcastList shows a feed's newest episodes, numbered for !podcast <feed> <number>
+func (b *Bot) podcastList(guildID string, podcast *audio.Podcast) string {
+	b.mu.Lock()
+	positions := b.podcastPositions[guildID]
+	var list strings.Builder
+	fmt.Fprintf(&list, "🎙️ **%s**", truncate(podcast.Title, 80))
+	if podcast.Author != "" {
+		fmt.Fprintf(&list, " by %s", truncate(podcast.Author, 60))
+	}
+	list.WriteString("\n\n")
+
+	for i := range podcast.Episodes[:min(len(podcast.Episodes), podcastListLimit)] {
+		episode := &podcast.Episodes[i]
+		fmt.Fprintf(&list, "`%d.` **%s**", i+1, truncate(episode.Title, 80))
+
+		var details []string
+		if !episode.Published.IsZero() {
+			details = append(details, episode.Published.Format("2006-01-02"))
+		}
+		if episode.Duration > 0 {
+			details = append(details, formatPosition(episode.Duration))
+		}
+		if len(details) > 0 {
+			fmt.Fprintf(&list, " (%s)", strings.Join(details, ", "))
+		}
+		if position := positions[podcast.EpisodeID(episode)]; position > 0 {
+			fmt.Fprintf(&list, " ⏯ %s", formatDuration(position))
+		}
+		list.WriteString("\n")
+	}
+	b.mu.Unlock()
+
+	if more := len(podcast.Episodes) - podcastListLimit; more > 0 {
+		fmt.Fprintf(&list, "…and %d older episodes\n", more)
+	}
+	list.WriteString("\nUse `!podcast <feed> <number>` or `!podcast <feed> latest` to play an episode.")
+	return list.String()
+}
+
+// resumeEpisode returns where a podcast episode was left off in a guild, or
+// 0 if it wasn't. The caller must hold b.mu.
+func (b *Bot) resumeEpisode(guildID string, track queue.Track) time.Duration {
+	if track.Platform != podcastPrefix {
+		return 0
+	}
+	return time.Duration(b.podcastPositions[guildID][track.URL]) * time.Second
+}
+
+// rememberEpisode records how far into a podcast episode a guild got. Near
+// its end the episode counts as finished and is forgotten, so it plays from
+// the start next time. The caller must hold b.mu.
+func (b *Bot) rememberEpisode(guildID string, track queue.Track, position time.Duration) {
+	if track.Platform != podcastPrefix {
+		return
+	}
+
+	positions := b.podcastPositions[guildID]
+	length := time.Duration(track.Duration) * time.Second
+	if position <= 0 || (length > 0 && position >= length-podcastFinishedMargin) {
+		if _, exists := positions[track.URL]; exists {
+			delete(positions, track.URL)
+			b.podcastsChanged[guildID] = true
+		}
+		return
+	}
+
+	seconds := int(position.Seconds())
+	if positions == nil {
+		positions = make(map[string]int)
+		b.podcastPositions[guildID] = positions
+	}
+	if positions[track.URL] != seconds {
+		positions[track.URL] = seconds
+		b.podcastsChanged[guildID] = true
+	}
+}
+
+// watchEpisode remembers the listening position of a podcast episode while it
+// plays, and where it stopped once the returned stop is called. Other tracks
+// are left alone.
+func (b *Bot) watchEpisode(guildID string, player *Player, track queue.Track) (stop func()) {
+	if track.Platform != podcastPrefix {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(podcastSaveInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				b.mu.Lock()
+				b.recordEpisode(guildID, player, track)
+				b.mu.Unlock()
+			case <-done:
+				return
+			case <-b.done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		b.mu.Lock()
+		b.recordEpisode(guildID, player, track)
+		b.mu.Unlock()
+	}
+}
+
+// recordEpisode remembers how far into track the player is, if it is still
+// playing it. A stopped player no longer knows where it was, so the last
+// recorded position is kept. The caller must hold b.mu.
+func (b *Bot) recordEpisode(guildID string, player *Player, track queue.Track) {
+	if b.players[guildID] != player || !player.isCurrent(track) || !player.isPlaying {
+		return
+	}
+	b.rememberEpisode(guildID, track, b.playbackPosition(guildID, player))
+}
+
+// savePodcastPositions saves the podcast positions of guilds that changed,
+// including where episodes playing now have got to
+func (b *Bot) savePodcastPositions() {
+	b.mu.Lock()
+	for guildID, player := range b.players {
+		if player.current != nil {
+			b.recordEpisode(guildID, player, *player.current)
+		}
+	}
+	changed := make(map[string]map[string]int, len(b.podcastsChanged))
+	for guildID := range b.podcastsChanged {
+		positions := make(map[string]int, len(b.podcastPositions[guildID]))
+		for link, position := range b.podcastPositions[guildID] {
+			positions[link] = position
+		}
+		changed[guildID] = positions
+		delete(b.podcastsChanged, guildID)
+	}
+	b.mu.Unlock()
+
+	for guildID, positions := range changed {
+		if err := b.store.SavePodcastPositions(guildID, positions); err != nil {
+			log.Printf("Failed to save podcast positions for guild %s: %v", guildID, err)
+			b.mu.Lock()
+			b.podcastsChanged[guildID] = true
+			b.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/audio"
+	"github.com/doomhound188/soulhound/internal/config"
+)
+
+// Prefixes of the built-in providers
+const (
+	youtubePrefix = "yt"
+	spotifyPrefix = "sp"
+	localPrefix   = "local"
+	streamPrefix  = "url"
+	podcastPrefix = "podcast"
+)
+
+// libraryScanInterval is how often the local music library is rescanned for
+// added, changed and removed files
+const libraryScanInterval = 30 * time.Second
+
+// newProviderRegistry registers the built-in providers. Adding a provider here
+// makes it available to !play <prefix>:query, !setdefault and !help.
+func newProviderRegistry(cfg *config.Config) (*audio.Registry, error) {
+	youtube := audio.NewYouTubeProvider(cfg.YouTubeToken)
+	if cfg.YTDLPPath != "" {
+		youtube.SetYTDLP(cfg.YTDLPPath)
+	}
+	if len(cfg.YouTubeResolvers) > 0 {
+		if err := youtube.SetResolverOrder(cfg.YouTubeResolvers); err != nil {
+			return nil, err
+		}
+	}
+
+	// Spotify doesn't serve audio, its tracks play through a YouTube match
+	spotify := audio.NewSpotifyProvider(cfg.SpotifyToken)
+	spotify.SetCredentials(cfg.SpotifyClientID, cfg.SpotifyClientSecret)
+	spotify.SetMirror(youtube)
+
+	registry := audio.NewRegistry()
+	providers := []audio.ProviderInfo{
+		{
+			Prefix:       youtubePrefix,
+			Name:         "YouTube",
+			Provider:     youtube,
+			Capabilities: audio.CapSearch | audio.CapStream | audio.CapRecommendations | audio.CapPlaylists,
+		},
+		{
+			Prefix:       spotifyPrefix,
+			Name:         "Spotify",
+			Provider:     spotify,
+			Capabilities: audio.CapSearch | audio.CapStream | audio.CapRecommendations | audio.CapPlaylists,
+		},
+		{
+			// Web streams are played from links rather than searched
+			Prefix:       streamPrefix,
+			Name:         "Web stream",
+			Provider:     audio.NewStreamProvider(),
+			Capabilities: audio.CapStream,
+		},
+		{
+			// Podcast episodes are picked from their feed with !podcast
+			Prefix:       podcastPrefix,
+			Name:         "Podcasts",
+			Provider:     audio.NewPodcastProvider(),
+			Capabilities: audio.CapStream,
+		},
+	}
+
+	if cfg.MusicDir != "" {
+		// Indexed in the background once the bot starts
+		providers = append(providers, audio.ProviderInfo{
+			Prefix:       localPrefix,
+			Name:         "Local library",
+			Provider:     audio.NewLocalProvider(cfg.MusicDir),
+			Capabilities: audio.CapSearch | audio.CapStream | audio.CapRecommendations,
+		})
+	}
+
+	for _, info := range providers {
+		if err := registry.Register(info); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// youtube returns the registered YouTube provider, used for yt-dlp status checks
+func (b *Bot) youtube() (*audio.YouTubeProvider, bool) {
+	info, exists := b.providers.Get(youtubePrefix)
+	if !exists {
+		return nil, false
+	}
+	youtube, ok := info.Provider.(*audio.YouTubeProvider)
+	return youtube, ok
+}
+
+// localLibrary returns the local music library provider, if one is configured
+func (b *Bot) localLibrary() (*audio.LocalProvider, bool) {
+	info, exists := b.providers.Get(localPrefix)
+	if !exists {
+		return nil, false
+	}
+	library, ok := info.Provider.(*audio.LocalProvider)
+	return library, ok
+}
+
+// indexLibrary indexes the local music library, then keeps rescanning it
+// until shutdown. Indexing a large library takes a while, so it runs in the
+// background and a failure is only logged; the rescans try again.
+func (b *Bot) indexLibrary(library *audio.LocalProvider) {
+	if _, err := library.Scan(); err != nil {
+		log.Printf("Failed to index the music library: %v", err)
+	} else {
+		log.Printf("Indexed %d tracks in the music library %s", library.Len(), library.Dir())
+	}
+	library.Watch(b.done, libraryScanInterval)
+}
+
+// webStreams returns the web stream provider, which plays raw links
+func (b *Bot) webStreams() (*audio.StreamProvider, bool) {
+	info, exists := b.providers.Get(streamPrefix)
+	if !exists {
+		return nil, false
+	}
+	streams, ok := info.Provider.(*audio.StreamProvider)
+	return streams, ok
+}
+
+// podcasts returns the podcast provider, which reads episodes from feeds
+func (b *Bot) podcasts() (*audio.PodcastProvider, bool) {
+	info, exists := b.providers.Get(podcastPrefix)
+	if !exists {
+		return nil, false
+	}
+	podcasts, ok := info.Provider.(*audio.PodcastProvider)
+	return podcasts, ok
+}
+
+// ytdlpAvailable reports whether the YouTube provider can use yt-dlp
+func (b *Bot) ytdlpAvailable() bool {
+	youtube, ok := b.youtube()
+	return ok && youtube.YTDLP().Available()
+}
+
+// searchProvider resolves the provider for a query, honouring an optional
+// "<prefix>:" and falling back to the guild's default platform.
+func (b *Bot) searchProvider(guildID, query string) (*audio.ProviderInfo, string, error) {
+	info, query, err := b.providers.Resolve(query, b.settings.DefaultPlatform(guildID))
+	if err != nil {
+		return nil, "", err
+	}
+	if !info.Can(audio.CapSearch) {
+		return nil, "", fmt.Errorf("%s does not support searching", info.Name)
+	}
+	return info, query, nil
+}
+
+// playlistProvider finds a provider that can expand link as a playlist or album
+func (b *Bot) playlistProvider(link string) (*audio.ProviderInfo, audio.PlaylistProvider, bool) {
+	for _, info := range b.providers.List() {
+		if !info.Can(audio.CapPlaylists) {
+			continue
+		}
+		if playlists, ok := info.Provider.(audio.PlaylistProvider); ok && playlists.IsPlaylistURL(link) {
+			return info, playlists, true
+		}
+	}
+	return nil, nil, false
+}
+
+// platformList describes the searchable platforms for help text, e.g. "yt (YouTube), sp (Spotify)"
+func (b *Bot) platformList() string {
+	var platforms []string
+	for _, info := range b.providers.List() {
+		if info.Can(audio.CapSearch) {
+			platforms = append(platforms, fmt.Sprintf("%s (%s)", info.Prefix, info.Name))
+		}
+	}
+	return strings.Join(platforms, ", ")
+}
+
+// platformChoices lists the searchable platforms as slash command choices
+func (b *Bot) platformChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, info := range b.providers.List() {
+		if info.Can(audio.CapSearch) {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: info.Name, Value: info.Prefix})
+		}
+	}
+	return choices
+}
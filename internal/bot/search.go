@@ -0,0 +1,252 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/audio"
+)
+
+const (
+	// searchResultsTTL is how long a user can pick from their last search
+	searchResultsTTL = 5 * time.Minute
+
+	maxSearchResults = 5
+
+	// searchMenuPrefix marks the custom IDs of search result menus. The ID of
+	// the user who searched and the number of the search follow it, as in
+	// pick:<user>:<search>.
+	searchMenuPrefix = "pick:"
+
+	// Discord's limits for select menu options
+	maxOptionLabel       = 100
+	maxOptionDescription = 100
+)
+
+// searchResults are the results of a user's last search in a guild
+type searchResults struct {
+	id       int // Numbers the search among all the bot offered
+	platform string
+	results  []audio.SearchResult
+	created  time.Time
+}
+
+func (r *searchResults) expired(now time.Time) bool {
+	return now.Sub(r.created) > searchResultsTTL
+}
+
+// isChooseFlag reports whether a !play argument asks to pick from the search
+// results instead of queueing the first one
+func isChooseFlag(arg string) bool {
+	switch strings.ToLower(arg) {
+	case "--choose", "-c":
+		return true
+	}
+	return false
+}
+
+// offersResults reports whether a command run with args answers with search
+// results to pick from
+func offersResults(command string, args []string) bool {
+	switch strings.ToLower(command) {
+	case "search":
+		return true
+	case "play":
+		return len(args) > 0 && isChooseFlag(args[0])
+	}
+	return false
+}
+
+func searchKey(guildID, userID string) string {
+	return guildID + ":" + userID
+}
+
+// offerResults remembers the top results of a user's search so they can pick
+// one, and lists them. Results from earlier searches that expired are dropped.
+func (b *Bot) offerResults(guildID, userID, platform string, results []audio.SearchResult) string {
+	results = results[:min(len(results), maxSearchResults)]
+	now := time.Now()
+
+	b.mu.Lock()
+	for key, search := range b.searches {
+		if search.expired(now) {
+			delete(b.searches, key)
+		}
+	}
+	b.searchCount++
+	b.searches[searchKey(guildID, userID)] = &searchResults{id: b.searchCount, platform: platform, results: results, created: now}
+	b.mu.Unlock()
+
+	var sb strings.Builder
+	sb.WriteString("Search results:\n")
+	for i, result := range results {
+		sb.WriteString(fmt.Sprintf("%d. %s - %s\n", i+1, result.Title, result.Artist))
+	}
+	sb.WriteString(fmt.Sprintf("\nQueue one with `%spick <number>`", b.settings.Prefix(guildID)))
+	return sb.String()
+}
+
+// recentResults returns a user's search results that haven't expired
+func (b *Bot) recentResults(guildID, userID string) (*searchResults, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := searchKey(guildID, userID)
+	search, exists := b.searches[key]
+	if !exists {
+		return nil, false
+	}
+	if search.expired(time.Now()) {
+		delete(b.searches, key)
+		return nil, false
+	}
+	return search, true
+}
+
+// handlePick queues one of the results of the user's last search
+func (b *Bot) handlePick(args []string, channelID, guildID, userID string) (string, error) {
+	prefix := b.settings.Prefix(guildID)
+	if len(args) != 1 {
+		return "", fmt.Errorf("please specify the number of a search result, e.g. %spick 2", prefix)
+	}
+
+	search, exists := b.recentResults(guildID, userID)
+	if !exists {
+		return "", fmt.Errorf("you have no recent search results, search with %ssearch <query> or %splay --choose <query> first", prefix, prefix)
+	}
+	number, err := strconv.Atoi(args[0])
+	if err != nil || number < 1 || number > len(search.results) {
+		return "", fmt.Errorf("please pick a number from 1 to %d", len(search.results))
+	}
+
+	if guildID == "" || channelID == "" {
+		return "", errors.New("you must be in a voice channel to play music - use !debug to troubleshoot")
+	}
+	if _, err := b.joinVoiceChannel(guildID, channelID); err != nil {
+		return "", fmt.Errorf("failed to join voice channel: %w", err)
+	}
+
+	track := newTrack(search.results[number-1], search.platform, b.requesterFor(guildID, userID))
+	return b.queueTrack(guildID, track)
+}
+
+// searchMenu builds a menu to pick from the results a user got since the
+// given time, or nil if they got none
+func (b *Bot) searchMenu(guildID, userID string, since time.Time) []discordgo.MessageComponent {
+	search, exists := b.recentResults(guildID, userID)
+	if !exists || search.created.Before(since) {
+		return nil
+	}
+
+	options := make([]discordgo.SelectMenuOption, 0, len(search.results))
+	for i, result := range search.results {
+		options = append(options, discordgo.SelectMenuOption{
+			Label:       truncate(fmt.Sprintf("%d. %s", i+1, result.Title), maxOptionLabel),
+			Description: truncate(result.Artist, maxOptionDescription),
+			Value:       strconv.Itoa(i + 1),
+		})
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    fmt.Sprintf("%s%s:%d", searchMenuPrefix, userID, search.id),
+				Placeholder: "Pick a track to queue",
+				Options:     options,
+			},
+		}},
+	}
+}
+
+// parseSearchMenuID returns the user and the search a search result menu's
+// custom ID names
+func parseSearchMenuID(customID string) (userID string, searchID int, ok bool) {
+	userID, number, found := strings.Cut(strings.TrimPrefix(customID, searchMenuPrefix), ":")
+	if !found {
+		return "", 0, false
+	}
+	searchID, err := strconv.Atoi(number)
+	if err != nil {
+		return "", 0, false
+	}
+	return userID, searchID, true
+}
+
+// handleSearchMenu queues the result picked from a search result menu. Only
+// the user who searched can pick, only from the menu of their latest search,
+// and the menu is replaced with the outcome.
+func (b *Bot) handleSearchMenu(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User) {
+	data := i.MessageComponentData()
+	private := func(content string) {
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Content: content, Flags: discordgo.MessageFlagsEphemeral},
+		})
+		if err != nil {
+			log.Printf("Failed to respond to search menu: %v", err)
+		}
+	}
+
+	if i.GuildID == "" {
+		private("Error: This command can only be used in a server")
+		return
+	}
+	userID, searchID, ok := parseSearchMenuID(data.CustomID)
+	if ok && userID != user.ID {
+		private("These are someone else's search results, search yourself to pick a track")
+		return
+	}
+	if search, exists := b.recentResults(i.GuildID, user.ID); !ok || !exists || search.id != searchID {
+		private("These search results are out of date, pick from your latest search or search again")
+		return
+	}
+	if len(data.Values) != 1 {
+		private("Pick one track from the menu")
+		return
+	}
+
+	// Acknowledge right away, joining voice and queueing can take longer
+	// than the three seconds Discord allows
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredMessageUpdate,
+	})
+	if err != nil {
+		log.Printf("Failed to acknowledge search menu: %v", err)
+		return
+	}
+	followUp := func(content string) {
+		_, err := s.FollowupMessageCreate(i.Interaction, true, &discordgo.WebhookParams{Content: content, Flags: discordgo.MessageFlagsEphemeral})
+		if err != nil {
+			log.Printf("Failed to respond to search menu: %v", err)
+		}
+	}
+
+	voiceState := b.findUserVoiceState(s, i.GuildID, user.ID, user.Username)
+	if voiceState == nil {
+		followUp(voiceChannelRequiredMessage)
+		return
+	}
+
+	b.setTextChannel(i.GuildID, i.ChannelID)
+	response, err := b.HandleCommand("pick", data.Values, voiceState.ChannelID, i.GuildID, user.ID)
+	if err != nil {
+		followUp(fmt.Sprintf("Error: %s", err))
+		return
+	}
+	_, err = s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &response, Components: &[]discordgo.MessageComponent{}})
+	if err != nil {
+		log.Printf("Failed to update search menu: %v", err)
+	}
+}
+
+// truncate shortens s to at most n runes
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return s
+}
@@ -0,0 +1,86 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSeekStep is how far !forward and !rewind move without an argument
+const defaultSeekStep = "10"
+
+// handleSeek moves to a position in the current track, given as a timestamp
+// such as 1:23. With a direction, forward (1) and rewind (-1) move that far
+// from the current position instead.
+func (b *Bot) handleSeek(args []string, guildID string, direction int) (string, error) {
+	if direction != 0 && len(args) == 0 {
+		args = []string{defaultSeekStep}
+	}
+	if len(args) != 1 {
+		return "", errors.New("please specify a position, e.g. 1:23")
+	}
+
+	offset, err := parseTimestamp(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists || !player.isPlaying || player.current == nil {
+		return "", errors.New("nothing is playing")
+	}
+	if player.current.Live {
+		return "", errors.New("live streams can't be seeked")
+	}
+
+	position := offset
+	if direction != 0 {
+		position = b.playbackPosition(guildID, player) + time.Duration(direction)*offset
+	}
+	position = max(position, 0)
+
+	length := time.Duration(player.current.Duration) * time.Second
+	if length > 0 && position >= length {
+		return "", fmt.Errorf("%s is past the end of the track (%s)", formatPosition(position), formatPosition(length))
+	}
+
+	if !b.restartAt(guildID, player, position) {
+		return "", errors.New("the current track can't be seeked yet, try again in a moment")
+	}
+	return fmt.Sprintf("⏩ Moved to %s", positionOf(position, player.current.Duration)), nil
+}
+
+// parseTimestamp parses a position such as 83, 1:23 or 1:02:03
+func parseTimestamp(value string) (time.Duration, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid position %q, use e.g. 1:23 or 83", value)
+	}
+
+	seconds := 0
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || (i > 0 && (len(part) != 2 || n >= 60)) {
+			return 0, fmt.Errorf("invalid position %q, use e.g. 1:23 or 83", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+func formatPosition(d time.Duration) string {
+	return formatDuration(int(d.Seconds()))
+}
+
+// positionOf shows a position in a track, e.g. 1:23 / 3:20
+func positionOf(position time.Duration, length int) string {
+	if length <= 0 {
+		return formatPosition(position)
+	}
+	return fmt.Sprintf("%s / %s", formatPosition(position), formatDuration(length))
+}
@@ -0,0 +1,470 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/audio"
+	"github.com/doomhound188/soulhound/internal/config"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+// settingField is a guild setting that !settings can show and change
+type settingField struct {
+	name        string
+	description string
+	show        func(b *Bot, guildID string, s config.GuildSettings) string
+	set         func(b *Bot, guildID string, s *config.GuildSettings, value string) error
+	apply       func(b *Bot, guildID string) // Optional, puts a changed setting into effect
+}
+
+// settingFields lists the settings in the order !settings shows them
+var settingFields = []settingField{
+	{
+		name:        "platform",
+		description: "Platform searched when a query has no prefix",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if info, exists := b.providers.Get(s.DefaultPlatform); exists {
+				return fmt.Sprintf("%s (%s)", info.Prefix, info.Name)
+			}
+			return s.DefaultPlatform
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			info, exists := b.providers.Get(value)
+			if !exists || !info.Can(audio.CapSearch) {
+				return fmt.Errorf("unknown platform %q, available: %s", value, b.platformList())
+			}
+			s.DefaultPlatform = info.Prefix
+			return nil
+		},
+	},
+	{
+		name:        "smartplay",
+		description: "Queue recommendations after each track",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			return onOff(s.SmartPlay)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			enabled, err := parseToggle(value)
+			s.SmartPlay = enabled
+			return err
+		},
+	},
+	{
+		name:        "volume",
+		description: fmt.Sprintf("Playback volume, 0-%d%%", config.MaxVolume),
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			return fmt.Sprintf("%d%%", s.Volume)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			volume, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil {
+				return fmt.Errorf("invalid volume %q, use a number from 0 to %d", value, config.MaxVolume)
+			}
+			s.Volume = volume
+			return nil
+		},
+		apply: func(b *Bot, guildID string) {
+			b.applyStreamSettings(guildID)
+		},
+	},
+	{
+		name:        "prefix",
+		description: "Prefix for message commands",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			return "`" + s.Prefix + "`"
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			s.Prefix = value
+			return nil
+		},
+	},
+	{
+		name:        "djrole",
+		description: "Role allowed to manage playback (none for everyone)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.DJRole == "" {
+				return "none"
+			}
+			return b.roleName(guildID, s.DJRole)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			if isNone(value) {
+				s.DJRole = ""
+				return nil
+			}
+			roleID, err := b.findRole(guildID, value)
+			s.DJRole = roleID
+			return err
+		},
+	},
+	{
+		name:        "maxqueue",
+		description: "Most tracks the queue may hold (off for no limit)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.MaxQueueLength == 0 {
+				return "no limit"
+			}
+			return strconv.Itoa(s.MaxQueueLength)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			if isNone(value) {
+				s.MaxQueueLength = 0
+				return nil
+			}
+			length, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid queue length %q, use a number or off", value)
+			}
+			s.MaxQueueLength = length
+			return nil
+		},
+	},
+	{
+		name:        "announce",
+		description: "Channel for now playing messages (none for the channel music was requested from)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.AnnounceChannel == "" {
+				return "command channel"
+			}
+			return "<#" + s.AnnounceChannel + ">"
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			if isNone(value) {
+				s.AnnounceChannel = ""
+				return nil
+			}
+			channelID := strings.TrimSuffix(strings.TrimPrefix(value, "<#"), ">")
+			if !isSnowflake(channelID) {
+				return fmt.Errorf("invalid channel %q, mention it with #", value)
+			}
+			s.AnnounceChannel = channelID
+			return nil
+		},
+	},
+	{
+		name:        "idle",
+		description: "Leave voice after this long with nothing playing or nobody listening, e.g. 5m (off to stay)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.IdleTimeout == 0 {
+				return "off"
+			}
+			return formatTimeout(s.IdleTimeout)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			timeout, err := parseTimeout(value)
+			s.IdleTimeout = timeout
+			return err
+		},
+	},
+	{
+		name:        "voteskip",
+		description: "Percent of listeners who must vote to skip (off to skip right away)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.SkipVotes == 0 {
+				return "off"
+			}
+			return fmt.Sprintf("%d%%", s.SkipVotes)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			if isNone(value) {
+				s.SkipVotes = 0
+				return nil
+			}
+			percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+			if err != nil {
+				return fmt.Errorf("invalid percentage %q, use a number from 1 to 100 or off", value)
+			}
+			s.SkipVotes = percent
+			return nil
+		},
+	},
+	{
+		name:        "fairqueue",
+		description: "Take turns between requesters instead of playing tracks in the order they were added",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			return onOff(s.FairQueue)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			enabled, err := parseToggle(value)
+			s.FairQueue = enabled
+			return err
+		},
+		apply: func(b *Bot, guildID string) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if player, exists := b.players[guildID]; exists {
+				player.queue.SetFair(b.settings.FairQueue(guildID))
+			}
+		},
+	},
+	{
+		name:        "usertracks",
+		description: "Most upcoming tracks one person may queue (off for no limit)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.MaxUserTracks == 0 {
+				return "no limit"
+			}
+			return strconv.Itoa(s.MaxUserTracks)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			if isNone(value) {
+				s.MaxUserTracks = 0
+				return nil
+			}
+			tracks, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid number of tracks %q, use a number or off", value)
+			}
+			s.MaxUserTracks = tracks
+			return nil
+		},
+	},
+	{
+		name:        "usertime",
+		description: "Most upcoming music one person may queue, e.g. 30m (off for no limit)",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			if s.MaxUserDuration == 0 {
+				return "no limit"
+			}
+			return formatTimeout(s.MaxUserDuration)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			duration, err := parseTimeout(value)
+			s.MaxUserDuration = duration
+			return err
+		},
+	},
+	{
+		name:        "autopause",
+		description: "Pause while nobody is in the voice channel and resume when someone joins",
+		show: func(b *Bot, guildID string, s config.GuildSettings) string {
+			return onOff(!s.KeepPlayingAlone)
+		},
+		set: func(b *Bot, guildID string, s *config.GuildSettings, value string) error {
+			enabled, err := parseToggle(value)
+			s.KeepPlayingAlone = !enabled
+			return err
+		},
+		apply: func(b *Bot, guildID string) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			b.updateAutoPause(guildID)
+		},
+	},
+}
+
+// findSettingField looks up a setting by name
+func findSettingField(name string) (settingField, bool) {
+	for _, field := range settingFields {
+		if strings.EqualFold(field.name, name) {
+			return field, true
+		}
+	}
+	return settingField{}, false
+}
+
+// settingNames lists the setting names for error messages
+func settingNames() string {
+	names := make([]string, 0, len(settingFields))
+	for _, field := range settingFields {
+		names = append(names, field.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// handleSettings shows the guild's settings, shows one setting, changes one
+// setting, or resets them all
+func (b *Bot) handleSettings(args []string, guildID string) (string, error) {
+	if guildID == "" {
+		return "", errors.New("settings can only be changed in a server")
+	}
+
+	settings := b.settings.Get(guildID)
+	if len(args) == 0 {
+		var sb strings.Builder
+		sb.WriteString("**⚙️ Server Settings:**\n")
+		for _, field := range settingFields {
+			sb.WriteString(fmt.Sprintf("• **%s**: %s - %s\n", field.name, field.show(b, guildID, settings), field.description))
+		}
+		sb.WriteString(fmt.Sprintf("\nChange one with `%ssettings <name> <value>`, restore the defaults with `%ssettings reset`", settings.Prefix, settings.Prefix))
+		return sb.String(), nil
+	}
+
+	if strings.EqualFold(args[0], "reset") {
+		if err := b.settings.Reset(guildID); err != nil {
+			return "", err
+		}
+		for _, field := range settingFields {
+			if field.apply != nil {
+				field.apply(b, guildID)
+			}
+		}
+		return "✅ Settings restored to the defaults", nil
+	}
+
+	field, exists := findSettingField(args[0])
+	if !exists {
+		return "", fmt.Errorf("unknown setting %q, available: %s", args[0], settingNames())
+	}
+	if len(args) == 1 {
+		return fmt.Sprintf("**%s**: %s - %s", field.name, field.show(b, guildID, settings), field.description), nil
+	}
+
+	value := strings.Join(args[1:], " ")
+	settings, err := b.settings.Update(guildID, func(s *config.GuildSettings) error {
+		return field.set(b, guildID, s, value)
+	})
+	if err != nil {
+		return "", err
+	}
+	if field.apply != nil {
+		field.apply(b, guildID)
+	}
+	return fmt.Sprintf("✅ **%s** set to %s", field.name, field.show(b, guildID, settings)), nil
+}
+
+// queueRoom returns how many more tracks fit in a guild's queue after the
+// current one, or -1 if its length isn't limited. The caller must hold b.mu.
+func (b *Bot) queueRoom(guildID string, player *Player) int {
+	limit := b.settings.MaxQueueLength(guildID)
+	if limit <= 0 {
+		return -1
+	}
+	return max(limit-player.queue.Upcoming(), 0)
+}
+
+func (b *Bot) errQueueFull(guildID string) error {
+	return fmt.Errorf("the queue is full, this server allows up to %d upcoming tracks", b.settings.MaxQueueLength(guildID))
+}
+
+// checkUserLimits returns an error if queueing track would take its requester
+// past the guild's limits on upcoming tracks and music per person. Tracks
+// added by the bot itself aren't limited. The caller must hold b.mu.
+func (b *Bot) checkUserLimits(guildID string, player *Player, track queue.Track) error {
+	if track.RequestedBy == "" {
+		return nil
+	}
+
+	tracks, seconds := player.queue.Queued(track.RequestedBy)
+	if limit := b.settings.MaxUserTracks(guildID); limit > 0 && tracks >= limit {
+		return fmt.Errorf("you already have %d upcoming tracks, the most this server allows per person. Try again once one of them has played", tracks)
+	}
+
+	limit := b.settings.MaxUserDuration(guildID)
+	if limit > 0 && track.Live {
+		return fmt.Errorf("%s is a live stream without an end, and this server allows %s of music per person", track.Title, formatTimeout(limit))
+	}
+	total := time.Duration(seconds+track.Duration) * time.Second
+	if limit > 0 && total > limit {
+		if tracks == 0 {
+			return fmt.Errorf("%s is %s long, longer than the %s of music this server allows per person", track.Title, formatPosition(total), formatTimeout(limit))
+		}
+		return fmt.Errorf("adding %s would give you %s of upcoming music, this server allows %s per person", track.Title, formatPosition(total), formatTimeout(limit))
+	}
+	return nil
+}
+
+// findRole resolves a role mention, ID or name to a role ID
+func (b *Bot) findRole(guildID, value string) (string, error) {
+	roleID := strings.TrimSuffix(strings.TrimPrefix(value, "<@&"), ">")
+	if isSnowflake(roleID) {
+		return roleID, nil
+	}
+
+	for _, role := range b.guildRoles(guildID) {
+		if strings.EqualFold(role.Name, value) {
+			return role.ID, nil
+		}
+	}
+	return "", fmt.Errorf("role %q not found", value)
+}
+
+// roleName returns a role's name, falling back to its ID. Names are shown
+// instead of mentions so that viewing settings doesn't ping the role.
+func (b *Bot) roleName(guildID, roleID string) string {
+	for _, role := range b.guildRoles(guildID) {
+		if role.ID == roleID {
+			return "@" + role.Name
+		}
+	}
+	return roleID
+}
+
+func (b *Bot) guildRoles(guildID string) []*discordgo.Role {
+	if b.session == nil || b.session.State == nil {
+		return nil
+	}
+	guild, err := b.session.State.Guild(guildID)
+	if err != nil {
+		return nil
+	}
+	return guild.Roles
+}
+
+// parseToggle parses on/off style values
+func parseToggle(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "enable", "enabled":
+		return true, nil
+	case "off", "false", "no", "disable", "disabled":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid value %q, use on or off", value)
+}
+
+// parseTimeout parses a duration such as 90s or 5m. Plain numbers are
+// minutes, and off or 0 turn the timeout off.
+func parseTimeout(value string) (time.Duration, error) {
+	if isNone(value) {
+		return 0, nil
+	}
+	if minutes, err := strconv.Atoi(value); err == nil {
+		return time.Duration(minutes) * time.Minute, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, use e.g. 5m, 90s or off", value)
+	}
+	return timeout, nil
+}
+
+// formatTimeout formats a duration without zero units, e.g. 5m instead of 5m0s
+func formatTimeout(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+func isNone(value string) bool {
+	switch strings.ToLower(value) {
+	case "off", "none", "0", "default":
+		return true
+	}
+	return false
+}
+
+// isSnowflake reports whether s looks like a Discord ID
+func isSnowflake(s string) bool {
+	if len(s) < 15 || len(s) > 20 {
+		return false
+	}
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,141 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+// handleSkip skips the current track, or with vote skipping on, records the
+// user's vote and skips once enough listeners agree. Whoever requested the
+// track skips it right away.
+func (b *Bot) handleSkip(guildID, userID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists {
+		return "", queue.ErrQueueEmpty
+	}
+
+	percent := b.settings.SkipVotes(guildID)
+	if percent == 0 || !player.isPlaying || player.current == nil || player.current.RequestedBy == userID {
+		return b.skip(guildID, player)
+	}
+
+	vc, connected := b.voiceConn[guildID]
+	if connected && userID != "" && !b.inChannel(guildID, userID, vc.channelID) {
+		return "", errors.New("only listeners in the bot's voice channel can vote to skip")
+	}
+
+	if player.skipVotes == nil {
+		player.skipVotes = make(map[string]bool)
+	}
+	alreadyVoted := player.skipVotes[userID]
+	player.skipVotes[userID] = true
+
+	votes, needed := b.skipVoteCount(guildID, player, percent)
+	if votes >= needed {
+		response, err := b.skip(guildID, player)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("🗳️ Vote passed (%d/%d). %s", votes, needed, response), nil
+	}
+
+	if alreadyVoted {
+		return fmt.Sprintf("🗳️ You already voted to skip (%d/%d needed)", votes, needed), nil
+	}
+	return fmt.Sprintf("🗳️ Vote to skip registered (%d/%d needed)", votes, needed), nil
+}
+
+// handleForceSkip skips the current track without a vote. Only the track's
+// requester and DJs may force a skip.
+func (b *Bot) handleForceSkip(guildID, userID string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists {
+		return "", queue.ErrQueueEmpty
+	}
+
+	requester := player.current != nil && player.current.RequestedBy == userID
+	if !requester && b.memberLevel(guildID, userID) < levelDJ {
+		return "", errors.New("only the track's requester or a DJ can force a skip, use !skip to vote")
+	}
+	return b.skip(guildID, player)
+}
+
+// skip moves to the next track. The caller must hold b.mu.
+func (b *Bot) skip(guildID string, player *Player) (string, error) {
+	player.skipVotes = nil
+
+	track, err := player.queue.Next()
+	if err == queue.ErrEndOfQueue {
+		b.playCurrent(guildID, player)
+		return "Skipped, that was the last track in the queue", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	b.playCurrent(guildID, player)
+	return fmt.Sprintf("Skipped to: %s - %s", track.Title, track.Artist), nil
+}
+
+// skipVoteCount returns the votes to skip from users still listening, and how
+// many are needed: percent of the listeners, rounded up, and at least one.
+// The caller must hold b.mu.
+func (b *Bot) skipVoteCount(guildID string, player *Player, percent int) (votes, needed int) {
+	vc, connected := b.voiceConn[guildID]
+	if !connected {
+		return len(player.skipVotes), votesNeeded(0, percent)
+	}
+
+	for userID := range player.skipVotes {
+		if b.inChannel(guildID, userID, vc.channelID) {
+			votes++
+		}
+	}
+	return votes, votesNeeded(b.listeners(guildID, vc.channelID), percent)
+}
+
+// votesNeeded returns how many of the listeners make up percent, rounded up,
+// and at least one
+func votesNeeded(listeners, percent int) int {
+	return max((listeners*percent+99)/100, 1)
+}
+
+// inChannel reports whether a user is in a voice channel. The caller must
+// hold b.mu.
+func (b *Bot) inChannel(guildID, userID, channelID string) bool {
+	info, exists := b.voiceStates[guildID+":"+userID]
+	return exists && info.VoiceState.ChannelID == channelID
+}
+
+// isDJ reports whether a user has the guild's DJ role. Without a DJ role,
+// everyone is a DJ. The caller must hold b.mu.
+func (b *Bot) isDJ(guildID, userID string) bool {
+	roleID := b.settings.DJRole(guildID)
+	if roleID == "" {
+		return true
+	}
+
+	var roles []string
+	if info, exists := b.voiceStates[guildID+":"+userID]; exists && info.VoiceState.Member != nil {
+		roles = info.VoiceState.Member.Roles
+	} else if b.session != nil && b.session.State != nil {
+		if member, err := b.session.State.Member(guildID, userID); err == nil {
+			roles = member.Roles
+		}
+	}
+
+	for _, role := range roles {
+		if role == roleID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,516 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/config"
+)
+
+// maxMessageLength is Discord's limit for message content
+const maxMessageLength = 2000
+
+// slashOptions indexes the options of an application command interaction by name
+type slashOptions map[string]*discordgo.ApplicationCommandInteractionDataOption
+
+// slashCommand pairs an application command definition with the conversion of its
+// typed options into the argument list HandleCommand expects.
+type slashCommand struct {
+	definition *discordgo.ApplicationCommand
+	args       func(opts slashOptions) []string
+}
+
+// platformOption is the name of options whose choices are filled from the provider registry
+const platformOption = "platform"
+
+// simpleSlashCommand defines a slash command without options
+func simpleSlashCommand(name, description string) slashCommand {
+	return slashCommand{
+		definition: &discordgo.ApplicationCommand{Name: name, Description: description},
+	}
+}
+
+// slashCommands mirrors every command handled by HandleCommand
+var slashCommands = []slashCommand{
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "play",
+			Description: "Play a song or add it to the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "query", Description: "What to search for", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: platformOption, Description: "Platform to search on"},
+				{Type: discordgo.ApplicationCommandOptionBoolean, Name: "choose", Description: "Pick from the top results instead of playing the first"},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			query := opts.str("query")
+			if platform := opts.str(platformOption); platform != "" {
+				query = platform + ":" + query
+			}
+			if opts.str("choose") == "on" {
+				return []string{"--choose", query}
+			}
+			return []string{query}
+		},
+	},
+	simpleSlashCommand("pause", "Pause current playback"),
+	simpleSlashCommand("resume", "Resume paused playback"),
+	simpleSlashCommand("stop", "Stop playback and clear the queue"),
+	simpleSlashCommand("skip", "Vote to skip the current track"),
+	simpleSlashCommand("forceskip", "Skip the current track without a vote"),
+	simpleSlashCommand("leave", "Leave the voice channel and clear the queue"),
+	simpleSlashCommand("queue", "Show the current queue"),
+	simpleSlashCommand("nowplaying", "Show the current track"),
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "seek",
+			Description: "Move to a position in the current track",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "position", Description: "Position such as 1:23, or seconds", Required: true},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("position")}
+		},
+	},
+	seekStepSlashCommand("forward", "Skip ahead in the current track"),
+	seekStepSlashCommand("rewind", "Go back in the current track"),
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "filter",
+			Description: "List or toggle audio filters",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "preset", Description: "Filter to turn on or off", Choices: filterChoices()},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			if preset := opts.str("preset"); preset != "" {
+				return []string{preset}
+			}
+			return []string{}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "volume",
+			Description: "Show or set the volume",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "level", Description: "Volume in percent, 100 is the original volume", MinValue: floatPtr(0), MaxValue: config.MaxVolume},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			if level := opts.str("level"); level != "" {
+				return []string{level}
+			}
+			return []string{}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "remove",
+			Description: "Remove a track from the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "position", Description: "Position in the queue", Required: true, MinValue: floatPtr(1)},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("position")}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "move",
+			Description: "Move a track to another position in the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "from", Description: "Position of the track to move", Required: true, MinValue: floatPtr(1)},
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "to", Description: "New position", Required: true, MinValue: floatPtr(1)},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("from"), opts.str("to")}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "jump",
+			Description: "Jump to a track in the queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "position", Description: "Position in the queue", Required: true, MinValue: floatPtr(1)},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("position")}
+		},
+	},
+	simpleSlashCommand("back", "Go back to the previous track"),
+	simpleSlashCommand("shuffle", "Shuffle the upcoming tracks"),
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "loop",
+			Description: "Set the loop mode, or cycle through the modes",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "mode",
+					Description: "Loop mode",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Off", Value: "off"},
+						{Name: "Track", Value: "track"},
+						{Name: "Queue", Value: "queue"},
+					},
+				},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			if mode := opts.str("mode"); mode != "" {
+				return []string{mode}
+			}
+			return []string{}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "search",
+			Description: "Search and pick a result to queue",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "query", Description: "What to search for", Required: true},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("query")}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "pick",
+			Description: "Queue one of the results of your last search",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "number", Description: "Number of the result", Required: true, MinValue: floatPtr(1), MaxValue: maxSearchResults},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("number")}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "podcast",
+			Description: "List a podcast's episodes or play one",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "feed", Description: "RSS or Atom feed link", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "episode", Description: "Episode number or latest, lists the episodes if left out"},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			args := []string{opts.str("feed")}
+			if episode := opts.str("episode"); episode != "" {
+				args = append(args, episode)
+			}
+			return args
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "setdefault",
+			Description: "Set this server's default platform",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: platformOption, Description: "Default platform", Required: true},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str(platformOption)}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "smartplay",
+			Description: "Toggle smart recommendations",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionBoolean, Name: "enabled", Description: "Queue recommendations automatically", Required: true},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			return []string{opts.str("enabled")}
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "settings",
+			Description: "View or change this server's settings",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "setting", Description: "Setting to view or change", Choices: settingChoices()},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "value", Description: "New value, e.g. 80 for volume or off"},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			args := []string{}
+			for _, name := range []string{"setting", "value"} {
+				if value := opts.str(name); value != "" {
+					args = append(args, value)
+				}
+			}
+			return args
+		},
+	},
+	{
+		definition: &discordgo.ApplicationCommand{
+			Name:        "permissions",
+			Description: "View or change who may run a command",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "command", Description: "Command to view or change, e.g. stop"},
+				{
+					Type: discordgo.ApplicationCommandOptionString, Name: "level", Description: "Who may run it",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "everyone", Value: config.LevelEveryone},
+						{Name: "dj", Value: config.LevelDJ},
+						{Name: "admin", Value: config.LevelAdmin},
+						{Name: "default", Value: "default"},
+					},
+				},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			args := []string{}
+			for _, name := range []string{"command", "level"} {
+				if value := opts.str(name); value != "" {
+					args = append(args, value)
+				}
+			}
+			return args
+		},
+	},
+	simpleSlashCommand("help", "Show all available commands"),
+	simpleSlashCommand("debug", "Show voice channel debug information"),
+	simpleSlashCommand("voicetest", "Test voice state detection"),
+	simpleSlashCommand("refreshvoice", "Force refresh voice state data"),
+	simpleSlashCommand("diagnose", "Comprehensive guild and channel diagnostic"),
+	simpleSlashCommand("undeafen", "Undeafen the bot in the voice channel"),
+	simpleSlashCommand("apitest", "Test Discord API connectivity"),
+	simpleSlashCommand("test", "Run a bot functionality test"),
+	simpleSlashCommand("voicemonitor", "Real-time voice state monitoring"),
+}
+
+// settingChoices offers every setting, plus resetting them all
+func settingChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(settingFields)+1)
+	for _, field := range settingFields {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: field.name, Value: field.name})
+	}
+	return append(choices, &discordgo.ApplicationCommandOptionChoice{Name: "reset (restore all defaults)", Value: "reset"})
+}
+
+// seekStepSlashCommand builds /forward and /rewind, which take an optional
+// number of seconds
+func seekStepSlashCommand(name, description string) slashCommand {
+	return slashCommand{
+		definition: &discordgo.ApplicationCommand{
+			Name:        name,
+			Description: description,
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "seconds", Description: "How far to move, 10 by default", MinValue: floatPtr(1)},
+			},
+		},
+		args: func(opts slashOptions) []string {
+			if seconds := opts.str("seconds"); seconds != "" {
+				return []string{seconds}
+			}
+			return []string{}
+		},
+	}
+}
+
+// filterChoices offers every filter preset, plus clearing them all
+func filterChoices() []*discordgo.ApplicationCommandOptionChoice {
+	choices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(filterPresets)+1)
+	for _, preset := range filterPresets {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: preset.name, Value: preset.name})
+	}
+	return append(choices, &discordgo.ApplicationCommandOptionChoice{Name: "clear (remove all filters)", Value: "clear"})
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// str returns an option's value in the textual form used by prefix commands.
+// Missing options return an empty string; booleans map to "on"/"off".
+func (opts slashOptions) str(name string) string {
+	opt, exists := opts[name]
+	if !exists {
+		return ""
+	}
+
+	switch opt.Type {
+	case discordgo.ApplicationCommandOptionString:
+		return opt.StringValue()
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(opt.IntValue(), 10)
+	case discordgo.ApplicationCommandOptionBoolean:
+		if opt.BoolValue() {
+			return "on"
+		}
+		return "off"
+	default:
+		return fmt.Sprint(opt.Value)
+	}
+}
+
+// slashCommandArgs converts interaction options into HandleCommand arguments
+func slashCommandArgs(name string, options []*discordgo.ApplicationCommandInteractionDataOption) ([]string, bool) {
+	opts := make(slashOptions, len(options))
+	for _, opt := range options {
+		opts[opt.Name] = opt
+	}
+
+	for _, cmd := range slashCommands {
+		if cmd.definition.Name != name {
+			continue
+		}
+		if cmd.args == nil {
+			return []string{}, true
+		}
+		return cmd.args(opts), true
+	}
+	return nil, false
+}
+
+// registerSlashCommands registers all slash commands, scoped to the configured
+// development guild if one is set and globally otherwise. Global commands can
+// take up to an hour to propagate, guild commands are available immediately.
+func (b *Bot) registerSlashCommands() error {
+	if b.session.State == nil || b.session.State.User == nil {
+		return fmt.Errorf("session is not ready")
+	}
+
+	choices := b.platformChoices()
+	definitions := make([]*discordgo.ApplicationCommand, 0, len(slashCommands))
+	for _, cmd := range slashCommands {
+		definitions = append(definitions, withPlatformChoices(cmd.definition, choices))
+	}
+
+	guildID := b.cfg.CommandGuild
+	registered, err := b.session.ApplicationCommandBulkOverwrite(b.session.State.User.ID, guildID, definitions)
+	if err != nil {
+		return err
+	}
+
+	if guildID != "" {
+		log.Printf("Registered %d slash commands in guild %s", len(registered), guildID)
+	} else {
+		log.Printf("Registered %d global slash commands", len(registered))
+	}
+	return nil
+}
+
+// withPlatformChoices returns a copy of definition whose platform options offer
+// the given choices. The shared definition is left untouched.
+func withPlatformChoices(definition *discordgo.ApplicationCommand, choices []*discordgo.ApplicationCommandOptionChoice) *discordgo.ApplicationCommand {
+	withChoices := *definition
+	withChoices.Options = make([]*discordgo.ApplicationCommandOption, len(definition.Options))
+	for i, opt := range definition.Options {
+		if opt.Name == platformOption {
+			option := *opt
+			option.Choices = choices
+			opt = &option
+		}
+		withChoices.Options[i] = opt
+	}
+	return &withChoices
+}
+
+func (b *Bot) interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var user *discordgo.User
+	if i.Member != nil {
+		user = i.Member.User
+	} else {
+		user = i.User
+	}
+	if user == nil {
+		return
+	}
+	rememberMember(s, i.GuildID, i.Member, user)
+
+	// Now playing buttons work whichever kind of commands is enabled
+	if i.Type == discordgo.InteractionMessageComponent {
+		if strings.HasPrefix(i.MessageComponentData().CustomID, searchMenuPrefix) {
+			b.handleSearchMenu(s, i, user)
+			return
+		}
+		b.handleButton(s, i, user)
+		return
+	}
+	if !b.cfg.SlashCommandsEnabled() || i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	args, known := slashCommandArgs(data.Name, data.Options)
+	if !known {
+		log.Printf("Received unknown slash command: %s", data.Name)
+		return
+	}
+
+	// Acknowledge right away, commands like play can take longer than
+	// the three seconds Discord allows for an initial response
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+	})
+	if err != nil {
+		log.Printf("Failed to acknowledge slash command %s: %v", data.Name, err)
+		return
+	}
+
+	var voiceChannelID string
+	if commandRequiresVoice(data.Name, args) {
+		if i.GuildID == "" {
+			b.editInteractionResponse(s, i.Interaction, "Error: This command can only be used in a server")
+			return
+		}
+
+		voiceState := b.findUserVoiceState(s, i.GuildID, user.ID, user.Username)
+		if voiceState == nil {
+			b.editInteractionResponse(s, i.Interaction, voiceChannelRequiredMessage)
+			return
+		}
+		voiceChannelID = voiceState.ChannelID
+	}
+
+	if data.Name == "play" || data.Name == "pick" || data.Name == "podcast" {
+		b.setTextChannel(i.GuildID, i.ChannelID)
+	}
+
+	started := time.Now()
+	response, err := b.HandleCommand(data.Name, args, voiceChannelID, i.GuildID, user.ID)
+	if err != nil {
+		response = fmt.Sprintf("Error: %s", err)
+	}
+	if response == "" {
+		response = "Done"
+	}
+
+	var components []discordgo.MessageComponent
+	if err == nil && offersResults(data.Name, args) {
+		components = b.searchMenu(i.GuildID, user.ID, started)
+	}
+	b.editInteractionResponse(s, i.Interaction, response, components...)
+}
+
+// editInteractionResponse replaces the deferred "thinking" response with
+// content and, if given, components
+func (b *Bot) editInteractionResponse(s *discordgo.Session, interaction *discordgo.Interaction, content string, components ...discordgo.MessageComponent) {
+	if runes := []rune(content); len(runes) > maxMessageLength {
+		content = string(runes[:maxMessageLength-3]) + "..."
+	}
+
+	edit := &discordgo.WebhookEdit{Content: &content}
+	if len(components) > 0 {
+		edit.Components = &components
+	}
+	if _, err := s.InteractionResponseEdit(interaction, edit); err != nil {
+		log.Printf("Failed to send slash command response: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package bot
+
+import (
+	"log"
+	"time"
+
+	"github.com/doomhound188/soulhound/internal/store"
+)
+
+// stateSaveInterval is how often queues and playback positions are saved.
+// A crash loses at most this much progress; a clean shutdown saves on exit.
+const stateSaveInterval = 10 * time.Second
+
+// loadState loads the saved guild settings, saves settings as they change,
+// and remembers the saved queues, which are resumed once the gateway session
+// is ready.
+func (b *Bot) loadState() error {
+	settings, err := b.store.LoadSettings()
+	if err != nil {
+		return err
+	}
+	b.settings.Load(settings)
+	b.settings.OnChange(b.store.SaveSettings)
+
+	guilds, err := b.store.LoadGuilds()
+	if err != nil {
+		return err
+	}
+	b.pendingRestore = guilds
+	for _, state := range guilds {
+		b.savedGuilds[state.GuildID] = true
+	}
+
+	positions, err := b.store.LoadPodcastPositions()
+	if err != nil {
+		return err
+	}
+	b.podcastPositions = positions
+
+	log.Printf("Loaded saved state from %s (settings for %d guilds, %d guilds to resume)", b.store.Dir(), len(settings), len(guilds))
+	return nil
+}
+
+// restoreState rejoins the voice channels saved before the last shutdown and
+// resumes their queues, then starts saving state periodically. It runs once,
+// after the first Ready event.
+func (b *Bot) restoreState() {
+	for _, state := range b.pendingRestore {
+		if len(state.Queue.Tracks) == 0 || state.VoiceChannelID == "" {
+			continue
+		}
+
+		if _, err := b.joinVoiceChannel(state.GuildID, state.VoiceChannelID); err != nil {
+			log.Printf("Could not rejoin voice channel %s in guild %s, discarding its saved queue: %v", state.VoiceChannelID, state.GuildID, err)
+			continue
+		}
+
+		b.mu.Lock()
+		restored := b.restorePlayer(state)
+		b.mu.Unlock()
+
+		if restored {
+			log.Printf("Resuming %d queued tracks in guild %s at %ds", len(state.Queue.Tracks), state.GuildID, state.Position)
+			go b.startPlaying(state.GuildID)
+		}
+	}
+	b.pendingRestore = nil
+
+	b.saveMu.Lock()
+	b.restored = true
+	b.saveMu.Unlock()
+
+	go b.runStateSaver()
+}
+
+// restorePlayer loads a saved queue into the guild's player. A queue started
+// since the bot came up is kept. The caller must hold b.mu.
+func (b *Bot) restorePlayer(state store.GuildState) bool {
+	player := b.getPlayer(state.GuildID)
+	if len(player.queue.List()) > 0 {
+		return false
+	}
+
+	player.queue.Restore(state.Queue)
+	player.startAt = time.Duration(state.Position) * time.Second
+	player.filters = state.Filters
+	player.textChannelID = state.TextChannelID
+	return true
+}
+
+// runStateSaver saves state every stateSaveInterval until the bot closes
+func (b *Bot) runStateSaver() {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.saveState()
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// saveState saves every guild's queue and playback position, and removes the
+// saved state of guilds whose queue is gone. Settings are saved as they change.
+// Queues aren't saved until saved guilds have been resumed, so an early
+// shutdown doesn't wipe them.
+func (b *Bot) saveState() {
+	if b.store == nil {
+		return
+	}
+
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+	b.savePodcastPositions()
+	if !b.restored {
+		return
+	}
+
+	b.mu.Lock()
+	guilds := make([]store.GuildState, 0, len(b.players))
+	for guildID, player := range b.players {
+		snapshot := player.queue.Snapshot()
+		if len(snapshot.Tracks) == 0 {
+			continue
+		}
+
+		state := store.GuildState{
+			GuildID:       guildID,
+			TextChannelID: player.textChannelID,
+			Queue:         snapshot,
+			Position:      int(b.playbackPosition(guildID, player).Seconds()),
+			Filters:       player.filters,
+		}
+		if vc, exists := b.voiceConn[guildID]; exists {
+			state.VoiceChannelID = vc.channelID
+		}
+		guilds = append(guilds, state)
+	}
+	b.mu.Unlock()
+
+	active := make(map[string]bool, len(guilds))
+	for _, state := range guilds {
+		active[state.GuildID] = true
+		if err := b.store.SaveGuild(state); err != nil {
+			log.Printf("Failed to save state for guild %s: %v", state.GuildID, err)
+			continue
+		}
+		b.savedGuilds[state.GuildID] = true
+	}
+
+	for guildID := range b.savedGuilds {
+		if active[guildID] {
+			continue
+		}
+		if err := b.store.DeleteGuild(guildID); err != nil {
+			log.Printf("Failed to delete saved state for guild %s: %v", guildID, err)
+			continue
+		}
+		delete(b.savedGuilds, guildID)
+	}
+}
+
+// playbackPosition estimates how far into the current track a guild is.
+// The caller must hold b.mu.
+func (b *Bot) playbackPosition(guildID string, player *Player) time.Duration {
+	if !player.isPlaying {
+		return player.startAt
+	}
+
+	position := player.streamStart
+	if vc, exists := b.voiceConn[guildID]; exists && vc.stream != nil {
+		played := vc.stream.PlaybackPosition()
+		if player.streamSpeed != 0 {
+			// Filters like nightcore get through the track faster than real time
+			played = time.Duration(float64(played) * player.streamSpeed)
+		}
+		position += played
+	}
+	return position
+}
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/jonas747/dca"
+)
+
+// streamSettings are the encoder settings for one stream of a track
+type streamSettings struct {
+	Start   time.Duration // Where to start in the track
+	Volume  int           // Percent, 100 leaves the source volume untouched
+	Filters []string      // Active filter presets
+}
+
+// streamSettings returns the settings for the next stream of a guild's
+// current track. Live streams always start at what is playing now, as
+// skipping ahead in them would only delay the audio. The caller must hold
+// b.mu.
+func (b *Bot) streamSettings(guildID string, player *Player) streamSettings {
+	settings := streamSettings{
+		Start:   player.startAt,
+		Volume:  b.settings.Volume(guildID),
+		Filters: append([]string(nil), player.filters...),
+	}
+	if player.current != nil && player.current.Live {
+		settings.Start = 0
+	}
+	return settings
+}
+
+// audioFilter builds the ffmpeg -af filter chain, empty if no filter is needed
+func (s streamSettings) audioFilter() string {
+	return filterChain(s.Filters, s.Volume)
+}
+
+// speed is how fast the stream plays through the track
+func (s streamSettings) speed() float64 {
+	return filterSpeed(s.Filters)
+}
+
+// restartCurrent restarts the current track at its playback position, so
+// changed stream settings take effect mid-track. It reports false if nothing
+// is playing. The caller must hold b.mu.
+func (b *Bot) restartCurrent(guildID string, player *Player) bool {
+	return b.restartAt(guildID, player, b.playbackPosition(guildID, player))
+}
+
+// restartAt restarts the current track at position. It reports false if
+// nothing is playing. The caller must hold b.mu.
+func (b *Bot) restartAt(guildID string, player *Player, position time.Duration) bool {
+	if !player.isPlaying || player.current == nil {
+		return false
+	}
+
+	vc, exists := b.voiceConn[guildID]
+	if !exists || vc.encoder == nil {
+		return false
+	}
+
+	player.startAt = position
+	player.skipped = true
+	player.restarting = true
+	vc.encoder.Cleanup()
+	return true
+}
+
+// forgetEncoder clears a voice connection's encoder once its session ended,
+// unless a newer session already took its place
+func (b *Bot) forgetEncoder(vc *VoiceConnection, session *dca.EncodeSession) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if vc.encoder == session {
+		vc.encoder = nil
+	}
+}
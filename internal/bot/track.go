@@ -0,0 +1,93 @@
+package bot
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/doomhound188/soulhound/internal/audio"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+// requester identifies who queued a track. The zero value stands for smart
+// play recommendations.
+type requester struct {
+	ID   string
+	Name string
+}
+
+// newTrack turns a search or playlist result into a queue track. Stream URLs
+// are resolved when the track comes up, not when it is queued.
+func newTrack(result audio.SearchResult, platform string, by requester) queue.Track {
+	return queue.Track{
+		Title:         result.Title,
+		Artist:        result.Artist,
+		URL:           result.ID,
+		Platform:      platform,
+		Duration:      result.Duration,
+		Live:          result.Live,
+		Genre:         result.Genre,
+		SourceURL:     result.URL,
+		Thumbnail:     result.Thumbnail,
+		RequestedBy:   by.ID,
+		RequesterName: by.Name,
+		AddedAt:       time.Now(),
+	}
+}
+
+// requesterFor looks up the display name of a guild member in the state cache,
+// preferring the server nickname. It falls back to the user ID.
+func (b *Bot) requesterFor(guildID, userID string) requester {
+	by := requester{ID: userID, Name: userID}
+	if userID == "" || b.session == nil || b.session.State == nil {
+		return by
+	}
+
+	member, err := b.session.State.Member(guildID, userID)
+	if err != nil || member == nil {
+		return by
+	}
+	switch {
+	case member.Nick != "":
+		by.Name = member.Nick
+	case member.User != nil && member.User.Username != "":
+		by.Name = member.User.Username
+	}
+	return by
+}
+
+// requestedBy describes who queued a track for queue listings. Names are used
+// instead of mentions so listing the queue doesn't ping anyone.
+func requestedBy(track queue.Track) string {
+	switch {
+	case track.RequesterName != "":
+		return track.RequesterName
+	case track.RequestedBy != "":
+		return track.RequestedBy
+	default:
+		return "smart play"
+	}
+}
+
+// formatDuration formats seconds as m:ss, or h:mm:ss for an hour or more
+func formatDuration(seconds int) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	h, m, s := seconds/3600, seconds/60%60, seconds%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// trackLength formats a track's duration, "live" for streams without an
+// end, or "?:??" when it is unknown
+func trackLength(track queue.Track) string {
+	if track.Live {
+		return "live"
+	}
+	if track.Duration <= 0 {
+		return "?:??"
+	}
+	return formatDuration(track.Duration)
+}
@@ -0,0 +1,210 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/doomhound188/soulhound/internal/permissions"
+	"github.com/jonas747/dca"
+)
+
+// voicePermissions are what the bot needs to play in a voice channel
+const voicePermissions = discordgo.PermissionViewChannel | discordgo.PermissionVoiceConnect | discordgo.PermissionVoiceSpeak
+
+// reconnectDelays are the waits before each attempt to rejoin a voice channel
+// after the connection dropped. Once they run out, the bot gives up and
+// leaves the guild's voice channel.
+var reconnectDelays = []time.Duration{
+	1 * time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+}
+
+// ownLeaveWindow is how long after the bot disconnects to rejoin or move that
+// Discord reporting it left voice is taken as its own doing
+const ownLeaveWindow = 15 * time.Second
+
+// expectLeave marks the bot as about to leave voice on purpose, so the
+// update Discord sends for it doesn't tear down the guild. The caller must
+// hold b.mu.
+func (vc *VoiceConnection) expectLeave() {
+	vc.leavingBy = time.Now().Add(ownLeaveWindow)
+}
+
+// leftOnPurpose reports whether the bot leaving voice was expected, and
+// clears the mark so a later disconnect counts again. The caller must hold
+// b.mu.
+func (vc *VoiceConnection) leftOnPurpose() bool {
+	expected := time.Now().Before(vc.leavingBy)
+	vc.leavingBy = time.Time{}
+	return expected
+}
+
+// voiceDropped reports whether a stream failed because the voice connection
+// went away, rather than because of the source
+func voiceDropped(vc *VoiceConnection, err error) bool {
+	if errors.Is(err, dca.ErrVoiceConnClosed) {
+		return true
+	}
+	if vc.connection == nil {
+		return false
+	}
+
+	vc.connection.RLock()
+	defer vc.connection.RUnlock()
+	return !vc.connection.Ready
+}
+
+// recoverVoice rejoins a guild's voice channel after its connection dropped
+// and restarts the current track where it was interrupted. If the bot can't
+// get back in, it leaves the guild and says so in the channel music was
+// requested from. It reports whether playback can continue.
+func (b *Bot) recoverVoice(guildID string, player *Player, vc *VoiceConnection) bool {
+	b.mu.Lock()
+	position := b.playbackPosition(guildID, player)
+	channelID := vc.channelID
+	b.mu.Unlock()
+
+	log.Printf("Voice connection in guild %s dropped at %v, reconnecting to channel %s", guildID, position, channelID)
+	if err := b.reconnectVoice(guildID, vc); err != nil {
+		log.Printf("Giving up on voice in guild %s: %v", guildID, err)
+
+		b.mu.Lock()
+		var textChannelID string
+		if b.voiceConn[guildID] == vc {
+			textChannelID = player.textChannelID
+			b.teardownGuild(guildID)
+		}
+		b.mu.Unlock()
+
+		if textChannelID != "" {
+			b.session.ChannelMessageSend(textChannelID, "❌ Lost the voice connection and couldn't reconnect, the queue was cleared")
+		}
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.players[guildID] != player || !player.isPlaying {
+		return false
+	}
+	player.startAt = position
+	player.skipped = true
+	player.restarting = true
+	log.Printf("Reconnected to voice in guild %s, resuming at %v", guildID, position)
+	return true
+}
+
+// reconnectVoice replaces a dropped voice connection with a new one to the
+// same channel, waiting longer after each failed attempt. It stops early if
+// the connection is torn down meanwhile, e.g. by !leave.
+func (b *Bot) reconnectVoice(guildID string, vc *VoiceConnection) error {
+	var lastErr error
+	for attempt, delay := range reconnectDelays {
+		time.Sleep(delay)
+
+		b.mu.Lock()
+		if b.voiceConn[guildID] != vc {
+			b.mu.Unlock()
+			return errors.New("voice connection was closed")
+		}
+		channelID := vc.channelID
+		old := vc.connection
+		if old != nil {
+			vc.expectLeave()
+		}
+		b.mu.Unlock()
+
+		// Drop what is left of the old connection so discordgo doesn't reuse it
+		if old != nil {
+			b.leaveVoice(old)
+		}
+
+		conn, err := b.joinVoice(guildID, channelID)
+		if err != nil {
+			lastErr = err
+			log.Printf("Voice reconnect attempt %d/%d in guild %s failed: %v", attempt+1, len(reconnectDelays), guildID, err)
+			continue
+		}
+
+		b.mu.Lock()
+		if b.voiceConn[guildID] != vc {
+			b.mu.Unlock()
+			b.leaveVoice(conn)
+			return errors.New("voice connection was closed")
+		}
+		vc.connection = conn
+		vc.stream = nil
+		b.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("failed to rejoin voice after %d attempts: %w", len(reconnectDelays), lastErr)
+}
+
+// botMoved follows the bot to the voice channel it was moved to, e.g. by a
+// moderator, so playback carries on there. The caller must hold b.mu.
+func (b *Bot) botMoved(guildID, channelID string) {
+	vc, exists := b.voiceConn[guildID]
+	if !exists || vc.channelID == channelID {
+		return
+	}
+
+	log.Printf("Bot was moved from voice channel %s to %s in guild %s", vc.channelID, channelID, guildID)
+	vc.channelID = channelID
+	vc.idleSince = time.Time{}
+}
+
+// checkVoiceJoin returns an error naming the permissions the bot lacks to
+// play in a voice channel, taking the channel's overwrites into account
+func (b *Bot) checkVoiceJoin(guildID, channelID string) error {
+	s := b.session
+	if s == nil || s.State == nil || s.State.User == nil {
+		return errors.New("not connected to Discord")
+	}
+
+	guild, err := s.State.Guild(guildID)
+	if err != nil {
+		if guild, err = s.Guild(guildID); err != nil {
+			return fmt.Errorf("couldn't look up the server to check my permissions: %w", err)
+		}
+	}
+	channel, err := b.lookupChannel(channelID)
+	if err != nil {
+		return fmt.Errorf("couldn't look up the voice channel to check my permissions: %w", err)
+	}
+	member, err := s.State.Member(guildID, s.State.User.ID)
+	if err != nil {
+		if member, err = s.GuildMember(guildID, s.State.User.ID); err != nil {
+			return fmt.Errorf("couldn't look up my roles to check my permissions: %w", err)
+		}
+	}
+
+	granted := permissions.Channel(guild, withUser(member, s.State.User.ID), channel)
+	if missing := permissions.Missing(granted, voicePermissions); len(missing) > 0 {
+		return fmt.Errorf("I lack %s in #%s", joinAnd(missing), channel.Name)
+	}
+	return nil
+}
+
+// lookupChannel returns a channel from the session state, or from the API if
+// the state doesn't have it
+func (b *Bot) lookupChannel(channelID string) (*discordgo.Channel, error) {
+	if channel, err := b.session.State.Channel(channelID); err == nil {
+		return channel, nil
+	}
+	return b.session.Channel(channelID)
+}
+
+// joinAnd joins names as in "View Channel, Connect and Speak"
+func joinAnd(names []string) string {
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
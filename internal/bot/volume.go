@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/doomhound188/soulhound/internal/config"
+)
+
+// handleVolume shows or sets the guild's volume. A new volume applies to the
+// current track right away by restarting it at its playback position.
+func (b *Bot) handleVolume(args []string, guildID string) (string, error) {
+	if guildID == "" {
+		return "", errors.New("volume can only be changed in a server")
+	}
+	if len(args) == 0 {
+		return fmt.Sprintf("🔊 Volume: %d%%", b.settings.Volume(guildID)), nil
+	}
+	if len(args) != 1 {
+		return "", fmt.Errorf("please specify a volume from 0 to %d", config.MaxVolume)
+	}
+
+	volume, err := strconv.Atoi(strings.TrimSuffix(args[0], "%"))
+	if err != nil || volume < 0 || volume > config.MaxVolume {
+		return "", fmt.Errorf("invalid volume %q, use a number from 0 to %d", args[0], config.MaxVolume)
+	}
+	if err := b.settings.SetVolume(guildID, volume); err != nil {
+		return "", err
+	}
+
+	response := fmt.Sprintf("🔊 Volume set to %d%%", volume)
+	if !b.applyStreamSettings(guildID) {
+		return response, nil
+	}
+	return response + " (applied to the current track)", nil
+}
+
+// applyStreamSettings restarts the current track so changed stream settings
+// take effect. It reports whether a track was restarted.
+func (b *Bot) applyStreamSettings(guildID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	player, exists := b.players[guildID]
+	if !exists {
+		return false
+	}
+	return b.restartCurrent(guildID, player)
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/doomhound188/soulhound/internal/audio"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+// queueStream queues what a web link plays: an audio file, internet radio,
+// HLS, or a PLS or M3U playlist of those
+func (b *Bot) queueStream(guildID string, streams *audio.StreamProvider, link string, by requester) (string, error) {
+	results, err := streams.Search(link)
+	if err != nil {
+		return "", err
+	}
+	return b.queueTrack(guildID, newTrack(results[0], streamPrefix, by))
+}
+
+// watchStreamTitle keeps the player's stream title up to date with what a
+// live stream says it is playing, until the returned stop is called. Streams
+// without ICY metadata are left without a title.
+func (b *Bot) watchStreamTitle(guildID string, player *Player, track queue.Track, streamURL string) (stop func()) {
+	streams, ok := b.webStreams()
+	if !ok || !track.Live || track.Platform != streamPrefix {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		err := streams.WatchTitles(ctx, streamURL, func(title string) {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.players[guildID] != player || !player.isCurrent(track) {
+				return
+			}
+			log.Printf("Stream in guild %s is now playing: %s", guildID, title)
+			player.streamTitle = title
+			player.refreshNowPlaying()
+		})
+		if err != nil && !errors.Is(err, audio.ErrNoStreamTitles) {
+			log.Printf("Stopped reading stream titles in guild %s: %v", guildID, err)
+		}
+	}()
+	return cancel
+}
@@ -1,22 +1,31 @@
 package config
 
+// Command modes select which command front-ends the bot listens to
+const (
+	CommandModePrefix = "prefix" // !-prefixed message commands only
+	CommandModeSlash  = "slash"  // Discord application (slash) commands only
+	CommandModeBoth   = "both"   // Both, for migrating servers over to slash commands
+)
+
 type Config struct {
 	DiscordToken  string
 	YouTubeToken  string
-	SpotifyToken  string
-	DefaultPlayer string // "yt" or "sp"
-}
+	SpotifyToken  string // Static Spotify access token, used without client credentials
+	DefaultPlayer string // Prefix of a registered provider, e.g. "yt"
+	CommandMode   string // "prefix", "slash" or "both"
+	CommandGuild  string // Register slash commands to this guild only (development)
+
+	YTDLPPath        string   // yt-dlp binary, looked up on PATH if empty
+	YouTubeResolvers []string // Resolution order: "ytdlp" and/or "library"
+
+	SpotifyClientID     string // Spotify app credentials for the client credentials flow
+	SpotifyClientSecret string
 
-type PlayerSettings struct {
-	SmartPlayEnabled bool
-	Platform         string
-	Volume           int
+	DataDir  string // Where queues and settings are saved, nothing is saved if empty
+	MusicDir string // Local music library served by the "local" provider, off if empty
 }
 
-var (
-	AppConfig    Config
-	PlayerConfig PlayerSettings
-)
+var AppConfig Config
 
 func Init(discordToken, youtubeToken, spotifyToken string) {
 	AppConfig = Config{
@@ -24,22 +33,17 @@ func Init(discordToken, youtubeToken, spotifyToken string) {
 		YouTubeToken:  youtubeToken,
 		SpotifyToken:  spotifyToken,
 		DefaultPlayer: "yt",
-	}
-
-	PlayerConfig = PlayerSettings{
-		SmartPlayEnabled: false,
-		Platform:         AppConfig.DefaultPlayer,
-		Volume:           100,
+		CommandMode:   CommandModeBoth,
 	}
 }
 
-func SetDefaultPlayer(platform string) {
-	if platform == "yt" || platform == "sp" {
-		AppConfig.DefaultPlayer = platform
-		PlayerConfig.Platform = platform
-	}
+// PrefixCommandsEnabled reports whether !-prefixed message commands are handled.
+// An unset mode keeps the original prefix behavior.
+func (c *Config) PrefixCommandsEnabled() bool {
+	return c.CommandMode != CommandModeSlash
 }
 
-func ToggleSmartPlay(enabled bool) {
-	PlayerConfig.SmartPlayEnabled = enabled
+// SlashCommandsEnabled reports whether slash commands are registered and handled
+func (c *Config) SlashCommandsEnabled() bool {
+	return c.CommandMode == CommandModeSlash || c.CommandMode == CommandModeBoth
 }
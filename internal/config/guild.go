@@ -0,0 +1,453 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Limits for guild settings
+const (
+	MaxVolume       = 200 // Percent
+	MaxPrefixLength = 5
+
+	DefaultIdleTimeout = 5 * time.Minute
+	DefaultSkipVotes   = 50 // Percent
+)
+
+// Permission levels a command can be set to require
+const (
+	LevelEveryone = "everyone"
+	LevelDJ       = "dj"
+	LevelAdmin    = "admin"
+)
+
+// GuildSettings are a server's settings, changed with !settings
+type GuildSettings struct {
+	DefaultPlatform  string        // Provider prefix used when a query has none
+	SmartPlay        bool          // Queue recommendations after each track
+	Volume           int           // Percent, 0-200
+	Prefix           string        // Message command prefix
+	DJRole           string        // Role ID that may manage playback, empty for everyone
+	MaxQueueLength   int           // Most tracks the queue may hold, 0 for no limit
+	AnnounceChannel  string        // Channel ID for now playing messages, empty for the command channel
+	IdleTimeout      time.Duration // Leave voice after this long without playing, 0 to stay
+	KeepPlayingAlone bool          // Don't pause when everyone leaves the voice channel
+	SkipVotes        int           // Percent of listeners who must vote to skip, 0 to skip right away
+	FairQueue        bool          // Upcoming tracks take turns between requesters
+	MaxUserTracks    int           // Most upcoming tracks one user may queue, 0 for no limit
+	MaxUserDuration  time.Duration // Most upcoming music one user may queue, 0 for no limit
+
+	// CommandLevels overrides the permission level commands need, keyed by
+	// command name. Commands without an entry use their default level.
+	CommandLevels map[string]string
+}
+
+// DefaultGuildSettings returns the settings of a guild that changed nothing
+func DefaultGuildSettings(cfg *Config) GuildSettings {
+	platform := cfg.DefaultPlayer
+	if platform == "" {
+		platform = "yt"
+	}
+	return GuildSettings{
+		DefaultPlatform: platform,
+		Volume:          100,
+		Prefix:          "!",
+		IdleTimeout:     DefaultIdleTimeout,
+		SkipVotes:       DefaultSkipVotes,
+	}
+}
+
+// Validate checks that every setting is within its limits
+func (s GuildSettings) Validate() error {
+	if s.DefaultPlatform == "" {
+		return fmt.Errorf("default platform can't be empty")
+	}
+	if s.Volume < 0 || s.Volume > MaxVolume {
+		return fmt.Errorf("volume must be between 0 and %d", MaxVolume)
+	}
+	if err := validatePrefix(s.Prefix); err != nil {
+		return err
+	}
+	if s.MaxQueueLength < 0 {
+		return fmt.Errorf("max queue length can't be negative")
+	}
+	if s.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout can't be negative")
+	}
+	if s.SkipVotes < 0 || s.SkipVotes > 100 {
+		return fmt.Errorf("skip votes must be between 0 and 100%%")
+	}
+	if s.MaxUserTracks < 0 {
+		return fmt.Errorf("tracks per user can't be negative")
+	}
+	if s.MaxUserDuration < 0 {
+		return fmt.Errorf("queued time per user can't be negative")
+	}
+	for command, level := range s.CommandLevels {
+		if !validLevel(level) {
+			return fmt.Errorf("invalid permission level %q for %s, use %s, %s or %s", level, command, LevelEveryone, LevelDJ, LevelAdmin)
+		}
+	}
+	return nil
+}
+
+func validLevel(level string) bool {
+	return level == LevelEveryone || level == LevelDJ || level == LevelAdmin
+}
+
+func validatePrefix(prefix string) error {
+	if prefix == "" || len(prefix) > MaxPrefixLength || strings.IndexFunc(prefix, unicode.IsSpace) >= 0 {
+		return fmt.Errorf("prefix must be 1 to %d characters without spaces", MaxPrefixLength)
+	}
+	return nil
+}
+
+// Guilds holds every guild's settings. Guilds that never changed a setting
+// use the defaults. Changes are passed to the save hook so they persist.
+type Guilds struct {
+	mu       sync.RWMutex
+	defaults GuildSettings
+	settings map[string]GuildSettings
+	save     func(guildID string, settings GuildSettings) error
+}
+
+// NewGuilds creates a settings holder with the given defaults
+func NewGuilds(defaults GuildSettings) *Guilds {
+	return &Guilds{
+		defaults: defaults,
+		settings: make(map[string]GuildSettings),
+	}
+}
+
+// Load replaces the settings of the given guilds, e.g. with saved settings.
+// Invalid saved values fall back to their defaults.
+func (g *Guilds) Load(settings map[string]GuildSettings) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for guildID, s := range settings {
+		g.settings[guildID] = g.sanitize(s)
+	}
+}
+
+// OnChange sets the hook called with a guild's settings after every change
+func (g *Guilds) OnChange(save func(guildID string, settings GuildSettings) error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.save = save
+}
+
+// Defaults returns the settings used by guilds that changed nothing
+func (g *Guilds) Defaults() GuildSettings {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.defaults
+}
+
+// Get returns a guild's settings
+func (g *Guilds) Get(guildID string) GuildSettings {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if s, exists := g.settings[guildID]; exists {
+		return s
+	}
+	return g.defaults
+}
+
+// Update changes a guild's settings with fn. Nothing changes if fn fails or
+// the result is invalid.
+func (g *Guilds) Update(guildID string, fn func(s *GuildSettings) error) (GuildSettings, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	s, exists := g.settings[guildID]
+	if !exists {
+		s = g.defaults
+	}
+	// fn gets its own copy of the map so a rejected change leaves no trace
+	s.CommandLevels = maps.Clone(s.CommandLevels)
+	if err := fn(&s); err != nil {
+		return g.current(guildID), err
+	}
+	if len(s.CommandLevels) == 0 {
+		s.CommandLevels = nil
+	}
+	if err := s.Validate(); err != nil {
+		return g.current(guildID), err
+	}
+
+	if g.save != nil {
+		if err := g.save(guildID, s); err != nil {
+			return g.current(guildID), fmt.Errorf("failed to save settings: %w", err)
+		}
+	}
+	g.settings[guildID] = s
+	return s, nil
+}
+
+// Reset puts all of a guild's settings back to the defaults
+func (g *Guilds) Reset(guildID string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		*s = g.defaults
+		return nil
+	})
+	return err
+}
+
+// current returns a guild's settings. The caller must hold g.mu.
+func (g *Guilds) current(guildID string) GuildSettings {
+	if s, exists := g.settings[guildID]; exists {
+		return s
+	}
+	return g.defaults
+}
+
+// sanitize replaces invalid values with their defaults. The caller must hold g.mu.
+func (g *Guilds) sanitize(s GuildSettings) GuildSettings {
+	if s.DefaultPlatform == "" {
+		s.DefaultPlatform = g.defaults.DefaultPlatform
+	}
+	if s.Volume < 0 || s.Volume > MaxVolume {
+		s.Volume = g.defaults.Volume
+	}
+	if validatePrefix(s.Prefix) != nil {
+		s.Prefix = g.defaults.Prefix
+	}
+	if s.MaxQueueLength < 0 {
+		s.MaxQueueLength = 0
+	}
+	if s.IdleTimeout < 0 {
+		s.IdleTimeout = 0
+	}
+	if s.SkipVotes < 0 || s.SkipVotes > 100 {
+		s.SkipVotes = g.defaults.SkipVotes
+	}
+	if s.MaxUserTracks < 0 {
+		s.MaxUserTracks = 0
+	}
+	if s.MaxUserDuration < 0 {
+		s.MaxUserDuration = 0
+	}
+	if len(s.CommandLevels) > 0 {
+		s.CommandLevels = maps.Clone(s.CommandLevels)
+		maps.DeleteFunc(s.CommandLevels, func(command, level string) bool {
+			return !validLevel(level)
+		})
+	}
+	if len(s.CommandLevels) == 0 {
+		s.CommandLevels = nil
+	}
+	return s
+}
+
+// DefaultPlatform returns the provider prefix used for queries without one
+func (g *Guilds) DefaultPlatform(guildID string) string {
+	return g.Get(guildID).DefaultPlatform
+}
+
+// SetDefaultPlatform sets the default provider prefix. Callers validate the
+// prefix against the provider registry.
+func (g *Guilds) SetDefaultPlatform(guildID, platform string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.DefaultPlatform = platform
+		return nil
+	})
+	return err
+}
+
+// SmartPlay reports whether recommendations are queued after each track
+func (g *Guilds) SmartPlay(guildID string) bool {
+	return g.Get(guildID).SmartPlay
+}
+
+// SetSmartPlay turns smart play on or off
+func (g *Guilds) SetSmartPlay(guildID string, enabled bool) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.SmartPlay = enabled
+		return nil
+	})
+	return err
+}
+
+// Volume returns the playback volume in percent
+func (g *Guilds) Volume(guildID string) int {
+	return g.Get(guildID).Volume
+}
+
+// SetVolume sets the playback volume in percent, 0 to MaxVolume
+func (g *Guilds) SetVolume(guildID string, volume int) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.Volume = volume
+		return nil
+	})
+	return err
+}
+
+// Prefix returns the message command prefix
+func (g *Guilds) Prefix(guildID string) string {
+	return g.Get(guildID).Prefix
+}
+
+// SetPrefix sets the message command prefix
+func (g *Guilds) SetPrefix(guildID, prefix string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.Prefix = prefix
+		return nil
+	})
+	return err
+}
+
+// DJRole returns the ID of the DJ role, empty if there is none
+func (g *Guilds) DJRole(guildID string) string {
+	return g.Get(guildID).DJRole
+}
+
+// SetDJRole sets the DJ role, empty to remove it
+func (g *Guilds) SetDJRole(guildID, roleID string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.DJRole = roleID
+		return nil
+	})
+	return err
+}
+
+// MaxQueueLength returns the most tracks the queue may hold, 0 for no limit
+func (g *Guilds) MaxQueueLength(guildID string) int {
+	return g.Get(guildID).MaxQueueLength
+}
+
+// SetMaxQueueLength limits the queue length, 0 for no limit
+func (g *Guilds) SetMaxQueueLength(guildID string, length int) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.MaxQueueLength = length
+		return nil
+	})
+	return err
+}
+
+// AnnounceChannel returns the channel for now playing messages, empty for the command channel
+func (g *Guilds) AnnounceChannel(guildID string) string {
+	return g.Get(guildID).AnnounceChannel
+}
+
+// SetAnnounceChannel sets the channel for now playing messages, empty to use the command channel
+func (g *Guilds) SetAnnounceChannel(guildID, channelID string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.AnnounceChannel = channelID
+		return nil
+	})
+	return err
+}
+
+// IdleTimeout returns how long the bot stays in voice without playing, 0 for no limit
+func (g *Guilds) IdleTimeout(guildID string) time.Duration {
+	return g.Get(guildID).IdleTimeout
+}
+
+// SetIdleTimeout sets how long the bot stays in voice without playing, 0 to stay
+func (g *Guilds) SetIdleTimeout(guildID string, timeout time.Duration) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.IdleTimeout = timeout
+		return nil
+	})
+	return err
+}
+
+// AutoPause reports whether playback pauses while nobody is listening
+func (g *Guilds) AutoPause(guildID string) bool {
+	return !g.Get(guildID).KeepPlayingAlone
+}
+
+// SetAutoPause sets whether playback pauses while nobody is listening
+func (g *Guilds) SetAutoPause(guildID string, enabled bool) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.KeepPlayingAlone = !enabled
+		return nil
+	})
+	return err
+}
+
+// SkipVotes returns the percent of listeners who must vote to skip a track,
+// 0 if a single !skip is enough
+func (g *Guilds) SkipVotes(guildID string) int {
+	return g.Get(guildID).SkipVotes
+}
+
+// SetSkipVotes sets the percent of listeners who must vote to skip, 0 to 100
+func (g *Guilds) SetSkipVotes(guildID string, percent int) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.SkipVotes = percent
+		return nil
+	})
+	return err
+}
+
+// FairQueue reports whether upcoming tracks take turns between requesters
+func (g *Guilds) FairQueue(guildID string) bool {
+	return g.Get(guildID).FairQueue
+}
+
+// SetFairQueue turns taking turns between requesters on or off
+func (g *Guilds) SetFairQueue(guildID string, enabled bool) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.FairQueue = enabled
+		return nil
+	})
+	return err
+}
+
+// MaxUserTracks returns the most upcoming tracks one user may queue, 0 for no limit
+func (g *Guilds) MaxUserTracks(guildID string) int {
+	return g.Get(guildID).MaxUserTracks
+}
+
+// SetMaxUserTracks limits the upcoming tracks one user may queue, 0 for no limit
+func (g *Guilds) SetMaxUserTracks(guildID string, tracks int) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.MaxUserTracks = tracks
+		return nil
+	})
+	return err
+}
+
+// MaxUserDuration returns the most upcoming music one user may queue, 0 for no limit
+func (g *Guilds) MaxUserDuration(guildID string) time.Duration {
+	return g.Get(guildID).MaxUserDuration
+}
+
+// SetMaxUserDuration limits the upcoming music one user may queue, 0 for no limit
+func (g *Guilds) SetMaxUserDuration(guildID string, duration time.Duration) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		s.MaxUserDuration = duration
+		return nil
+	})
+	return err
+}
+
+// CommandLevel returns the permission level a guild set for a command, empty
+// if the command uses its default level
+func (g *Guilds) CommandLevel(guildID, command string) string {
+	return g.Get(guildID).CommandLevels[command]
+}
+
+// SetCommandLevel sets the permission level a command needs, empty to go
+// back to its default level
+func (g *Guilds) SetCommandLevel(guildID, command, level string) error {
+	_, err := g.Update(guildID, func(s *GuildSettings) error {
+		if level == "" {
+			delete(s.CommandLevels, command)
+			return nil
+		}
+		if s.CommandLevels == nil {
+			s.CommandLevels = make(map[string]string)
+		}
+		s.CommandLevels[command] = level
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGuildSettingsArePerGuild(t *testing.T) {
+	guilds := NewGuilds(DefaultGuildSettings(&Config{}))
+
+	if err := guilds.SetVolume("a", 50); err != nil {
+		t.Fatalf("SetVolume failed: %v", err)
+	}
+	if err := guilds.SetSmartPlay("a", true); err != nil {
+		t.Fatalf("SetSmartPlay failed: %v", err)
+	}
+
+	if guilds.Volume("a") != 50 || !guilds.SmartPlay("a") {
+		t.Errorf("Expected guild a to have its own settings, got %+v", guilds.Get("a"))
+	}
+	if guilds.Volume("b") != 100 || guilds.SmartPlay("b") || guilds.DefaultPlatform("b") != "yt" || guilds.Prefix("b") != "!" {
+		t.Errorf("Expected guild b to use the defaults, got %+v", guilds.Get("b"))
+	}
+}
+
+func TestGuildSettingsValidation(t *testing.T) {
+	guilds := NewGuilds(DefaultGuildSettings(&Config{DefaultPlayer: "sp"}))
+
+	invalid := map[string]error{
+		"volume above max": guilds.SetVolume("a", MaxVolume+1),
+		"negative volume":  guilds.SetVolume("a", -1),
+		"empty prefix":     guilds.SetPrefix("a", ""),
+		"prefix too long":  guilds.SetPrefix("a", "!!!!!!"),
+		"prefix spaces":    guilds.SetPrefix("a", "! "),
+		"negative queue":   guilds.SetMaxQueueLength("a", -5),
+		"negative idle":    guilds.SetIdleTimeout("a", -time.Minute),
+		"skip votes > 100": guilds.SetSkipVotes("a", 101),
+		"unknown level":    guilds.SetCommandLevel("a", "stop", "owner"),
+		"negative tracks":  guilds.SetMaxUserTracks("a", -1),
+		"negative time":    guilds.SetMaxUserDuration("a", -time.Minute),
+		"empty platform":   guilds.SetDefaultPlatform("a", ""),
+	}
+	for name, err := range invalid {
+		if err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+
+	if got := guilds.Get("a"); !reflect.DeepEqual(got, guilds.Defaults()) {
+		t.Errorf("Expected rejected changes to leave the defaults, got %+v", got)
+	}
+	if guilds.DefaultPlatform("a") != "sp" {
+		t.Errorf("Expected the configured default platform, got %s", guilds.DefaultPlatform("a"))
+	}
+}
+
+func TestGuildSettingsSaveHook(t *testing.T) {
+	guilds := NewGuilds(DefaultGuildSettings(&Config{}))
+
+	saved := make(map[string]GuildSettings)
+	var failSave bool
+	guilds.OnChange(func(guildID string, settings GuildSettings) error {
+		if failSave {
+			return errors.New("disk full")
+		}
+		saved[guildID] = settings
+		return nil
+	})
+
+	if err := guilds.SetDJRole("a", "123"); err != nil {
+		t.Fatalf("SetDJRole failed: %v", err)
+	}
+	if saved["a"].DJRole != "123" {
+		t.Errorf("Expected the change to be saved, got %+v", saved["a"])
+	}
+
+	// A change that can't be saved isn't applied either
+	failSave = true
+	if err := guilds.SetAnnounceChannel("a", "456"); err == nil {
+		t.Error("Expected a failed save to be reported")
+	}
+	if guilds.AnnounceChannel("a") != "" {
+		t.Errorf("Expected the unsaved change to be dropped, got %q", guilds.AnnounceChannel("a"))
+	}
+
+	failSave = false
+	if err := guilds.Reset("a"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if guilds.DJRole("a") != "" || !reflect.DeepEqual(saved["a"], guilds.Defaults()) {
+		t.Errorf("Expected reset to restore and save the defaults, got %+v", saved["a"])
+	}
+}
+
+func TestGuildSettingsLoadSanitizes(t *testing.T) {
+	guilds := NewGuilds(DefaultGuildSettings(&Config{}))
+	guilds.Load(map[string]GuildSettings{
+		"a": {
+			DefaultPlatform: "sp", Volume: 999, Prefix: "", MaxQueueLength: -1, IdleTimeout: 2 * time.Minute,
+			CommandLevels: map[string]string{"stop": LevelEveryone, "skip": "owner"},
+		},
+	})
+
+	got := guilds.Get("a")
+	want := GuildSettings{
+		DefaultPlatform: "sp", Volume: 100, Prefix: "!", IdleTimeout: 2 * time.Minute,
+		CommandLevels: map[string]string{"stop": LevelEveryone},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected invalid saved values to fall back to defaults, got %+v", got)
+	}
+}
+
+func TestGuildCommandLevels(t *testing.T) {
+	guilds := NewGuilds(DefaultGuildSettings(&Config{}))
+
+	if err := guilds.SetCommandLevel("a", "stop", LevelEveryone); err != nil {
+		t.Fatalf("SetCommandLevel failed: %v", err)
+	}
+	before := guilds.Get("a")
+
+	if err := guilds.SetCommandLevel("a", "queue", "owner"); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if !reflect.DeepEqual(guilds.Get("a"), before) || before.CommandLevels["queue"] != "" {
+		t.Errorf("Expected a rejected change not to touch the saved levels, got %v", guilds.Get("a").CommandLevels)
+	}
+
+	if guilds.CommandLevel("a", "stop") != LevelEveryone || guilds.CommandLevel("b", "stop") != "" {
+		t.Errorf("Expected the override to apply to guild a only")
+	}
+
+	if err := guilds.SetCommandLevel("a", "stop", ""); err != nil {
+		t.Fatalf("SetCommandLevel failed: %v", err)
+	}
+	if got := guilds.Get("a").CommandLevels; got != nil {
+		t.Errorf("Expected removing the last override to clear the map, got %v", got)
+	}
+}
@@ -0,0 +1,114 @@
+// Package permissions computes what a guild member may do, following
+// Discord's rules: the @everyone role, the member's roles, then the channel's
+// permission overwrites, with owners and administrators allowed everything.
+package permissions
+
+import (
+	"github.com/bwmarrin/discordgo"
+)
+
+// names are the names Discord shows for the permissions the bot checks
+var names = []struct {
+	permission int64
+	name       string
+}{
+	{discordgo.PermissionViewChannel, "View Channel"},
+	{discordgo.PermissionSendMessages, "Send Messages"},
+	{discordgo.PermissionEmbedLinks, "Embed Links"},
+	{discordgo.PermissionVoiceConnect, "Connect"},
+	{discordgo.PermissionVoiceSpeak, "Speak"},
+	{discordgo.PermissionManageServer, "Manage Server"},
+	{discordgo.PermissionAdministrator, "Administrator"},
+}
+
+// All is every permission, held by guild owners and administrators
+const All = int64(discordgo.PermissionAll)
+
+// Guild returns a member's guild-wide permissions
+func Guild(guild *discordgo.Guild, member *discordgo.Member) int64 {
+	if member.User != nil && guild.OwnerID == member.User.ID {
+		return All
+	}
+
+	roles := make(map[string]*discordgo.Role, len(guild.Roles))
+	for _, role := range guild.Roles {
+		roles[role.ID] = role
+	}
+
+	// The @everyone role shares the guild's ID
+	var permissions int64
+	if everyone, exists := roles[guild.ID]; exists {
+		permissions = everyone.Permissions
+	}
+	for _, roleID := range member.Roles {
+		if role, exists := roles[roleID]; exists {
+			permissions |= role.Permissions
+		}
+	}
+
+	if permissions&discordgo.PermissionAdministrator != 0 {
+		return All
+	}
+	return permissions
+}
+
+// Channel returns a member's permissions in a channel: their guild
+// permissions with the channel's overwrites applied, @everyone first, then
+// the member's roles together, then the member, so the most specific
+// overwrite wins. A channel synced with its category holds a copy of the
+// category's overwrites, so those are covered too.
+func Channel(guild *discordgo.Guild, member *discordgo.Member, channel *discordgo.Channel) int64 {
+	permissions := Guild(guild, member)
+	if permissions == All {
+		return All
+	}
+	return applyOverwrites(permissions, guild.ID, member, channel.PermissionOverwrites)
+}
+
+func applyOverwrites(permissions int64, guildID string, member *discordgo.Member, overwrites []*discordgo.PermissionOverwrite) int64 {
+	memberRoles := make(map[string]bool, len(member.Roles))
+	for _, roleID := range member.Roles {
+		memberRoles[roleID] = true
+	}
+
+	var everyone, memberOverwrite *discordgo.PermissionOverwrite
+	var roleAllow, roleDeny int64
+	for _, overwrite := range overwrites {
+		switch {
+		case overwrite.Type == discordgo.PermissionOverwriteTypeRole && overwrite.ID == guildID:
+			everyone = overwrite
+		case overwrite.Type == discordgo.PermissionOverwriteTypeRole && memberRoles[overwrite.ID]:
+			roleAllow |= overwrite.Allow
+			roleDeny |= overwrite.Deny
+		case overwrite.Type == discordgo.PermissionOverwriteTypeMember && member.User != nil && overwrite.ID == member.User.ID:
+			memberOverwrite = overwrite
+		}
+	}
+
+	if everyone != nil {
+		permissions = permissions&^everyone.Deny | everyone.Allow
+	}
+	permissions = permissions&^roleDeny | roleAllow
+	if memberOverwrite != nil {
+		permissions = permissions&^memberOverwrite.Deny | memberOverwrite.Allow
+	}
+	return permissions
+}
+
+// Has reports whether permissions include every permission in required
+func Has(permissions, required int64) bool {
+	return permissions&required == required
+}
+
+// Missing returns the names of the permissions in required that permissions
+// lacks, in the order Discord lists them. Only the permissions the bot checks
+// have names.
+func Missing(permissions, required int64) []string {
+	var missing []string
+	for _, n := range names {
+		if required&n.permission != 0 && permissions&n.permission == 0 {
+			missing = append(missing, n.name)
+		}
+	}
+	return missing
+}
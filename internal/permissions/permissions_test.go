@@ -0,0 +1,109 @@
+package permissions
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func testGuild() *discordgo.Guild {
+	return &discordgo.Guild{
+		ID:      "guild",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "guild", Permissions: discordgo.PermissionViewChannel | discordgo.PermissionVoiceConnect},
+			{ID: "dj", Permissions: discordgo.PermissionVoiceSpeak},
+			{ID: "mod", Permissions: discordgo.PermissionManageServer},
+			{ID: "admin", Permissions: discordgo.PermissionAdministrator},
+		},
+	}
+}
+
+func member(userID string, roles ...string) *discordgo.Member {
+	return &discordgo.Member{User: &discordgo.User{ID: userID}, Roles: roles}
+}
+
+func TestGuild(t *testing.T) {
+	guild := testGuild()
+	everyone := int64(discordgo.PermissionViewChannel | discordgo.PermissionVoiceConnect)
+
+	tests := []struct {
+		name   string
+		member *discordgo.Member
+		want   int64
+	}{
+		{"everyone role only", member("user"), everyone},
+		{"roles add up", member("user", "dj", "mod"), everyone | discordgo.PermissionVoiceSpeak | discordgo.PermissionManageServer},
+		{"unknown roles are ignored", member("user", "gone"), everyone},
+		{"administrator has everything", member("user", "admin"), All},
+		{"owner has everything", member("owner"), All},
+	}
+	for _, tt := range tests {
+		if got := Guild(guild, tt.member); got != tt.want {
+			t.Errorf("%s: expected %b, got %b", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestHas(t *testing.T) {
+	permissions := int64(discordgo.PermissionViewChannel | discordgo.PermissionVoiceConnect)
+	if !Has(permissions, discordgo.PermissionVoiceConnect) {
+		t.Error("Expected a held permission to be found")
+	}
+	if Has(permissions, discordgo.PermissionVoiceConnect|discordgo.PermissionVoiceSpeak) {
+		t.Error("Expected a missing permission to fail the check")
+	}
+}
+
+func TestChannel(t *testing.T) {
+	guild := testGuild()
+	connect := int64(discordgo.PermissionVoiceConnect)
+	speak := int64(discordgo.PermissionVoiceSpeak)
+
+	role := func(id string, allow, deny int64) *discordgo.PermissionOverwrite {
+		return &discordgo.PermissionOverwrite{ID: id, Type: discordgo.PermissionOverwriteTypeRole, Allow: allow, Deny: deny}
+	}
+	user := func(id string, allow, deny int64) *discordgo.PermissionOverwrite {
+		return &discordgo.PermissionOverwrite{ID: id, Type: discordgo.PermissionOverwriteTypeMember, Allow: allow, Deny: deny}
+	}
+	channel := func(overwrites ...*discordgo.PermissionOverwrite) *discordgo.Channel {
+		return &discordgo.Channel{ID: "music", PermissionOverwrites: overwrites}
+	}
+
+	tests := []struct {
+		name    string
+		member  *discordgo.Member
+		channel *discordgo.Channel
+		check   int64
+		want    bool
+	}{
+		{"no overwrites", member("user"), channel(), connect, true},
+		{"everyone denied", member("user"), channel(role("guild", 0, connect)), connect, false},
+		{"role allow beats everyone deny", member("user", "dj"), channel(role("guild", 0, connect), role("dj", connect, 0)), connect, true},
+		{"role allow beats another role's deny", member("user", "dj", "mod"), channel(role("mod", 0, speak), role("dj", speak, 0)), speak, true},
+		{"other roles don't apply", member("user"), channel(role("dj", 0, connect)), connect, true},
+		{"member deny beats role allow", member("user", "dj"), channel(role("dj", speak, 0), user("user", 0, speak)), speak, false},
+		{"member allow beats everyone deny", member("user"), channel(role("guild", 0, connect), user("user", connect, 0)), connect, true},
+		{"other members don't apply", member("user"), channel(user("someone", 0, connect)), connect, true},
+		{"role deny beats everyone allow", member("user", "dj"), channel(role("guild", speak, 0), role("dj", 0, speak)), speak, false},
+		{"administrator ignores overwrites", member("user", "admin"), channel(role("guild", 0, connect), user("user", 0, connect)), connect, true},
+		{"owner ignores overwrites", member("owner"), channel(role("guild", 0, connect|speak)), connect | speak, true},
+	}
+	for _, tt := range tests {
+		if got := Has(Channel(guild, tt.member, tt.channel), tt.check); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMissing(t *testing.T) {
+	required := int64(discordgo.PermissionViewChannel | discordgo.PermissionVoiceConnect | discordgo.PermissionVoiceSpeak)
+
+	missing := Missing(discordgo.PermissionViewChannel, required)
+	if len(missing) != 2 || missing[0] != "Connect" || missing[1] != "Speak" {
+		t.Errorf("Expected Connect and Speak to be missing, got %v", missing)
+	}
+	if missing := Missing(All, required); len(missing) != 0 {
+		t.Errorf("Expected nothing to be missing, got %v", missing)
+	}
+}
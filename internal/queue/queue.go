@@ -2,27 +2,93 @@ package queue
 
 import (
 	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Track struct {
-	Title    string
-	Artist   string
-	URL      string
-	Platform string
-	Duration int
-	Genre    string
+	Title     string
+	Artist    string
+	URL       string // Provider ID used to resolve the stream
+	Platform  string
+	Duration  int  // Seconds, 0 if unknown
+	Live      bool // Has no end, e.g. internet radio, so it can't be seeked
+	Genre     string
+	SourceURL string // Canonical link to the track, e.g. a YouTube watch URL
+	Thumbnail string
+
+	RequestedBy   string // Discord user ID, empty for tracks added by smart play
+	RequesterName string // Display name of the requester when the track was added
+	AddedAt       time.Time
+}
+
+// LoopMode controls what happens when a track or the whole queue finishes
+type LoopMode int
+
+const (
+	LoopOff   LoopMode = iota // Stop after the last track
+	LoopTrack                 // Repeat the current track
+	LoopQueue                 // Start over after the last track
+)
+
+var loopModeNames = map[LoopMode]string{
+	LoopOff:   "off",
+	LoopTrack: "track",
+	LoopQueue: "queue",
 }
 
+func (m LoopMode) String() string {
+	if name, exists := loopModeNames[m]; exists {
+		return name
+	}
+	return fmt.Sprintf("LoopMode(%d)", int(m))
+}
+
+// MarshalText stores loop modes by name
+func (m LoopMode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText parses a loop mode name
+func (m *LoopMode) UnmarshalText(text []byte) error {
+	mode, err := ParseLoopMode(string(text))
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// ParseLoopMode parses "off", "track" or "queue"
+func ParseLoopMode(s string) (LoopMode, error) {
+	for mode, name := range loopModeNames {
+		if strings.EqualFold(s, name) {
+			return mode, nil
+		}
+	}
+	return LoopOff, fmt.Errorf("invalid loop mode %q, use off, track or queue", s)
+}
+
+// Queue is an ordered list of tracks with a cursor on the current one. Played
+// tracks stay in the queue so Previous can go back to them. In fair mode,
+// upcoming tracks take turns between requesters instead of playing in the
+// order they were added.
 type Queue struct {
 	tracks  []Track
 	mu      sync.Mutex
-	current int
+	current int // -1 when empty, len(tracks) once the last track finished
+	loop    LoopMode
+	fair    bool
 }
 
 var (
 	ErrQueueEmpty   = errors.New("queue is empty")
 	ErrInvalidIndex = errors.New("invalid track index")
+	ErrEndOfQueue   = errors.New("end of queue")
+	ErrStartOfQueue = errors.New("already at the first track")
 )
 
 func NewQueue() *Queue {
@@ -35,12 +101,62 @@ func NewQueue() *Queue {
 func (q *Queue) Add(track Track) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.tracks = append(q.tracks, track)
+	if track.AddedAt.IsZero() {
+		track.AddedAt = time.Now()
+	}
+	q.insert(track)
+}
+
+// insert adds a track at the end, or in fair mode at its requester's next
+// turn. The caller must hold q.mu.
+func (q *Queue) insert(track Track) {
+	index := len(q.tracks)
+	if q.fair {
+		index = q.fairIndex(track.RequestedBy)
+	}
+	q.tracks = append(q.tracks, Track{})
+	copy(q.tracks[index+1:], q.tracks[index:])
+	q.tracks[index] = track
 	if q.current == -1 {
 		q.current = 0
 	}
 }
 
+// fairIndex returns where a new track by requestedBy goes in fair mode. A
+// requester's n-th upcoming track plays in round n, and the current track
+// counts as its requester's first, so the new track goes after the last
+// track of its round. The caller must hold q.mu.
+func (q *Queue) fairIndex(requestedBy string) int {
+	start := q.upcoming()
+	turns := make(map[string]int)
+	if q.current >= 0 && q.current < len(q.tracks) {
+		turns[q.tracks[q.current].RequestedBy] = 1
+	}
+
+	round := turns[requestedBy]
+	for _, track := range q.tracks[start:] {
+		if track.RequestedBy == requestedBy {
+			round++
+		}
+	}
+
+	index := start
+	for i := start; i < len(q.tracks); i++ {
+		by := q.tracks[i].RequestedBy
+		if turns[by] <= round {
+			index = i + 1
+		}
+		turns[by]++
+	}
+	return index
+}
+
+// upcoming returns the index of the first track after the current one.
+// The caller must hold q.mu.
+func (q *Queue) upcoming() int {
+	return min(q.current+1, len(q.tracks))
+}
+
 func (q *Queue) Remove(index int) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -58,26 +174,276 @@ func (q *Queue) Remove(index int) error {
 	return nil
 }
 
-func (q *Queue) Current() (*Track, error) {
+// Current returns a copy of the current track. The queue's own copy moves
+// around as tracks are moved, removed or shuffled.
+func (q *Queue) Current() (Track, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
 	if q.current < 0 || q.current >= len(q.tracks) {
-		return nil, ErrQueueEmpty
+		return Track{}, ErrQueueEmpty
 	}
-	return &q.tracks[q.current], nil
+	return q.tracks[q.current], nil
 }
 
-func (q *Queue) Next() (*Track, error) {
+// Next moves to the next track, as when skipping. It wraps around only in
+// LoopQueue mode; otherwise it returns ErrEndOfQueue after the last track.
+func (q *Queue) Next() (Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.next()
+}
+
+// Advance moves on after the current track finished playing. It is Next,
+// except that LoopTrack repeats the current track.
+func (q *Queue) Advance() (Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.loop == LoopTrack && q.current >= 0 && q.current < len(q.tracks) {
+		return q.tracks[q.current], nil
+	}
+	return q.next()
+}
+
+func (q *Queue) next() (Track, error) {
+	if len(q.tracks) == 0 {
+		return Track{}, ErrQueueEmpty
+	}
+
+	if q.current+1 < len(q.tracks) {
+		q.current++
+	} else if q.loop == LoopQueue {
+		q.current = 0
+	} else {
+		q.current = len(q.tracks)
+		return Track{}, ErrEndOfQueue
+	}
+	return q.tracks[q.current], nil
+}
+
+// Previous moves back to the track before the current one. After the queue
+// finished it goes back to the last track.
+func (q *Queue) Previous() (Track, error) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
 	if len(q.tracks) == 0 {
-		return nil, ErrQueueEmpty
+		return Track{}, ErrQueueEmpty
+	}
+
+	if q.current > 0 {
+		q.current--
+	} else if q.loop == LoopQueue {
+		q.current = len(q.tracks) - 1
+	} else {
+		return Track{}, ErrStartOfQueue
+	}
+	return q.tracks[q.current], nil
+}
+
+// JumpTo makes the track at index the current one
+func (q *Queue) JumpTo(index int) (Track, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if index < 0 || index >= len(q.tracks) {
+		return Track{}, ErrInvalidIndex
+	}
+
+	q.current = index
+	return q.tracks[q.current], nil
+}
+
+// Move moves the track at index from to index to, shifting the tracks in
+// between. The current track stays current.
+func (q *Queue) Move(from, to int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if from < 0 || from >= len(q.tracks) || to < 0 || to >= len(q.tracks) {
+		return ErrInvalidIndex
+	}
+	if from == to {
+		return nil
+	}
+
+	track := q.tracks[from]
+	if from < to {
+		copy(q.tracks[from:to], q.tracks[from+1:to+1])
+	} else {
+		copy(q.tracks[to+1:from+1], q.tracks[to:from])
 	}
+	q.tracks[to] = track
 
-	q.current = (q.current + 1) % len(q.tracks)
-	return &q.tracks[q.current], nil
+	switch {
+	case q.current == from:
+		q.current = to
+	case from < q.current && q.current <= to:
+		q.current--
+	case to <= q.current && q.current < from:
+		q.current++
+	}
+	return nil
+}
+
+// Shuffle shuffles the tracks after the current one. The current track and
+// the tracks already played keep their positions.
+func (q *Queue) Shuffle() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	start := q.current + 1
+	if q.current < 0 {
+		start = 0
+	}
+	if start >= len(q.tracks) {
+		return 0
+	}
+
+	upcoming := q.tracks[start:]
+	rand.Shuffle(len(upcoming), func(i, j int) {
+		upcoming[i], upcoming[j] = upcoming[j], upcoming[i]
+	})
+	return len(upcoming)
+}
+
+// SetLoop sets the loop mode
+func (q *Queue) SetLoop(mode LoopMode) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.loop = mode
+}
+
+// Loop returns the loop mode
+func (q *Queue) Loop() LoopMode {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.loop
+}
+
+// SetFair turns fair mode on or off. Turning it on reorders the upcoming
+// tracks so requesters take turns, keeping each requester's tracks in order.
+func (q *Queue) SetFair(enabled bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if enabled && !q.fair {
+		q.fair = true
+		start := q.upcoming()
+		upcoming := append([]Track{}, q.tracks[start:]...)
+		q.tracks = q.tracks[:start]
+		for _, track := range upcoming {
+			q.insert(track)
+		}
+	}
+	q.fair = enabled
+}
+
+// Fair reports whether requesters take turns
+func (q *Queue) Fair() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.fair
+}
+
+// Queued returns how many of the tracks after the current one were requested
+// by a user, and their total length in seconds. Tracks of unknown length
+// count as zero seconds.
+func (q *Queue) Queued(requestedBy string) (tracks int, seconds int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, track := range q.tracks[q.upcoming():] {
+		if track.RequestedBy == requestedBy {
+			tracks++
+			seconds += track.Duration
+		}
+	}
+	return tracks, seconds
+}
+
+// CurrentIndex returns the index of the current track, or -1 if there is none
+func (q *Queue) CurrentIndex() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current < 0 || q.current >= len(q.tracks) {
+		return -1
+	}
+	return q.current
+}
+
+// Upcoming returns how many tracks are after the current one
+func (q *Queue) Upcoming() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.tracks) - q.upcoming()
+}
+
+// Pending returns how many tracks are left to play, counting the current one
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.current < 0 || q.current >= len(q.tracks) {
+		return 0
+	}
+	return len(q.tracks) - q.current
+}
+
+// Remaining returns the total length in seconds of the current track and the
+// tracks after it, and how many of them have an unknown length.
+func (q *Queue) Remaining() (seconds int, unknown int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	start := q.current
+	if start < 0 {
+		start = 0
+	}
+	for _, track := range q.tracks[min(start, len(q.tracks)):] {
+		if track.Duration > 0 {
+			seconds += track.Duration
+		} else {
+			unknown++
+		}
+	}
+	return seconds, unknown
+}
+
+// Snapshot is a copy of a queue's contents, used to persist it
+type Snapshot struct {
+	Tracks  []Track
+	Current int // Index of the current track, -1 when empty
+	Loop    LoopMode
+}
+
+// Snapshot copies the tracks, cursor and loop mode
+func (q *Queue) Snapshot() Snapshot {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return Snapshot{
+		Tracks:  append([]Track{}, q.tracks...),
+		Current: q.current,
+		Loop:    q.loop,
+	}
+}
+
+// Restore replaces the queue's contents with a snapshot. An out of range
+// cursor is moved to the first track.
+func (q *Queue) Restore(s Snapshot) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tracks = append([]Track{}, s.Tracks...)
+	q.loop = s.Loop
+	q.current = s.Current
+	if len(q.tracks) == 0 {
+		q.current = -1
+	} else if q.current < 0 || q.current > len(q.tracks) {
+		q.current = 0
+	}
 }
 
 func (q *Queue) List() []Track {
@@ -1,7 +1,12 @@
 package queue
 
 import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewQueue(t *testing.T) {
@@ -82,4 +87,363 @@ func TestQueueClear(t *testing.T) {
 	if q.current != -1 {
 		t.Errorf("Expected current to be -1 after clear, got %d", q.current)
 	}
-}
\ No newline at end of file
+}
+// newTestQueue returns a queue of tracks titled "0" to "n-1"
+func newTestQueue(n int) *Queue {
+	q := NewQueue()
+	for i := 0; i < n; i++ {
+		q.Add(Track{Title: strconv.Itoa(i), Platform: "yt"})
+	}
+	return q
+}
+
+// titles returns the queue's track titles joined by spaces
+func titles(q *Queue) string {
+	var names []string
+	for _, track := range q.List() {
+		names = append(names, track.Title)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestQueueLoopModes(t *testing.T) {
+	tests := []struct {
+		mode     LoopMode
+		advances []string // Titles after each Advance, "" for ErrEndOfQueue
+	}{
+		{LoopOff, []string{"1", "2", ""}},
+		{LoopTrack, []string{"0", "0", "0"}},
+		{LoopQueue, []string{"1", "2", "0", "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			q := newTestQueue(3)
+			q.SetLoop(tt.mode)
+
+			for i, want := range tt.advances {
+				track, err := q.Advance()
+				if want == "" {
+					if err != ErrEndOfQueue {
+						t.Fatalf("Advance %d: expected ErrEndOfQueue, got %v", i, err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Advance %d failed: %v", i, err)
+				}
+				if track.Title != want {
+					t.Errorf("Advance %d: expected track %s, got %s", i, want, track.Title)
+				}
+			}
+		})
+	}
+}
+
+func TestQueueNextSkipsTrackLoop(t *testing.T) {
+	q := newTestQueue(2)
+	q.SetLoop(LoopTrack)
+
+	track, err := q.Next()
+	if err != nil || track.Title != "1" {
+		t.Fatalf("Expected Next to move on despite LoopTrack, got %v, %v", track, err)
+	}
+	if _, err := q.Next(); err != ErrEndOfQueue {
+		t.Errorf("Expected ErrEndOfQueue, got %v", err)
+	}
+	if _, err := q.Current(); err != ErrQueueEmpty {
+		t.Errorf("Expected no current track after the end, got %v", err)
+	}
+
+	// Tracks added after the end play next
+	q.Add(Track{Title: "2"})
+	if track, err := q.Current(); err != nil || track.Title != "2" {
+		t.Errorf("Expected the new track to be current, got %v, %v", track, err)
+	}
+}
+
+func TestQueuePrevious(t *testing.T) {
+	q := newTestQueue(3)
+
+	if _, err := q.Previous(); err != ErrStartOfQueue {
+		t.Errorf("Expected ErrStartOfQueue on the first track, got %v", err)
+	}
+
+	q.Next()
+	q.Next()
+	q.Next() // Past the end
+	for _, want := range []string{"2", "1", "0"} {
+		track, err := q.Previous()
+		if err != nil || track.Title != want {
+			t.Fatalf("Expected previous track %s, got %v, %v", want, track, err)
+		}
+	}
+
+	q.SetLoop(LoopQueue)
+	if track, err := q.Previous(); err != nil || track.Title != "2" {
+		t.Errorf("Expected LoopQueue to wrap to the last track, got %v, %v", track, err)
+	}
+}
+
+func TestQueueJumpTo(t *testing.T) {
+	q := newTestQueue(3)
+
+	track, err := q.JumpTo(2)
+	if err != nil || track.Title != "2" || q.CurrentIndex() != 2 {
+		t.Fatalf("Expected to jump to track 2, got %v, %v", track, err)
+	}
+
+	for _, index := range []int{-1, 3} {
+		if _, err := q.JumpTo(index); err != ErrInvalidIndex {
+			t.Errorf("JumpTo(%d): expected ErrInvalidIndex, got %v", index, err)
+		}
+	}
+}
+
+func TestQueueMove(t *testing.T) {
+	tests := []struct {
+		from, to    int
+		current     int // Current index before the move
+		wantOrder   string
+		wantCurrent int
+	}{
+		{0, 3, 2, "1 2 3 0 4", 1},
+		{4, 1, 2, "0 4 1 2 3", 3},
+		{2, 0, 2, "2 0 1 3 4", 0},
+		{3, 4, 2, "0 1 2 4 3", 2},
+		{1, 1, 1, "0 1 2 3 4", 1},
+	}
+
+	for _, tt := range tests {
+		q := newTestQueue(5)
+		q.JumpTo(tt.current)
+
+		if err := q.Move(tt.from, tt.to); err != nil {
+			t.Fatalf("Move(%d, %d) failed: %v", tt.from, tt.to, err)
+		}
+		if got := titles(q); got != tt.wantOrder {
+			t.Errorf("Move(%d, %d): expected order %q, got %q", tt.from, tt.to, tt.wantOrder, got)
+		}
+		if got := q.CurrentIndex(); got != tt.wantCurrent {
+			t.Errorf("Move(%d, %d): expected current index %d, got %d", tt.from, tt.to, tt.wantCurrent, got)
+		}
+	}
+
+	if err := newTestQueue(2).Move(0, 2); err != ErrInvalidIndex {
+		t.Errorf("Expected ErrInvalidIndex, got %v", err)
+	}
+}
+
+func TestQueueCurrentIsACopy(t *testing.T) {
+	q := newTestQueue(3)
+	held, err := q.Current()
+	if err != nil {
+		t.Fatalf("Current failed: %v", err)
+	}
+
+	// Moving the current track rewrites the slice it was in
+	if err := q.Move(0, 2); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	current, _ := q.Current()
+	if held.Title != "0" || current.Title != "0" {
+		t.Errorf("Expected the held and current track to stay 0, got %s and %s", held.Title, current.Title)
+	}
+
+	held.Title = "changed"
+	if current, _ := q.Current(); current.Title != "0" {
+		t.Errorf("Expected changing a returned track to leave the queue alone, got %s", current.Title)
+	}
+}
+
+func TestQueueShuffle(t *testing.T) {
+	q := newTestQueue(20)
+	q.JumpTo(5)
+
+	if n := q.Shuffle(); n != 14 {
+		t.Errorf("Expected 14 upcoming tracks to be shuffled, got %d", n)
+	}
+
+	tracks := q.List()
+	if current, _ := q.Current(); current.Title != "5" || q.CurrentIndex() != 5 {
+		t.Errorf("Expected the current track to be preserved, got %s at %d", current.Title, q.CurrentIndex())
+	}
+	for i := 0; i < 5; i++ {
+		if tracks[i].Title != strconv.Itoa(i) {
+			t.Errorf("Expected played track %d to keep its position, got %s", i, tracks[i].Title)
+		}
+	}
+
+	upcoming := make([]string, 0, 14)
+	for _, track := range tracks[6:] {
+		upcoming = append(upcoming, track.Title)
+	}
+	sort.Slice(upcoming, func(i, j int) bool {
+		a, _ := strconv.Atoi(upcoming[i])
+		b, _ := strconv.Atoi(upcoming[j])
+		return a < b
+	})
+	if got := strings.Join(upcoming, " "); got != "6 7 8 9 10 11 12 13 14 15 16 17 18 19" {
+		t.Errorf("Expected the upcoming tracks to be a permutation, got %s", got)
+	}
+}
+
+func TestParseLoopMode(t *testing.T) {
+	for _, mode := range []LoopMode{LoopOff, LoopTrack, LoopQueue} {
+		parsed, err := ParseLoopMode(strings.ToUpper(mode.String()))
+		if err != nil || parsed != mode {
+			t.Errorf("ParseLoopMode(%q) = %v, %v", mode.String(), parsed, err)
+		}
+	}
+	if _, err := ParseLoopMode("forever"); err == nil {
+		t.Error("Expected an invalid loop mode to be rejected")
+	}
+}
+
+func TestQueueRemaining(t *testing.T) {
+	q := NewQueue()
+	if seconds, unknown := q.Remaining(); seconds != 0 || unknown != 0 {
+		t.Errorf("Expected nothing remaining in an empty queue, got %d (%d unknown)", seconds, unknown)
+	}
+
+	q.Add(Track{Title: "A", Duration: 100})
+	q.Add(Track{Title: "B"})
+	q.Add(Track{Title: "C", Duration: 50})
+
+	if seconds, unknown := q.Remaining(); seconds != 150 || unknown != 1 {
+		t.Errorf("Expected 150s and 1 unknown remaining, got %d (%d unknown)", seconds, unknown)
+	}
+
+	q.Next()
+	q.Next()
+	if seconds, unknown := q.Remaining(); seconds != 50 || unknown != 0 {
+		t.Errorf("Expected 50s remaining on the last track, got %d (%d unknown)", seconds, unknown)
+	}
+
+	q.Next()
+	if seconds, unknown := q.Remaining(); seconds != 0 || unknown != 0 {
+		t.Errorf("Expected nothing remaining after the end, got %d (%d unknown)", seconds, unknown)
+	}
+}
+
+func TestQueueAddSetsAddedAt(t *testing.T) {
+	q := NewQueue()
+	added := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	q.Add(Track{Title: "A"})
+	q.Add(Track{Title: "B", AddedAt: added})
+
+	tracks := q.List()
+	if tracks[0].AddedAt.IsZero() {
+		t.Error("Expected Add to stamp tracks without an added-at time")
+	}
+	if !tracks[1].AddedAt.Equal(added) {
+		t.Errorf("Expected Add to keep the added-at time, got %v", tracks[1].AddedAt)
+	}
+}
+
+func TestQueueSnapshotRestore(t *testing.T) {
+	q := newTestQueue(4)
+	q.Next()
+	q.SetLoop(LoopQueue)
+
+	snapshot := q.Snapshot()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"Loop":"queue"`) {
+		t.Errorf("Expected the loop mode to be saved by name, got %s", data)
+	}
+
+	var decoded Snapshot
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	restored := NewQueue()
+	restored.Restore(decoded)
+	if got := titles(restored); got != titles(q) {
+		t.Errorf("Expected tracks %s, got %s", titles(q), got)
+	}
+	if restored.CurrentIndex() != 1 || restored.Loop() != LoopQueue {
+		t.Errorf("Expected current 1 and loop queue, got %d and %s", restored.CurrentIndex(), restored.Loop())
+	}
+
+	// Restoring copies the tracks
+	snapshot.Tracks[0].Title = "changed"
+	if restored.List()[0].Title == "changed" {
+		t.Error("Expected Restore to copy the snapshot's tracks")
+	}
+
+	// A bad cursor falls back to the first track
+	restored.Restore(Snapshot{Tracks: snapshot.Tracks, Current: 10})
+	if restored.CurrentIndex() != 0 {
+		t.Errorf("Expected an out of range cursor to reset to 0, got %d", restored.CurrentIndex())
+	}
+	restored.Restore(Snapshot{})
+	if restored.CurrentIndex() != -1 {
+		t.Errorf("Expected an empty snapshot to empty the queue, got %d", restored.CurrentIndex())
+	}
+
+	if err := json.Unmarshal([]byte(`{"Loop":"sideways"}`), &decoded); err == nil {
+		t.Error("Expected an unknown loop mode to fail to parse")
+	}
+}
+
+func TestFairQueue(t *testing.T) {
+	q := NewQueue()
+	q.SetFair(true)
+
+	add := func(title string) {
+		q.Add(Track{Title: title, RequestedBy: title[:1], Duration: 60})
+	}
+
+	// The current track counts as its requester's turn
+	add("A1")
+	add("A2")
+	add("A3")
+	add("B1")
+	add("C1")
+	add("B2")
+	if got := titles(q); got != "A1 B1 C1 A2 B2 A3" {
+		t.Errorf("Expected requesters to take turns, got %s", got)
+	}
+
+	// C1 playing counts as C's turn, so C2 waits for the next round
+	q.Next()
+	q.Next()
+	add("C2")
+	if got := titles(q); got != "A1 B1 C1 A2 B2 A3 C2" {
+		t.Errorf("Expected C2 to wait for the next round, got %s", got)
+	}
+	add("D1")
+	if got := titles(q); got != "A1 B1 C1 A2 B2 D1 A3 C2" {
+		t.Errorf("Expected a new requester to play in the current round, got %s", got)
+	}
+	if tracks, seconds := q.Queued("A"); tracks != 2 || seconds != 120 {
+		t.Errorf("Expected A to have 2 tracks and 120s queued, got %d and %d", tracks, seconds)
+	}
+	if tracks, _ := q.Queued("C"); tracks != 1 {
+		t.Errorf("Expected only upcoming tracks to count as queued, got %d for C", tracks)
+	}
+	if upcoming := q.Upcoming(); upcoming != 5 {
+		t.Errorf("Expected 5 tracks after the current one, got %d", upcoming)
+	}
+
+	// Turning fair mode on reorders what is still to come
+	q = NewQueue()
+	for _, title := range []string{"A1", "A2", "A3", "A4", "B1", "B2"} {
+		add(title)
+	}
+	q.SetFair(true)
+	if got := titles(q); got != "A1 B1 A2 B2 A3 A4" {
+		t.Errorf("Expected enabling fair mode to interleave the queue, got %s", got)
+	}
+
+	q.SetFair(false)
+	add("C1")
+	if got := titles(q); got != "A1 B1 A2 B2 A3 A4 C1" {
+		t.Errorf("Expected tracks to be appended with fair mode off, got %s", got)
+	}
+}
@@ -0,0 +1,157 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// Discord voice audio format
+const (
+	SampleRate    = 48000
+	Channels      = 2
+	FrameDuration = 20 * time.Millisecond
+	FrameSize     = SampleRate / 1000 * 20 // Samples per channel in one frame
+)
+
+// SilenceFrame is a complete Opus packet that decodes to 20ms of silence.
+// Discord recommends sending a few of these when audio stops.
+var SilenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// ErrStopped is returned by Pace when it is interrupted through its stop channel
+var ErrStopped = errors.New("playback stopped")
+
+// OpusReader yields Opus frames until io.EOF. It matches dca.OpusReader, so
+// dca encode sessions can be paced the same way as generated audio.
+type OpusReader interface {
+	OpusFrame() ([]byte, error)
+	FrameDuration() time.Duration
+}
+
+// Silence produces Opus silence frames for a fixed duration
+type Silence struct {
+	remaining int
+}
+
+// NewSilence returns a source of silence lasting d, rounded up to whole frames
+func NewSilence(d time.Duration) *Silence {
+	return &Silence{remaining: frameCount(d)}
+}
+
+func (s *Silence) OpusFrame() ([]byte, error) {
+	if s.remaining <= 0 {
+		return nil, io.EOF
+	}
+	s.remaining--
+	return SilenceFrame, nil
+}
+
+func (s *Silence) FrameDuration() time.Duration {
+	return FrameDuration
+}
+
+// Tone generates a stereo sine wave as 16-bit PCM. Voice connections only take
+// Opus, so the PCM is meant to be encoded (e.g. WAV through dca/ffmpeg) first.
+type Tone struct {
+	Frequency float64 // Hz
+	Amplitude float64 // 0.0 - 1.0
+	Duration  time.Duration
+}
+
+// NewTone returns a tone at the given frequency, at half amplitude
+func NewTone(frequency float64, d time.Duration) *Tone {
+	return &Tone{Frequency: frequency, Amplitude: 0.5, Duration: d}
+}
+
+// Samples returns the interleaved stereo samples for the whole tone
+func (t *Tone) Samples() []int16 {
+	perChannel := frameCount(t.Duration) * FrameSize
+	samples := make([]int16, perChannel*Channels)
+
+	peak := t.Amplitude * math.MaxInt16
+	for i := 0; i < perChannel; i++ {
+		value := int16(peak * math.Sin(2*math.Pi*t.Frequency*float64(i)/SampleRate))
+		for c := 0; c < Channels; c++ {
+			samples[i*Channels+c] = value
+		}
+	}
+	return samples
+}
+
+// WAV returns the tone as a 16-bit PCM WAV file
+func (t *Tone) WAV() []byte {
+	samples := t.Samples()
+	dataSize := len(samples) * 2
+
+	var buf bytes.Buffer
+	buf.Grow(44 + dataSize)
+
+	// RIFF header and fmt chunk
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+dataSize))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))                    // fmt chunk size
+	binary.Write(&buf, binary.LittleEndian, uint16(1))                     // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(Channels))              // channels
+	binary.Write(&buf, binary.LittleEndian, uint32(SampleRate))            // sample rate
+	binary.Write(&buf, binary.LittleEndian, uint32(SampleRate*Channels*2)) // byte rate
+	binary.Write(&buf, binary.LittleEndian, uint16(Channels*2))            // block align
+	binary.Write(&buf, binary.LittleEndian, uint16(16))                    // bits per sample
+
+	// data chunk
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(dataSize))
+	binary.Write(&buf, binary.LittleEndian, samples)
+
+	return buf.Bytes()
+}
+
+// Pace sends frames from src to out, one per frame duration, until src is
+// exhausted or stop is closed. It returns the number of frames sent. The
+// first frame goes out immediately.
+func Pace(src OpusReader, out chan<- []byte, stop <-chan struct{}) (int, error) {
+	interval := src.FrameDuration()
+	if interval <= 0 {
+		interval = FrameDuration
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	sent := 0
+	for {
+		frame, err := src.OpusFrame()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		if sent > 0 {
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return sent, ErrStopped
+			}
+		}
+
+		select {
+		case out <- frame:
+			sent++
+		case <-stop:
+			return sent, ErrStopped
+		}
+	}
+}
+
+// frameCount returns how many frames are needed to cover d
+func frameCount(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int((d + FrameDuration - 1) / FrameDuration)
+}
@@ -0,0 +1,137 @@
+package source
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSilenceFrames(t *testing.T) {
+	silence := NewSilence(time.Second)
+
+	frames := 0
+	for {
+		frame, err := silence.OpusFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !bytes.Equal(frame, SilenceFrame) {
+			t.Fatalf("Expected Opus silence frame, got %v", frame)
+		}
+		frames++
+	}
+
+	if frames != 50 {
+		t.Errorf("Expected 50 frames for one second of silence, got %d", frames)
+	}
+
+	// Partial frames are rounded up
+	if got := frameCount(30 * time.Millisecond); got != 2 {
+		t.Errorf("Expected 30ms to need 2 frames, got %d", got)
+	}
+}
+
+func TestPaceTiming(t *testing.T) {
+	// Fake OpusSend channel, buffered so the receiver never slows the pacer down
+	opusSend := make(chan []byte, 100)
+
+	start := time.Now()
+	sent, err := Pace(NewSilence(200*time.Millisecond), opusSend, nil)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("Pace failed: %v", err)
+	}
+	if sent != 10 || len(opusSend) != 10 {
+		t.Errorf("Expected 10 frames sent, got %d (channel holds %d)", sent, len(opusSend))
+	}
+
+	// The first frame goes out immediately, the other nine are 20ms apart
+	if elapsed < 170*time.Millisecond {
+		t.Errorf("Frames were not paced, 10 frames took only %v", elapsed)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Pacing too slow, 10 frames took %v", elapsed)
+	}
+}
+
+func TestPaceStop(t *testing.T) {
+	opusSend := make(chan []byte, 100)
+	stop := make(chan struct{})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+	}()
+
+	sent, err := Pace(NewSilence(10*time.Second), opusSend, stop)
+	if err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+	if sent == 0 || sent > 10 {
+		t.Errorf("Expected a handful of frames before stopping, got %d", sent)
+	}
+}
+
+func TestToneSamples(t *testing.T) {
+	tone := NewTone(440, time.Second)
+	samples := tone.Samples()
+
+	if len(samples) != SampleRate*Channels {
+		t.Fatalf("Expected %d samples, got %d", SampleRate*Channels, len(samples))
+	}
+
+	// Both channels carry the same signal
+	for i := 0; i < len(samples); i += Channels {
+		if samples[i] != samples[i+1] {
+			t.Fatalf("Channels differ at sample %d", i/Channels)
+		}
+	}
+
+	// A 440 Hz sine crosses zero upwards 440 times per second
+	crossings := 0
+	var peak int16
+	for i := Channels; i < len(samples); i += Channels {
+		if samples[i-Channels] < 0 && samples[i] >= 0 {
+			crossings++
+		}
+		if samples[i] > peak {
+			peak = samples[i]
+		}
+	}
+	if crossings < 438 || crossings > 441 {
+		t.Errorf("Expected about 440 upward zero crossings, got %d", crossings)
+	}
+
+	// Half amplitude
+	if peak < 16000 || peak > 16400 {
+		t.Errorf("Expected peak around 16383, got %d", peak)
+	}
+}
+
+func TestToneWAV(t *testing.T) {
+	tone := NewTone(440, 100*time.Millisecond)
+	wav := tone.WAV()
+
+	if string(wav[0:4]) != "RIFF" || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+		t.Fatal("Invalid WAV header")
+	}
+
+	channels := binary.LittleEndian.Uint16(wav[22:24])
+	sampleRate := binary.LittleEndian.Uint32(wav[24:28])
+	dataSize := binary.LittleEndian.Uint32(wav[40:44])
+
+	if channels != Channels || sampleRate != SampleRate {
+		t.Errorf("Expected %d channels at %d Hz, got %d at %d Hz", Channels, SampleRate, channels, sampleRate)
+	}
+
+	expectedSize := 5 * FrameSize * Channels * 2
+	if int(dataSize) != expectedSize || len(wav) != 44+expectedSize {
+		t.Errorf("Expected %d bytes of PCM data, header says %d, file has %d", expectedSize, dataSize, len(wav)-44)
+	}
+}
@@ -0,0 +1,247 @@
+// Package store persists queues and settings as JSON files in a data
+// directory, so a restarted bot can pick up where it left off.
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/doomhound188/soulhound/internal/config"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+const (
+	guildsDir   = "guilds"   // Queue state, one file per guild
+	settingsDir = "settings" // Guild settings, one file per guild
+	podcastsDir = "podcasts" // Podcast listening positions, one file per guild
+)
+
+// GuildState is what is needed to resume playback in a guild
+type GuildState struct {
+	GuildID        string
+	VoiceChannelID string
+	TextChannelID  string // Where now playing messages were posted
+	Queue          queue.Snapshot
+	Position       int      // Seconds into the current track
+	Filters        []string // Active filter presets
+}
+
+// Store reads and writes state files under a data directory. Files are
+// replaced atomically and writes that wouldn't change a file are skipped.
+type Store struct {
+	dir     string
+	mu      sync.Mutex
+	written map[string][]byte // Last contents written to each file
+}
+
+// Open creates the data directory if needed and returns a store for it
+func Open(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("no data directory configured")
+	}
+	for _, sub := range []string{guildsDir, settingsDir, podcastsDir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create data directory: %w", err)
+		}
+	}
+	return &Store{dir: dir, written: make(map[string][]byte)}, nil
+}
+
+// Dir returns the data directory
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// LoadSettings reads the saved settings of every guild, keyed by guild ID
+func (s *Store) LoadSettings() (map[string]config.GuildSettings, error) {
+	paths, err := s.files(settingsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]config.GuildSettings, len(paths))
+	for guildID, path := range paths {
+		var guild config.GuildSettings
+		if err := s.read(path, &guild); err != nil {
+			return nil, err
+		}
+		settings[guildID] = guild
+	}
+	return settings, nil
+}
+
+// SaveSettings saves one guild's settings
+func (s *Store) SaveSettings(guildID string, settings config.GuildSettings) error {
+	path, err := s.path(settingsDir, guildID)
+	if err != nil {
+		return err
+	}
+	return s.write(path, settings)
+}
+
+// LoadGuilds reads the saved state of every guild
+func (s *Store) LoadGuilds() ([]GuildState, error) {
+	paths, err := s.files(guildsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]GuildState, 0, len(paths))
+	for guildID, path := range paths {
+		var state GuildState
+		if err := s.read(path, &state); err != nil {
+			return nil, err
+		}
+		state.GuildID = guildID
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].GuildID < states[j].GuildID })
+	return states, nil
+}
+
+// SaveGuild saves the state of one guild
+func (s *Store) SaveGuild(state GuildState) error {
+	path, err := s.path(guildsDir, state.GuildID)
+	if err != nil {
+		return err
+	}
+	return s.write(path, state)
+}
+
+// DeleteGuild removes the saved state of a guild. Deleting a guild that has
+// no saved state is not an error.
+func (s *Store) DeleteGuild(guildID string) error {
+	path, err := s.path(guildsDir, guildID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.written, path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// LoadPodcastPositions reads every guild's podcast listening positions,
+// keyed by guild ID and then episode ID, in seconds
+func (s *Store) LoadPodcastPositions() (map[string]map[string]int, error) {
+	paths, err := s.files(podcastsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	positions := make(map[string]map[string]int, len(paths))
+	for guildID, path := range paths {
+		var guild map[string]int
+		if err := s.read(path, &guild); err != nil {
+			return nil, err
+		}
+		positions[guildID] = guild
+	}
+	return positions, nil
+}
+
+// SavePodcastPositions saves one guild's podcast listening positions. Saving
+// none removes the guild's file.
+func (s *Store) SavePodcastPositions(guildID string, positions map[string]int) error {
+	path, err := s.path(podcastsDir, guildID)
+	if err != nil {
+		return err
+	}
+	if len(positions) > 0 {
+		return s.write(path, positions)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.written, path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// path returns a guild's file in sub, rejecting IDs that would escape it
+func (s *Store) path(sub, guildID string) (string, error) {
+	if guildID == "" || guildID != filepath.Base(guildID) || strings.HasPrefix(guildID, ".") {
+		return "", fmt.Errorf("invalid guild ID %q", guildID)
+	}
+	return filepath.Join(s.dir, sub, guildID+".json"), nil
+}
+
+// files lists the guild files in sub, keyed by guild ID
+func (s *Store) files(sub string) (map[string]string, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, sub, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]string, len(paths))
+	for _, path := range paths {
+		files[strings.TrimSuffix(filepath.Base(path), ".json")] = path
+	}
+	return files, nil
+}
+
+func (s *Store) read(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	s.mu.Lock()
+	s.written[path] = data
+	s.mu.Unlock()
+	return nil
+}
+
+// write replaces a file through a temporary file and rename, so a crash
+// mid-write leaves the previous version in place
+func (s *Store) write(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if bytes.Equal(s.written[path], data) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	s.written[path] = data
+	return nil
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/doomhound188/soulhound/internal/config"
+	"github.com/doomhound188/soulhound/internal/queue"
+)
+
+func TestSettingsRoundTrip(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if settings, err := s.LoadSettings(); err != nil || len(settings) != 0 {
+		t.Fatalf("Expected no saved settings in a new store, got %v (%v)", settings, err)
+	}
+
+	want := config.GuildSettings{
+		DefaultPlatform: "sp", SmartPlay: true, Volume: 80, Prefix: "?", IdleTimeout: 5 * time.Minute,
+		CommandLevels: map[string]string{"stop": config.LevelEveryone},
+	}
+	if err := s.SaveSettings("123", want); err != nil {
+		t.Fatalf("SaveSettings failed: %v", err)
+	}
+	if err := s.SaveSettings("456", config.GuildSettings{DefaultPlatform: "yt"}); err != nil {
+		t.Fatalf("SaveSettings failed: %v", err)
+	}
+
+	// A fresh store reads what the first one wrote
+	reopened, err := Open(s.Dir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	settings, err := reopened.LoadSettings()
+	if err != nil {
+		t.Fatalf("LoadSettings failed: %v", err)
+	}
+	if len(settings) != 2 {
+		t.Fatalf("Expected settings for 2 guilds, got %d", len(settings))
+	}
+	if !reflect.DeepEqual(settings["123"], want) {
+		t.Errorf("Expected %+v, got %+v", want, settings["123"])
+	}
+}
+
+func TestGuildRoundTrip(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	added := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	state := GuildState{
+		GuildID:        "123456789",
+		VoiceChannelID: "987654321",
+		Queue: queue.Snapshot{
+			Tracks: []queue.Track{
+				{Title: "Song A", URL: "a", Platform: "yt", Duration: 200, RequestedBy: "42", AddedAt: added},
+				{Title: "Song B", URL: "b", Platform: "sp"},
+			},
+			Current: 1,
+			Loop:    queue.LoopQueue,
+		},
+		Position: 73,
+	}
+	if err := s.SaveGuild(state); err != nil {
+		t.Fatalf("SaveGuild failed: %v", err)
+	}
+	if err := s.SaveGuild(GuildState{GuildID: "555", Queue: queue.Snapshot{Current: -1}}); err != nil {
+		t.Fatalf("SaveGuild failed: %v", err)
+	}
+
+	reopened, err := Open(s.Dir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	states, err := reopened.LoadGuilds()
+	if err != nil {
+		t.Fatalf("LoadGuilds failed: %v", err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("Expected 2 saved guilds, got %d", len(states))
+	}
+
+	var got GuildState
+	for _, s := range states {
+		if s.GuildID == state.GuildID {
+			got = s
+		}
+	}
+	if got.VoiceChannelID != state.VoiceChannelID || got.Position != 73 {
+		t.Errorf("Unexpected guild state: %+v", got)
+	}
+	if got.Queue.Current != 1 || got.Queue.Loop != queue.LoopQueue || len(got.Queue.Tracks) != 2 {
+		t.Fatalf("Unexpected queue: %+v", got.Queue)
+	}
+	if track := got.Queue.Tracks[0]; track.Title != "Song A" || track.RequestedBy != "42" || !track.AddedAt.Equal(added) {
+		t.Errorf("Unexpected first track: %+v", track)
+	}
+
+	if err := reopened.DeleteGuild("555"); err != nil {
+		t.Fatalf("DeleteGuild failed: %v", err)
+	}
+	if err := reopened.DeleteGuild("555"); err != nil {
+		t.Errorf("Expected deleting a missing guild to succeed, got %v", err)
+	}
+	if states, _ := reopened.LoadGuilds(); len(states) != 1 {
+		t.Errorf("Expected 1 saved guild after delete, got %d", len(states))
+	}
+}
+
+func TestPodcastPositionsRoundTrip(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	want := map[string]int{"https://example.com/ep1.mp3": 754, "https://example.com/ep2.mp3": 12}
+	if err := s.SavePodcastPositions("123", want); err != nil {
+		t.Fatalf("SavePodcastPositions failed: %v", err)
+	}
+	if err := s.SavePodcastPositions("456", map[string]int{"https://example.com/other.mp3": 5}); err != nil {
+		t.Fatalf("SavePodcastPositions failed: %v", err)
+	}
+
+	reopened, err := Open(s.Dir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	positions, err := reopened.LoadPodcastPositions()
+	if err != nil {
+		t.Fatalf("LoadPodcastPositions failed: %v", err)
+	}
+	if len(positions) != 2 || !reflect.DeepEqual(positions["123"], want) {
+		t.Errorf("Expected %v for guild 123 of 2 guilds, got %v", want, positions)
+	}
+
+	// Saving no positions removes the guild's file
+	if err := reopened.SavePodcastPositions("456", nil); err != nil {
+		t.Fatalf("SavePodcastPositions failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir(), podcastsDir, "456.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the guild's podcast file to be removed, got %v", err)
+	}
+	if positions, _ := reopened.LoadPodcastPositions(); len(positions) != 1 {
+		t.Errorf("Expected positions for 1 guild, got %v", positions)
+	}
+}
+
+func TestSkipsUnchangedWrites(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	settings := config.GuildSettings{DefaultPlatform: "yt", Volume: 100}
+	if err := s.SaveSettings("123", settings); err != nil {
+		t.Fatalf("SaveSettings failed: %v", err)
+	}
+
+	// Remove the file behind the store's back: an unchanged save must not rewrite it
+	path := filepath.Join(s.Dir(), settingsDir, "123.json")
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := s.SaveSettings("123", settings); err != nil {
+		t.Fatalf("SaveSettings failed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected an unchanged save to be skipped, got %v", err)
+	}
+
+	settings.Volume = 50
+	if err := s.SaveSettings("123", settings); err != nil {
+		t.Fatalf("SaveSettings failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected a changed save to be written, got %v", err)
+	}
+
+	// No temporary files are left behind
+	leftovers, _ := filepath.Glob(filepath.Join(s.Dir(), settingsDir, ".tmp-*"))
+	if len(leftovers) != 0 {
+		t.Errorf("Expected no temporary files, got %v", leftovers)
+	}
+}
+
+func TestRejectsInvalidGuildIDs(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	for _, id := range []string{"", "..", "../settings", "a/b", ".hidden"} {
+		if err := s.SaveGuild(GuildState{GuildID: id}); err == nil {
+			t.Errorf("Expected guild ID %q to be rejected", id)
+		}
+		if err := s.SaveSettings(id, config.GuildSettings{}); err == nil {
+			t.Errorf("Expected guild ID %q to be rejected for settings", id)
+		}
+	}
+}
+
+func TestOpenRequiresDir(t *testing.T) {
+	if _, err := Open(""); err == nil {
+		t.Error("Expected an empty data directory to be rejected")
+	}
+}